@@ -4,4 +4,9 @@ const (
 	ResticKey                = "restic.appscode.com"
 	LastAppliedConfiguration = ResticKey + "/last-applied-configuration"
 	VersionTag               = ResticKey + "/tag"
+	RestartedAt              = ResticKey + "/restarted-at"
+
+	// SkipInjection annotation set to "true" on a workload or its pod template stops the sidecar
+	// from being injected, even if the workload matches a Restic.
+	SkipInjection = "stash.appscode.com/skip"
 )
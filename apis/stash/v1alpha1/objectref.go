@@ -15,6 +15,40 @@ func (r Restic) ObjectReference() *core.ObjectReference {
 	}
 }
 
+// DefaultScratchMountPath is the mount path of the scratch volume in stash containers, unless set in the Restic.
+const DefaultScratchMountPath = "/tmp"
+
+// GetScratchMountPath returns the mount path of the scratch volume in the stash containers of r.
+func (r Restic) GetScratchMountPath() string {
+	if r.Spec.ScratchMountPath != "" {
+		return r.Spec.ScratchMountPath
+	}
+	return DefaultScratchMountPath
+}
+
+// DefaultCacheMountPath is the mount path of the cache volume in the sidecar, unless set in the Restic.
+const DefaultCacheMountPath = "/var/cache/restic"
+
+// GetCacheMountPath returns the mount path of the cache volume in the sidecar of r, or "" if r keeps
+// the restic cache in the scratch volume.
+func (r Restic) GetCacheMountPath() string {
+	if r.Spec.Cache == nil {
+		return ""
+	}
+	if r.Spec.Cache.MountPath != "" {
+		return r.Spec.Cache.MountPath
+	}
+	return DefaultCacheMountPath
+}
+
+// GetResticNamespace returns the namespace of the Restic recovered by r.
+func (r Recovery) GetResticNamespace() string {
+	if r.Spec.ResticNamespace != "" {
+		return r.Spec.ResticNamespace
+	}
+	return r.Namespace
+}
+
 func (r Recovery) ObjectReference() *core.ObjectReference {
 	return &core.ObjectReference{
 		APIVersion:      SchemeGroupVersion.String(),
@@ -2,6 +2,7 @@ package v1alpha1
 
 import (
 	core "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
@@ -12,6 +13,9 @@ const (
 	ResourceKindRecovery = "Recovery"
 	ResourceNameRecovery = "recovery"
 	ResourceTypeRecovery = "recoveries"
+	ResourceKindSnapshot = "Snapshot"
+	ResourceNameSnapshot = "snapshot"
+	ResourceTypeSnapshot = "snapshots"
 )
 
 // +genclient
@@ -26,7 +30,13 @@ type Restic struct {
 }
 
 type ResticSpec struct {
-	Selector   metav1.LabelSelector `json:"selector,omitempty"`
+	Selector metav1.LabelSelector `json:"selector,omitempty"`
+	// Selects all workloads in the namespace of the Restic. An empty Selector is rejected
+	// unless SelectAll is set, so that a Restic does not apply to every workload by accident.
+	SelectAll bool `json:"selectAll,omitempty"`
+	// Workload backed up by this Restic, an alternative to Selector for workloads
+	// without suitable labels. Exactly one of Selector and Target must be set.
+	Target     *LocalTypedReference `json:"target,omitempty"`
 	FileGroups []FileGroup          `json:"fileGroups,omitempty"`
 	Backend    Backend              `json:"backend,omitempty"`
 	Schedule   string               `json:"schedule,omitempty"`
@@ -37,14 +47,106 @@ type ResticSpec struct {
 	RetentionPolicies []RetentionPolicy         `json:"retentionPolicies,omitempty"`
 	// https://github.com/appscode/stash/issues/225
 	Type BackupType `json:"type,omitempty"`
+	// Image pull policy of the sidecar container. Defaults to Always for canary
+	// images and IfNotPresent otherwise.
+	ImagePullPolicy core.PullPolicy `json:"imagePullPolicy,omitempty"`
+	// Size limit of the scratch EmptyDir volume. Unbounded if not set.
+	ScratchSizeLimit *resource.Quantity `json:"scratchSizeLimit,omitempty"`
+	// Storage medium of the scratch EmptyDir volume. Set to Memory to use tmpfs.
+	// Files written to a tmpfs scratch volume count against the memory limit of
+	// the sidecar container.
+	ScratchMedium core.StorageMedium `json:"scratchMedium,omitempty"`
+	// Log level (--v) of the sidecar and recovery containers. Overrides the level set for the operator.
+	LogLevel *int32 `json:"logLevel,omitempty"`
+	// Interval between repository integrity checks (restic check). Defaults to 3 days.
+	CheckInterval *metav1.Duration `json:"checkInterval,omitempty"`
+	// Security options of the sidecar container. If not set, the operator may apply a
+	// default non-root security context, see the --sidecar-default-security-context flag.
+	SecurityContext *core.SecurityContext `json:"securityContext,omitempty"`
+	// Hook run before each backup, e.g. to flush or lock a database. The backup fails if the hook fails.
+	// It runs in the stash container, not in the application container, see BackupHook.
+	PreBackupHook *BackupHook `json:"preBackupHook,omitempty"`
+	// Priority class of the workload pods running the sidecar. Overrides the priority
+	// class of the workload while the Restic applies to it.
+	PriorityClassName string `json:"priorityClassName,omitempty"`
+	// Upload rate limit of restic in KiB/s. Not limited by default.
+	LimitUpload *int32 `json:"limitUpload,omitempty"`
+	// Download rate limit of restic in KiB/s. Not limited by default.
+	LimitDownload *int32 `json:"limitDownload,omitempty"`
+	// Maximum duration of a restic command run by the sidecar or a stash job. Restic has no
+	// connection timeout, so this stops commands hanging on a stalled backend. Not limited by default.
+	Timeout *metav1.Duration `json:"timeout,omitempty"`
+	// Environment variables of the sidecar container and the recovery, check, forget and init jobs,
+	// e.g. backend credentials taken from Secrets or ConfigMaps already used by the workload.
+	Env []core.EnvVar `json:"env,omitempty"`
+	// Sources of environment variables of the sidecar container and the stash jobs.
+	EnvFrom []core.EnvFromSource `json:"envFrom,omitempty"`
+	// Mount path of the podinfo volume in the sidecar container. It holds the labels, annotations
+	// and namespace of the pod. Defaults to /etc/stash.
+	PodinfoMountPath string `json:"podinfoMountPath,omitempty"`
+	// Mount path of the scratch volume in stash containers. restic keeps its cache and temporary
+	// files below it. Defaults to /tmp.
+	ScratchMountPath string `json:"scratchMountPath,omitempty"`
+	// Persistent volume keeping the restic cache of the sidecar across backups and pod restarts.
+	// If not set, the cache is kept in the scratch volume and lost when the pod is recreated.
+	// All pods of the workload mount the same claim, so it can't be used for StatefulSets and DaemonSets.
+	Cache *CacheSpec `json:"cache,omitempty"`
+	// Patterns of files and directories skipped by restic backup in all fileGroups, passed to
+	// restic as --exclude.
+	Excludes []string `json:"excludes,omitempty"`
+	// Skip directories marked with a CACHEDIR.TAG file, passed to restic as --exclude-caches.
+	ExcludeCaches bool `json:"excludeCaches,omitempty"`
+	// Don't cross filesystem boundaries below the paths of fileGroups, passed to restic as
+	// --one-file-system.
+	OneFileSystem bool `json:"oneFileSystem,omitempty"`
+	// Secondary backend receiving a copy of every backup, for redundancy. The sidecar backs up
+	// to Backend first and then to Mirror. Checks and recoveries use Backend only.
+	Mirror *Backend `json:"mirror,omitempty"`
+}
+
+// CacheSpec refers to the PersistentVolumeClaim mounted as restic cache in the sidecar.
+type CacheSpec struct {
+	// Name of the PersistentVolumeClaim in the namespace of the workload.
+	ClaimName string `json:"claimName"`
+	// Mount path of the cache volume in the sidecar container. Defaults to /var/cache/restic.
+	MountPath string `json:"mountPath,omitempty"`
+}
+
+// BackupHook is a command run by the stash container of the workload pod. It shares the network
+// and the mounted volumes of the workload, so it can reach the application via localhost. Only the
+// binaries of the stash image are available, and it runs with the security context of the stash container.
+type BackupHook struct {
+	Exec *core.ExecAction `json:"exec,omitempty"`
+	// Number of seconds after which the hook times out. Defaults to 60 seconds.
+	TimeoutSeconds *int32 `json:"timeoutSeconds,omitempty"`
 }
 
 type ResticStatus struct {
-	FirstBackupTime          *metav1.Time `json:"firstBackupTime,omitempty"`
-	LastBackupTime           *metav1.Time `json:"lastBackupTime,omitempty"`
-	LastSuccessfulBackupTime *metav1.Time `json:"lastSuccessfulBackupTime,omitempty"`
-	LastBackupDuration       string       `json:"lastBackupDuration,omitempty"`
-	BackupCount              int64        `json:"backupCount,omitempty"`
+	FirstBackupTime          *metav1.Time      `json:"firstBackupTime,omitempty"`
+	LastBackupTime           *metav1.Time      `json:"lastBackupTime,omitempty"`
+	LastSuccessfulBackupTime *metav1.Time      `json:"lastSuccessfulBackupTime,omitempty"`
+	LastBackupDuration       string            `json:"lastBackupDuration,omitempty"`
+	BackupCount              int64             `json:"backupCount,omitempty"`
+	Conditions               []ResticCondition `json:"conditions,omitempty"`
+}
+
+type ResticConditionType string
+
+const (
+	// ResticConditionRepositoryHealthy is False if the last restic check found problems in the repository.
+	ResticConditionRepositoryHealthy ResticConditionType = "RepositoryHealthy"
+	// ResticConditionRepositoryInitialized is set once the repository init job of the Restic finished.
+	ResticConditionRepositoryInitialized ResticConditionType = "RepositoryInitialized"
+	// ResticConditionMirrorBackedUp is False if the last backup to the mirror backend failed.
+	ResticConditionMirrorBackedUp ResticConditionType = "MirrorBackedUp"
+)
+
+type ResticCondition struct {
+	Type               ResticConditionType  `json:"type,omitempty"`
+	Status             core.ConditionStatus `json:"status,omitempty"`
+	LastTransitionTime metav1.Time          `json:"lastTransitionTime,omitempty"`
+	Reason             string               `json:"reason,omitempty"`
+	Message            string               `json:"message,omitempty"`
 }
 
 // +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
@@ -66,14 +168,20 @@ type FileGroup struct {
 
 type Backend struct {
 	StorageSecretName string `json:"storageSecretName,omitempty"`
-
-	Local *LocalSpec `json:"local,omitempty"`
-	S3    *S3Spec    `json:"s3,omitempty"`
-	GCS   *GCSSpec   `json:"gcs,omitempty"`
-	Azure *AzureSpec `json:"azure,omitempty"`
-	Swift *SwiftSpec `json:"swift,omitempty"`
-	// B2    *B2Spec         `json:"b2,omitempty"`
-	// Rest  *RestServerSpec `json:"rest,omitempty"`
+	// How restic reads the repository password, the RESTIC_PASSWORD key of the storage secret.
+	// Env passes it as environment variable, File mounts it and sets RESTIC_PASSWORD_FILE.
+	// Defaults to Env.
+	PasswordSource PasswordSource `json:"passwordSource,omitempty"`
+
+	Local *LocalSpec      `json:"local,omitempty"`
+	S3    *S3Spec         `json:"s3,omitempty"`
+	GCS   *GCSSpec        `json:"gcs,omitempty"`
+	Azure *AzureSpec      `json:"azure,omitempty"`
+	Swift *SwiftSpec      `json:"swift,omitempty"`
+	Rest  *RestServerSpec `json:"rest,omitempty"`
+	B2    *B2Spec         `json:"b2,omitempty"`
+	Raw   *RawSpec        `json:"raw,omitempty"`
+	SFTP  *SFTPSpec       `json:"sftp,omitempty"`
 }
 
 type LocalSpec struct {
@@ -109,6 +217,31 @@ type B2Spec struct {
 
 type RestServerSpec struct {
 	URL string `json:"url,omitempty"`
+	// Secret holding the TLS client certificate and key in client.pem, for REST
+	// servers using mutual TLS.
+	TLSSecretName string `json:"tlsSecretName,omitempty"`
+}
+
+type SFTPSpec struct {
+	Host string `json:"host,omitempty"`
+	// SSH port of the server. Defaults to 22.
+	Port int32  `json:"port,omitempty"`
+	User string `json:"user,omitempty"`
+	// Path of the repository on the server, relative to the home directory of user if not absolute.
+	Path string `json:"path,omitempty"`
+	// Secret holding the SSH private key in ssh-privatekey and, optionally, the public keys of the
+	// server in known_hosts. Without known_hosts, the host key of the server is not checked.
+	SSHSecretName string `json:"sshSecretName,omitempty"`
+}
+
+// RawSpec sets the restic repository url directly, for backends without a dedicated spec,
+// e.g. sftp or rclone.
+type RawSpec struct {
+	// Restic repository url, e.g. sftp:user@host:/srv/restic. The repository prefix
+	// of the workload is appended as sub path.
+	URL string `json:"url,omitempty"`
+	// Secret whose keys are exposed as environment variables, e.g. credentials of the backend.
+	SecretName string `json:"secretName,omitempty"`
 }
 
 type BackupType string
@@ -118,6 +251,13 @@ const (
 	BackupOffline BackupType = "offline" // injects init container
 )
 
+type PasswordSource string
+
+const (
+	PasswordSourceEnv  PasswordSource = "Env"
+	PasswordSourceFile PasswordSource = "File"
+)
+
 type RetentionStrategy string
 
 const (
@@ -155,11 +295,89 @@ type Recovery struct {
 }
 
 type RecoverySpec struct {
-	Restic     string              `json:"restic,omitempty"`
-	Workload   LocalTypedReference `json:"workload,omitempty"`
-	PodOrdinal string              `json:"podOrdinal,omitempty"`
-	NodeName   string              `json:"nodeName,omitempty"`
-	Volumes    []core.Volume       `json:"volumes,omitempty"`
+	Restic string `json:"restic,omitempty"`
+	// Namespace of the Restic. Defaults to the namespace of the Recovery. Volumes and secrets used
+	// by the backend of the Restic, e.g. its storage secret, must exist in the namespace of the Recovery.
+	ResticNamespace string              `json:"resticNamespace,omitempty"`
+	Workload        LocalTypedReference `json:"workload,omitempty"`
+	PodOrdinal      string              `json:"podOrdinal,omitempty"`
+	NodeName        string              `json:"nodeName,omitempty"`
+	// NodeSelector and Tolerations of the recovery job pod. NodeName, if also set,
+	// takes precedence in scheduling.
+	NodeSelector map[string]string `json:"nodeSelector,omitempty"`
+	Tolerations  []core.Toleration `json:"tolerations,omitempty"`
+	// Affinity of the recovery job pod. Can't be used together with NodeName.
+	Affinity *core.Affinity `json:"affinity,omitempty"`
+	Volumes  []core.Volume  `json:"volumes,omitempty"`
+	// Secrets used to pull the operator image for the recovery job.
+	ImagePullSecrets []core.LocalObjectReference `json:"imagePullSecrets,omitempty"`
+	// Compute Resources required by the recovery container. Defaults to the
+	// resources of the restic sidecar container.
+	Resources core.ResourceRequirements `json:"resources,omitempty"`
+	// If true, the recovery job is validated but not created.
+	DryRun bool `json:"dryRun,omitempty"`
+	// Recover the snapshot selected by this keyword. Only latest is supported, which recovers the
+	// latest snapshot having all Tags. Can't be combined with SnapshotID or Time.
+	Snapshot string `json:"snapshot,omitempty"`
+	// ID of the snapshot to recover. At most one of SnapshotID, Tags and Time
+	// can be set. If none is set, the latest snapshot is recovered.
+	SnapshotID string `json:"snapshotID,omitempty"`
+	// Recover the latest snapshot having all of these tags.
+	Tags []string `json:"tags,omitempty"`
+	// Recover the latest snapshot taken at or before this time.
+	Time *metav1.Time `json:"time,omitempty"`
+	// Recover snapshots taken on this host instead of the host of the workload, e.g. to restore
+	// data backed up by another node or pod into a repository shared by multiple hosts.
+	SourceHost string `json:"sourceHost,omitempty"`
+	// Recover these paths instead of the FileGroups of the Restic. Requires SnapshotID or SourceHost.
+	// Unless target is set, the paths must be below a volume mount of the Restic.
+	SourcePaths []string `json:"sourcePaths,omitempty"`
+	// Restore only files matching these patterns. Restores everything if empty.
+	IncludePatterns []string `json:"includePatterns,omitempty"`
+	// Skip files matching these patterns while restoring. Can't be used together with IncludePatterns.
+	ExcludePatterns []string `json:"excludePatterns,omitempty"`
+	// Liveness probe of the recovery container. No probe is added if not set. Without a
+	// handler, the probe checks that a running restic process is not stopped or a zombie.
+	// Unset timings default to initialDelaySeconds 30, periodSeconds 60, timeoutSeconds 10
+	// and failureThreshold 3.
+	LivenessProbe *core.Probe `json:"livenessProbe,omitempty"`
+	// Number of retries before the recovery job is marked as failed. Defaults to the
+	// Kubernetes default of 6.
+	BackoffLimit *int32 `json:"backoffLimit,omitempty"`
+	// Duration in seconds the recovery job may be active before it is terminated and the
+	// recovery is marked as failed. Not limited by default.
+	ActiveDeadlineSeconds *int64 `json:"activeDeadlineSeconds,omitempty"`
+	// Priority class of the recovery job pods.
+	PriorityClassName string `json:"priorityClassName,omitempty"`
+	// Restore into this volume instead of the volume mounts of the Restic, e.g. to inspect
+	// the recovered files before replacing the original data.
+	Target *RecoveryTarget `json:"target,omitempty"`
+	// Restart policy of the recovery job pods, OnFailure or Never. Defaults to OnFailure.
+	// With Never, failed pods are kept for debugging and the job creates new ones to retry.
+	RestartPolicy core.RestartPolicy `json:"restartPolicy,omitempty"`
+	// Labels and annotations added to the recovery job and its pods. Labels and
+	// annotations set by stash take precedence.
+	JobLabels      map[string]string `json:"jobLabels,omitempty"`
+	PodLabels      map[string]string `json:"podLabels,omitempty"`
+	PodAnnotations map[string]string `json:"podAnnotations,omitempty"`
+	// If true, one recovery job per volume mount of the Restic is created, restoring the
+	// FileGroups below its mount path in parallel. Can't be used together with target.
+	ParallelVolumes bool `json:"parallelVolumes,omitempty"`
+	// Seconds after which a succeeded, failed or partially recovered Recovery is deleted together
+	// with its recovery jobs. The Recovery is kept if not set.
+	TTLSecondsAfterFinished *int32 `json:"ttlSecondsAfterFinished,omitempty"`
+}
+
+// SnapshotLatest selects the latest snapshot of the host, restricted to the snapshots having the tags
+// of the Recovery if set.
+const SnapshotLatest = "latest"
+
+type RecoveryTarget struct {
+	// Name of the volume in spec.volumes to restore into.
+	Volume string `json:"volume,omitempty"`
+	// Path to mount the volume at in the recovery container. Files keep their original
+	// path below it, e.g. /source/data is restored to <mountPath>/source/data.
+	MountPath string `json:"mountPath,omitempty"`
 }
 
 // +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
@@ -192,16 +410,78 @@ const (
 	RecoveryRunning   RecoveryPhase = "Running"
 	RecoverySucceeded RecoveryPhase = "Succeeded"
 	RecoveryFailed    RecoveryPhase = "Failed"
+	RecoveryPartial   RecoveryPhase = "Partial"
 	RecoveryUnknown   RecoveryPhase = "Unknown"
 )
 
 type RecoveryStatus struct {
-	Phase RecoveryPhase  `json:"phase,omitempty"`
-	Stats []RestoreStats `json:"stats,omitempty"`
+	Phase      RecoveryPhase       `json:"phase,omitempty"`
+	Stats      []RestoreStats      `json:"stats,omitempty"`
+	Conditions []RecoveryCondition `json:"conditions,omitempty"`
+}
+
+type RecoveryConditionType string
+
+const (
+	// RecoveryConditionDryRun describes the recovery job that would have been created.
+	RecoveryConditionDryRun RecoveryConditionType = "DryRun"
+	// RecoveryConditionJobCreated is True once the recovery job has been created.
+	RecoveryConditionJobCreated RecoveryConditionType = "JobCreated"
+	// RecoveryConditionComplete is True if the recovery job completed successfully.
+	RecoveryConditionComplete RecoveryConditionType = "Complete"
+	// RecoveryConditionFailed is True if the Recovery failed, the reason is given in the message.
+	RecoveryConditionFailed RecoveryConditionType = "Failed"
+)
+
+type RecoveryCondition struct {
+	Type               RecoveryConditionType `json:"type,omitempty"`
+	Status             core.ConditionStatus  `json:"status,omitempty"`
+	LastTransitionTime metav1.Time           `json:"lastTransitionTime,omitempty"`
+	Reason             string                `json:"reason,omitempty"`
+	Message            string                `json:"message,omitempty"`
 }
 
 type RestoreStats struct {
 	Path     string        `json:"path,omitempty"`
 	Phase    RecoveryPhase `json:"phase,omitempty"`
 	Duration string        `json:"duration,omitempty"`
+	// Number of files and bytes restored, unset if restic did not report them.
+	FilesRestored *int64 `json:"filesRestored,omitempty"`
+	BytesRestored *int64 `json:"bytesRestored,omitempty"`
+}
+
+// +k8s:openapi-gen=true
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// Snapshot is a read-only view of a restic snapshot in the repository of a Restic.
+// Snapshots are not stored in the cluster, they are read from the backend on request.
+type Snapshot struct {
+	metav1.TypeMeta   `json:",inline,omitempty"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+	Spec              SnapshotSpec   `json:"spec,omitempty"`
+	Status            SnapshotStatus `json:"status,omitempty"`
+}
+
+type SnapshotSpec struct {
+	// Name of the Restic whose repository contains the snapshot.
+	Restic string `json:"restic,omitempty"`
+	// Snapshot ID as reported by restic.
+	ID       string      `json:"id,omitempty"`
+	Time     metav1.Time `json:"time,omitempty"`
+	Hostname string      `json:"hostname,omitempty"`
+	Paths    []string    `json:"paths,omitempty"`
+	Tags     []string    `json:"tags,omitempty"`
+}
+
+type SnapshotStatus struct {
+	// Total size in bytes of the files in the snapshot. Zero if not known.
+	Size int64 `json:"size,omitempty"`
+}
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+type SnapshotList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []Snapshot `json:"items,omitempty"`
 }
@@ -2,10 +2,38 @@ package v1alpha1
 
 import (
 	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
 
 	"gopkg.in/robfig/cron.v2"
+	core "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
 )
 
+// Mount paths used by the stash sidecar for its scratch and podinfo volumes.
+var reservedMountPaths = []string{"/tmp", "/etc/stash"}
+
+// sidecarMountPaths returns the mount paths of the scratch, podinfo and, if set, cache volumes in
+// the sidecar of r.
+func (r Restic) sidecarMountPaths() []string {
+	paths := []string{r.GetScratchMountPath(), reservedMountPaths[1]}
+	if r.Spec.PodinfoMountPath != "" {
+		paths[1] = r.Spec.PodinfoMountPath
+	}
+	if p := r.GetCacheMountPath(); p != "" {
+		paths = append(paths, p)
+	}
+	return paths
+}
+
+// Environment variables of the stash sidecar that can not be overridden by spec.env.
+var reservedEnvNames = []string{"NODE_NAME", "POD_NAME", "REPOSITORY_PREFIX", "RESTIC_REPOSITORY"}
+
+// System directories of the sidecar image that must not be shadowed by the local backend volume
+// or the volumes mounted at the configurable mount paths of the sidecar.
+var reservedLocalPaths = []string{"/bin", "/dev", "/etc", "/lib", "/proc", "/sbin", "/sys", "/usr"}
+
 func (r Restic) IsValid() error {
 	for i, fg := range r.Spec.FileGroups {
 		if fg.RetentionPolicyName == "" {
@@ -31,23 +59,351 @@ func (r Restic) IsValid() error {
 	if r.Spec.Backend.StorageSecretName == "" {
 		return fmt.Errorf("missing repository secret name")
 	}
+	if p := r.Spec.ScratchMountPath; p != "" {
+		if err := validateLocalPath(p, nil); err != nil {
+			return fmt.Errorf("spec.scratchMountPath %s is invalid, %s", p, err)
+		}
+	}
+	// the default /etc/stash is below the reserved /etc, but does not shadow it
+	if p := r.Spec.PodinfoMountPath; p != "" && filepath.Clean(p) != reservedMountPaths[1] {
+		if err := validateLocalPath(p, nil); err != nil {
+			return fmt.Errorf("spec.podinfoMountPath %s is invalid, %s", p, err)
+		}
+	}
+	if paths := r.sidecarMountPaths(); pathsOverlap(paths[0], paths[1]) {
+		return fmt.Errorf("podinfo volume mount path %s overlaps with scratch volume mount path %s", paths[1], paths[0])
+	}
+	if cache := r.Spec.Cache; cache != nil {
+		if errs := validation.IsDNS1123Subdomain(cache.ClaimName); len(errs) > 0 {
+			return fmt.Errorf("spec.cache.claimName %q is invalid. Reason: %s", cache.ClaimName, strings.Join(errs, ", "))
+		}
+		if p := cache.MountPath; p != "" && (!filepath.IsAbs(p) || filepath.Clean(p) == "/") {
+			return fmt.Errorf("spec.cache.mountPath %s is invalid, must be an absolute path other than /", p)
+		}
+		paths := r.sidecarMountPaths()
+		for _, reserved := range paths[:2] {
+			if pathsOverlap(paths[2], reserved) {
+				return fmt.Errorf("cache volume mount path %s overlaps with %s reserved by stash sidecar", paths[2], reserved)
+			}
+		}
+	}
+	for i, p := range r.Spec.Excludes {
+		if strings.TrimSpace(p) == "" {
+			return fmt.Errorf("spec.excludes[%d] must not be an empty pattern", i)
+		}
+	}
+	if err := validateBackend("spec.backend", r.Spec.Backend, r.sidecarMountPaths()); err != nil {
+		return err
+	}
+	if mirror := r.Spec.Mirror; mirror != nil {
+		if mirror.StorageSecretName == "" {
+			return fmt.Errorf("missing spec.mirror.storageSecretName")
+		}
+		if err := validateBackend("spec.mirror", *mirror, r.sidecarMountPaths()); err != nil {
+			return err
+		}
+		if mirror.Local != nil && r.Spec.Backend.Local != nil && pathsOverlap(mirror.Local.Path, r.Spec.Backend.Local.Path) {
+			return fmt.Errorf("spec.mirror.local.path %s overlaps with spec.backend.local.path %s", mirror.Local.Path, r.Spec.Backend.Local.Path)
+		}
+	}
+	hasSelector := len(r.Spec.Selector.MatchLabels) > 0 || len(r.Spec.Selector.MatchExpressions) > 0
+	if r.Spec.SelectAll {
+		if hasSelector || r.Spec.Target != nil {
+			return fmt.Errorf("spec.selectAll can not be combined with spec.selector or spec.target")
+		}
+		// pods of offline backups are restarted by a kubectl cron job, it would restart all pods of the namespace
+		if r.Spec.Type == BackupOffline {
+			return fmt.Errorf("spec.selectAll is not supported for offline backup, use spec.selector")
+		}
+	} else if !hasSelector && r.Spec.Target == nil {
+		return fmt.Errorf("spec.selector is empty and would select all workloads in namespace %s, set spec.selectAll to back up all of them or spec.target to back up a single workload", r.Namespace)
+	} else if hasSelector && r.Spec.Target != nil {
+		return fmt.Errorf("exactly one of spec.selector and spec.target must be specified")
+	}
+	if r.Spec.Target != nil {
+		target := *r.Spec.Target
+		if err := target.Canonicalize(); err != nil {
+			return fmt.Errorf("spec.target is invalid. Reason: %s", err)
+		}
+		// pods of offline backups are restarted by a kubectl cron job using spec.selector
+		if r.Spec.Type == BackupOffline {
+			return fmt.Errorf("spec.target is not supported for offline backup, use spec.selector")
+		}
+	}
+	switch r.Spec.ImagePullPolicy {
+	case "", core.PullAlways, core.PullNever, core.PullIfNotPresent:
+	default:
+		return fmt.Errorf("spec.imagePullPolicy %s is invalid", r.Spec.ImagePullPolicy)
+	}
+	switch r.Spec.ScratchMedium {
+	case core.StorageMediumDefault, core.StorageMediumMemory:
+	default:
+		return fmt.Errorf("spec.scratchMedium %s is invalid", r.Spec.ScratchMedium)
+	}
+	if r.Spec.CheckInterval != nil && r.Spec.CheckInterval.Duration <= 0 {
+		return fmt.Errorf("spec.checkInterval %s is invalid", r.Spec.CheckInterval.Duration)
+	}
+	if r.Spec.LimitUpload != nil && *r.Spec.LimitUpload <= 0 {
+		return fmt.Errorf("spec.limitUpload %d is invalid, must be positive", *r.Spec.LimitUpload)
+	}
+	if r.Spec.LimitDownload != nil && *r.Spec.LimitDownload <= 0 {
+		return fmt.Errorf("spec.limitDownload %d is invalid, must be positive", *r.Spec.LimitDownload)
+	}
+	if r.Spec.Timeout != nil && r.Spec.Timeout.Duration < time.Minute {
+		return fmt.Errorf("spec.timeout %s is invalid, must be at least 1m", r.Spec.Timeout.Duration)
+	}
+	if hook := r.Spec.PreBackupHook; hook != nil {
+		if hook.Exec == nil || len(hook.Exec.Command) == 0 {
+			return fmt.Errorf("spec.preBackupHook.exec.command is missing")
+		}
+		if hook.TimeoutSeconds != nil && *hook.TimeoutSeconds <= 0 {
+			return fmt.Errorf("spec.preBackupHook.timeoutSeconds %d is invalid", *hook.TimeoutSeconds)
+		}
+	}
+	if r.Spec.PriorityClassName != "" {
+		if errs := validation.IsDNS1123Subdomain(r.Spec.PriorityClassName); len(errs) > 0 {
+			return fmt.Errorf("spec.priorityClassName %s is invalid: %s", r.Spec.PriorityClassName, strings.Join(errs, ", "))
+		}
+	}
+	for i, env := range r.Spec.Env {
+		if errs := validation.IsEnvVarName(env.Name); len(errs) > 0 {
+			return fmt.Errorf("spec.env[%d].name %s is invalid: %s", i, env.Name, strings.Join(errs, ", "))
+		}
+		for _, reserved := range reservedEnvNames {
+			if env.Name == reserved {
+				return fmt.Errorf("spec.env[%d].name %s is reserved by stash sidecar", i, env.Name)
+			}
+		}
+		// the password is read from exactly one source, chosen by spec.backend.passwordSource
+		if env.Name == "RESTIC_PASSWORD" || env.Name == "RESTIC_PASSWORD_FILE" {
+			return fmt.Errorf("spec.env[%d].name %s conflicts with spec.backend.passwordSource", i, env.Name)
+		}
+		if env.Name == "RESTIC_CACHE_DIR" && r.Spec.Cache != nil {
+			return fmt.Errorf("spec.env[%d].name %s conflicts with spec.cache", i, env.Name)
+		}
+	}
+	for i, src := range r.Spec.EnvFrom {
+		if err := validateEnvFromSource(src); err != nil {
+			return fmt.Errorf("spec.envFrom[%d] is invalid. Reason: %s", i, err)
+		}
+	}
+	for i, m := range r.Spec.VolumeMounts {
+		for _, reserved := range r.sidecarMountPaths() {
+			if pathsOverlap(m.MountPath, reserved) {
+				return fmt.Errorf("spec.volumeMounts[%d].mountPath %s overlaps with %s reserved by stash sidecar", i, m.MountPath, reserved)
+			}
+		}
+	}
 	return nil
 }
 
+// validateEnvFromSource checks that src refers to exactly one named ConfigMap or Secret.
+func validateEnvFromSource(src core.EnvFromSource) error {
+	var name string
+	switch {
+	case src.ConfigMapRef != nil && src.SecretRef != nil:
+		return fmt.Errorf("only one of configMapRef and secretRef may be set")
+	case src.ConfigMapRef != nil:
+		name = src.ConfigMapRef.Name
+	case src.SecretRef != nil:
+		name = src.SecretRef.Name
+	default:
+		return fmt.Errorf("one of configMapRef and secretRef must be set")
+	}
+	if errs := validation.IsDNS1123Subdomain(name); len(errs) > 0 {
+		return fmt.Errorf("name %q is invalid: %s", name, strings.Join(errs, ", "))
+	}
+	if src.Prefix != "" {
+		if errs := validation.IsEnvVarName(src.Prefix); len(errs) > 0 {
+			return fmt.Errorf("prefix %s is invalid: %s", src.Prefix, strings.Join(errs, ", "))
+		}
+	}
+	return nil
+}
+
+// validateBackend checks the backend at path of a Restic, whose sidecar mounts stash volumes at sidecarPaths.
+func validateBackend(path string, backend Backend, sidecarPaths []string) error {
+	switch backend.PasswordSource {
+	case "", PasswordSourceEnv, PasswordSourceFile:
+	default:
+		return fmt.Errorf("%s.passwordSource %s is invalid, must be %s or %s", path, backend.PasswordSource, PasswordSourceEnv, PasswordSourceFile)
+	}
+	if s3 := backend.S3; s3 != nil {
+		if s3.Endpoint == "" {
+			return fmt.Errorf("missing %s.s3.endpoint", path)
+		}
+		if s3.Bucket == "" {
+			return fmt.Errorf("missing %s.s3.bucket", path)
+		}
+	}
+	if sftp := backend.SFTP; sftp != nil {
+		if sftp.Host == "" {
+			return fmt.Errorf("missing %s.sftp.host", path)
+		}
+		if sftp.Path == "" {
+			return fmt.Errorf("missing %s.sftp.path", path)
+		}
+		if sftp.Port != 0 {
+			if errs := validation.IsValidPortNum(int(sftp.Port)); len(errs) > 0 {
+				return fmt.Errorf("%s.sftp.port %d is invalid. Reason: %s", path, sftp.Port, strings.Join(errs, ", "))
+			}
+		}
+		if errs := validation.IsDNS1123Subdomain(sftp.SSHSecretName); len(errs) > 0 {
+			return fmt.Errorf("%s.sftp.sshSecretName %q is invalid. Reason: %s", path, sftp.SSHSecretName, strings.Join(errs, ", "))
+		}
+	}
+	if raw := backend.Raw; raw != nil {
+		if raw.URL == "" {
+			return fmt.Errorf("missing %s.raw.url", path)
+		}
+		if raw.SecretName != "" {
+			if errs := validation.IsDNS1123Subdomain(raw.SecretName); len(errs) > 0 {
+				return fmt.Errorf("%s.raw.secretName %s is invalid. Reason: %s", path, raw.SecretName, strings.Join(errs, ", "))
+			}
+		}
+	}
+	if local := backend.Local; local != nil {
+		if err := validateLocalPath(local.Path, sidecarPaths); err != nil {
+			return fmt.Errorf("%s.local.path %s is invalid. Reason: %s", path, local.Path, err)
+		}
+	}
+	return nil
+}
+
+// validateLocalPath checks that a volume, e.g. the local backend, which is mounted at path in the sidecar, does not
+// hide the root or system directories of the sidecar or the volumes mounted by stash at sidecarPaths.
+func validateLocalPath(path string, sidecarPaths []string) error {
+	if !filepath.IsAbs(path) {
+		return fmt.Errorf("must be an absolute path")
+	}
+	if filepath.Clean(path) == "/" {
+		return fmt.Errorf("must not be /")
+	}
+	for _, reserved := range append(reservedLocalPaths, sidecarPaths...) {
+		if pathsOverlap(path, reserved) {
+			return fmt.Errorf("overlaps with reserved path %s", reserved)
+		}
+	}
+	return nil
+}
+
+// pathsOverlap returns true if a and b are the same path or one contains the other.
+func pathsOverlap(a, b string) bool {
+	a, b = filepath.Clean(a), filepath.Clean(b)
+	return a == b ||
+		strings.HasPrefix(a, strings.TrimSuffix(b, "/")+"/") ||
+		strings.HasPrefix(b, strings.TrimSuffix(a, "/")+"/")
+}
+
 func (r Recovery) IsValid() error {
 	if r.Spec.Restic == "" {
 		return fmt.Errorf("missing restic name")
 	}
+	if r.Spec.ResticNamespace != "" {
+		if errs := validation.IsDNS1123Label(r.Spec.ResticNamespace); len(errs) > 0 {
+			return fmt.Errorf("resticNamespace %s is invalid: %s", r.Spec.ResticNamespace, strings.Join(errs, ", "))
+		}
+	}
 	if len(r.Spec.Volumes) == 0 {
 		return fmt.Errorf("missing target vollume")
 	}
 
+	switch r.Spec.Snapshot {
+	case "":
+	case SnapshotLatest:
+		if r.Spec.SnapshotID != "" || r.Spec.Time != nil {
+			return fmt.Errorf("snapshot %s can't be combined with snapshotID or time", r.Spec.Snapshot)
+		}
+	default:
+		return fmt.Errorf("snapshot %s is invalid, only %s is supported", r.Spec.Snapshot, SnapshotLatest)
+	}
+	selectors := 0
+	if r.Spec.SnapshotID != "" {
+		selectors++
+	}
+	if len(r.Spec.Tags) > 0 {
+		selectors++
+	}
+	if r.Spec.Time != nil {
+		selectors++
+	}
+	if selectors > 1 {
+		return fmt.Errorf("at most one of snapshotID, tags and time can be specified")
+	}
+	if len(r.Spec.SourcePaths) > 0 && r.Spec.SnapshotID == "" && r.Spec.SourceHost == "" {
+		return fmt.Errorf("sourcePaths require snapshotID or sourceHost")
+	}
+	for _, p := range r.Spec.SourcePaths {
+		if !filepath.IsAbs(p) {
+			return fmt.Errorf("sourcePath %s must be an absolute path", p)
+		}
+	}
+	for _, p := range r.Spec.IncludePatterns {
+		if strings.TrimSpace(p) == "" {
+			return fmt.Errorf("includePatterns must not contain empty patterns")
+		}
+	}
+	for _, p := range r.Spec.ExcludePatterns {
+		if strings.TrimSpace(p) == "" {
+			return fmt.Errorf("excludePatterns must not contain empty patterns")
+		}
+	}
+	if len(r.Spec.IncludePatterns) > 0 && len(r.Spec.ExcludePatterns) > 0 {
+		return fmt.Errorf("includePatterns can't be used together with excludePatterns")
+	}
+
+	if r.Spec.NodeName != "" && r.Spec.Affinity != nil {
+		return fmt.Errorf("affinity can't be used together with nodeName")
+	}
+	if r.Spec.PriorityClassName != "" {
+		if errs := validation.IsDNS1123Subdomain(r.Spec.PriorityClassName); len(errs) > 0 {
+			return fmt.Errorf("priorityClassName %s is invalid: %s", r.Spec.PriorityClassName, strings.Join(errs, ", "))
+		}
+	}
+	if r.Spec.BackoffLimit != nil && *r.Spec.BackoffLimit < 0 {
+		return fmt.Errorf("backoffLimit must not be negative")
+	}
+	if r.Spec.ActiveDeadlineSeconds != nil && *r.Spec.ActiveDeadlineSeconds <= 0 {
+		return fmt.Errorf("activeDeadlineSeconds must be positive")
+	}
+	if r.Spec.TTLSecondsAfterFinished != nil && *r.Spec.TTLSecondsAfterFinished < 0 {
+		return fmt.Errorf("ttlSecondsAfterFinished must not be negative")
+	}
+	switch r.Spec.RestartPolicy {
+	case "", core.RestartPolicyOnFailure, core.RestartPolicyNever:
+	default:
+		return fmt.Errorf("restartPolicy %s is invalid, must be %s or %s", r.Spec.RestartPolicy, core.RestartPolicyOnFailure, core.RestartPolicyNever)
+	}
+	if r.Spec.ParallelVolumes && r.Spec.Target != nil {
+		return fmt.Errorf("parallelVolumes can't be used together with target")
+	}
+	if target := r.Spec.Target; target != nil {
+		found := false
+		for _, v := range r.Spec.Volumes {
+			if v.Name == target.Volume {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("target volume %q is not found in volumes", target.Volume)
+		}
+		if !filepath.IsAbs(target.MountPath) {
+			return fmt.Errorf("target mountPath %q must be an absolute path", target.MountPath)
+		}
+		for _, reserved := range reservedMountPaths {
+			if pathsOverlap(target.MountPath, reserved) {
+				return fmt.Errorf("target mountPath %s overlaps with %s reserved by stash", target.MountPath, reserved)
+			}
+		}
+	}
+
 	if err := r.Spec.Workload.Canonicalize(); err != nil {
 		return err
 	}
 
 	switch r.Spec.Workload.Kind {
-	case KindDeployment, KindReplicaSet, KindReplicationController:
+	case KindDeployment, KindReplicaSet, KindReplicationController, KindCronJob, KindDeploymentConfig, KindPod:
 		if r.Spec.PodOrdinal != "" || r.Spec.NodeName != "" {
 			return fmt.Errorf("should not specify podOrdinal/nodeSelector for workload kind %s", r.Spec.Workload.Kind)
 		}
@@ -0,0 +1,797 @@
+package v1alpha1
+
+import (
+	"testing"
+	"time"
+
+	core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestResticVolumeMountsReservedPaths(t *testing.T) {
+	cases := map[string]bool{
+		"/tmp":             false,
+		"/tmp/":            false,
+		"/tmp/data":        false,
+		"/etc/stash":       false,
+		"/etc":             false,
+		"/":                false,
+		"/source/data":     true,
+		"/tmpdata":         true,
+		"/etc/stash-extra": true,
+	}
+	for mountPath, valid := range cases {
+		r := Restic{
+			Spec: ResticSpec{
+				Selector: metav1.LabelSelector{MatchLabels: map[string]string{"app": "stash-demo"}},
+				Schedule: "@every 1m",
+				Backend: Backend{
+					StorageSecretName: "secret",
+				},
+				VolumeMounts: []core.VolumeMount{
+					{Name: "source-data", MountPath: mountPath},
+				},
+			},
+		}
+		err := r.IsValid()
+		if valid && err != nil {
+			t.Errorf("mountPath %s: unexpected error: %s", mountPath, err)
+		} else if !valid && err == nil {
+			t.Errorf("mountPath %s: expected collision with reserved path", mountPath)
+		}
+	}
+}
+
+func TestResticLocalBackendPath(t *testing.T) {
+	cases := map[string]bool{
+		"":                  false,
+		"safe/data":         false,
+		"./data":            false,
+		"/":                 false,
+		"//":                false,
+		"/etc":              false,
+		"/etc/backup":       false,
+		"/usr/local/backup": false,
+		"/tmp/repo":         false,
+		"/safe/data":        true,
+		"/repository/":      true,
+		"/etcd-backup":      true,
+	}
+	for path, valid := range cases {
+		r := Restic{
+			Spec: ResticSpec{
+				Selector: metav1.LabelSelector{MatchLabels: map[string]string{"app": "stash-demo"}},
+				Schedule: "@every 1m",
+				Backend: Backend{
+					StorageSecretName: "secret",
+					Local: &LocalSpec{
+						VolumeSource: core.VolumeSource{HostPath: &core.HostPathVolumeSource{Path: "/data/stash"}},
+						Path:         path,
+					},
+				},
+			},
+		}
+		err := r.IsValid()
+		if valid && err != nil {
+			t.Errorf("path %q: unexpected error: %s", path, err)
+		} else if !valid && err == nil {
+			t.Errorf("path %q: expected error", path)
+		}
+	}
+}
+
+func TestResticEnv(t *testing.T) {
+	secretRef := func(name string) core.EnvFromSource {
+		return core.EnvFromSource{SecretRef: &core.SecretEnvSource{LocalObjectReference: core.LocalObjectReference{Name: name}}}
+	}
+	cases := map[string]struct {
+		env     []core.EnvVar
+		envFrom []core.EnvFromSource
+		valid   bool
+	}{
+		"none":             {nil, nil, true},
+		"env":              {[]core.EnvVar{{Name: "AWS_ACCESS_KEY_ID", Value: "key"}}, nil, true},
+		"invalid env name": {[]core.EnvVar{{Name: "1KEY"}}, nil, false},
+		"reserved env":     {[]core.EnvVar{{Name: "RESTIC_REPOSITORY", Value: "s3:other"}}, nil, false},
+		"secret":           {nil, []core.EnvFromSource{secretRef("aws-credentials")}, true},
+		"configmap": {nil, []core.EnvFromSource{{
+			Prefix:       "BACKUP_",
+			ConfigMapRef: &core.ConfigMapEnvSource{LocalObjectReference: core.LocalObjectReference{Name: "backup-config"}},
+		}}, true},
+		"invalid name":   {nil, []core.EnvFromSource{secretRef("AWS Credentials")}, false},
+		"missing name":   {nil, []core.EnvFromSource{secretRef("")}, false},
+		"missing source": {nil, []core.EnvFromSource{{Prefix: "AWS_"}}, false},
+		"invalid prefix": {nil, []core.EnvFromSource{{Prefix: "1_", SecretRef: secretRef("aws-credentials").SecretRef}}, false},
+		"both sources": {nil, []core.EnvFromSource{{
+			SecretRef:    secretRef("aws-credentials").SecretRef,
+			ConfigMapRef: &core.ConfigMapEnvSource{LocalObjectReference: core.LocalObjectReference{Name: "backup-config"}},
+		}}, false},
+	}
+	for name, c := range cases {
+		r := Restic{
+			Spec: ResticSpec{
+				Selector: metav1.LabelSelector{MatchLabels: map[string]string{"app": "stash-demo"}},
+				Schedule: "@every 1m",
+				Backend:  Backend{StorageSecretName: "secret"},
+				Env:      c.env,
+				EnvFrom:  c.envFrom,
+			},
+		}
+		err := r.IsValid()
+		if c.valid && err != nil {
+			t.Errorf("%s: unexpected error: %s", name, err)
+		} else if !c.valid && err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
+
+func TestResticPasswordSource(t *testing.T) {
+	cases := map[string]struct {
+		source PasswordSource
+		env    []core.EnvVar
+		valid  bool
+	}{
+		"default":        {"", nil, true},
+		"env":            {PasswordSourceEnv, nil, true},
+		"file":           {PasswordSourceFile, nil, true},
+		"invalid source": {"Vault", nil, false},
+		"password env":   {PasswordSourceFile, []core.EnvVar{{Name: "RESTIC_PASSWORD", Value: "changeit"}}, false},
+		"password file":  {PasswordSourceEnv, []core.EnvVar{{Name: "RESTIC_PASSWORD_FILE", Value: "/etc/password"}}, false},
+	}
+	for name, c := range cases {
+		r := Restic{
+			Spec: ResticSpec{
+				Selector: metav1.LabelSelector{MatchLabels: map[string]string{"app": "stash-demo"}},
+				Schedule: "@every 1m",
+				Backend:  Backend{StorageSecretName: "secret", PasswordSource: c.source},
+				Env:      c.env,
+			},
+		}
+		err := r.IsValid()
+		if c.valid && err != nil {
+			t.Errorf("%s: unexpected error: %s", name, err)
+		} else if !c.valid && err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
+
+func TestResticPodinfoMountPath(t *testing.T) {
+	cases := map[string]struct {
+		podinfoMountPath string
+		volumeMountPath  string
+		valid            bool
+	}{
+		"default":              {"", "/source/data", true},
+		"custom":               {"/var/run/stash", "/source/data", true},
+		"relative":             {"var/run/stash", "/source/data", false},
+		"root":                 {"/", "/source/data", false},
+		"scratch dir":          {"/tmp/podinfo", "/source/data", false},
+		"overlaps volumeMount": {"/source/data/podinfo", "/source/data", false},
+		"default path freed":   {"/var/run/stash", "/etc/stash", true},
+		"explicit default":     {"/etc/stash", "/source/data", true},
+		"binaries":             {"/bin", "/source/data", false},
+		"CA certificates":      {"/etc", "/source/data", false},
+		"below system dir":     {"/usr/share/podinfo", "/source/data", false},
+	}
+	for name, c := range cases {
+		r := Restic{
+			Spec: ResticSpec{
+				Selector:         metav1.LabelSelector{MatchLabels: map[string]string{"app": "stash-demo"}},
+				Schedule:         "@every 1m",
+				Backend:          Backend{StorageSecretName: "secret"},
+				VolumeMounts:     []core.VolumeMount{{Name: "source-data", MountPath: c.volumeMountPath}},
+				PodinfoMountPath: c.podinfoMountPath,
+			},
+		}
+		err := r.IsValid()
+		if c.valid && err != nil {
+			t.Errorf("%s: unexpected error: %s", name, err)
+		} else if !c.valid && err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
+
+func TestResticScratchMountPath(t *testing.T) {
+	cases := map[string]struct {
+		scratchMountPath string
+		volumeMountPath  string
+		valid            bool
+	}{
+		"default":              {"", "/source/data", true},
+		"custom":               {"/var/cache/stash", "/source/data", true},
+		"relative":             {"var/cache/stash", "/source/data", false},
+		"root":                 {"/", "/source/data", false},
+		"podinfo dir":          {"/etc/stash/scratch", "/source/data", false},
+		"overlaps volumeMount": {"/source/data/scratch", "/source/data", false},
+		"default path freed":   {"/var/cache/stash", "/tmp", true},
+		"explicit default":     {"/tmp", "/source/data", true},
+		"binaries":             {"/bin", "/source/data", false},
+		"CA certificates":      {"/etc", "/source/data", false},
+		"below system dir":     {"/usr/local/cache", "/source/data", false},
+	}
+	for name, c := range cases {
+		r := Restic{
+			Spec: ResticSpec{
+				Selector:         metav1.LabelSelector{MatchLabels: map[string]string{"app": "stash-demo"}},
+				Schedule:         "@every 1m",
+				Backend:          Backend{StorageSecretName: "secret"},
+				VolumeMounts:     []core.VolumeMount{{Name: "source-data", MountPath: c.volumeMountPath}},
+				ScratchMountPath: c.scratchMountPath,
+			},
+		}
+		err := r.IsValid()
+		if c.valid && err != nil {
+			t.Errorf("%s: unexpected error: %s", name, err)
+		} else if !c.valid && err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
+
+func TestResticCache(t *testing.T) {
+	cases := map[string]struct {
+		cache *CacheSpec
+		env   []core.EnvVar
+		valid bool
+	}{
+		"not set":              {nil, nil, true},
+		"default mount path":   {&CacheSpec{ClaimName: "restic-cache"}, nil, true},
+		"custom mount path":    {&CacheSpec{ClaimName: "restic-cache", MountPath: "/cache"}, nil, true},
+		"missing claim":        {&CacheSpec{}, nil, false},
+		"relative":             {&CacheSpec{ClaimName: "restic-cache", MountPath: "cache"}, nil, false},
+		"root":                 {&CacheSpec{ClaimName: "restic-cache", MountPath: "/"}, nil, false},
+		"scratch dir":          {&CacheSpec{ClaimName: "restic-cache", MountPath: "/tmp/cache"}, nil, false},
+		"overlaps volumeMount": {&CacheSpec{ClaimName: "restic-cache", MountPath: "/source/data/cache"}, nil, false},
+		"env without cache":    {nil, []core.EnvVar{{Name: "RESTIC_CACHE_DIR", Value: "/cache"}}, true},
+		"env with cache":       {&CacheSpec{ClaimName: "restic-cache"}, []core.EnvVar{{Name: "RESTIC_CACHE_DIR", Value: "/cache"}}, false},
+	}
+	for name, c := range cases {
+		r := Restic{
+			Spec: ResticSpec{
+				Selector:     metav1.LabelSelector{MatchLabels: map[string]string{"app": "stash-demo"}},
+				Schedule:     "@every 1m",
+				Backend:      Backend{StorageSecretName: "secret"},
+				VolumeMounts: []core.VolumeMount{{Name: "source-data", MountPath: "/source/data"}},
+				Cache:        c.cache,
+				Env:          c.env,
+			},
+		}
+		err := r.IsValid()
+		if c.valid && err != nil {
+			t.Errorf("%s: unexpected error: %s", name, err)
+		} else if !c.valid && err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
+
+func TestResticExcludes(t *testing.T) {
+	cases := map[string]struct {
+		excludes []string
+		valid    bool
+	}{
+		"not set":       {nil, true},
+		"patterns":      {[]string{"*.tmp", "/source/data/logs"}, true},
+		"empty pattern": {[]string{"*.tmp", ""}, false},
+		"blank pattern": {[]string{"  "}, false},
+	}
+	for name, c := range cases {
+		r := Restic{
+			Spec: ResticSpec{
+				Selector:      metav1.LabelSelector{MatchLabels: map[string]string{"app": "stash-demo"}},
+				Schedule:      "@every 1m",
+				Backend:       Backend{StorageSecretName: "secret"},
+				Excludes:      c.excludes,
+				ExcludeCaches: true,
+			},
+		}
+		err := r.IsValid()
+		if c.valid && err != nil {
+			t.Errorf("%s: unexpected error: %s", name, err)
+		} else if !c.valid && err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
+
+func TestResticS3Backend(t *testing.T) {
+	cases := map[string]struct {
+		s3    S3Spec
+		valid bool
+	}{
+		"valid":            {S3Spec{Endpoint: "s3.amazonaws.com", Bucket: "stash", Prefix: "demo"}, true},
+		"minio":            {S3Spec{Endpoint: "http://minio:9000", Bucket: "stash"}, true},
+		"missing endpoint": {S3Spec{Bucket: "stash"}, false},
+		"missing bucket":   {S3Spec{Endpoint: "s3.amazonaws.com"}, false},
+	}
+	for name, c := range cases {
+		s3 := c.s3
+		r := Restic{
+			Spec: ResticSpec{
+				Selector: metav1.LabelSelector{MatchLabels: map[string]string{"app": "stash-demo"}},
+				Schedule: "@every 1m",
+				Backend:  Backend{StorageSecretName: "secret", S3: &s3},
+			},
+		}
+		err := r.IsValid()
+		if c.valid && err != nil {
+			t.Errorf("%s: unexpected error: %s", name, err)
+		} else if !c.valid && err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
+
+func TestResticSFTPBackend(t *testing.T) {
+	cases := map[string]struct {
+		sftp  SFTPSpec
+		valid bool
+	}{
+		"valid":              {SFTPSpec{Host: "nas", User: "backup", Path: "/srv/restic", SSHSecretName: "sftp-ssh"}, true},
+		"port":               {SFTPSpec{Host: "nas", Port: 2222, Path: "restic", SSHSecretName: "sftp-ssh"}, true},
+		"missing host":       {SFTPSpec{Path: "/srv/restic", SSHSecretName: "sftp-ssh"}, false},
+		"missing path":       {SFTPSpec{Host: "nas", SSHSecretName: "sftp-ssh"}, false},
+		"invalid port":       {SFTPSpec{Host: "nas", Port: 70000, Path: "/srv/restic", SSHSecretName: "sftp-ssh"}, false},
+		"missing ssh secret": {SFTPSpec{Host: "nas", Path: "/srv/restic"}, false},
+	}
+	for name, c := range cases {
+		sftp := c.sftp
+		r := Restic{
+			Spec: ResticSpec{
+				Selector: metav1.LabelSelector{MatchLabels: map[string]string{"app": "stash-demo"}},
+				Schedule: "@every 1m",
+				Backend:  Backend{StorageSecretName: "secret", SFTP: &sftp},
+			},
+		}
+		err := r.IsValid()
+		if c.valid && err != nil {
+			t.Errorf("%s: unexpected error: %s", name, err)
+		} else if !c.valid && err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
+
+func TestResticRawBackend(t *testing.T) {
+	cases := map[string]struct {
+		raw   RawSpec
+		valid bool
+	}{
+		"url":                {RawSpec{URL: "sftp:backup@nas:/srv/restic"}, true},
+		"url and secret":     {RawSpec{URL: "rclone:remote:stash", SecretName: "rclone-config"}, true},
+		"missing url":        {RawSpec{SecretName: "rclone-config"}, false},
+		"invalid secretName": {RawSpec{URL: "rclone:remote:stash", SecretName: "Rclone Config"}, false},
+	}
+	for name, c := range cases {
+		raw := c.raw
+		r := Restic{
+			Spec: ResticSpec{
+				Selector: metav1.LabelSelector{MatchLabels: map[string]string{"app": "stash-demo"}},
+				Schedule: "@every 1m",
+				Backend:  Backend{StorageSecretName: "secret", Raw: &raw},
+			},
+		}
+		err := r.IsValid()
+		if c.valid && err != nil {
+			t.Errorf("%s: unexpected error: %s", name, err)
+		} else if !c.valid && err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
+
+func TestResticMirror(t *testing.T) {
+	local := func(path string) *LocalSpec {
+		return &LocalSpec{VolumeSource: core.VolumeSource{EmptyDir: &core.EmptyDirVolumeSource{}}, Path: path}
+	}
+	cases := map[string]struct {
+		mirror Backend
+		valid  bool
+	}{
+		"s3":             {Backend{StorageSecretName: "mirror", S3: &S3Spec{Endpoint: "s3.amazonaws.com", Bucket: "stash"}}, true},
+		"local":          {Backend{StorageSecretName: "mirror", Local: local("/mirror")}, true},
+		"missing secret": {Backend{S3: &S3Spec{Endpoint: "s3.amazonaws.com", Bucket: "stash"}}, false},
+		"same path":      {Backend{StorageSecretName: "mirror", Local: local("/repository/mirror")}, false},
+		"reserved path":  {Backend{StorageSecretName: "mirror", Local: local("/tmp/mirror")}, false},
+		"missing url":    {Backend{StorageSecretName: "mirror", Raw: &RawSpec{}}, false},
+		"password":       {Backend{StorageSecretName: "mirror", PasswordSource: "Vault", Local: local("/mirror")}, false},
+	}
+	for name, c := range cases {
+		mirror := c.mirror
+		r := Restic{
+			Spec: ResticSpec{
+				Selector: metav1.LabelSelector{MatchLabels: map[string]string{"app": "stash-demo"}},
+				Schedule: "@every 1m",
+				Backend:  Backend{StorageSecretName: "secret", Local: local("/repository")},
+				Mirror:   &mirror,
+			},
+		}
+		err := r.IsValid()
+		if c.valid && err != nil {
+			t.Errorf("%s: unexpected error: %s", name, err)
+		} else if !c.valid && err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
+
+func TestRecoverySnapshotSelection(t *testing.T) {
+	now := metav1.Now()
+	cases := map[string]struct {
+		spec  RecoverySpec
+		valid bool
+	}{
+		"latest":        {RecoverySpec{}, true},
+		"snapshot":      {RecoverySpec{SnapshotID: "4bba301e"}, true},
+		"tags":          {RecoverySpec{Tags: []string{"daily"}}, true},
+		"time":          {RecoverySpec{Time: &now}, true},
+		"snapshot+tags": {RecoverySpec{SnapshotID: "4bba301e", Tags: []string{"daily"}}, false},
+		"snapshot+time": {RecoverySpec{SnapshotID: "4bba301e", Time: &now}, false},
+		"tags+time":     {RecoverySpec{Tags: []string{"daily"}, Time: &now}, false},
+		"all":           {RecoverySpec{SnapshotID: "4bba301e", Tags: []string{"daily"}, Time: &now}, false},
+		"latest+tags":   {RecoverySpec{Snapshot: SnapshotLatest, Tags: []string{"prod"}}, true},
+		"latest+id":     {RecoverySpec{Snapshot: SnapshotLatest, SnapshotID: "4bba301e"}, false},
+		"latest+time":   {RecoverySpec{Snapshot: SnapshotLatest, Time: &now}, false},
+		"unknown":       {RecoverySpec{Snapshot: "oldest"}, false},
+	}
+	for name, c := range cases {
+		r := Recovery{Spec: c.spec}
+		r.Spec.Restic = "stash-demo"
+		r.Spec.Workload = LocalTypedReference{Kind: KindDeployment, Name: "stash-demo"}
+		r.Spec.Volumes = []core.Volume{{Name: "source-data"}}
+		err := r.IsValid()
+		if c.valid && err != nil {
+			t.Errorf("%s: unexpected error: %s", name, err)
+		} else if !c.valid && err == nil {
+			t.Errorf("%s: expected error for invalid snapshot selection", name)
+		}
+	}
+}
+
+func TestRecoverySource(t *testing.T) {
+	cases := map[string]struct {
+		snapshotID string
+		host       string
+		paths      []string
+		valid      bool
+	}{
+		"none":           {"", "", nil, true},
+		"host":           {"", "node-1", nil, true},
+		"paths and host": {"", "node-1", []string{"/source/data"}, true},
+		"paths and id":   {"c3d1b4e2", "", []string{"/source/data"}, true},
+		"paths only":     {"", "", []string{"/source/data"}, false},
+		"relative path":  {"", "node-1", []string{"source/data"}, false},
+	}
+	for name, c := range cases {
+		r := Recovery{}
+		r.Spec.Restic = "stash-demo"
+		r.Spec.Workload = LocalTypedReference{Kind: KindDeployment, Name: "stash-demo"}
+		r.Spec.Volumes = []core.Volume{{Name: "source-data"}}
+		r.Spec.SnapshotID = c.snapshotID
+		r.Spec.SourceHost = c.host
+		r.Spec.SourcePaths = c.paths
+		err := r.IsValid()
+		if c.valid && err != nil {
+			t.Errorf("%s: unexpected error: %s", name, err)
+		} else if !c.valid && err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
+
+func TestRecoveryPatterns(t *testing.T) {
+	cases := map[string]struct {
+		spec  RecoverySpec
+		valid bool
+	}{
+		"none":          {RecoverySpec{}, true},
+		"include":       {RecoverySpec{IncludePatterns: []string{"/source/data/*.sql"}}, true},
+		"exclude":       {RecoverySpec{ExcludePatterns: []string{"*.tmp"}}, true},
+		"empty include": {RecoverySpec{IncludePatterns: []string{"/source/data", ""}}, false},
+		"blank exclude": {RecoverySpec{ExcludePatterns: []string{"  "}}, false},
+		"both":          {RecoverySpec{IncludePatterns: []string{"/source/data"}, ExcludePatterns: []string{"*.tmp"}}, false},
+	}
+	for name, c := range cases {
+		r := Recovery{Spec: c.spec}
+		r.Spec.Restic = "stash-demo"
+		r.Spec.Workload = LocalTypedReference{Kind: KindDeployment, Name: "stash-demo"}
+		r.Spec.Volumes = []core.Volume{{Name: "source-data"}}
+		err := r.IsValid()
+		if c.valid && err != nil {
+			t.Errorf("%s: unexpected error: %s", name, err)
+		} else if !c.valid && err == nil {
+			t.Errorf("%s: expected error for invalid patterns", name)
+		}
+	}
+}
+
+func TestRecoveryTarget(t *testing.T) {
+	cases := map[string]struct {
+		target *RecoveryTarget
+		valid  bool
+	}{
+		"none":             {nil, true},
+		"valid":            {&RecoveryTarget{Volume: "staging", MountPath: "/restore"}, true},
+		"missing volume":   {&RecoveryTarget{Volume: "other", MountPath: "/restore"}, false},
+		"relative path":    {&RecoveryTarget{Volume: "staging", MountPath: "restore"}, false},
+		"reserved path":    {&RecoveryTarget{Volume: "staging", MountPath: "/tmp/restore"}, false},
+		"empty mount path": {&RecoveryTarget{Volume: "staging"}, false},
+	}
+	for name, c := range cases {
+		r := Recovery{}
+		r.Spec.Restic = "stash-demo"
+		r.Spec.Workload = LocalTypedReference{Kind: KindDeployment, Name: "stash-demo"}
+		r.Spec.Volumes = []core.Volume{{Name: "source-data"}, {Name: "staging"}}
+		r.Spec.Target = c.target
+		err := r.IsValid()
+		if c.valid && err != nil {
+			t.Errorf("%s: unexpected error: %s", name, err)
+		} else if !c.valid && err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+		r.Spec.ParallelVolumes = true
+		if err = r.IsValid(); c.target != nil && err == nil {
+			t.Errorf("%s: expected error for parallelVolumes with target", name)
+		} else if c.target == nil && err != nil {
+			t.Errorf("%s: unexpected error for parallelVolumes: %s", name, err)
+		}
+	}
+}
+
+func TestRecoveryAffinity(t *testing.T) {
+	r := Recovery{}
+	r.Spec.Restic = "stash-demo"
+	r.Spec.Workload = LocalTypedReference{Kind: KindDeployment, Name: "stash-demo"}
+	r.Spec.Volumes = []core.Volume{{Name: "source-data"}}
+	r.Spec.Affinity = &core.Affinity{NodeAffinity: &core.NodeAffinity{}}
+	if err := r.IsValid(); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+	r.Spec.NodeName = "node-1"
+	if err := r.IsValid(); err == nil {
+		t.Error("expected error for affinity with nodeName")
+	}
+}
+
+func TestRecoveryResticNamespace(t *testing.T) {
+	cases := map[string]bool{
+		"":        true,
+		"backup":  true,
+		"Backup":  false,
+		"backup/": false,
+	}
+	for ns, valid := range cases {
+		r := Recovery{}
+		r.Spec.Restic = "stash-demo"
+		r.Spec.ResticNamespace = ns
+		r.Spec.Workload = LocalTypedReference{Kind: KindDeployment, Name: "stash-demo"}
+		r.Spec.Volumes = []core.Volume{{Name: "source-data"}}
+		err := r.IsValid()
+		if valid && err != nil {
+			t.Errorf("%q: unexpected error: %s", ns, err)
+		} else if !valid && err == nil {
+			t.Errorf("%q: expected error", ns)
+		}
+	}
+}
+
+func TestRecoveryRestartPolicy(t *testing.T) {
+	cases := map[core.RestartPolicy]bool{
+		"":                          true,
+		core.RestartPolicyOnFailure: true,
+		core.RestartPolicyNever:     true,
+		core.RestartPolicyAlways:    false,
+	}
+	for policy, valid := range cases {
+		r := Recovery{}
+		r.Spec.Restic = "stash-demo"
+		r.Spec.Workload = LocalTypedReference{Kind: KindDeployment, Name: "stash-demo"}
+		r.Spec.Volumes = []core.Volume{{Name: "source-data"}}
+		r.Spec.RestartPolicy = policy
+		err := r.IsValid()
+		if valid && err != nil {
+			t.Errorf("%q: unexpected error: %s", policy, err)
+		} else if !valid && err == nil {
+			t.Errorf("%q: expected error", policy)
+		}
+	}
+}
+
+func TestRecoveryJobLimits(t *testing.T) {
+	negative, zero, positive := int32(-1), int64(0), int64(60)
+	cases := map[string]struct {
+		spec  RecoverySpec
+		valid bool
+	}{
+		"defaults":         {RecoverySpec{}, true},
+		"limits":           {RecoverySpec{BackoffLimit: new(int32), ActiveDeadlineSeconds: &positive}, true},
+		"negative backoff": {RecoverySpec{BackoffLimit: &negative}, false},
+		"zero deadline":    {RecoverySpec{ActiveDeadlineSeconds: &zero}, false},
+		"ttl":              {RecoverySpec{TTLSecondsAfterFinished: new(int32)}, true},
+		"negative ttl":     {RecoverySpec{TTLSecondsAfterFinished: &negative}, false},
+	}
+	for name, c := range cases {
+		r := Recovery{Spec: c.spec}
+		r.Spec.Restic = "stash-demo"
+		r.Spec.Workload = LocalTypedReference{Kind: KindDeployment, Name: "stash-demo"}
+		r.Spec.Volumes = []core.Volume{{Name: "source-data"}}
+		err := r.IsValid()
+		if c.valid && err != nil {
+			t.Errorf("%s: unexpected error: %s", name, err)
+		} else if !c.valid && err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
+
+func TestResticPreBackupHook(t *testing.T) {
+	zero, timeout := int32(0), int32(30)
+	cases := map[string]struct {
+		hook  *BackupHook
+		valid bool
+	}{
+		"no hook":         {nil, true},
+		"command":         {&BackupHook{Exec: &core.ExecAction{Command: []string{"sync"}}, TimeoutSeconds: &timeout}, true},
+		"missing exec":    {&BackupHook{}, false},
+		"missing command": {&BackupHook{Exec: &core.ExecAction{}}, false},
+		"zero timeout":    {&BackupHook{Exec: &core.ExecAction{Command: []string{"sync"}}, TimeoutSeconds: &zero}, false},
+	}
+	for name, c := range cases {
+		r := Restic{
+			Spec: ResticSpec{
+				Selector:      metav1.LabelSelector{MatchLabels: map[string]string{"app": "stash-demo"}},
+				Schedule:      "@every 1m",
+				Backend:       Backend{StorageSecretName: "secret"},
+				PreBackupHook: c.hook,
+			},
+		}
+		err := r.IsValid()
+		if c.valid && err != nil {
+			t.Errorf("%s: unexpected error: %s", name, err)
+		} else if !c.valid && err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
+
+func TestPriorityClassName(t *testing.T) {
+	cases := map[string]bool{
+		"":              true,
+		"high-priority": true,
+		"High_Priority": false,
+		"-critical":     false,
+	}
+	for name, valid := range cases {
+		r := Restic{
+			Spec: ResticSpec{
+				Selector:          metav1.LabelSelector{MatchLabels: map[string]string{"app": "stash-demo"}},
+				Schedule:          "@every 1m",
+				Backend:           Backend{StorageSecretName: "secret"},
+				PriorityClassName: name,
+			},
+		}
+		rec := Recovery{
+			Spec: RecoverySpec{
+				Restic:            "stash-demo",
+				Workload:          LocalTypedReference{Kind: KindDeployment, Name: "stash-demo"},
+				Volumes:           []core.Volume{{Name: "source-data"}},
+				PriorityClassName: name,
+			},
+		}
+		for kind, err := range map[string]error{"restic": r.IsValid(), "recovery": rec.IsValid()} {
+			if valid && err != nil {
+				t.Errorf("%s priorityClassName %q: unexpected error: %s", kind, name, err)
+			} else if !valid && err == nil {
+				t.Errorf("%s priorityClassName %q: expected error", kind, name)
+			}
+		}
+	}
+}
+
+func TestResticCheckInterval(t *testing.T) {
+	cases := map[string]bool{
+		"24h": true,
+		"0s":  false,
+		"-1h": false,
+	}
+	for interval, valid := range cases {
+		d, _ := time.ParseDuration(interval)
+		r := Restic{
+			Spec: ResticSpec{
+				Selector: metav1.LabelSelector{MatchLabels: map[string]string{"app": "stash-demo"}},
+				Schedule: "@every 1m",
+				Backend: Backend{
+					StorageSecretName: "secret",
+				},
+				CheckInterval: &metav1.Duration{Duration: d},
+			},
+		}
+		err := r.IsValid()
+		if valid && err != nil {
+			t.Errorf("checkInterval %s: unexpected error: %s", interval, err)
+		} else if !valid && err == nil {
+			t.Errorf("checkInterval %s: expected error", interval)
+		}
+	}
+}
+
+func TestResticSelectorOrTarget(t *testing.T) {
+	selector := metav1.LabelSelector{MatchLabels: map[string]string{"app": "stash-demo"}}
+	cases := map[string]struct {
+		selector   metav1.LabelSelector
+		target     *LocalTypedReference
+		selectAll  bool
+		backupType BackupType
+		valid      bool
+	}{
+		"selector":             {selector, nil, false, BackupOnline, true},
+		"target":               {metav1.LabelSelector{}, &LocalTypedReference{Kind: "deploy", Name: "stash-demo"}, false, BackupOnline, true},
+		"neither":              {metav1.LabelSelector{}, nil, false, BackupOnline, false},
+		"both":                 {selector, &LocalTypedReference{Kind: KindDeployment, Name: "stash-demo"}, false, BackupOnline, false},
+		"target no name":       {metav1.LabelSelector{}, &LocalTypedReference{Kind: KindDeployment}, false, BackupOnline, false},
+		"target bad kind":      {metav1.LabelSelector{}, &LocalTypedReference{Kind: "Foobar", Name: "stash-demo"}, false, BackupOnline, false},
+		"target offline":       {metav1.LabelSelector{}, &LocalTypedReference{Kind: KindDeployment, Name: "stash-demo"}, false, BackupOffline, false},
+		"selector offline":     {selector, nil, false, BackupOffline, true},
+		"selectAll":            {metav1.LabelSelector{}, nil, true, BackupOnline, true},
+		"selectAll selector":   {selector, nil, true, BackupOnline, false},
+		"selectAll target":     {metav1.LabelSelector{}, &LocalTypedReference{Kind: KindDeployment, Name: "stash-demo"}, true, BackupOnline, false},
+		"selectAll offline":    {metav1.LabelSelector{}, nil, true, BackupOffline, false},
+		"empty matchLabels":    {metav1.LabelSelector{MatchLabels: map[string]string{}}, nil, false, BackupOnline, false},
+		"selectAll matchLabel": {metav1.LabelSelector{MatchLabels: map[string]string{}}, nil, true, BackupOnline, true},
+	}
+	for name, c := range cases {
+		r := Restic{
+			Spec: ResticSpec{
+				Selector:  c.selector,
+				Target:    c.target,
+				SelectAll: c.selectAll,
+				Schedule:  "@every 1m",
+				Backend:   Backend{StorageSecretName: "secret"},
+				Type:      c.backupType,
+			},
+		}
+		err := r.IsValid()
+		if c.valid && err != nil {
+			t.Errorf("%s: unexpected error: %s", name, err)
+		} else if !c.valid && err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
+
+func TestResticLimits(t *testing.T) {
+	zero, positive := int32(0), int32(1024)
+	cases := map[string]struct {
+		upload   *int32
+		download *int32
+		timeout  *metav1.Duration
+		valid    bool
+	}{
+		"defaults":      {nil, nil, nil, true},
+		"limits":        {&positive, &positive, &metav1.Duration{Duration: time.Hour}, true},
+		"zero upload":   {&zero, nil, nil, false},
+		"zero download": {nil, &zero, nil, false},
+		"short timeout": {nil, nil, &metav1.Duration{Duration: time.Second}, false},
+	}
+	for name, c := range cases {
+		r := Restic{
+			Spec: ResticSpec{
+				Selector:      metav1.LabelSelector{MatchLabels: map[string]string{"app": "stash-demo"}},
+				Schedule:      "@every 1m",
+				Backend:       Backend{StorageSecretName: "secret"},
+				LimitUpload:   c.upload,
+				LimitDownload: c.download,
+				Timeout:       c.timeout,
+			},
+		}
+		err := r.IsValid()
+		if c.valid && err != nil {
+			t.Errorf("%s: unexpected error: %s", name, err)
+		} else if !c.valid && err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
@@ -11,6 +11,9 @@ const (
 	KindReplicationController = "ReplicationController"
 	KindStatefulSet           = "StatefulSet"
 	KindDaemonSet             = "DaemonSet"
+	KindCronJob               = "CronJob"
+	KindDeploymentConfig      = "DeploymentConfig"
+	KindPod                   = "Pod"
 )
 
 func (workload *LocalTypedReference) Canonicalize() error {
@@ -28,6 +31,12 @@ func (workload *LocalTypedReference) Canonicalize() error {
 		workload.Kind = KindStatefulSet
 	case "daemonsets", "daemonset", "ds":
 		workload.Kind = KindDaemonSet
+	case "cronjobs", "cronjob", "cj":
+		workload.Kind = KindCronJob
+	case "deploymentconfigs", "deploymentconfig", "dc":
+		workload.Kind = KindDeploymentConfig
+	case "pods", "pod", "po":
+		workload.Kind = KindPod
 	default:
 		return fmt.Errorf(`unrecognized workload "Kind" %v`, workload.Kind)
 	}
@@ -43,7 +52,7 @@ func (workload LocalTypedReference) HostnamePrefix(podName, nodeName string) (ho
 		return "", "", fmt.Errorf("missing workload name or kind")
 	}
 	switch workload.Kind {
-	case KindDeployment, KindReplicaSet, KindReplicationController:
+	case KindDeployment, KindReplicaSet, KindReplicationController, KindCronJob, KindDeploymentConfig, KindPod:
 		return workload.Name, strings.ToLower(workload.Kind) + "/" + workload.Name, nil
 	case KindStatefulSet:
 		if podName == "" {
@@ -25,6 +25,7 @@ import (
 
 	stash "github.com/appscode/stash/apis/stash"
 	v1 "k8s.io/api/core/v1"
+	resource "k8s.io/apimachinery/pkg/api/resource"
 	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	conversion "k8s.io/apimachinery/pkg/conversion"
 	runtime "k8s.io/apimachinery/pkg/runtime"
@@ -44,6 +45,10 @@ func RegisterConversions(scheme *runtime.Scheme) error {
 		Convert_stash_B2Spec_To_v1alpha1_B2Spec,
 		Convert_v1alpha1_Backend_To_stash_Backend,
 		Convert_stash_Backend_To_v1alpha1_Backend,
+		Convert_v1alpha1_BackupHook_To_stash_BackupHook,
+		Convert_stash_BackupHook_To_v1alpha1_BackupHook,
+		Convert_v1alpha1_CacheSpec_To_stash_CacheSpec,
+		Convert_stash_CacheSpec_To_v1alpha1_CacheSpec,
 		Convert_v1alpha1_FileGroup_To_stash_FileGroup,
 		Convert_stash_FileGroup_To_v1alpha1_FileGroup,
 		Convert_v1alpha1_GCSSpec_To_stash_GCSSpec,
@@ -52,18 +57,26 @@ func RegisterConversions(scheme *runtime.Scheme) error {
 		Convert_stash_LocalSpec_To_v1alpha1_LocalSpec,
 		Convert_v1alpha1_LocalTypedReference_To_stash_LocalTypedReference,
 		Convert_stash_LocalTypedReference_To_v1alpha1_LocalTypedReference,
+		Convert_v1alpha1_RawSpec_To_stash_RawSpec,
+		Convert_stash_RawSpec_To_v1alpha1_RawSpec,
 		Convert_v1alpha1_Recovery_To_stash_Recovery,
 		Convert_stash_Recovery_To_v1alpha1_Recovery,
+		Convert_v1alpha1_RecoveryCondition_To_stash_RecoveryCondition,
+		Convert_stash_RecoveryCondition_To_v1alpha1_RecoveryCondition,
 		Convert_v1alpha1_RecoveryList_To_stash_RecoveryList,
 		Convert_stash_RecoveryList_To_v1alpha1_RecoveryList,
 		Convert_v1alpha1_RecoverySpec_To_stash_RecoverySpec,
 		Convert_stash_RecoverySpec_To_v1alpha1_RecoverySpec,
 		Convert_v1alpha1_RecoveryStatus_To_stash_RecoveryStatus,
 		Convert_stash_RecoveryStatus_To_v1alpha1_RecoveryStatus,
+		Convert_v1alpha1_RecoveryTarget_To_stash_RecoveryTarget,
+		Convert_stash_RecoveryTarget_To_v1alpha1_RecoveryTarget,
 		Convert_v1alpha1_RestServerSpec_To_stash_RestServerSpec,
 		Convert_stash_RestServerSpec_To_v1alpha1_RestServerSpec,
 		Convert_v1alpha1_Restic_To_stash_Restic,
 		Convert_stash_Restic_To_v1alpha1_Restic,
+		Convert_v1alpha1_ResticCondition_To_stash_ResticCondition,
+		Convert_stash_ResticCondition_To_v1alpha1_ResticCondition,
 		Convert_v1alpha1_ResticList_To_stash_ResticList,
 		Convert_stash_ResticList_To_v1alpha1_ResticList,
 		Convert_v1alpha1_ResticSpec_To_stash_ResticSpec,
@@ -76,6 +89,8 @@ func RegisterConversions(scheme *runtime.Scheme) error {
 		Convert_stash_RetentionPolicy_To_v1alpha1_RetentionPolicy,
 		Convert_v1alpha1_S3Spec_To_stash_S3Spec,
 		Convert_stash_S3Spec_To_v1alpha1_S3Spec,
+		Convert_v1alpha1_SFTPSpec_To_stash_SFTPSpec,
+		Convert_stash_SFTPSpec_To_v1alpha1_SFTPSpec,
 		Convert_v1alpha1_SwiftSpec_To_stash_SwiftSpec,
 		Convert_stash_SwiftSpec_To_v1alpha1_SwiftSpec,
 	)
@@ -127,11 +142,16 @@ func Convert_stash_B2Spec_To_v1alpha1_B2Spec(in *stash.B2Spec, out *B2Spec, s co
 
 func autoConvert_v1alpha1_Backend_To_stash_Backend(in *Backend, out *stash.Backend, s conversion.Scope) error {
 	out.StorageSecretName = in.StorageSecretName
+	out.PasswordSource = stash.PasswordSource(in.PasswordSource)
 	out.Local = (*stash.LocalSpec)(unsafe.Pointer(in.Local))
 	out.S3 = (*stash.S3Spec)(unsafe.Pointer(in.S3))
 	out.GCS = (*stash.GCSSpec)(unsafe.Pointer(in.GCS))
 	out.Azure = (*stash.AzureSpec)(unsafe.Pointer(in.Azure))
 	out.Swift = (*stash.SwiftSpec)(unsafe.Pointer(in.Swift))
+	out.Rest = (*stash.RestServerSpec)(unsafe.Pointer(in.Rest))
+	out.B2 = (*stash.B2Spec)(unsafe.Pointer(in.B2))
+	out.Raw = (*stash.RawSpec)(unsafe.Pointer(in.Raw))
+	out.SFTP = (*stash.SFTPSpec)(unsafe.Pointer(in.SFTP))
 	return nil
 }
 
@@ -142,11 +162,16 @@ func Convert_v1alpha1_Backend_To_stash_Backend(in *Backend, out *stash.Backend,
 
 func autoConvert_stash_Backend_To_v1alpha1_Backend(in *stash.Backend, out *Backend, s conversion.Scope) error {
 	out.StorageSecretName = in.StorageSecretName
+	out.PasswordSource = PasswordSource(in.PasswordSource)
 	out.Local = (*LocalSpec)(unsafe.Pointer(in.Local))
 	out.S3 = (*S3Spec)(unsafe.Pointer(in.S3))
 	out.GCS = (*GCSSpec)(unsafe.Pointer(in.GCS))
 	out.Azure = (*AzureSpec)(unsafe.Pointer(in.Azure))
 	out.Swift = (*SwiftSpec)(unsafe.Pointer(in.Swift))
+	out.Rest = (*RestServerSpec)(unsafe.Pointer(in.Rest))
+	out.B2 = (*B2Spec)(unsafe.Pointer(in.B2))
+	out.Raw = (*RawSpec)(unsafe.Pointer(in.Raw))
+	out.SFTP = (*SFTPSpec)(unsafe.Pointer(in.SFTP))
 	return nil
 }
 
@@ -155,6 +180,50 @@ func Convert_stash_Backend_To_v1alpha1_Backend(in *stash.Backend, out *Backend,
 	return autoConvert_stash_Backend_To_v1alpha1_Backend(in, out, s)
 }
 
+func autoConvert_v1alpha1_BackupHook_To_stash_BackupHook(in *BackupHook, out *stash.BackupHook, s conversion.Scope) error {
+	out.Exec = (*v1.ExecAction)(unsafe.Pointer(in.Exec))
+	out.TimeoutSeconds = (*int32)(unsafe.Pointer(in.TimeoutSeconds))
+	return nil
+}
+
+// Convert_v1alpha1_BackupHook_To_stash_BackupHook is an autogenerated conversion function.
+func Convert_v1alpha1_BackupHook_To_stash_BackupHook(in *BackupHook, out *stash.BackupHook, s conversion.Scope) error {
+	return autoConvert_v1alpha1_BackupHook_To_stash_BackupHook(in, out, s)
+}
+
+func autoConvert_stash_BackupHook_To_v1alpha1_BackupHook(in *stash.BackupHook, out *BackupHook, s conversion.Scope) error {
+	out.Exec = (*v1.ExecAction)(unsafe.Pointer(in.Exec))
+	out.TimeoutSeconds = (*int32)(unsafe.Pointer(in.TimeoutSeconds))
+	return nil
+}
+
+// Convert_stash_BackupHook_To_v1alpha1_BackupHook is an autogenerated conversion function.
+func Convert_stash_BackupHook_To_v1alpha1_BackupHook(in *stash.BackupHook, out *BackupHook, s conversion.Scope) error {
+	return autoConvert_stash_BackupHook_To_v1alpha1_BackupHook(in, out, s)
+}
+
+func autoConvert_v1alpha1_CacheSpec_To_stash_CacheSpec(in *CacheSpec, out *stash.CacheSpec, s conversion.Scope) error {
+	out.ClaimName = in.ClaimName
+	out.MountPath = in.MountPath
+	return nil
+}
+
+// Convert_v1alpha1_CacheSpec_To_stash_CacheSpec is an autogenerated conversion function.
+func Convert_v1alpha1_CacheSpec_To_stash_CacheSpec(in *CacheSpec, out *stash.CacheSpec, s conversion.Scope) error {
+	return autoConvert_v1alpha1_CacheSpec_To_stash_CacheSpec(in, out, s)
+}
+
+func autoConvert_stash_CacheSpec_To_v1alpha1_CacheSpec(in *stash.CacheSpec, out *CacheSpec, s conversion.Scope) error {
+	out.ClaimName = in.ClaimName
+	out.MountPath = in.MountPath
+	return nil
+}
+
+// Convert_stash_CacheSpec_To_v1alpha1_CacheSpec is an autogenerated conversion function.
+func Convert_stash_CacheSpec_To_v1alpha1_CacheSpec(in *stash.CacheSpec, out *CacheSpec, s conversion.Scope) error {
+	return autoConvert_stash_CacheSpec_To_v1alpha1_CacheSpec(in, out, s)
+}
+
 func autoConvert_v1alpha1_FileGroup_To_stash_FileGroup(in *FileGroup, out *stash.FileGroup, s conversion.Scope) error {
 	out.Path = in.Path
 	out.Tags = *(*[]string)(unsafe.Pointer(&in.Tags))
@@ -247,6 +316,28 @@ func Convert_stash_LocalTypedReference_To_v1alpha1_LocalTypedReference(in *stash
 	return autoConvert_stash_LocalTypedReference_To_v1alpha1_LocalTypedReference(in, out, s)
 }
 
+func autoConvert_v1alpha1_RawSpec_To_stash_RawSpec(in *RawSpec, out *stash.RawSpec, s conversion.Scope) error {
+	out.URL = in.URL
+	out.SecretName = in.SecretName
+	return nil
+}
+
+// Convert_v1alpha1_RawSpec_To_stash_RawSpec is an autogenerated conversion function.
+func Convert_v1alpha1_RawSpec_To_stash_RawSpec(in *RawSpec, out *stash.RawSpec, s conversion.Scope) error {
+	return autoConvert_v1alpha1_RawSpec_To_stash_RawSpec(in, out, s)
+}
+
+func autoConvert_stash_RawSpec_To_v1alpha1_RawSpec(in *stash.RawSpec, out *RawSpec, s conversion.Scope) error {
+	out.URL = in.URL
+	out.SecretName = in.SecretName
+	return nil
+}
+
+// Convert_stash_RawSpec_To_v1alpha1_RawSpec is an autogenerated conversion function.
+func Convert_stash_RawSpec_To_v1alpha1_RawSpec(in *stash.RawSpec, out *RawSpec, s conversion.Scope) error {
+	return autoConvert_stash_RawSpec_To_v1alpha1_RawSpec(in, out, s)
+}
+
 func autoConvert_v1alpha1_Recovery_To_stash_Recovery(in *Recovery, out *stash.Recovery, s conversion.Scope) error {
 	out.ObjectMeta = in.ObjectMeta
 	if err := Convert_v1alpha1_RecoverySpec_To_stash_RecoverySpec(&in.Spec, &out.Spec, s); err != nil {
@@ -279,6 +370,34 @@ func Convert_stash_Recovery_To_v1alpha1_Recovery(in *stash.Recovery, out *Recove
 	return autoConvert_stash_Recovery_To_v1alpha1_Recovery(in, out, s)
 }
 
+func autoConvert_v1alpha1_RecoveryCondition_To_stash_RecoveryCondition(in *RecoveryCondition, out *stash.RecoveryCondition, s conversion.Scope) error {
+	out.Type = stash.RecoveryConditionType(in.Type)
+	out.Status = v1.ConditionStatus(in.Status)
+	out.LastTransitionTime = in.LastTransitionTime
+	out.Reason = in.Reason
+	out.Message = in.Message
+	return nil
+}
+
+// Convert_v1alpha1_RecoveryCondition_To_stash_RecoveryCondition is an autogenerated conversion function.
+func Convert_v1alpha1_RecoveryCondition_To_stash_RecoveryCondition(in *RecoveryCondition, out *stash.RecoveryCondition, s conversion.Scope) error {
+	return autoConvert_v1alpha1_RecoveryCondition_To_stash_RecoveryCondition(in, out, s)
+}
+
+func autoConvert_stash_RecoveryCondition_To_v1alpha1_RecoveryCondition(in *stash.RecoveryCondition, out *RecoveryCondition, s conversion.Scope) error {
+	out.Type = RecoveryConditionType(in.Type)
+	out.Status = v1.ConditionStatus(in.Status)
+	out.LastTransitionTime = in.LastTransitionTime
+	out.Reason = in.Reason
+	out.Message = in.Message
+	return nil
+}
+
+// Convert_stash_RecoveryCondition_To_v1alpha1_RecoveryCondition is an autogenerated conversion function.
+func Convert_stash_RecoveryCondition_To_v1alpha1_RecoveryCondition(in *stash.RecoveryCondition, out *RecoveryCondition, s conversion.Scope) error {
+	return autoConvert_stash_RecoveryCondition_To_v1alpha1_RecoveryCondition(in, out, s)
+}
+
 func autoConvert_v1alpha1_RecoveryList_To_stash_RecoveryList(in *RecoveryList, out *stash.RecoveryList, s conversion.Scope) error {
 	out.ListMeta = in.ListMeta
 	out.Items = *(*[]stash.Recovery)(unsafe.Pointer(&in.Items))
@@ -303,12 +422,38 @@ func Convert_stash_RecoveryList_To_v1alpha1_RecoveryList(in *stash.RecoveryList,
 
 func autoConvert_v1alpha1_RecoverySpec_To_stash_RecoverySpec(in *RecoverySpec, out *stash.RecoverySpec, s conversion.Scope) error {
 	out.Restic = in.Restic
+	out.ResticNamespace = in.ResticNamespace
 	if err := Convert_v1alpha1_LocalTypedReference_To_stash_LocalTypedReference(&in.Workload, &out.Workload, s); err != nil {
 		return err
 	}
 	out.PodOrdinal = in.PodOrdinal
 	out.NodeName = in.NodeName
+	out.NodeSelector = *(*map[string]string)(unsafe.Pointer(&in.NodeSelector))
+	out.Tolerations = *(*[]v1.Toleration)(unsafe.Pointer(&in.Tolerations))
+	out.Affinity = (*v1.Affinity)(unsafe.Pointer(in.Affinity))
 	out.Volumes = *(*[]v1.Volume)(unsafe.Pointer(&in.Volumes))
+	out.ImagePullSecrets = *(*[]v1.LocalObjectReference)(unsafe.Pointer(&in.ImagePullSecrets))
+	out.Resources = in.Resources
+	out.DryRun = in.DryRun
+	out.Snapshot = in.Snapshot
+	out.SnapshotID = in.SnapshotID
+	out.Tags = *(*[]string)(unsafe.Pointer(&in.Tags))
+	out.Time = (*meta_v1.Time)(unsafe.Pointer(in.Time))
+	out.SourceHost = in.SourceHost
+	out.SourcePaths = *(*[]string)(unsafe.Pointer(&in.SourcePaths))
+	out.IncludePatterns = *(*[]string)(unsafe.Pointer(&in.IncludePatterns))
+	out.ExcludePatterns = *(*[]string)(unsafe.Pointer(&in.ExcludePatterns))
+	out.LivenessProbe = (*v1.Probe)(unsafe.Pointer(in.LivenessProbe))
+	out.BackoffLimit = (*int32)(unsafe.Pointer(in.BackoffLimit))
+	out.ActiveDeadlineSeconds = (*int64)(unsafe.Pointer(in.ActiveDeadlineSeconds))
+	out.PriorityClassName = in.PriorityClassName
+	out.Target = (*stash.RecoveryTarget)(unsafe.Pointer(in.Target))
+	out.RestartPolicy = v1.RestartPolicy(in.RestartPolicy)
+	out.JobLabels = *(*map[string]string)(unsafe.Pointer(&in.JobLabels))
+	out.PodLabels = *(*map[string]string)(unsafe.Pointer(&in.PodLabels))
+	out.PodAnnotations = *(*map[string]string)(unsafe.Pointer(&in.PodAnnotations))
+	out.ParallelVolumes = in.ParallelVolumes
+	out.TTLSecondsAfterFinished = (*int32)(unsafe.Pointer(in.TTLSecondsAfterFinished))
 	return nil
 }
 
@@ -319,12 +464,38 @@ func Convert_v1alpha1_RecoverySpec_To_stash_RecoverySpec(in *RecoverySpec, out *
 
 func autoConvert_stash_RecoverySpec_To_v1alpha1_RecoverySpec(in *stash.RecoverySpec, out *RecoverySpec, s conversion.Scope) error {
 	out.Restic = in.Restic
+	out.ResticNamespace = in.ResticNamespace
 	if err := Convert_stash_LocalTypedReference_To_v1alpha1_LocalTypedReference(&in.Workload, &out.Workload, s); err != nil {
 		return err
 	}
 	out.PodOrdinal = in.PodOrdinal
 	out.NodeName = in.NodeName
+	out.NodeSelector = *(*map[string]string)(unsafe.Pointer(&in.NodeSelector))
+	out.Tolerations = *(*[]v1.Toleration)(unsafe.Pointer(&in.Tolerations))
+	out.Affinity = (*v1.Affinity)(unsafe.Pointer(in.Affinity))
 	out.Volumes = *(*[]v1.Volume)(unsafe.Pointer(&in.Volumes))
+	out.ImagePullSecrets = *(*[]v1.LocalObjectReference)(unsafe.Pointer(&in.ImagePullSecrets))
+	out.Resources = in.Resources
+	out.DryRun = in.DryRun
+	out.Snapshot = in.Snapshot
+	out.SnapshotID = in.SnapshotID
+	out.Tags = *(*[]string)(unsafe.Pointer(&in.Tags))
+	out.Time = (*meta_v1.Time)(unsafe.Pointer(in.Time))
+	out.SourceHost = in.SourceHost
+	out.SourcePaths = *(*[]string)(unsafe.Pointer(&in.SourcePaths))
+	out.IncludePatterns = *(*[]string)(unsafe.Pointer(&in.IncludePatterns))
+	out.ExcludePatterns = *(*[]string)(unsafe.Pointer(&in.ExcludePatterns))
+	out.LivenessProbe = (*v1.Probe)(unsafe.Pointer(in.LivenessProbe))
+	out.BackoffLimit = (*int32)(unsafe.Pointer(in.BackoffLimit))
+	out.ActiveDeadlineSeconds = (*int64)(unsafe.Pointer(in.ActiveDeadlineSeconds))
+	out.PriorityClassName = in.PriorityClassName
+	out.Target = (*RecoveryTarget)(unsafe.Pointer(in.Target))
+	out.RestartPolicy = v1.RestartPolicy(in.RestartPolicy)
+	out.JobLabels = *(*map[string]string)(unsafe.Pointer(&in.JobLabels))
+	out.PodLabels = *(*map[string]string)(unsafe.Pointer(&in.PodLabels))
+	out.PodAnnotations = *(*map[string]string)(unsafe.Pointer(&in.PodAnnotations))
+	out.ParallelVolumes = in.ParallelVolumes
+	out.TTLSecondsAfterFinished = (*int32)(unsafe.Pointer(in.TTLSecondsAfterFinished))
 	return nil
 }
 
@@ -336,6 +507,7 @@ func Convert_stash_RecoverySpec_To_v1alpha1_RecoverySpec(in *stash.RecoverySpec,
 func autoConvert_v1alpha1_RecoveryStatus_To_stash_RecoveryStatus(in *RecoveryStatus, out *stash.RecoveryStatus, s conversion.Scope) error {
 	out.Phase = stash.RecoveryPhase(in.Phase)
 	out.Stats = *(*[]stash.RestoreStats)(unsafe.Pointer(&in.Stats))
+	out.Conditions = *(*[]stash.RecoveryCondition)(unsafe.Pointer(&in.Conditions))
 	return nil
 }
 
@@ -347,6 +519,7 @@ func Convert_v1alpha1_RecoveryStatus_To_stash_RecoveryStatus(in *RecoveryStatus,
 func autoConvert_stash_RecoveryStatus_To_v1alpha1_RecoveryStatus(in *stash.RecoveryStatus, out *RecoveryStatus, s conversion.Scope) error {
 	out.Phase = RecoveryPhase(in.Phase)
 	out.Stats = *(*[]RestoreStats)(unsafe.Pointer(&in.Stats))
+	out.Conditions = *(*[]RecoveryCondition)(unsafe.Pointer(&in.Conditions))
 	return nil
 }
 
@@ -355,8 +528,31 @@ func Convert_stash_RecoveryStatus_To_v1alpha1_RecoveryStatus(in *stash.RecoveryS
 	return autoConvert_stash_RecoveryStatus_To_v1alpha1_RecoveryStatus(in, out, s)
 }
 
+func autoConvert_v1alpha1_RecoveryTarget_To_stash_RecoveryTarget(in *RecoveryTarget, out *stash.RecoveryTarget, s conversion.Scope) error {
+	out.Volume = in.Volume
+	out.MountPath = in.MountPath
+	return nil
+}
+
+// Convert_v1alpha1_RecoveryTarget_To_stash_RecoveryTarget is an autogenerated conversion function.
+func Convert_v1alpha1_RecoveryTarget_To_stash_RecoveryTarget(in *RecoveryTarget, out *stash.RecoveryTarget, s conversion.Scope) error {
+	return autoConvert_v1alpha1_RecoveryTarget_To_stash_RecoveryTarget(in, out, s)
+}
+
+func autoConvert_stash_RecoveryTarget_To_v1alpha1_RecoveryTarget(in *stash.RecoveryTarget, out *RecoveryTarget, s conversion.Scope) error {
+	out.Volume = in.Volume
+	out.MountPath = in.MountPath
+	return nil
+}
+
+// Convert_stash_RecoveryTarget_To_v1alpha1_RecoveryTarget is an autogenerated conversion function.
+func Convert_stash_RecoveryTarget_To_v1alpha1_RecoveryTarget(in *stash.RecoveryTarget, out *RecoveryTarget, s conversion.Scope) error {
+	return autoConvert_stash_RecoveryTarget_To_v1alpha1_RecoveryTarget(in, out, s)
+}
+
 func autoConvert_v1alpha1_RestServerSpec_To_stash_RestServerSpec(in *RestServerSpec, out *stash.RestServerSpec, s conversion.Scope) error {
 	out.URL = in.URL
+	out.TLSSecretName = in.TLSSecretName
 	return nil
 }
 
@@ -367,6 +563,7 @@ func Convert_v1alpha1_RestServerSpec_To_stash_RestServerSpec(in *RestServerSpec,
 
 func autoConvert_stash_RestServerSpec_To_v1alpha1_RestServerSpec(in *stash.RestServerSpec, out *RestServerSpec, s conversion.Scope) error {
 	out.URL = in.URL
+	out.TLSSecretName = in.TLSSecretName
 	return nil
 }
 
@@ -407,6 +604,34 @@ func Convert_stash_Restic_To_v1alpha1_Restic(in *stash.Restic, out *Restic, s co
 	return autoConvert_stash_Restic_To_v1alpha1_Restic(in, out, s)
 }
 
+func autoConvert_v1alpha1_ResticCondition_To_stash_ResticCondition(in *ResticCondition, out *stash.ResticCondition, s conversion.Scope) error {
+	out.Type = stash.ResticConditionType(in.Type)
+	out.Status = v1.ConditionStatus(in.Status)
+	out.LastTransitionTime = in.LastTransitionTime
+	out.Reason = in.Reason
+	out.Message = in.Message
+	return nil
+}
+
+// Convert_v1alpha1_ResticCondition_To_stash_ResticCondition is an autogenerated conversion function.
+func Convert_v1alpha1_ResticCondition_To_stash_ResticCondition(in *ResticCondition, out *stash.ResticCondition, s conversion.Scope) error {
+	return autoConvert_v1alpha1_ResticCondition_To_stash_ResticCondition(in, out, s)
+}
+
+func autoConvert_stash_ResticCondition_To_v1alpha1_ResticCondition(in *stash.ResticCondition, out *ResticCondition, s conversion.Scope) error {
+	out.Type = ResticConditionType(in.Type)
+	out.Status = v1.ConditionStatus(in.Status)
+	out.LastTransitionTime = in.LastTransitionTime
+	out.Reason = in.Reason
+	out.Message = in.Message
+	return nil
+}
+
+// Convert_stash_ResticCondition_To_v1alpha1_ResticCondition is an autogenerated conversion function.
+func Convert_stash_ResticCondition_To_v1alpha1_ResticCondition(in *stash.ResticCondition, out *ResticCondition, s conversion.Scope) error {
+	return autoConvert_stash_ResticCondition_To_v1alpha1_ResticCondition(in, out, s)
+}
+
 func autoConvert_v1alpha1_ResticList_To_stash_ResticList(in *ResticList, out *stash.ResticList, s conversion.Scope) error {
 	out.ListMeta = in.ListMeta
 	out.Items = *(*[]stash.Restic)(unsafe.Pointer(&in.Items))
@@ -431,6 +656,8 @@ func Convert_stash_ResticList_To_v1alpha1_ResticList(in *stash.ResticList, out *
 
 func autoConvert_v1alpha1_ResticSpec_To_stash_ResticSpec(in *ResticSpec, out *stash.ResticSpec, s conversion.Scope) error {
 	out.Selector = in.Selector
+	out.SelectAll = in.SelectAll
+	out.Target = (*stash.LocalTypedReference)(unsafe.Pointer(in.Target))
 	out.FileGroups = *(*[]stash.FileGroup)(unsafe.Pointer(&in.FileGroups))
 	if err := Convert_v1alpha1_Backend_To_stash_Backend(&in.Backend, &out.Backend, s); err != nil {
 		return err
@@ -440,6 +667,26 @@ func autoConvert_v1alpha1_ResticSpec_To_stash_ResticSpec(in *ResticSpec, out *st
 	out.Resources = in.Resources
 	out.RetentionPolicies = *(*[]stash.RetentionPolicy)(unsafe.Pointer(&in.RetentionPolicies))
 	out.Type = stash.BackupType(in.Type)
+	out.ImagePullPolicy = v1.PullPolicy(in.ImagePullPolicy)
+	out.ScratchSizeLimit = (*resource.Quantity)(unsafe.Pointer(in.ScratchSizeLimit))
+	out.ScratchMedium = v1.StorageMedium(in.ScratchMedium)
+	out.LogLevel = (*int32)(unsafe.Pointer(in.LogLevel))
+	out.CheckInterval = (*meta_v1.Duration)(unsafe.Pointer(in.CheckInterval))
+	out.SecurityContext = (*v1.SecurityContext)(unsafe.Pointer(in.SecurityContext))
+	out.PreBackupHook = (*stash.BackupHook)(unsafe.Pointer(in.PreBackupHook))
+	out.PriorityClassName = in.PriorityClassName
+	out.LimitUpload = (*int32)(unsafe.Pointer(in.LimitUpload))
+	out.LimitDownload = (*int32)(unsafe.Pointer(in.LimitDownload))
+	out.Timeout = (*meta_v1.Duration)(unsafe.Pointer(in.Timeout))
+	out.Env = *(*[]v1.EnvVar)(unsafe.Pointer(&in.Env))
+	out.EnvFrom = *(*[]v1.EnvFromSource)(unsafe.Pointer(&in.EnvFrom))
+	out.PodinfoMountPath = in.PodinfoMountPath
+	out.ScratchMountPath = in.ScratchMountPath
+	out.Cache = (*stash.CacheSpec)(unsafe.Pointer(in.Cache))
+	out.Excludes = *(*[]string)(unsafe.Pointer(&in.Excludes))
+	out.ExcludeCaches = in.ExcludeCaches
+	out.OneFileSystem = in.OneFileSystem
+	out.Mirror = (*stash.Backend)(unsafe.Pointer(in.Mirror))
 	return nil
 }
 
@@ -450,6 +697,8 @@ func Convert_v1alpha1_ResticSpec_To_stash_ResticSpec(in *ResticSpec, out *stash.
 
 func autoConvert_stash_ResticSpec_To_v1alpha1_ResticSpec(in *stash.ResticSpec, out *ResticSpec, s conversion.Scope) error {
 	out.Selector = in.Selector
+	out.SelectAll = in.SelectAll
+	out.Target = (*LocalTypedReference)(unsafe.Pointer(in.Target))
 	out.FileGroups = *(*[]FileGroup)(unsafe.Pointer(&in.FileGroups))
 	if err := Convert_stash_Backend_To_v1alpha1_Backend(&in.Backend, &out.Backend, s); err != nil {
 		return err
@@ -459,6 +708,26 @@ func autoConvert_stash_ResticSpec_To_v1alpha1_ResticSpec(in *stash.ResticSpec, o
 	out.Resources = in.Resources
 	out.RetentionPolicies = *(*[]RetentionPolicy)(unsafe.Pointer(&in.RetentionPolicies))
 	out.Type = BackupType(in.Type)
+	out.ImagePullPolicy = v1.PullPolicy(in.ImagePullPolicy)
+	out.ScratchSizeLimit = (*resource.Quantity)(unsafe.Pointer(in.ScratchSizeLimit))
+	out.ScratchMedium = v1.StorageMedium(in.ScratchMedium)
+	out.LogLevel = (*int32)(unsafe.Pointer(in.LogLevel))
+	out.CheckInterval = (*meta_v1.Duration)(unsafe.Pointer(in.CheckInterval))
+	out.SecurityContext = (*v1.SecurityContext)(unsafe.Pointer(in.SecurityContext))
+	out.PreBackupHook = (*BackupHook)(unsafe.Pointer(in.PreBackupHook))
+	out.PriorityClassName = in.PriorityClassName
+	out.LimitUpload = (*int32)(unsafe.Pointer(in.LimitUpload))
+	out.LimitDownload = (*int32)(unsafe.Pointer(in.LimitDownload))
+	out.Timeout = (*meta_v1.Duration)(unsafe.Pointer(in.Timeout))
+	out.Env = *(*[]v1.EnvVar)(unsafe.Pointer(&in.Env))
+	out.EnvFrom = *(*[]v1.EnvFromSource)(unsafe.Pointer(&in.EnvFrom))
+	out.PodinfoMountPath = in.PodinfoMountPath
+	out.ScratchMountPath = in.ScratchMountPath
+	out.Cache = (*CacheSpec)(unsafe.Pointer(in.Cache))
+	out.Excludes = *(*[]string)(unsafe.Pointer(&in.Excludes))
+	out.ExcludeCaches = in.ExcludeCaches
+	out.OneFileSystem = in.OneFileSystem
+	out.Mirror = (*Backend)(unsafe.Pointer(in.Mirror))
 	return nil
 }
 
@@ -473,6 +742,7 @@ func autoConvert_v1alpha1_ResticStatus_To_stash_ResticStatus(in *ResticStatus, o
 	out.LastSuccessfulBackupTime = (*meta_v1.Time)(unsafe.Pointer(in.LastSuccessfulBackupTime))
 	out.LastBackupDuration = in.LastBackupDuration
 	out.BackupCount = in.BackupCount
+	out.Conditions = *(*[]stash.ResticCondition)(unsafe.Pointer(&in.Conditions))
 	return nil
 }
 
@@ -487,6 +757,7 @@ func autoConvert_stash_ResticStatus_To_v1alpha1_ResticStatus(in *stash.ResticSta
 	out.LastSuccessfulBackupTime = (*meta_v1.Time)(unsafe.Pointer(in.LastSuccessfulBackupTime))
 	out.LastBackupDuration = in.LastBackupDuration
 	out.BackupCount = in.BackupCount
+	out.Conditions = *(*[]ResticCondition)(unsafe.Pointer(&in.Conditions))
 	return nil
 }
 
@@ -499,6 +770,8 @@ func autoConvert_v1alpha1_RestoreStats_To_stash_RestoreStats(in *RestoreStats, o
 	out.Path = in.Path
 	out.Phase = stash.RecoveryPhase(in.Phase)
 	out.Duration = in.Duration
+	out.FilesRestored = (*int64)(unsafe.Pointer(in.FilesRestored))
+	out.BytesRestored = (*int64)(unsafe.Pointer(in.BytesRestored))
 	return nil
 }
 
@@ -511,6 +784,8 @@ func autoConvert_stash_RestoreStats_To_v1alpha1_RestoreStats(in *stash.RestoreSt
 	out.Path = in.Path
 	out.Phase = RecoveryPhase(in.Phase)
 	out.Duration = in.Duration
+	out.FilesRestored = (*int64)(unsafe.Pointer(in.FilesRestored))
+	out.BytesRestored = (*int64)(unsafe.Pointer(in.BytesRestored))
 	return nil
 }
 
@@ -581,6 +856,34 @@ func Convert_stash_S3Spec_To_v1alpha1_S3Spec(in *stash.S3Spec, out *S3Spec, s co
 	return autoConvert_stash_S3Spec_To_v1alpha1_S3Spec(in, out, s)
 }
 
+func autoConvert_v1alpha1_SFTPSpec_To_stash_SFTPSpec(in *SFTPSpec, out *stash.SFTPSpec, s conversion.Scope) error {
+	out.Host = in.Host
+	out.Port = in.Port
+	out.User = in.User
+	out.Path = in.Path
+	out.SSHSecretName = in.SSHSecretName
+	return nil
+}
+
+// Convert_v1alpha1_SFTPSpec_To_stash_SFTPSpec is an autogenerated conversion function.
+func Convert_v1alpha1_SFTPSpec_To_stash_SFTPSpec(in *SFTPSpec, out *stash.SFTPSpec, s conversion.Scope) error {
+	return autoConvert_v1alpha1_SFTPSpec_To_stash_SFTPSpec(in, out, s)
+}
+
+func autoConvert_stash_SFTPSpec_To_v1alpha1_SFTPSpec(in *stash.SFTPSpec, out *SFTPSpec, s conversion.Scope) error {
+	out.Host = in.Host
+	out.Port = in.Port
+	out.User = in.User
+	out.Path = in.Path
+	out.SSHSecretName = in.SSHSecretName
+	return nil
+}
+
+// Convert_stash_SFTPSpec_To_v1alpha1_SFTPSpec is an autogenerated conversion function.
+func Convert_stash_SFTPSpec_To_v1alpha1_SFTPSpec(in *stash.SFTPSpec, out *SFTPSpec, s conversion.Scope) error {
+	return autoConvert_stash_SFTPSpec_To_v1alpha1_SFTPSpec(in, out, s)
+}
+
 func autoConvert_v1alpha1_SwiftSpec_To_stash_SwiftSpec(in *SwiftSpec, out *stash.SwiftSpec, s conversion.Scope) error {
 	out.Container = in.Container
 	out.Prefix = in.Prefix
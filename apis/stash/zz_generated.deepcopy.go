@@ -24,6 +24,7 @@ import (
 	reflect "reflect"
 
 	v1 "k8s.io/api/core/v1"
+	resource "k8s.io/apimachinery/pkg/api/resource"
 	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	conversion "k8s.io/apimachinery/pkg/conversion"
 	runtime "k8s.io/apimachinery/pkg/runtime"
@@ -51,6 +52,14 @@ func RegisterDeepCopies(scheme *runtime.Scheme) error {
 			in.(*Backend).DeepCopyInto(out.(*Backend))
 			return nil
 		}, InType: reflect.TypeOf(&Backend{})},
+		conversion.GeneratedDeepCopyFunc{Fn: func(in interface{}, out interface{}, c *conversion.Cloner) error {
+			in.(*BackupHook).DeepCopyInto(out.(*BackupHook))
+			return nil
+		}, InType: reflect.TypeOf(&BackupHook{})},
+		conversion.GeneratedDeepCopyFunc{Fn: func(in interface{}, out interface{}, c *conversion.Cloner) error {
+			in.(*CacheSpec).DeepCopyInto(out.(*CacheSpec))
+			return nil
+		}, InType: reflect.TypeOf(&CacheSpec{})},
 		conversion.GeneratedDeepCopyFunc{Fn: func(in interface{}, out interface{}, c *conversion.Cloner) error {
 			in.(*FileGroup).DeepCopyInto(out.(*FileGroup))
 			return nil
@@ -67,10 +76,18 @@ func RegisterDeepCopies(scheme *runtime.Scheme) error {
 			in.(*LocalTypedReference).DeepCopyInto(out.(*LocalTypedReference))
 			return nil
 		}, InType: reflect.TypeOf(&LocalTypedReference{})},
+		conversion.GeneratedDeepCopyFunc{Fn: func(in interface{}, out interface{}, c *conversion.Cloner) error {
+			in.(*RawSpec).DeepCopyInto(out.(*RawSpec))
+			return nil
+		}, InType: reflect.TypeOf(&RawSpec{})},
 		conversion.GeneratedDeepCopyFunc{Fn: func(in interface{}, out interface{}, c *conversion.Cloner) error {
 			in.(*Recovery).DeepCopyInto(out.(*Recovery))
 			return nil
 		}, InType: reflect.TypeOf(&Recovery{})},
+		conversion.GeneratedDeepCopyFunc{Fn: func(in interface{}, out interface{}, c *conversion.Cloner) error {
+			in.(*RecoveryCondition).DeepCopyInto(out.(*RecoveryCondition))
+			return nil
+		}, InType: reflect.TypeOf(&RecoveryCondition{})},
 		conversion.GeneratedDeepCopyFunc{Fn: func(in interface{}, out interface{}, c *conversion.Cloner) error {
 			in.(*RecoveryList).DeepCopyInto(out.(*RecoveryList))
 			return nil
@@ -83,6 +100,10 @@ func RegisterDeepCopies(scheme *runtime.Scheme) error {
 			in.(*RecoveryStatus).DeepCopyInto(out.(*RecoveryStatus))
 			return nil
 		}, InType: reflect.TypeOf(&RecoveryStatus{})},
+		conversion.GeneratedDeepCopyFunc{Fn: func(in interface{}, out interface{}, c *conversion.Cloner) error {
+			in.(*RecoveryTarget).DeepCopyInto(out.(*RecoveryTarget))
+			return nil
+		}, InType: reflect.TypeOf(&RecoveryTarget{})},
 		conversion.GeneratedDeepCopyFunc{Fn: func(in interface{}, out interface{}, c *conversion.Cloner) error {
 			in.(*RestServerSpec).DeepCopyInto(out.(*RestServerSpec))
 			return nil
@@ -91,6 +112,10 @@ func RegisterDeepCopies(scheme *runtime.Scheme) error {
 			in.(*Restic).DeepCopyInto(out.(*Restic))
 			return nil
 		}, InType: reflect.TypeOf(&Restic{})},
+		conversion.GeneratedDeepCopyFunc{Fn: func(in interface{}, out interface{}, c *conversion.Cloner) error {
+			in.(*ResticCondition).DeepCopyInto(out.(*ResticCondition))
+			return nil
+		}, InType: reflect.TypeOf(&ResticCondition{})},
 		conversion.GeneratedDeepCopyFunc{Fn: func(in interface{}, out interface{}, c *conversion.Cloner) error {
 			in.(*ResticList).DeepCopyInto(out.(*ResticList))
 			return nil
@@ -115,6 +140,10 @@ func RegisterDeepCopies(scheme *runtime.Scheme) error {
 			in.(*S3Spec).DeepCopyInto(out.(*S3Spec))
 			return nil
 		}, InType: reflect.TypeOf(&S3Spec{})},
+		conversion.GeneratedDeepCopyFunc{Fn: func(in interface{}, out interface{}, c *conversion.Cloner) error {
+			in.(*SFTPSpec).DeepCopyInto(out.(*SFTPSpec))
+			return nil
+		}, InType: reflect.TypeOf(&SFTPSpec{})},
 		conversion.GeneratedDeepCopyFunc{Fn: func(in interface{}, out interface{}, c *conversion.Cloner) error {
 			in.(*SwiftSpec).DeepCopyInto(out.(*SwiftSpec))
 			return nil
@@ -202,6 +231,42 @@ func (in *Backend) DeepCopyInto(out *Backend) {
 			**out = **in
 		}
 	}
+	if in.Rest != nil {
+		in, out := &in.Rest, &out.Rest
+		if *in == nil {
+			*out = nil
+		} else {
+			*out = new(RestServerSpec)
+			**out = **in
+		}
+	}
+	if in.B2 != nil {
+		in, out := &in.B2, &out.B2
+		if *in == nil {
+			*out = nil
+		} else {
+			*out = new(B2Spec)
+			**out = **in
+		}
+	}
+	if in.Raw != nil {
+		in, out := &in.Raw, &out.Raw
+		if *in == nil {
+			*out = nil
+		} else {
+			*out = new(RawSpec)
+			**out = **in
+		}
+	}
+	if in.SFTP != nil {
+		in, out := &in.SFTP, &out.SFTP
+		if *in == nil {
+			*out = nil
+		} else {
+			*out = new(SFTPSpec)
+			**out = **in
+		}
+	}
 	return
 }
 
@@ -215,6 +280,56 @@ func (in *Backend) DeepCopy() *Backend {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *BackupHook) DeepCopyInto(out *BackupHook) {
+	*out = *in
+	if in.Exec != nil {
+		in, out := &in.Exec, &out.Exec
+		if *in == nil {
+			*out = nil
+		} else {
+			*out = new(v1.ExecAction)
+			(*in).DeepCopyInto(*out)
+		}
+	}
+	if in.TimeoutSeconds != nil {
+		in, out := &in.TimeoutSeconds, &out.TimeoutSeconds
+		if *in == nil {
+			*out = nil
+		} else {
+			*out = new(int32)
+			**out = **in
+		}
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new BackupHook.
+func (in *BackupHook) DeepCopy() *BackupHook {
+	if in == nil {
+		return nil
+	}
+	out := new(BackupHook)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *CacheSpec) DeepCopyInto(out *CacheSpec) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new CacheSpec.
+func (in *CacheSpec) DeepCopy() *CacheSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(CacheSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *FileGroup) DeepCopyInto(out *FileGroup) {
 	*out = *in
@@ -285,6 +400,22 @@ func (in *LocalTypedReference) DeepCopy() *LocalTypedReference {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RawSpec) DeepCopyInto(out *RawSpec) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RawSpec.
+func (in *RawSpec) DeepCopy() *RawSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(RawSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *Recovery) DeepCopyInto(out *Recovery) {
 	*out = *in
@@ -314,6 +445,23 @@ func (in *Recovery) DeepCopyObject() runtime.Object {
 	}
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RecoveryCondition) DeepCopyInto(out *RecoveryCondition) {
+	*out = *in
+	in.LastTransitionTime.DeepCopyInto(&out.LastTransitionTime)
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RecoveryCondition.
+func (in *RecoveryCondition) DeepCopy() *RecoveryCondition {
+	if in == nil {
+		return nil
+	}
+	out := new(RecoveryCondition)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *RecoveryList) DeepCopyInto(out *RecoveryList) {
 	*out = *in
@@ -352,6 +500,29 @@ func (in *RecoveryList) DeepCopyObject() runtime.Object {
 func (in *RecoverySpec) DeepCopyInto(out *RecoverySpec) {
 	*out = *in
 	out.Workload = in.Workload
+	if in.NodeSelector != nil {
+		in, out := &in.NodeSelector, &out.NodeSelector
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.Tolerations != nil {
+		in, out := &in.Tolerations, &out.Tolerations
+		*out = make([]v1.Toleration, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Affinity != nil {
+		in, out := &in.Affinity, &out.Affinity
+		if *in == nil {
+			*out = nil
+		} else {
+			*out = new(v1.Affinity)
+			(*in).DeepCopyInto(*out)
+		}
+	}
 	if in.Volumes != nil {
 		in, out := &in.Volumes, &out.Volumes
 		*out = make([]v1.Volume, len(*in))
@@ -359,6 +530,107 @@ func (in *RecoverySpec) DeepCopyInto(out *RecoverySpec) {
 			(*in)[i].DeepCopyInto(&(*out)[i])
 		}
 	}
+	if in.ImagePullSecrets != nil {
+		in, out := &in.ImagePullSecrets, &out.ImagePullSecrets
+		*out = make([]v1.LocalObjectReference, len(*in))
+		copy(*out, *in)
+	}
+	in.Resources.DeepCopyInto(&out.Resources)
+	if in.Tags != nil {
+		in, out := &in.Tags, &out.Tags
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Time != nil {
+		in, out := &in.Time, &out.Time
+		if *in == nil {
+			*out = nil
+		} else {
+			*out = new(meta_v1.Time)
+			(*in).DeepCopyInto(*out)
+		}
+	}
+	if in.SourcePaths != nil {
+		in, out := &in.SourcePaths, &out.SourcePaths
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.IncludePatterns != nil {
+		in, out := &in.IncludePatterns, &out.IncludePatterns
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.ExcludePatterns != nil {
+		in, out := &in.ExcludePatterns, &out.ExcludePatterns
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.LivenessProbe != nil {
+		in, out := &in.LivenessProbe, &out.LivenessProbe
+		if *in == nil {
+			*out = nil
+		} else {
+			*out = new(v1.Probe)
+			(*in).DeepCopyInto(*out)
+		}
+	}
+	if in.BackoffLimit != nil {
+		in, out := &in.BackoffLimit, &out.BackoffLimit
+		if *in == nil {
+			*out = nil
+		} else {
+			*out = new(int32)
+			**out = **in
+		}
+	}
+	if in.ActiveDeadlineSeconds != nil {
+		in, out := &in.ActiveDeadlineSeconds, &out.ActiveDeadlineSeconds
+		if *in == nil {
+			*out = nil
+		} else {
+			*out = new(int64)
+			**out = **in
+		}
+	}
+	if in.Target != nil {
+		in, out := &in.Target, &out.Target
+		if *in == nil {
+			*out = nil
+		} else {
+			*out = new(RecoveryTarget)
+			**out = **in
+		}
+	}
+	if in.JobLabels != nil {
+		in, out := &in.JobLabels, &out.JobLabels
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.PodLabels != nil {
+		in, out := &in.PodLabels, &out.PodLabels
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.PodAnnotations != nil {
+		in, out := &in.PodAnnotations, &out.PodAnnotations
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.TTLSecondsAfterFinished != nil {
+		in, out := &in.TTLSecondsAfterFinished, &out.TTLSecondsAfterFinished
+		if *in == nil {
+			*out = nil
+		} else {
+			*out = new(int32)
+			**out = **in
+		}
+	}
 	return
 }
 
@@ -378,7 +650,16 @@ func (in *RecoveryStatus) DeepCopyInto(out *RecoveryStatus) {
 	if in.Stats != nil {
 		in, out := &in.Stats, &out.Stats
 		*out = make([]RestoreStats, len(*in))
-		copy(*out, *in)
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]RecoveryCondition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
 	}
 	return
 }
@@ -393,6 +674,22 @@ func (in *RecoveryStatus) DeepCopy() *RecoveryStatus {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RecoveryTarget) DeepCopyInto(out *RecoveryTarget) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RecoveryTarget.
+func (in *RecoveryTarget) DeepCopy() *RecoveryTarget {
+	if in == nil {
+		return nil
+	}
+	out := new(RecoveryTarget)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *RestServerSpec) DeepCopyInto(out *RestServerSpec) {
 	*out = *in
@@ -438,6 +735,23 @@ func (in *Restic) DeepCopyObject() runtime.Object {
 	}
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ResticCondition) DeepCopyInto(out *ResticCondition) {
+	*out = *in
+	in.LastTransitionTime.DeepCopyInto(&out.LastTransitionTime)
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ResticCondition.
+func (in *ResticCondition) DeepCopy() *ResticCondition {
+	if in == nil {
+		return nil
+	}
+	out := new(ResticCondition)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *ResticList) DeepCopyInto(out *ResticList) {
 	*out = *in
@@ -476,6 +790,15 @@ func (in *ResticList) DeepCopyObject() runtime.Object {
 func (in *ResticSpec) DeepCopyInto(out *ResticSpec) {
 	*out = *in
 	in.Selector.DeepCopyInto(&out.Selector)
+	if in.Target != nil {
+		in, out := &in.Target, &out.Target
+		if *in == nil {
+			*out = nil
+		} else {
+			*out = new(LocalTypedReference)
+			**out = **in
+		}
+	}
 	if in.FileGroups != nil {
 		in, out := &in.FileGroups, &out.FileGroups
 		*out = make([]FileGroup, len(*in))
@@ -499,6 +822,115 @@ func (in *ResticSpec) DeepCopyInto(out *ResticSpec) {
 			(*in)[i].DeepCopyInto(&(*out)[i])
 		}
 	}
+	if in.ScratchSizeLimit != nil {
+		in, out := &in.ScratchSizeLimit, &out.ScratchSizeLimit
+		if *in == nil {
+			*out = nil
+		} else {
+			*out = new(resource.Quantity)
+			**out = (*in).DeepCopy()
+		}
+	}
+	if in.LogLevel != nil {
+		in, out := &in.LogLevel, &out.LogLevel
+		if *in == nil {
+			*out = nil
+		} else {
+			*out = new(int32)
+			**out = **in
+		}
+	}
+	if in.CheckInterval != nil {
+		in, out := &in.CheckInterval, &out.CheckInterval
+		if *in == nil {
+			*out = nil
+		} else {
+			*out = new(meta_v1.Duration)
+			**out = **in
+		}
+	}
+	if in.SecurityContext != nil {
+		in, out := &in.SecurityContext, &out.SecurityContext
+		if *in == nil {
+			*out = nil
+		} else {
+			*out = new(v1.SecurityContext)
+			(*in).DeepCopyInto(*out)
+		}
+	}
+	if in.PreBackupHook != nil {
+		in, out := &in.PreBackupHook, &out.PreBackupHook
+		if *in == nil {
+			*out = nil
+		} else {
+			*out = new(BackupHook)
+			(*in).DeepCopyInto(*out)
+		}
+	}
+	if in.LimitUpload != nil {
+		in, out := &in.LimitUpload, &out.LimitUpload
+		if *in == nil {
+			*out = nil
+		} else {
+			*out = new(int32)
+			**out = **in
+		}
+	}
+	if in.LimitDownload != nil {
+		in, out := &in.LimitDownload, &out.LimitDownload
+		if *in == nil {
+			*out = nil
+		} else {
+			*out = new(int32)
+			**out = **in
+		}
+	}
+	if in.Timeout != nil {
+		in, out := &in.Timeout, &out.Timeout
+		if *in == nil {
+			*out = nil
+		} else {
+			*out = new(meta_v1.Duration)
+			**out = **in
+		}
+	}
+	if in.Env != nil {
+		in, out := &in.Env, &out.Env
+		*out = make([]v1.EnvVar, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.EnvFrom != nil {
+		in, out := &in.EnvFrom, &out.EnvFrom
+		*out = make([]v1.EnvFromSource, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Cache != nil {
+		in, out := &in.Cache, &out.Cache
+		if *in == nil {
+			*out = nil
+		} else {
+			*out = new(CacheSpec)
+			**out = **in
+		}
+	}
+	if in.Excludes != nil {
+		in, out := &in.Excludes, &out.Excludes
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Mirror != nil {
+		in, out := &in.Mirror, &out.Mirror
+		if *in == nil {
+			*out = nil
+		} else {
+			*out = new(Backend)
+			(*in).DeepCopyInto(*out)
+		}
+	}
 	return
 }
 
@@ -542,6 +974,13 @@ func (in *ResticStatus) DeepCopyInto(out *ResticStatus) {
 			(*in).DeepCopyInto(*out)
 		}
 	}
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]ResticCondition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
 	return
 }
 
@@ -558,6 +997,24 @@ func (in *ResticStatus) DeepCopy() *ResticStatus {
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *RestoreStats) DeepCopyInto(out *RestoreStats) {
 	*out = *in
+	if in.FilesRestored != nil {
+		in, out := &in.FilesRestored, &out.FilesRestored
+		if *in == nil {
+			*out = nil
+		} else {
+			*out = new(int64)
+			**out = **in
+		}
+	}
+	if in.BytesRestored != nil {
+		in, out := &in.BytesRestored, &out.BytesRestored
+		if *in == nil {
+			*out = nil
+		} else {
+			*out = new(int64)
+			**out = **in
+		}
+	}
 	return
 }
 
@@ -608,6 +1065,22 @@ func (in *S3Spec) DeepCopy() *S3Spec {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SFTPSpec) DeepCopyInto(out *SFTPSpec) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SFTPSpec.
+func (in *SFTPSpec) DeepCopy() *SFTPSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(SFTPSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *SwiftSpec) DeepCopyInto(out *SwiftSpec) {
 	*out = *in
@@ -3,7 +3,6 @@ package util
 import (
 	"encoding/json"
 	"fmt"
-	"time"
 
 	"github.com/appscode/go/log"
 	"github.com/appscode/kutil"
@@ -15,6 +14,7 @@ import (
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/jsonmergepatch"
 	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/util/retry"
 )
 
 func EnsureRecovery(c cs.StashV1alpha1Interface, meta metav1.ObjectMeta, transform func(alert *api.Recovery) *api.Recovery) (*api.Recovery, error) {
@@ -38,7 +38,8 @@ func CreateOrPatchRecovery(c cs.StashV1alpha1Interface, meta metav1.ObjectMeta,
 	return PatchRecovery(c, cur, transform)
 }
 
-func PatchRecovery(c cs.StashV1alpha1Interface, cur *api.Recovery, transform func(*api.Recovery) *api.Recovery) (*api.Recovery, error) {
+// recoveryMergePatch returns the merge patch transforming cur, or nil if transform changes nothing.
+func recoveryMergePatch(cur *api.Recovery, transform func(*api.Recovery) *api.Recovery) ([]byte, error) {
 	curJson, err := json.Marshal(cur)
 	if err != nil {
 		return nil, err
@@ -54,7 +55,15 @@ func PatchRecovery(c cs.StashV1alpha1Interface, cur *api.Recovery, transform fun
 		return nil, err
 	}
 	if len(patch) == 0 || string(patch) == "{}" {
-		return cur, nil
+		return nil, nil
+	}
+	return patch, nil
+}
+
+func PatchRecovery(c cs.StashV1alpha1Interface, cur *api.Recovery, transform func(*api.Recovery) *api.Recovery) (*api.Recovery, error) {
+	patch, err := recoveryMergePatch(cur, transform)
+	if err != nil || patch == nil {
+		return cur, err
 	}
 	glog.V(3).Infof("Patching Recovery %s/%s with %s.", cur.Namespace, cur.Name, string(patch))
 	result, err := c.Recoveries(cur.Namespace).Patch(cur.Name, types.MergePatchType, patch)
@@ -115,27 +124,100 @@ func SetRecoveryStatus(c cs.StashV1alpha1Interface, rec *api.Recovery, status ap
 	}
 }
 
-func SetRecoveryStatusPhase(c cs.StashV1alpha1Interface, rec *api.Recovery, phase api.RecoveryPhase) {
-	SetRecoveryStatus(c, rec, api.RecoveryStatus{Phase: phase})
+// patchRecoveryIfUnchanged patches cur like PatchRecovery, but the patch includes the resourceVersion
+// of cur, so that it is rejected with a conflict if the Recovery was changed since cur was read.
+func patchRecoveryIfUnchanged(c cs.StashV1alpha1Interface, cur *api.Recovery, transform func(*api.Recovery) *api.Recovery) (*api.Recovery, error) {
+	patch, err := recoveryMergePatch(cur, transform)
+	if err != nil || patch == nil {
+		return cur, err
+	}
+	fields := map[string]interface{}{}
+	if err = json.Unmarshal(patch, &fields); err != nil {
+		return nil, err
+	}
+	metadata, _ := fields["metadata"].(map[string]interface{})
+	if metadata == nil {
+		metadata = map[string]interface{}{}
+	}
+	metadata["resourceVersion"] = cur.ResourceVersion
+	fields["metadata"] = metadata
+	if patch, err = json.Marshal(fields); err != nil {
+		return nil, err
+	}
+	glog.V(3).Infof("Patching Recovery %s/%s with %s.", cur.Namespace, cur.Name, string(patch))
+	return c.Recoveries(cur.Namespace).Patch(cur.Name, types.MergePatchType, patch)
+}
+
+// SetRecoveryStatusPhase updates the phase of rec and sets the given conditions in the same patch.
+// Other fields of the status are kept. The patch is only applied if rec is the latest Recovery,
+// on conflict it is applied again to the latest Recovery.
+func SetRecoveryStatusPhase(c cs.StashV1alpha1Interface, rec *api.Recovery, phase api.RecoveryPhase, conditions ...api.RecoveryCondition) {
+	cur := rec
+	attempt := 0
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		if attempt++; attempt > 1 {
+			latest, err := c.Recoveries(rec.Namespace).Get(rec.Name, metav1.GetOptions{})
+			if err != nil {
+				return err
+			}
+			cur = latest
+		}
+		_, err := patchRecoveryIfUnchanged(c, cur, func(in *api.Recovery) *api.Recovery {
+			in.Status.Phase = phase
+			for _, condition := range conditions {
+				in.Status.Conditions = UpsertRecoveryCondition(in.Status.Conditions, condition)
+			}
+			return in
+		})
+		return err
+	})
+	if err != nil {
+		log.Errorln("Error updating recovery phase:", phase, "reason:", err)
+	} else {
+		log.Infoln("Updated recovery phase:", phase)
+	}
+}
+
+// SetRecoveryCondition adds the condition to the status of rec, replacing any existing one of the same type.
+func SetRecoveryCondition(c cs.StashV1alpha1Interface, rec *api.Recovery, condition api.RecoveryCondition) {
+	_, err := PatchRecovery(c, rec, func(in *api.Recovery) *api.Recovery {
+		in.Status.Conditions = UpsertRecoveryCondition(in.Status.Conditions, condition)
+		return in
+	})
+	if err != nil {
+		log.Errorln("Error updating recovery condition:", condition.Type, "reason:", err)
+	} else {
+		log.Infoln("Updated recovery condition:", condition.Type)
+	}
 }
 
-func SetRecoveryStats(c cs.StashV1alpha1Interface, recovery *api.Recovery, path string, d time.Duration, phase api.RecoveryPhase) (*api.Recovery, error) {
-	return PatchRecovery(c, recovery, func(in *api.Recovery) *api.Recovery {
-		found := false
-		for _, stats := range in.Status.Stats {
-			if stats.Path == path {
-				found = true
-				stats.Duration = d.String()
-				stats.Phase = phase
+// UpsertRecoveryCondition adds condition to conditions or replaces the existing one of the same type.
+// LastTransitionTime is only updated if the status of the condition changes.
+func UpsertRecoveryCondition(conditions []api.RecoveryCondition, condition api.RecoveryCondition) []api.RecoveryCondition {
+	condition.LastTransitionTime = metav1.Now()
+	for i := range conditions {
+		if conditions[i].Type == condition.Type {
+			if conditions[i].Status == condition.Status {
+				condition.LastTransitionTime = conditions[i].LastTransitionTime
 			}
+			conditions[i] = condition
+			return conditions
 		}
-		if !found {
-			recovery.Status.Stats = append(recovery.Status.Stats, api.RestoreStats{
-				Path:     path,
-				Duration: d.String(),
-				Phase:    phase,
-			})
+	}
+	return append(conditions, condition)
+}
+
+// SetRecoveryStats adds stats to the status of recovery, replacing the stats of the same path.
+// The latest Recovery is updated, so that stats reported by parallel recovery jobs are kept.
+func SetRecoveryStats(c cs.StashV1alpha1Interface, recovery *api.Recovery, stats api.RestoreStats) (*api.Recovery, error) {
+	return TryUpdateRecovery(c, recovery.ObjectMeta, func(in *api.Recovery) *api.Recovery {
+		for i := range in.Status.Stats {
+			if in.Status.Stats[i].Path == stats.Path {
+				in.Status.Stats[i] = stats
+				return in
+			}
 		}
+		in.Status.Stats = append(in.Status.Stats, stats)
 		return in
 	})
 }
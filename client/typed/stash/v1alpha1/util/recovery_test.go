@@ -0,0 +1,100 @@
+package util
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	api "github.com/appscode/stash/apis/stash/v1alpha1"
+	stash_fake "github.com/appscode/stash/client/fake"
+	core "k8s.io/api/core/v1"
+	kerr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clienttesting "k8s.io/client-go/testing"
+)
+
+func TestUpsertRecoveryConditionTransitionTime(t *testing.T) {
+	created := metav1.NewTime(time.Now().Add(-time.Hour))
+	conditions := []api.RecoveryCondition{{
+		Type:               api.RecoveryConditionJobCreated,
+		Status:             core.ConditionTrue,
+		LastTransitionTime: created,
+		Message:            "Recovery job created: recover-a",
+	}}
+
+	// same status keeps the transition time, but updates the message
+	conditions = UpsertRecoveryCondition(conditions, api.RecoveryCondition{
+		Type:    api.RecoveryConditionJobCreated,
+		Status:  core.ConditionTrue,
+		Message: "Recovery job created: recover-b",
+	})
+	if len(conditions) != 1 || !conditions[0].LastTransitionTime.Equal(&created) || conditions[0].Message != "Recovery job created: recover-b" {
+		t.Errorf("unexpected conditions %+v", conditions)
+	}
+
+	// changed status updates the transition time
+	conditions = UpsertRecoveryCondition(conditions, api.RecoveryCondition{
+		Type:   api.RecoveryConditionJobCreated,
+		Status: core.ConditionFalse,
+	})
+	if len(conditions) != 1 || !conditions[0].LastTransitionTime.After(created.Time) {
+		t.Errorf("expected transition time to be updated, found %+v", conditions)
+	}
+
+	// new types are appended with the current time
+	conditions = UpsertRecoveryCondition(conditions, api.RecoveryCondition{
+		Type:   api.RecoveryConditionFailed,
+		Status: core.ConditionTrue,
+	})
+	if len(conditions) != 2 || conditions[1].Type != api.RecoveryConditionFailed || conditions[1].LastTransitionTime.IsZero() {
+		t.Errorf("expected failed condition to be appended, found %+v", conditions)
+	}
+}
+
+func TestSetRecoveryStatusPhaseConflict(t *testing.T) {
+	stale := &api.Recovery{ObjectMeta: metav1.ObjectMeta{Name: "rec", Namespace: "default", ResourceVersion: "1"}}
+	latest := stale.DeepCopy()
+	latest.ResourceVersion = "2"
+	latest.Status.Conditions = []api.RecoveryCondition{{Type: api.RecoveryConditionJobCreated, Status: core.ConditionTrue}}
+	client := stash_fake.NewSimpleClientset(latest)
+
+	var patches [][]byte
+	client.PrependReactor("patch", "recoveries", func(action clienttesting.Action) (bool, runtime.Object, error) {
+		patches = append(patches, action.(clienttesting.PatchAction).GetPatch())
+		if len(patches) == 1 {
+			return true, nil, kerr.NewConflict(api.Resource("recoveries"), "rec", nil)
+		}
+		return true, latest, nil
+	})
+
+	SetRecoveryStatusPhase(client.StashV1alpha1(), stale, api.RecoveryFailed,
+		api.RecoveryCondition{Type: api.RecoveryConditionFailed, Status: core.ConditionTrue})
+	if len(patches) != 2 {
+		t.Fatalf("expected patch to be retried once after conflict, found %d patches", len(patches))
+	}
+
+	var stalePatch, patch api.Recovery
+	if err := json.Unmarshal(patches[0], &stalePatch); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(patches[1], &patch); err != nil {
+		t.Fatal(err)
+	}
+	// the apiserver only rejects a merge patch with a conflict if it includes the resourceVersion
+	if stalePatch.ResourceVersion != stale.ResourceVersion || patch.ResourceVersion != latest.ResourceVersion {
+		t.Errorf("expected resourceVersions %s and %s in patches, found %s and %s",
+			stale.ResourceVersion, latest.ResourceVersion, stalePatch.ResourceVersion, patch.ResourceVersion)
+	}
+	if patch.Status.Phase != api.RecoveryFailed {
+		t.Errorf("expected phase %s in retried patch, found %s", api.RecoveryFailed, patch.Status.Phase)
+	}
+	// the retried patch is computed from the latest Recovery, so it keeps the condition set meanwhile
+	types := map[api.RecoveryConditionType]bool{}
+	for _, cond := range patch.Status.Conditions {
+		types[cond.Type] = true
+	}
+	if !types[api.RecoveryConditionJobCreated] || !types[api.RecoveryConditionFailed] {
+		t.Errorf("expected JobCreated and Failed conditions in retried patch, found %+v", patch.Status.Conditions)
+	}
+}
@@ -100,3 +100,27 @@ func TryUpdateRestic(c cs.StashV1alpha1Interface, meta metav1.ObjectMeta, transf
 	}
 	return
 }
+
+// SetResticCondition adds or replaces the condition of the same type in the status of the Restic.
+// LastTransitionTime is only updated if the status of the condition changes.
+func SetResticCondition(c cs.StashV1alpha1Interface, restic *api.Restic, condition api.ResticCondition) {
+	_, err := PatchRestic(c, restic, func(in *api.Restic) *api.Restic {
+		condition.LastTransitionTime = metav1.Now()
+		for i := range in.Status.Conditions {
+			if in.Status.Conditions[i].Type == condition.Type {
+				if in.Status.Conditions[i].Status == condition.Status {
+					condition.LastTransitionTime = in.Status.Conditions[i].LastTransitionTime
+				}
+				in.Status.Conditions[i] = condition
+				return in
+			}
+		}
+		in.Status.Conditions = append(in.Status.Conditions, condition)
+		return in
+	})
+	if err != nil {
+		glog.Errorln("Error updating restic condition:", condition.Type, "reason:", err)
+	} else {
+		glog.Infoln("Updated restic condition:", condition.Type)
+	}
+}
@@ -14,7 +14,8 @@ source "$REPO_ROOT/hack/libbuild/common/public_image.sh"
 
 APPSCODE_ENV=${APPSCODE_ENV:-dev}
 IMG=stash
-RESTIC_VER=${RESTIC_VER:-0.8.0}
+# restic 0.8.2 added --tls-client-cert, used for rest backends with mutual TLS
+RESTIC_VER=${RESTIC_VER:-0.8.3}
 RESTIC_BRANCH=${RESTIC_BRANCH:-stash-0.4.2}
 
 DIST=$REPO_ROOT/dist
@@ -75,7 +76,7 @@ build_docker() {
 FROM alpine
 
 RUN set -x \
-  && apk add --update --no-cache ca-certificates
+  && apk add --update --no-cache ca-certificates openssh-client
 
 COPY restic /bin/restic
 COPY stash /bin/stash
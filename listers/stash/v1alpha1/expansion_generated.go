@@ -33,3 +33,11 @@ type ResticListerExpansion interface{}
 // ResticNamespaceListerExpansion allows custom methods to be added to
 // ResticNamespaceLister.
 type ResticNamespaceListerExpansion interface{}
+
+// SnapshotListerExpansion allows custom methods to be added to
+// SnapshotLister.
+type SnapshotListerExpansion interface{}
+
+// SnapshotNamespaceListerExpansion allows custom methods to be added to
+// SnapshotNamespaceLister.
+type SnapshotNamespaceListerExpansion interface{}
@@ -0,0 +1,55 @@
+// Package admission holds the subset of the admission.k8s.io/v1beta1 API used by the stash mutating
+// webhook. The vendored k8s.io/api predates this API group, so the wire types are declared here.
+package admission
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+type Operation string
+
+const (
+	Create Operation = "CREATE"
+	Update Operation = "UPDATE"
+)
+
+type PatchType string
+
+const (
+	PatchTypeJSONPatch PatchType = "JSONPatch"
+)
+
+// AdmissionReview is sent by the apiserver to the webhook and returned with Response set.
+type AdmissionReview struct {
+	metav1.TypeMeta `json:",inline"`
+	Request         *AdmissionRequest  `json:"request,omitempty"`
+	Response        *AdmissionResponse `json:"response,omitempty"`
+}
+
+type AdmissionRequest struct {
+	UID       types.UID                   `json:"uid"`
+	Kind      metav1.GroupVersionKind     `json:"kind"`
+	Resource  metav1.GroupVersionResource `json:"resource"`
+	Name      string                      `json:"name,omitempty"`
+	Namespace string                      `json:"namespace,omitempty"`
+	Operation Operation                   `json:"operation"`
+	Object    runtime.RawExtension        `json:"object,omitempty"`
+}
+
+type AdmissionResponse struct {
+	UID     types.UID      `json:"uid"`
+	Allowed bool           `json:"allowed"`
+	Result  *metav1.Status `json:"status,omitempty"`
+	// JSON patch (RFC 6902) applied to the object, only if Allowed.
+	Patch     []byte     `json:"patch,omitempty"`
+	PatchType *PatchType `json:"patchType,omitempty"`
+}
+
+// PatchOperation is a single operation of a JSON patch.
+type PatchOperation struct {
+	Op    string      `json:"op"`
+	Path  string      `json:"path"`
+	Value interface{} `json:"value,omitempty"`
+}
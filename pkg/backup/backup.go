@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"time"
 
 	"github.com/appscode/go/log"
@@ -20,9 +21,12 @@ import (
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/push"
 	"gopkg.in/robfig/cron.v2"
+	batch "k8s.io/api/batch/v1"
 	core "k8s.io/api/core/v1"
 	rbac "k8s.io/api/rbac/v1beta1"
+	kerr "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/tools/cache"
 	"k8s.io/client-go/tools/record"
@@ -43,8 +47,13 @@ type Options struct {
 	ResyncPeriod     time.Duration
 	MaxNumRequeues   int
 	RunViaCron       bool
-	ImageTag         string // image tag for check job
-	EnableRBAC       bool   // rbac for check job
+	ImageTag         string // image tag for check and forget jobs
+	EnableRBAC       bool   // rbac for check and forget jobs
+	Limits           cli.Limits
+	Excludes         cli.Excludes
+	OneFileSystem    bool
+	// Requests and limits of check and forget jobs for resources not set in the Restic
+	JobDefaultResources core.ResourceRequirements
 }
 
 type Controller struct {
@@ -55,6 +64,8 @@ type Controller struct {
 	resticCLI   *cli.ResticWrapper
 	cron        *cron.Cron
 	recorder    record.EventRecorder
+	// restic for the mirror backend, nil if the Restic has none
+	mirrorCLI *cli.ResticWrapper
 
 	// Restic
 	rQueue    workqueue.RateLimitingInterface
@@ -75,11 +86,19 @@ func New(k8sClient kubernetes.Interface, stashClient cs.StashV1alpha1Interface,
 		opt:         opt,
 		cron:        cron.New(),
 		locked:      make(chan struct{}, 1),
-		resticCLI:   cli.New(opt.ScratchDir, true, opt.SnapshotHostname),
+		resticCLI:   newResticCLI(opt),
 		recorder:    eventer.NewEventRecorder(k8sClient, BackupEventComponent),
 	}
 }
 
+func newResticCLI(opt Options) *cli.ResticWrapper {
+	w := cli.New(opt.ScratchDir, true, opt.SnapshotHostname)
+	w.SetLimits(opt.Limits)
+	w.SetExcludes(opt.Excludes)
+	w.SetOneFileSystem(opt.OneFileSystem)
+	return w
+}
+
 func (c *Controller) Backup() error {
 	resource, err := c.setup()
 	if err != nil {
@@ -98,17 +117,55 @@ func (c *Controller) Backup() error {
 		return fmt.Errorf("failed to run backup, reason: %s", err)
 	}
 
+	var errs []error
+
 	// create check job
-	job := util.CreateCheckJob(resource, c.opt.SnapshotHostname, c.opt.SmartPrefix, c.opt.ImageTag)
+	if job, err := util.CreateCheckJob(resource, c.opt.SnapshotHostname, c.opt.SmartPrefix, c.opt.ImageTag); err != nil {
+		errs = append(errs, fmt.Errorf("failed to build check job, reason: %s", err))
+	} else if err = c.createJob(resource, job, "check", eventer.EventReasonCheckJobCreated); err != nil {
+		errs = append(errs, err)
+	}
+
+	// create forget job, offline backups don't apply retention policies themselves.
+	// It is created even if the check job failed, so that retention is still applied.
+	if util.HasRetentionPolicy(resource) {
+		if job, err := util.CreateForgetJob(resource, c.opt.SnapshotHostname, c.opt.SmartPrefix, c.opt.ImageTag); err != nil {
+			errs = append(errs, fmt.Errorf("failed to build forget job, reason: %s", err))
+		} else if err = c.createJob(resource, job, "forget", eventer.EventReasonForgetJobCreated); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return utilerrors.NewAggregate(errs)
+}
+
+func (c *Controller) createJob(resource *api.Restic, job *batch.Job, op, reason string) (err error) {
+	if err = util.ValidateBackendSecret(c.k8sClient, resource.Namespace, resource.Spec.Backend); err != nil {
+		err = fmt.Errorf("failed to create %s job, reason: %s", op, err)
+		eventer.CreateEventWithLog(
+			c.k8sClient,
+			BackupEventComponent,
+			resource.ObjectReference(),
+			core.EventTypeWarning,
+			eventer.EventReasonFailedCronJob,
+			err.Error(),
+		)
+		return err
+	}
+
 	if c.opt.EnableRBAC {
-		if err = c.ensureCheckRBAC(job.Name, job.Namespace); err != nil {
-			return fmt.Errorf("error ensuring rbac for check job %s, reason: %s\n", job.Name, err)
+		if err = c.ensureJobRBAC(job.Name, job.Namespace); err != nil {
+			return fmt.Errorf("error ensuring rbac for %s job %s, reason: %s\n", op, job.Name, err)
 		}
 		job.Spec.Template.Spec.ServiceAccountName = job.Name
 	}
+	util.ApplyJobDefaultResources(job, c.opt.JobDefaultResources)
 
-	if job, err = c.k8sClient.BatchV1().Jobs(resource.Namespace).Create(job); err != nil {
-		err = fmt.Errorf("failed to create check job, reason: %s", err)
+	if _, err = c.k8sClient.BatchV1().Jobs(resource.Namespace).Create(job); kerr.IsAlreadyExists(err) {
+		// another pod of the workload or an earlier backup already created it
+		log.Infof("%s job %s already exists\n", op, job.Name)
+		return nil
+	} else if err != nil {
+		err = fmt.Errorf("failed to create %s job, reason: %s", op, err)
 		eventer.CreateEventWithLog(
 			c.k8sClient,
 			BackupEventComponent,
@@ -120,14 +177,14 @@ func (c *Controller) Backup() error {
 		return err
 	}
 
-	log.Infoln("Created check job:", job.Name)
+	log.Infof("Created %s job: %s\n", op, job.Name)
 	eventer.CreateEventWithLog(
 		c.k8sClient,
 		BackupEventComponent,
 		resource.ObjectReference(),
 		core.EventTypeNormal,
-		eventer.EventReasonCheckJobCreated,
-		fmt.Sprintf("Created check job: %s", job.Name),
+		reason,
+		fmt.Sprintf("Created %s job: %s", op, job.Name),
 	)
 	return nil
 }
@@ -164,6 +221,9 @@ func (c *Controller) setup() (*api.Restic, error) {
 	if err = c.resticCLI.InitRepositoryIfAbsent(); err != nil {
 		return nil, err
 	}
+	if err = c.setupMirror(resource); err != nil {
+		return nil, err
+	}
 
 	return resource, nil
 }
@@ -219,16 +279,25 @@ func (c *Controller) runResticBackup(resource *api.Restic) (err error) {
 		}
 
 		stash_util.PatchRestic(c.stashClient, resource, func(in *api.Restic) *api.Restic {
-			in.Status.BackupCount++
-			in.Status.LastBackupTime = &startTime
-			if in.Status.FirstBackupTime == nil {
-				in.Status.FirstBackupTime = &startTime
-			}
-			in.Status.LastBackupDuration = endTime.Sub(startTime.Time).String()
-			return in
+			return setBackupStatus(in, startTime, endTime, err == nil)
 		})
 	}()
 
+	if resource.Spec.PreBackupHook != nil {
+		if err = runHook(resource.Spec.PreBackupHook); err != nil {
+			log.Errorf("Pre-backup hook failed for Restic %s/%s due to %s\n", resource.Namespace, resource.Name, err)
+			eventer.CreateEventWithLog(
+				c.k8sClient,
+				BackupEventComponent,
+				resource.ObjectReference(),
+				core.EventTypeWarning,
+				eventer.EventReasonFailedToBackup,
+				fmt.Sprintf("Pre-backup hook failed for Restic %s/%s due to %s", resource.Namespace, resource.Name, err),
+			)
+			return
+		}
+	}
+
 	for _, fg := range resource.Spec.FileGroups {
 		backupOpMetric := restic_session_duration_seconds.WithLabelValues(sanitizeLabelValue(fg.Path), "backup")
 		err = c.measure(c.resticCLI.Backup, resource, fg, backupOpMetric)
@@ -255,6 +324,9 @@ func (c *Controller) runResticBackup(resource *api.Restic) (err error) {
 			)
 		}
 
+		if !c.opt.RunViaCron {
+			continue // retention policies of offline backups are applied by the forget job
+		}
 		forgetOpMetric := restic_session_duration_seconds.WithLabelValues(sanitizeLabelValue(fg.Path), "forget")
 		err = c.measure(c.resticCLI.Forget, resource, fg, forgetOpMetric)
 		if err != nil {
@@ -270,9 +342,76 @@ func (c *Controller) runResticBackup(resource *api.Restic) (err error) {
 			return
 		}
 	}
+	if c.mirrorCLI != nil {
+		// a failed mirror backup doesn't fail the session, it is reported by the MirrorBackedUp condition
+		mirrorErr := c.runMirrorBackup(resource, restic_session_duration_seconds)
+		stash_util.SetResticCondition(c.stashClient, resource, util.MirrorBackedUpCondition(c.opt.SnapshotHostname, mirrorErr))
+	}
 	return
 }
 
+// runMirrorBackup backs up the fileGroups of resource to its mirror backend. Retention policies of
+// online backups are applied to the mirror as well.
+func (c *Controller) runMirrorBackup(resource *api.Restic, durations *prometheus.GaugeVec) error {
+	for _, fg := range resource.Spec.FileGroups {
+		err := c.measure(c.mirrorCLI.Backup, resource, fg, durations.WithLabelValues(sanitizeLabelValue(fg.Path), "mirror"))
+		if err == nil && c.opt.RunViaCron {
+			err = c.measure(c.mirrorCLI.Forget, resource, fg, durations.WithLabelValues(sanitizeLabelValue(fg.Path), "mirror-forget"))
+		}
+		if err != nil {
+			log.Errorf("Mirror backup failed for Restic %s/%s due to %s\n", resource.Namespace, resource.Name, err)
+			eventer.CreateEventWithLog(
+				c.k8sClient,
+				BackupEventComponent,
+				resource.ObjectReference(),
+				core.EventTypeWarning,
+				eventer.EventReasonFailedToBackup,
+				fmt.Sprintf("Mirror backup failed for Restic %s/%s due to %s", resource.Namespace, resource.Name, err),
+			)
+			return err
+		}
+	}
+	return nil
+}
+
+// setupMirror sets up mirrorCLI to access the repository of the mirror backend of resource and
+// initializes the repository if absent. mirrorCLI is nil if resource has no mirror backend. It uses
+// its own scratch dir, so that credentials written there don't overwrite those of the primary backend.
+func (c *Controller) setupMirror(resource *api.Restic) error {
+	if resource.Spec.Mirror == nil {
+		c.mirrorCLI = nil
+		return nil
+	}
+	if c.mirrorCLI == nil {
+		opt := c.opt
+		opt.ScratchDir = filepath.Join(c.opt.ScratchDir, "mirror")
+		if err := os.MkdirAll(opt.ScratchDir, 0755); err != nil {
+			return fmt.Errorf("failed to create scratch dir of mirror: %s", err)
+		}
+		c.mirrorCLI = newResticCLI(opt)
+	}
+	if err := util.SetupMirrorEnv(c.k8sClient, c.mirrorCLI, resource, c.opt.SmartPrefix); err != nil {
+		return fmt.Errorf("failed to setup mirror backend: %s", err)
+	}
+	return c.mirrorCLI.InitRepositoryIfAbsent()
+}
+
+// setBackupStatus records a backup session that started at startTime and ended at endTime in the
+// status of restic. LastSuccessfulBackupTime is only set if the backup succeeded, so that stale
+// backups can be detected.
+func setBackupStatus(restic *api.Restic, startTime, endTime metav1.Time, succeeded bool) *api.Restic {
+	restic.Status.BackupCount++
+	restic.Status.LastBackupTime = &startTime
+	if restic.Status.FirstBackupTime == nil {
+		restic.Status.FirstBackupTime = &startTime
+	}
+	if succeeded {
+		restic.Status.LastSuccessfulBackupTime = &startTime
+	}
+	restic.Status.LastBackupDuration = endTime.Sub(startTime.Time).String()
+	return restic
+}
+
 func (c *Controller) measure(f func(*api.Restic, api.FileGroup) error, resource *api.Restic, fg api.FileGroup, g prometheus.Gauge) (err error) {
 	startTime := time.Now()
 	defer func() {
@@ -283,7 +422,7 @@ func (c *Controller) measure(f func(*api.Restic, api.FileGroup) error, resource
 }
 
 // use Sidecar Cluster Role
-func (c *Controller) ensureCheckRBAC(resourceName string, namespace string) error {
+func (c *Controller) ensureJobRBAC(resourceName string, namespace string) error {
 	// ensure service account
 	meta := metav1.ObjectMeta{
 		Name:      resourceName,
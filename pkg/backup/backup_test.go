@@ -0,0 +1,77 @@
+package backup
+
+import (
+	"testing"
+	"time"
+
+	api "github.com/appscode/stash/apis/stash/v1alpha1"
+	"github.com/appscode/stash/pkg/cli"
+	"github.com/appscode/stash/pkg/util"
+	core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestSetBackupStatus(t *testing.T) {
+	first := metav1.NewTime(time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC))
+	second := metav1.NewTime(first.Add(time.Hour))
+
+	restic := setBackupStatus(&api.Restic{}, first, metav1.NewTime(first.Add(time.Minute)), true)
+	if restic.Status.BackupCount != 1 || !restic.Status.FirstBackupTime.Equal(&first) || !restic.Status.LastBackupTime.Equal(&first) {
+		t.Errorf("unexpected status after first backup %+v", restic.Status)
+	}
+	if !restic.Status.LastSuccessfulBackupTime.Equal(&first) || restic.Status.LastBackupDuration != "1m0s" {
+		t.Errorf("unexpected status after first backup %+v", restic.Status)
+	}
+
+	// failed backups don't move the last successful backup time
+	restic = setBackupStatus(restic, second, metav1.NewTime(second.Add(time.Second)), false)
+	if restic.Status.BackupCount != 2 || !restic.Status.FirstBackupTime.Equal(&first) || !restic.Status.LastBackupTime.Equal(&second) {
+		t.Errorf("unexpected status after failed backup %+v", restic.Status)
+	}
+	if !restic.Status.LastSuccessfulBackupTime.Equal(&first) {
+		t.Errorf("expected last successful backup at %s, found %s", first, restic.Status.LastSuccessfulBackupTime)
+	}
+}
+
+func TestCreateJobAlreadyExists(t *testing.T) {
+	restic := &api.Restic{ObjectMeta: metav1.ObjectMeta{Name: "stash-demo", Namespace: "default"}}
+	restic.Spec.Backend = api.Backend{
+		StorageSecretName: "backend-secret",
+		Local:             &api.LocalSpec{Path: "/repo", VolumeSource: core.VolumeSource{EmptyDir: &core.EmptyDirVolumeSource{}}},
+	}
+	restic.Spec.FileGroups = []api.FileGroup{{Path: "/source/data", RetentionPolicyName: "keep-last-5"}}
+	secret := &core.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "backend-secret", Namespace: "default"},
+		Data:       map[string][]byte{cli.RESTIC_PASSWORD: []byte("secret")},
+	}
+	c := &Controller{k8sClient: fake.NewSimpleClientset(secret)}
+
+	// every pod of a StatefulSet creates its own forget job
+	for _, host := range []string{"host-0", "host-1"} {
+		job, err := util.CreateForgetJob(restic, host, "statefulset/"+host, "canary")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = c.createJob(restic, job, "forget", "ForgetJobCreated"); err != nil {
+			t.Errorf("%s: unexpected error %v", host, err)
+		}
+	}
+	// the check job of a second pod already exists
+	for i := 0; i < 2; i++ {
+		job, err := util.CreateCheckJob(restic, "host-0", "statefulset/host-0", "canary")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = c.createJob(restic, job, "check", "CheckJobCreated"); err != nil {
+			t.Errorf("expected existing check job to be ignored, found %v", err)
+		}
+	}
+	jobs, err := c.k8sClient.BatchV1().Jobs("default").List(metav1.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(jobs.Items) != 3 {
+		t.Errorf("expected 3 jobs, found %d", len(jobs.Items))
+	}
+}
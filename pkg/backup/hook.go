@@ -0,0 +1,35 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+
+	api "github.com/appscode/stash/apis/stash/v1alpha1"
+)
+
+const defaultHookTimeout = 60 * time.Second
+
+// hookCommand returns the command of hook. It is killed once the timeout of the hook
+// passes or cancel is called.
+func hookCommand(hook *api.BackupHook) (cmd *exec.Cmd, cancel context.CancelFunc) {
+	timeout := defaultHookTimeout
+	if hook.TimeoutSeconds != nil {
+		timeout = time.Duration(*hook.TimeoutSeconds) * time.Second
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	return exec.CommandContext(ctx, hook.Exec.Command[0], hook.Exec.Command[1:]...), cancel
+}
+
+// runHook runs hook and returns an error including the output of the command if it fails.
+func runHook(hook *api.BackupHook) error {
+	cmd, cancel := hookCommand(hook)
+	defer cancel()
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("command %s failed, reason: %s, output: %s", strings.Join(hook.Exec.Command, " "), err, out)
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+package backup
+
+import (
+	"strings"
+	"testing"
+
+	api "github.com/appscode/stash/apis/stash/v1alpha1"
+	stash_fake "github.com/appscode/stash/client/fake"
+	"github.com/appscode/stash/pkg/eventer"
+	core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestHookCommand(t *testing.T) {
+	hook := &api.BackupHook{Exec: &core.ExecAction{Command: []string{"/bin/sh", "-c", "sync"}}}
+	cmd, cancel := hookCommand(hook)
+	defer cancel()
+	if cmd.Path != "/bin/sh" || strings.Join(cmd.Args, " ") != "/bin/sh -c sync" {
+		t.Errorf("unexpected hook command %s %v", cmd.Path, cmd.Args)
+	}
+}
+
+func TestRunHook(t *testing.T) {
+	timeout := int32(1)
+	cases := map[string]struct {
+		hook *api.BackupHook
+		err  string
+	}{
+		"succeeded": {&api.BackupHook{Exec: &core.ExecAction{Command: []string{"true"}}}, ""},
+		"failed": {
+			&api.BackupHook{Exec: &core.ExecAction{Command: []string{"/bin/sh", "-c", "echo table locked; exit 3"}}},
+			"table locked",
+		},
+		"timed out": {
+			&api.BackupHook{Exec: &core.ExecAction{Command: []string{"sleep", "10"}}, TimeoutSeconds: &timeout},
+			"killed",
+		},
+	}
+	for name, c := range cases {
+		err := runHook(c.hook)
+		if c.err == "" && err != nil {
+			t.Errorf("%s: unexpected error: %s", name, err)
+		} else if c.err != "" && (err == nil || !strings.Contains(err.Error(), c.err)) {
+			t.Errorf("%s: expected error containing %q, found %v", name, c.err, err)
+		}
+	}
+}
+
+func TestRunResticBackupHookFailed(t *testing.T) {
+	restic := &api.Restic{
+		ObjectMeta: metav1.ObjectMeta{Name: "stash-demo", Namespace: "default"},
+		Spec: api.ResticSpec{
+			FileGroups: []api.FileGroup{{Path: "/source/data"}},
+			PreBackupHook: &api.BackupHook{
+				Exec: &core.ExecAction{Command: []string{"/bin/sh", "-c", "echo table locked; exit 3"}},
+			},
+		},
+	}
+	k8sClient := fake.NewSimpleClientset()
+	stashClient := stash_fake.NewSimpleClientset(restic)
+	// restic is not set up, so the backup must be aborted before any restic command runs
+	c := &Controller{k8sClient: k8sClient, stashClient: stashClient.StashV1alpha1()}
+
+	if err := c.runResticBackup(restic); err == nil || !strings.Contains(err.Error(), "table locked") {
+		t.Fatalf("expected hook error, found %v", err)
+	}
+	events, err := k8sClient.CoreV1().Events(restic.Namespace).List(metav1.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events.Items) != 1 || events.Items[0].Reason != eventer.EventReasonFailedToBackup ||
+		!strings.Contains(events.Items[0].Message, "table locked") {
+		t.Errorf("expected a single %s event with the hook output, found %+v", eventer.EventReasonFailedToBackup, events.Items)
+	}
+	patched := false
+	for _, action := range stashClient.Actions() {
+		patched = patched || action.GetVerb() == "patch"
+	}
+	if !patched {
+		t.Error("expected the backup status of the Restic to be patched")
+	}
+}
@@ -7,6 +7,7 @@ import (
 
 	"github.com/appscode/go/log"
 	api "github.com/appscode/stash/apis/stash/v1alpha1"
+	stash_util "github.com/appscode/stash/client/typed/stash/v1alpha1/util"
 	"github.com/appscode/stash/pkg/eventer"
 	"github.com/appscode/stash/pkg/util"
 	"github.com/golang/glog"
@@ -29,12 +30,11 @@ func (c *Controller) BackupScheduler() error {
 	defer close(stopBackup)
 
 	// split code from here for leader election
-	switch c.opt.Workload.Kind {
-	case api.KindDeployment, api.KindReplicaSet, api.KindReplicationController:
+	if util.SharesRepository(c.opt.Workload.Kind) {
 		if err := c.electLeader(stopBackup); err != nil {
 			return err
 		}
-	default:
+	} else {
 		if err := c.setupAndRunScheduler(stopBackup); err != nil {
 			return err
 		}
@@ -121,7 +121,11 @@ func (c *Controller) configureScheduler(r *api.Restic) error {
 	if err != nil {
 		return err
 	}
-	_, err = c.cron.AddFunc("0 0 */3 * *", func() { c.checkOnceForScheduler() })
+	checkSchedule := "0 0 */3 * *"
+	if r.Spec.CheckInterval != nil {
+		checkSchedule = "@every " + r.Spec.CheckInterval.Duration.String()
+	}
+	_, err = c.cron.AddFunc(checkSchedule, func() { c.checkOnceForScheduler() })
 	return err
 }
 
@@ -159,6 +163,9 @@ func (c *Controller) runOnceForScheduler() error {
 	if err = c.resticCLI.InitRepositoryIfAbsent(); err != nil {
 		return err
 	}
+	if err = c.setupMirror(resource); err != nil {
+		return err
+	}
 
 	// run final restic backup command
 	return c.runResticBackup(resource)
@@ -189,5 +196,6 @@ func (c *Controller) checkOnceForScheduler() (err error) {
 	if err != nil {
 		c.recorder.Eventf(resource.ObjectReference(), core.EventTypeWarning, eventer.EventReasonFailedToCheck, "Repository check failed for workload %s %s/%s. Reason: %v", c.opt.Workload.Kind, c.opt.Namespace, c.opt.Workload.Name, err)
 	}
+	stash_util.SetResticCondition(c.stashClient, resource, util.RepositoryHealthyCondition(c.opt.SnapshotHostname, err))
 	return
 }
@@ -4,8 +4,10 @@ import (
 	"fmt"
 
 	cs "github.com/appscode/stash/client/typed/stash/v1alpha1"
+	stash_util "github.com/appscode/stash/client/typed/stash/v1alpha1/util"
 	"github.com/appscode/stash/pkg/cli"
 	"github.com/appscode/stash/pkg/eventer"
+	"github.com/appscode/stash/pkg/util"
 	core "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
@@ -21,6 +23,7 @@ type Options struct {
 	ResticName  string
 	HostName    string
 	SmartPrefix string
+	Limits      cli.Limits
 }
 
 type Controller struct {
@@ -46,6 +49,7 @@ func (c *Controller) Run() (err error) {
 	}
 
 	defer func() {
+		stash_util.SetResticCondition(c.stashClient, restic, util.RepositoryHealthyCondition(c.opt.HostName, err))
 		if err != nil {
 			eventer.CreateEventWithLog(
 				c.k8sClient,
@@ -73,7 +77,8 @@ func (c *Controller) Run() (err error) {
 		return
 	}
 
-	cli := cli.New("/tmp", false, c.opt.HostName)
+	cli := cli.New(restic.GetScratchMountPath(), false, c.opt.HostName)
+	cli.SetLimits(c.opt.Limits)
 	if err = cli.SetupEnv(restic, secret, c.opt.SmartPrefix); err != nil {
 		return
 	}
@@ -4,8 +4,10 @@ import (
 	"errors"
 	"fmt"
 	"io/ioutil"
+	"net/url"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 
 	"github.com/appscode/go/log"
@@ -14,9 +16,11 @@ import (
 )
 
 const (
-	RESTIC_REPOSITORY = "RESTIC_REPOSITORY"
-	RESTIC_PASSWORD   = "RESTIC_PASSWORD"
-	TMPDIR            = "TMPDIR"
+	RESTIC_REPOSITORY    = "RESTIC_REPOSITORY"
+	RESTIC_PASSWORD      = "RESTIC_PASSWORD"
+	RESTIC_PASSWORD_FILE = "RESTIC_PASSWORD_FILE"
+	RESTIC_CACHE_DIR     = "RESTIC_CACHE_DIR"
+	TMPDIR               = "TMPDIR"
 
 	AWS_ACCESS_KEY_ID     = "AWS_ACCESS_KEY_ID"
 	AWS_SECRET_ACCESS_KEY = "AWS_SECRET_ACCESS_KEY"
@@ -31,6 +35,9 @@ const (
 	REST_SERVER_USERNAME = "REST_SERVER_USERNAME"
 	REST_SERVER_PASSWORD = "REST_SERVER_PASSWORD"
 
+	RESTIC_REST_USERNAME = "RESTIC_REST_USERNAME"
+	RESTIC_REST_PASSWORD = "RESTIC_REST_PASSWORD"
+
 	B2_ACCOUNT_ID  = "B2_ACCOUNT_ID"
 	B2_ACCOUNT_KEY = "B2_ACCOUNT_KEY"
 
@@ -52,12 +59,53 @@ const (
 	// For authentication based on tokens
 	OS_STORAGE_URL = "OS_STORAGE_URL"
 	OS_AUTH_TOKEN  = "OS_AUTH_TOKEN"
+
+	// Keys of the SSH secret of sftp backend
+	SSH_PRIVATE_KEY = "ssh-privatekey"
+	SSH_KNOWN_HOSTS = "known_hosts"
+)
+
+// SFTPKeyDir is the directory where the SSH secret of sftp backend is mounted in stash containers.
+const SFTPKeyDir = "/etc/stash-sftp"
+
+// RestTLSDir is the directory where the TLS secret of rest backend is mounted in stash containers.
+// restic reads the client certificate and key from RestTLSClientCertFile, passed via --tls-client-cert.
+const (
+	RestTLSDir            = "/etc/stash-rest-tls"
+	RestTLSClientCertFile = "client.pem"
 )
 
 func (w *ResticWrapper) SetupEnv(resource *api.Restic, secret *core.Secret, autoPrefix string) error {
-	if v, ok := secret.Data[RESTIC_PASSWORD]; !ok {
+	return w.setupBackendEnv(resource.Spec.Backend, secret, autoPrefix)
+}
+
+// SetupMirrorEnv sets up restic commands to access the repository of mirror, the mirror backend of a
+// Restic. Containers created by stash provide the password file and credentials of the primary backend
+// only, so the password of the mirror is always passed as RESTIC_PASSWORD and those of the primary
+// backend are cleared.
+func (w *ResticWrapper) SetupMirrorEnv(mirror api.Backend, secret *core.Secret, autoPrefix string) error {
+	primaryKeys := []string{RESTIC_PASSWORD_FILE, RESTIC_REST_USERNAME, RESTIC_REST_PASSWORD,
+		AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY, GOOGLE_PROJECT_ID, GOOGLE_APPLICATION_CREDENTIALS}
+	for _, key := range primaryKeys {
+		w.sh.SetEnv(key, "")
+	}
+	mirror.PasswordSource = api.PasswordSourceEnv
+	if err := w.setupBackendEnv(mirror, secret, autoPrefix); err != nil {
+		return err
+	}
+	// the TLS secret mounted in the container belongs to the primary backend
+	w.tlsClientCert = ""
+	return nil
+}
+
+func (w *ResticWrapper) setupBackendEnv(backend api.Backend, secret *core.Secret, autoPrefix string) error {
+	v, ok := secret.Data[RESTIC_PASSWORD]
+	if !ok {
 		return errors.New("Missing repository password")
-	} else {
+	}
+	// containers created by stash mount the password file, elsewhere, e.g. in the operator, the
+	// password is passed to the restic commands of this session only
+	if backend.PasswordSource != api.PasswordSourceFile || os.Getenv(RESTIC_PASSWORD_FILE) == "" {
 		w.sh.SetEnv(RESTIC_PASSWORD, string(v))
 	}
 
@@ -67,40 +115,54 @@ func (w *ResticWrapper) SetupEnv(resource *api.Restic, secret *core.Secret, auto
 	}
 	w.sh.SetEnv(TMPDIR, tmpDir)
 
-	backend := resource.Spec.Backend
+	if backend.Rest != nil {
+		// the rest server credentials are part of the url
+		rest := *backend.Rest
+		u, err := url.Parse(rest.URL)
+		if err != nil {
+			return err
+		}
+		if username, ok := secret.Data[REST_SERVER_USERNAME]; ok {
+			if password, ok := secret.Data[REST_SERVER_PASSWORD]; ok {
+				u.User = url.UserPassword(string(username), string(password))
+			} else {
+				u.User = url.User(string(username))
+			}
+		}
+		rest.URL = u.String()
+		backend.Rest = &rest
+	}
+	r, err := RepositoryURL(backend, autoPrefix)
+	if err != nil {
+		return err
+	}
+	if r != "" {
+		w.sh.SetEnv(RESTIC_REPOSITORY, r)
+	}
+
 	if backend.Local != nil {
-		r := filepath.Join(backend.Local.Path, autoPrefix)
 		if err := os.MkdirAll(r, 0755); err != nil {
 			return err
 		}
-		w.sh.SetEnv(RESTIC_REPOSITORY, r)
 	} else if backend.S3 != nil {
-		prefix := strings.TrimPrefix(filepath.Join(backend.S3.Bucket, backend.S3.Prefix, autoPrefix), "/")
-		r := fmt.Sprintf("s3:%s/%s", backend.S3.Endpoint, prefix)
-		w.sh.SetEnv(RESTIC_REPOSITORY, r)
-		w.sh.SetEnv(AWS_ACCESS_KEY_ID, string(secret.Data[AWS_ACCESS_KEY_ID]))
-		w.sh.SetEnv(AWS_SECRET_ACCESS_KEY, string(secret.Data[AWS_SECRET_ACCESS_KEY]))
+		// without keys, restic uses the IAM role of the pod
+		w.setSecretEnv(secret, AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY)
 	} else if backend.GCS != nil {
-		prefix := strings.TrimPrefix(filepath.Join(backend.GCS.Prefix, autoPrefix), "/")
-		r := fmt.Sprintf("gs:%s:/%s", backend.GCS.Bucket, prefix)
-		w.sh.SetEnv(RESTIC_REPOSITORY, r)
-		w.sh.SetEnv(GOOGLE_PROJECT_ID, string(secret.Data[GOOGLE_PROJECT_ID]))
-		jsonKeyPath := filepath.Join(w.scratchDir, "gcs_sa.json")
-		err := ioutil.WriteFile(jsonKeyPath, secret.Data[GOOGLE_SERVICE_ACCOUNT_JSON_KEY], 0644)
-		if err != nil {
-			return err
+		w.setSecretEnv(secret, GOOGLE_PROJECT_ID)
+		// without json key, restic uses the default credentials of the pod, e.g. workload identity. An
+		// empty GOOGLE_APPLICATION_CREDENTIALS overrides the path to the optional mounted key.
+		jsonKeyPath := ""
+		if key, ok := secret.Data[GOOGLE_SERVICE_ACCOUNT_JSON_KEY]; ok {
+			jsonKeyPath = filepath.Join(w.scratchDir, "gcs_sa.json")
+			if err := ioutil.WriteFile(jsonKeyPath, key, 0644); err != nil {
+				return err
+			}
 		}
 		w.sh.SetEnv(GOOGLE_APPLICATION_CREDENTIALS, jsonKeyPath)
 	} else if backend.Azure != nil {
-		prefix := strings.TrimPrefix(filepath.Join(backend.Azure.Prefix, autoPrefix), "/")
-		r := fmt.Sprintf("azure:%s:/%s", backend.Azure.Container, prefix)
-		w.sh.SetEnv(RESTIC_REPOSITORY, r)
 		w.sh.SetEnv(AZURE_ACCOUNT_NAME, string(secret.Data[AZURE_ACCOUNT_NAME]))
 		w.sh.SetEnv(AZURE_ACCOUNT_KEY, string(secret.Data[AZURE_ACCOUNT_KEY]))
 	} else if backend.Swift != nil {
-		prefix := strings.TrimPrefix(filepath.Join(backend.Swift.Prefix, autoPrefix), "/")
-		r := fmt.Sprintf("swift:%s:/%s", backend.Swift.Container, prefix)
-		w.sh.SetEnv(RESTIC_REPOSITORY, r)
 		// For keystone v1 authentication
 		w.sh.SetEnv(ST_AUTH, string(secret.Data[ST_AUTH]))
 		w.sh.SetEnv(ST_USER, string(secret.Data[ST_USER]))
@@ -114,39 +176,184 @@ func (w *ResticWrapper) SetupEnv(resource *api.Restic, secret *core.Secret, auto
 		w.sh.SetEnv(OS_TENANT_NAME, string(secret.Data[OS_TENANT_NAME]))
 		// For keystone v3 authentication (some variables are optional)
 		w.sh.SetEnv(OS_USER_DOMAIN_NAME, string(secret.Data[OS_USER_DOMAIN_NAME]))
-		w.sh.SetEnv(OS_PROJECT_NAME, string(secret.Data[AZURE_ACCOUNT_NAME]))
-		w.sh.SetEnv(AZURE_ACCOUNT_NAME, string(secret.Data[OS_PROJECT_NAME]))
+		w.sh.SetEnv(OS_PROJECT_NAME, string(secret.Data[OS_PROJECT_NAME]))
 		w.sh.SetEnv(OS_PROJECT_DOMAIN_NAME, string(secret.Data[OS_PROJECT_DOMAIN_NAME]))
 		// For authentication based on tokens
 		w.sh.SetEnv(OS_STORAGE_URL, string(secret.Data[OS_STORAGE_URL]))
 		w.sh.SetEnv(OS_AUTH_TOKEN, string(secret.Data[OS_AUTH_TOKEN]))
-		/*
-			} else if backend.Rest != nil {
-				u, err := url.Parse(backend.Rest.URL)
-				if err != nil {
-					return err
-				}
-				if username, ok := secret.Data[REST_SERVER_USERNAME]; ok {
-					if password, ok := secret.Data[REST_SERVER_PASSWORD]; ok {
-						u.User = url.UserPassword(string(username), string(password))
-					} else {
-						u.User = url.User(string(username))
-					}
-				}
-				u.Path = filepath.Join(u.Path, autoPrefix) // TODO: check
-				r := fmt.Sprintf("rest:%s", u.String())
-				w.sh.SetEnv(RESTIC_REPOSITORY, r)
-			} else if backend.B2 != nil {
-				prefix := filepath.Join(backend.B2.Prefix, autoPrefix)
-				r := fmt.Sprintf("b2:%s:%s", backend.B2.Bucket, prefix)
-				w.sh.SetEnv(RESTIC_REPOSITORY, r)
-				w.sh.SetEnv(B2_ACCOUNT_ID, string(secret.Data[B2_ACCOUNT_ID]))
-				w.sh.SetEnv(B2_ACCOUNT_KEY, string(secret.Data[B2_ACCOUNT_KEY]))
-		*/
+	} else if backend.Rest != nil {
+		// the TLS secret is only mounted in containers created by stash
+		if cert := filepath.Join(w.restTLSMountDir, RestTLSClientCertFile); backend.Rest.TLSSecretName != "" && fileExists(cert) {
+			w.tlsClientCert = cert
+		}
+	} else if backend.B2 != nil {
+		w.sh.SetEnv(B2_ACCOUNT_ID, string(secret.Data[B2_ACCOUNT_ID]))
+		w.sh.SetEnv(B2_ACCOUNT_KEY, string(secret.Data[B2_ACCOUNT_KEY]))
+	} else if backend.SFTP != nil {
+		w.sftp = backend.SFTP
+		// the mounted key is readable by all, so that non-root containers can read it, but ssh
+		// refuses such a key if it is owned by its user
+		if keys := readSFTPKey(w.sftpMountDir); len(keys) > 0 {
+			if err := w.writeSFTPKey(keys); err != nil {
+				return err
+			}
+		}
 	}
 	return nil
 }
 
+// RepositoryURL returns the url of the restic repository of backend, whose path ends with prefix. It is
+// shared by the containers created by stash, which refer to the prefix as an environment variable,
+// and the restic commands run by stash. The url is empty if backend has no storage configured.
+func RepositoryURL(backend api.Backend, prefix string) (string, error) {
+	switch {
+	case backend.Local != nil:
+		if backend.Local.Path == "" {
+			return "", errors.New("missing local backend path")
+		}
+		return filepath.Join(backend.Local.Path, prefix), nil
+	case backend.S3 != nil:
+		if backend.S3.Endpoint == "" {
+			return "", errors.New("missing s3 backend endpoint")
+		}
+		repo := strings.TrimPrefix(filepath.Join(backend.S3.Bucket, backend.S3.Prefix, prefix), "/")
+		return fmt.Sprintf("s3:%s/%s", backend.S3.Endpoint, repo), nil
+	case backend.GCS != nil:
+		repo := strings.TrimPrefix(filepath.Join(backend.GCS.Prefix, prefix), "/")
+		return fmt.Sprintf("gs:%s:/%s", backend.GCS.Bucket, repo), nil
+	case backend.Azure != nil:
+		if backend.Azure.Container == "" {
+			return "", errors.New("missing azure backend container")
+		}
+		repo := strings.TrimPrefix(filepath.Join(backend.Azure.Prefix, prefix), "/")
+		return fmt.Sprintf("azure:%s:/%s", backend.Azure.Container, repo), nil
+	case backend.Swift != nil:
+		if backend.Swift.Container == "" {
+			return "", errors.New("missing swift backend container")
+		}
+		repo := strings.TrimPrefix(filepath.Join(backend.Swift.Prefix, prefix), "/")
+		return fmt.Sprintf("swift:%s:/%s", backend.Swift.Container, repo), nil
+	case backend.Rest != nil:
+		if _, err := url.Parse(backend.Rest.URL); err != nil {
+			return "", err
+		}
+		return "rest:" + appendPrefix(backend.Rest.URL, prefix), nil
+	case backend.B2 != nil:
+		return fmt.Sprintf("b2:%s:%s", backend.B2.Bucket, filepath.Join(backend.B2.Prefix, prefix)), nil
+	case backend.SFTP != nil:
+		return fmt.Sprintf("sftp:%s:%s", sftpUserHost(backend.SFTP), filepath.Join(backend.SFTP.Path, prefix)), nil
+	case backend.Raw != nil:
+		// credentials are exposed as environment variables of the container from backend.Raw.SecretName
+		if backend.Raw.URL == "" {
+			return "", errors.New("missing raw backend url")
+		}
+		return appendPrefix(backend.Raw.URL, prefix), nil
+	}
+	return "", nil
+}
+
+// appendPrefix appends prefix to the path of url. The url is not parsed, as prefix may refer to an
+// environment variable, e.g. $(REPOSITORY_PREFIX), that must not be escaped.
+func appendPrefix(repo, prefix string) string {
+	repo = strings.TrimSuffix(repo, "/")
+	if prefix == "" {
+		return repo
+	}
+	return repo + "/" + prefix
+}
+
+// setSecretEnv sets the given keys of secret as environment variables of restic commands. Keys missing
+// in secret are left alone, so that e.g. credentials set by spec.env still apply.
+func (w *ResticWrapper) setSecretEnv(secret *core.Secret, keys ...string) {
+	for _, key := range keys {
+		if v, ok := secret.Data[key]; ok {
+			w.sh.SetEnv(key, string(v))
+		}
+	}
+}
+
+// SetupSFTPKey writes the SSH secret of sftp backend to the scratch dir, for restic commands run
+// where the secret is not mounted at SFTPKeyDir.
+func (w *ResticWrapper) SetupSFTPKey(secret *core.Secret) error {
+	return w.writeSFTPKey(secret.Data)
+}
+
+// readSFTPKey returns the private key and known hosts of sftp backend mounted in dir, if any.
+func readSFTPKey(dir string) map[string][]byte {
+	keys := map[string][]byte{}
+	for _, key := range []string{SSH_PRIVATE_KEY, SSH_KNOWN_HOSTS} {
+		if data, err := ioutil.ReadFile(filepath.Join(dir, key)); err == nil {
+			keys[key] = data
+		}
+	}
+	return keys
+}
+
+// writeSFTPKey writes the private key and known hosts of sftp backend to a dir in the scratch dir only
+// accessible by the user of the session. The dir is unique to the session, so that sessions sharing
+// the scratch dir, like those of the operator, don't overwrite each other's keys.
+func (w *ResticWrapper) writeSFTPKey(keys map[string][]byte) error {
+	if w.sftpPrivateDir == "" {
+		dir, err := ioutil.TempDir(w.scratchDir, "sftp-")
+		if err != nil {
+			return err
+		}
+		w.sftpPrivateDir = dir
+	}
+	for _, key := range []string{SSH_PRIVATE_KEY, SSH_KNOWN_HOSTS} {
+		if data, ok := keys[key]; ok {
+			if err := ioutil.WriteFile(filepath.Join(w.sftpPrivateDir, key), data, 0600); err != nil {
+				return err
+			}
+		}
+	}
+	w.sftpKeyDir = w.sftpPrivateDir
+	return nil
+}
+
+// Cleanup removes the files the session wrote to the scratch dir for sftp backend.
+func (w *ResticWrapper) Cleanup() error {
+	if w.sftpPrivateDir == "" {
+		return nil
+	}
+	return os.RemoveAll(w.sftpPrivateDir)
+}
+
+func sftpUserHost(spec *api.SFTPSpec) string {
+	if spec.User != "" {
+		return spec.User + "@" + spec.Host
+	}
+	return spec.Host
+}
+
+// sftpCommand returns the ssh command restic uses to connect to the sftp server, authenticating
+// with the private key in keyDir.
+func sftpCommand(spec *api.SFTPSpec, keyDir string) string {
+	args := []string{"ssh", sftpUserHost(spec)}
+	if spec.Port != 0 {
+		args = append(args, "-p", strconv.Itoa(int(spec.Port)))
+	}
+	args = append(args, "-i", filepath.Join(keyDir, SSH_PRIVATE_KEY))
+	if knownHosts := filepath.Join(keyDir, SSH_KNOWN_HOSTS); fileExists(knownHosts) {
+		args = append(args, "-o", "UserKnownHostsFile="+knownHosts)
+	} else {
+		args = append(args, "-o", "StrictHostKeyChecking=no", "-o", "UserKnownHostsFile=/dev/null")
+	}
+	return strings.Join(append(args, "-s", "sftp"), " ")
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// SetupSecretEnv sets all keys of secret as environment variables of restic commands.
+func (w *ResticWrapper) SetupSecretEnv(secret *core.Secret) {
+	for k, v := range secret.Data {
+		w.sh.SetEnv(k, string(v))
+	}
+}
+
 func (w *ResticWrapper) DumpEnv() error {
 	out, err := w.sh.Command("env").Output()
 	if err != nil {
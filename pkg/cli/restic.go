@@ -1,8 +1,16 @@
 package cli
 
 import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
 	"path/filepath"
+	"regexp"
 	"strconv"
+	"strings"
 	"time"
 
 	api "github.com/appscode/stash/apis/stash/v1alpha1"
@@ -13,11 +21,49 @@ const (
 	Exe = "/bin/restic"
 )
 
+var (
+	// ErrRepositoryAuth is returned when restic can not open the repository with the configured password.
+	ErrRepositoryAuth = errors.New("wrong repository password or no key found")
+)
+
 type ResticWrapper struct {
 	sh          *shell.Session
 	scratchDir  string
 	enableCache bool
 	hostname    string
+	limits      Limits
+	excludes    Excludes
+	// backups stay within the filesystems of the backed up paths
+	oneFileSystem bool
+	// sftp backend, connected via ssh with the key in sftpKeyDir. The key mounted in sftpMountDir
+	// is copied to sftpPrivateDir, a dir in the scratch dir unique to the session.
+	sftp           *api.SFTPSpec
+	sftpKeyDir     string
+	sftpMountDir   string
+	sftpPrivateDir string
+	// client certificate of rest backend with mutual TLS, mounted in restTLSMountDir
+	tlsClientCert   string
+	restTLSMountDir string
+	// whether restic restore prints a summary, see restoreReportsStats. restic is only asked once
+	// per session, as restores of all FileGroups use the same restic.
+	restoreStatsChecked bool
+	restoreStats        bool
+}
+
+// Limits bounds the bandwidth and duration of restic commands. Zero values are unlimited.
+type Limits struct {
+	// Upload and Download rate limits in KiB/s.
+	Upload   int
+	Download int
+	// Timeout kills a restic command running longer, e.g. when the backend stalls.
+	Timeout time.Duration
+}
+
+// Excludes selects the files skipped by restic backup.
+type Excludes struct {
+	Patterns []string
+	// Caches skips directories marked with a CACHEDIR.TAG file.
+	Caches bool
 }
 
 func New(scratchDir string, enableCache bool, hostname string) *ResticWrapper {
@@ -26,6 +72,10 @@ func New(scratchDir string, enableCache bool, hostname string) *ResticWrapper {
 		scratchDir:  scratchDir,
 		enableCache: enableCache,
 		hostname:    hostname,
+		sftpKeyDir:  SFTPKeyDir,
+		// the ssh secret is mounted here in containers created by stash
+		sftpMountDir:    SFTPKeyDir,
+		restTLSMountDir: RestTLSDir,
 	}
 	ctrl.sh.SetDir(scratchDir)
 	ctrl.sh.ShowCMD = true
@@ -37,23 +87,49 @@ type Snapshot struct {
 	Time     time.Time `json:"time"`
 	Tree     string    `json:"tree"`
 	Paths    []string  `json:"paths"`
+	Tags     []string  `json:"tags,omitempty"`
 	Hostname string    `json:"hostname"`
 	Username string    `json:"username"`
 	UID      int       `json:"uid"`
 	Gid      int       `json:"gid"`
 }
 
+// ListSnapshots returns the snapshots stored in the repository. A repository
+// that has not been initialized yet is reported as having no snapshots.
 func (w *ResticWrapper) ListSnapshots() ([]Snapshot, error) {
 	result := make([]Snapshot, 0)
-	args := w.appendCacheDirFlag([]interface{}{"snapshots", "--json"})
+	args := w.appendGlobalFlags([]interface{}{"snapshots", "--json"})
+
+	stderr := bytes.NewBuffer(nil)
+	oldErr := w.sh.Stderr
+	w.sh.Stderr = io.MultiWriter(oldErr, stderr)
 	err := w.sh.Command(Exe, args...).UnmarshalJSON(&result)
-	return result, err
+	w.sh.Stderr = oldErr
+	if err != nil {
+		return snapshotsResult(stderr.String(), err)
+	}
+	if result == nil {
+		// restic prints null for a repository without snapshots
+		result = make([]Snapshot, 0)
+	}
+	return result, nil
+}
+
+func snapshotsResult(stderr string, err error) ([]Snapshot, error) {
+	switch {
+	case strings.Contains(stderr, "wrong password or no key found"):
+		return nil, ErrRepositoryAuth
+	case strings.Contains(stderr, "Is there a repository at the following location?"),
+		strings.Contains(stderr, "unable to open config file"):
+		return make([]Snapshot, 0), nil
+	}
+	return nil, err
 }
 
 func (w *ResticWrapper) InitRepositoryIfAbsent() error {
-	args := w.appendCacheDirFlag([]interface{}{"snapshots", "--json"})
+	args := w.appendGlobalFlags([]interface{}{"snapshots", "--json"})
 	if err := w.sh.Command(Exe, args...).Run(); err != nil {
-		args = w.appendCacheDirFlag([]interface{}{"init"})
+		args = w.appendGlobalFlags([]interface{}{"init"})
 		return w.sh.Command(Exe, args...).Run()
 	}
 	return nil
@@ -70,7 +146,17 @@ func (w *ResticWrapper) Backup(resource *api.Restic, fg api.FileGroup) error {
 		args = append(args, "--tag")
 		args = append(args, tag)
 	}
-	args = w.appendCacheDirFlag(args)
+	for _, pattern := range w.excludes.Patterns {
+		args = append(args, "--exclude")
+		args = append(args, pattern)
+	}
+	if w.excludes.Caches {
+		args = append(args, "--exclude-caches")
+	}
+	if w.oneFileSystem {
+		args = append(args, "--one-file-system")
+	}
+	args = w.appendGlobalFlags(args)
 	return w.sh.Command(Exe, args...).Run()
 }
 
@@ -120,34 +206,269 @@ func (w *ResticWrapper) Forget(resource *api.Restic, fg api.FileGroup) error {
 		args = append(args, "--dry-run")
 	}
 	if len(args) > 1 {
-		args = w.appendCacheDirFlag(args)
+		args = w.appendGlobalFlags(args)
 		return w.sh.Command(Exe, args...).Run()
 	}
 	return nil
 }
 
-func (w *ResticWrapper) Restore(path, host string) error {
+// RestoreOptions selects the snapshot to restore. At most one of SnapshotID,
+// Tags and Before should be set; if none is set, the latest snapshot is restored.
+// Include and Exclude limit the restored files to the matching patterns.
+// Target is the directory to restore into, by default the restored path itself.
+type RestoreOptions struct {
+	SnapshotID string
+	Tags       []string
+	Before     *time.Time
+	Include    []string
+	Exclude    []string
+	Target     string
+}
+
+// Restore restores path from the selected snapshot. The returned stats are nil
+// if the installed restic does not report them.
+func (w *ResticWrapper) Restore(path, host string, opt RestoreOptions) (*RestoreStats, error) {
+	snapshotID := "latest"
+	if opt.SnapshotID != "" {
+		snapshotID = opt.SnapshotID
+	} else if opt.Before != nil {
+		id, err := w.lastSnapshotBefore(path, host, *opt.Before)
+		if err != nil {
+			return nil, err
+		}
+		snapshotID = id
+	}
+
+	args := restoreArgs(path, host, snapshotID, opt)
+	reportsStats := w.restoreReportsStats()
+	if reportsStats {
+		args = append(args, "--json")
+	}
+	args = w.appendGlobalFlags(args)
+
+	stderr := bytes.NewBuffer(nil)
+	oldErr := w.sh.Stderr
+	w.sh.Stderr = io.MultiWriter(oldErr, stderr)
+	var stats *RestoreStats
+	var err error
+	if reportsStats {
+		var out []byte
+		if out, err = w.sh.Command(Exe, args...).Output(); err == nil || len(out) > 0 {
+			stats = parseRestoreSummary(out)
+		}
+	} else {
+		err = w.sh.Command(Exe, args...).Run()
+	}
+	w.sh.Stderr = oldErr
+
+	// restic skips files it can't restore, reports them on stderr and exits with 0 or, since 0.10, with 1
+	if errs := parseRestoreErrors(stderr.String()); len(errs) > 0 {
+		return stats, &PartialRestoreError{Path: path, Errors: errs}
+	}
+	return stats, err
+}
+
+// PartialRestoreError is returned by Restore if restic restored the snapshot, but skipped some files.
+type PartialRestoreError struct {
+	Path string
+	// Errors reported by restic for the skipped files
+	Errors []string
+}
+
+func (e *PartialRestoreError) Error() string {
+	return fmt.Sprintf("%d files of path %s could not be restored: %s", len(e.Errors), e.Path, strings.Join(e.Errors, "; "))
+}
+
+// IsPartialRestore returns true if err reports that only some files were restored.
+func IsPartialRestore(err error) bool {
+	_, ok := err.(*PartialRestoreError)
+	return ok
+}
+
+// restoreError is an error printed by restore --json.
+type restoreError struct {
+	MessageType string `json:"message_type"`
+	Error       struct {
+		Message string `json:"message"`
+	} `json:"error"`
+	Item string `json:"item"`
+}
+
+// parseRestoreErrors returns the errors for files skipped by restic restore, printed as
+// "ignoring error for <file>: <reason>" or as JSON errors with --json.
+func parseRestoreErrors(stderr string) []string {
+	var errs []string
+	for _, line := range strings.Split(stderr, "\n") {
+		var msg restoreError
+		if strings.HasPrefix(line, "ignoring error for ") {
+			errs = append(errs, strings.TrimPrefix(line, "ignoring error for "))
+		} else if json.Unmarshal([]byte(line), &msg) == nil && msg.MessageType == "error" && msg.Item != "" {
+			errs = append(errs, msg.Item+": "+msg.Error.Message)
+		}
+	}
+	return errs
+}
+
+// restoreArgs returns the arguments of restic restore without global flags. All tags
+// are passed in a single --tag, so that restic only considers snapshots having every tag.
+func restoreArgs(path, host, snapshotID string, opt RestoreOptions) []interface{} {
 	args := []interface{}{"restore"}
-	args = append(args, "latest") // TODO @ Dipta: Add support for specific snapshotID
+	args = append(args, snapshotID)
 	args = append(args, "--path")
 	args = append(args, path) // source-path specified in restic fileGroup
 	args = append(args, "--host")
 	args = append(args, host)
+	if len(opt.Tags) > 0 {
+		args = append(args, "--tag")
+		args = append(args, strings.Join(opt.Tags, ","))
+	}
+	for _, pattern := range opt.Include {
+		args = append(args, "--include")
+		args = append(args, pattern)
+	}
+	for _, pattern := range opt.Exclude {
+		args = append(args, "--exclude")
+		args = append(args, pattern)
+	}
 	args = append(args, "--target")
-	args = append(args, path) // restore in same path as source-path
-	args = w.appendCacheDirFlag(args)
-	return w.sh.Command(Exe, args...).Run()
+	if opt.Target != "" {
+		args = append(args, opt.Target)
+	} else {
+		args = append(args, path) // restore in same path as source-path
+	}
+	return args
+}
+
+// RestoreStats is the summary restic prints at the end of restore --json.
+type RestoreStats struct {
+	MessageType   string `json:"message_type"`
+	FilesRestored int64  `json:"files_restored"`
+	BytesRestored int64  `json:"bytes_restored"`
+}
+
+// parseRestoreSummary returns the summary from the JSON lines printed by restore --json,
+// or nil if no summary was printed.
+func parseRestoreSummary(out []byte) *RestoreStats {
+	var summary *RestoreStats
+	for _, line := range bytes.Split(out, []byte("\n")) {
+		var msg RestoreStats
+		if json.Unmarshal(line, &msg) == nil && msg.MessageType == "summary" {
+			summary = &msg
+		}
+	}
+	return summary
+}
+
+// minRestoreStatsVersion is the first restic release that prints a summary for restore --json.
+var minRestoreStatsVersion = [2]int{0, 17}
+
+// restoreReportsStats reports whether the installed restic prints a restore summary.
+// Older releases reject --json for restore, so stats are only requested if the version is known to support it.
+func (w *ResticWrapper) restoreReportsStats() bool {
+	if !w.restoreStatsChecked {
+		w.restoreStats = w.checkRestoreStats()
+		w.restoreStatsChecked = true
+	}
+	return w.restoreStats
+}
+
+func (w *ResticWrapper) checkRestoreStats() bool {
+	out, err := w.sh.Command(Exe, "version").Output()
+	if err != nil {
+		return false
+	}
+	major, minor, ok := parseResticVersion(string(out))
+	if !ok {
+		return false
+	}
+	return major > minRestoreStatsVersion[0] || (major == minRestoreStatsVersion[0] && minor >= minRestoreStatsVersion[1])
+}
+
+var resticVersionRegexp = regexp.MustCompile(`^restic (\d+)\.(\d+)`)
+
+// parseResticVersion parses the major and minor version from the output of restic version,
+// eg. "restic 0.8.0 compiled with go1.9.2 on linux/amd64".
+func parseResticVersion(out string) (major, minor int, ok bool) {
+	m := resticVersionRegexp.FindStringSubmatch(strings.TrimSpace(out))
+	if m == nil {
+		return 0, 0, false
+	}
+	major, _ = strconv.Atoi(m[1])
+	minor, _ = strconv.Atoi(m[2])
+	return major, minor, true
+}
+
+func (w *ResticWrapper) lastSnapshotBefore(path, host string, before time.Time) (string, error) {
+	result := make([]Snapshot, 0)
+	args := w.appendGlobalFlags([]interface{}{"snapshots", "--json", "--path", path, "--host", host})
+	if err := w.sh.Command(Exe, args...).UnmarshalJSON(&result); err != nil {
+		return "", err
+	}
+	return selectSnapshotBefore(result, before)
+}
+
+func selectSnapshotBefore(snapshots []Snapshot, before time.Time) (string, error) {
+	var found *Snapshot
+	for i := range snapshots {
+		s := &snapshots[i]
+		if s.Time.After(before) {
+			continue
+		}
+		if found == nil || s.Time.After(found.Time) {
+			found = s
+		}
+	}
+	if found == nil {
+		return "", fmt.Errorf("no snapshot found before %s", before.Format(time.RFC3339))
+	}
+	return found.ID, nil
 }
 
 func (w *ResticWrapper) Check() error {
-	args := w.appendCacheDirFlag([]interface{}{"check"})
+	args := w.appendGlobalFlags([]interface{}{"check"})
 	return w.sh.Command(Exe, args...).Run()
 }
 
-func (w *ResticWrapper) appendCacheDirFlag(args []interface{}) []interface{} {
+// SetLimits applies l to all restic commands run afterwards.
+func (w *ResticWrapper) SetLimits(l Limits) {
+	w.limits = l
+	w.sh.SetTimeout(l.Timeout)
+}
+
+// SetExcludes applies e to all backups run afterwards.
+func (w *ResticWrapper) SetExcludes(e Excludes) {
+	w.excludes = e
+}
+
+// SetOneFileSystem makes all backups run afterwards stay within the filesystems of the backed up paths.
+func (w *ResticWrapper) SetOneFileSystem(oneFileSystem bool) {
+	w.oneFileSystem = oneFileSystem
+}
+
+func (w *ResticWrapper) appendGlobalFlags(args []interface{}) []interface{} {
+	if w.limits.Upload > 0 {
+		args = append(args, "--limit-upload", strconv.Itoa(w.limits.Upload))
+	}
+	if w.limits.Download > 0 {
+		args = append(args, "--limit-download", strconv.Itoa(w.limits.Download))
+	}
+	if w.sftp != nil {
+		args = append(args, "-o", "sftp.command="+sftpCommand(w.sftp, w.sftpKeyDir))
+	}
+	if w.tlsClientCert != "" {
+		args = append(args, "--tls-client-cert", w.tlsClientCert)
+	}
 	if w.enableCache {
-		cacheDir := filepath.Join(w.scratchDir, "restic-cache")
-		return append(args, "--cache-dir", cacheDir)
+		return append(args, "--cache-dir", w.cacheDir())
 	}
 	return append(args, "--no-cache")
 }
+
+// cacheDir returns the restic cache directory. It is the cache volume if mounted by stash,
+// otherwise the cache is kept in scratch dir.
+func (w *ResticWrapper) cacheDir() string {
+	if dir := os.Getenv(RESTIC_CACHE_DIR); dir != "" {
+		return dir
+	}
+	return filepath.Join(w.scratchDir, "restic-cache")
+}
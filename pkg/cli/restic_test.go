@@ -0,0 +1,435 @@
+package cli
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	api "github.com/appscode/stash/apis/stash/v1alpha1"
+	core "k8s.io/api/core/v1"
+)
+
+func TestSnapshotsResult(t *testing.T) {
+	exitErr := errors.New("exit status 1")
+
+	if _, err := snapshotsResult("Fatal: wrong password or no key found\n", exitErr); err != ErrRepositoryAuth {
+		t.Errorf("expected ErrRepositoryAuth, got %v", err)
+	}
+
+	stderr := "Fatal: unable to open config file: Stat: stat /repo/config: no such file or directory\nIs there a repository at the following location?\n/repo\n"
+	snapshots, err := snapshotsResult(stderr, exitErr)
+	if err != nil {
+		t.Fatalf("expected no error for empty repository, got %v", err)
+	}
+	if snapshots == nil || len(snapshots) != 0 {
+		t.Errorf("expected empty snapshot list, got %v", snapshots)
+	}
+
+	if _, err := snapshotsResult("Fatal: connection refused\n", exitErr); err != exitErr {
+		t.Errorf("expected original error, got %v", err)
+	}
+}
+
+func TestSelectSnapshotBefore(t *testing.T) {
+	base := time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+	snapshots := []Snapshot{
+		{ID: "a", Time: base},
+		{ID: "c", Time: base.Add(2 * time.Hour)},
+		{ID: "b", Time: base.Add(time.Hour)},
+	}
+
+	cases := map[time.Duration]string{
+		30 * time.Minute: "a",
+		time.Hour:        "b",
+		90 * time.Minute: "b",
+		3 * time.Hour:    "c",
+	}
+	for offset, expected := range cases {
+		id, err := selectSnapshotBefore(snapshots, base.Add(offset))
+		if err != nil {
+			t.Errorf("cutoff +%s: unexpected error: %s", offset, err)
+		} else if id != expected {
+			t.Errorf("cutoff +%s: expected snapshot %s, found %s", offset, expected, id)
+		}
+	}
+
+	if _, err := selectSnapshotBefore(snapshots, base.Add(-time.Minute)); err == nil {
+		t.Error("expected error when no snapshot precedes the cutoff")
+	}
+}
+
+func TestAppendGlobalFlagsLimits(t *testing.T) {
+	w := New("/tmp", false, "")
+	if args := w.appendGlobalFlags([]interface{}{"check"}); len(args) != 2 {
+		t.Errorf("expected no limit flags by default, found %v", args)
+	}
+
+	w.SetLimits(Limits{Upload: 512, Download: 1024, Timeout: time.Minute})
+	args := w.appendGlobalFlags([]interface{}{"check"})
+	expected := []interface{}{"check", "--limit-upload", "512", "--limit-download", "1024", "--no-cache"}
+	if len(args) != len(expected) {
+		t.Fatalf("expected args %v, found %v", expected, args)
+	}
+	for i := range expected {
+		if args[i] != expected[i] {
+			t.Errorf("expected args %v, found %v", expected, args)
+			break
+		}
+	}
+}
+
+func TestAppendGlobalFlagsCacheDir(t *testing.T) {
+	w := New("/tmp", true, "")
+	args := w.appendGlobalFlags([]interface{}{"check"})
+	if len(args) != 3 || args[2] != "/tmp/restic-cache" {
+		t.Errorf("expected cache in scratch dir, found %v", args)
+	}
+
+	os.Setenv(RESTIC_CACHE_DIR, "/var/cache/restic")
+	defer os.Unsetenv(RESTIC_CACHE_DIR)
+	args = w.appendGlobalFlags([]interface{}{"check"})
+	if len(args) != 3 || args[2] != "/var/cache/restic" {
+		t.Errorf("expected cache in %s, found %v", RESTIC_CACHE_DIR, args)
+	}
+}
+
+func TestSetupEnvSFTP(t *testing.T) {
+	scratchDir, err := ioutil.TempDir("", "stash-sftp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(scratchDir)
+
+	restic := &api.Restic{}
+	restic.Spec.Backend.SFTP = &api.SFTPSpec{Host: "nas", Port: 2222, User: "backup", Path: "/srv/restic", SSHSecretName: "sftp-ssh"}
+	secret := &core.Secret{Data: map[string][]byte{RESTIC_PASSWORD: []byte("changeit")}}
+
+	w := New(scratchDir, false, "")
+	if err := w.SetupEnv(restic, secret, "deployment/app"); err != nil {
+		t.Fatal(err)
+	}
+	if r := w.sh.Env[RESTIC_REPOSITORY]; r != "sftp:backup@nas:/srv/restic/deployment/app" {
+		t.Errorf("unexpected %s %q", RESTIC_REPOSITORY, r)
+	}
+	args := w.appendGlobalFlags([]interface{}{"check"})
+	expected := "sftp.command=ssh backup@nas -p 2222 -i /etc/stash-sftp/ssh-privatekey -o StrictHostKeyChecking=no -o UserKnownHostsFile=/dev/null -s sftp"
+	if len(args) != 4 || args[1] != "-o" || args[2] != expected {
+		t.Errorf("expected sftp command %q, found %v", expected, args)
+	}
+
+	sshSecret := &core.Secret{Data: map[string][]byte{
+		SSH_PRIVATE_KEY: []byte("private key"),
+		SSH_KNOWN_HOSTS: []byte("nas ssh-rsa AAAA"),
+	}}
+	if err := w.SetupSFTPKey(sshSecret); err != nil {
+		t.Fatal(err)
+	}
+	keyDir := w.sftpKeyDir
+	if filepath.Dir(keyDir) != scratchDir {
+		t.Errorf("expected key dir in scratch dir %s, found %s", scratchDir, keyDir)
+	}
+	if fi, err := os.Stat(filepath.Join(keyDir, SSH_PRIVATE_KEY)); err != nil || fi.Mode().Perm() != 0600 {
+		t.Errorf("expected private key with mode 0600, found %v, %v", fi, err)
+	}
+	args = w.appendGlobalFlags([]interface{}{"check"})
+	expected = "sftp.command=ssh backup@nas -p 2222 -i " + filepath.Join(keyDir, SSH_PRIVATE_KEY) +
+		" -o UserKnownHostsFile=" + filepath.Join(keyDir, SSH_KNOWN_HOSTS) + " -s sftp"
+	if len(args) != 4 || args[2] != expected {
+		t.Errorf("expected sftp command %q, found %v", expected, args)
+	}
+
+	// sessions sharing the scratch dir use their own key dir
+	other := New(scratchDir, false, "")
+	if err := other.SetupSFTPKey(sshSecret); err != nil {
+		t.Fatal(err)
+	}
+	if other.sftpKeyDir == keyDir {
+		t.Errorf("expected sessions to use different key dirs, found %s", keyDir)
+	}
+	if err := other.Cleanup(); err != nil || fileExists(other.sftpKeyDir) {
+		t.Errorf("expected key dir %s to be removed, found %v", other.sftpKeyDir, err)
+	}
+
+	// a mounted key is copied to a private file
+	mountDir := filepath.Join(scratchDir, "mount")
+	if err := os.MkdirAll(mountDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(mountDir, SSH_PRIVATE_KEY), []byte("mounted key"), 0444); err != nil {
+		t.Fatal(err)
+	}
+	w = New(scratchDir, false, "")
+	w.sftpMountDir = mountDir
+	if err := w.SetupEnv(restic, secret, "deployment/app"); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := ioutil.ReadFile(filepath.Join(w.sftpKeyDir, SSH_PRIVATE_KEY)); err != nil || string(data) != "mounted key" {
+		t.Errorf("expected copy of mounted key, found %q, %v", data, err)
+	} else if fi, _ := os.Stat(filepath.Join(w.sftpKeyDir, SSH_PRIVATE_KEY)); fi.Mode().Perm() != 0600 {
+		t.Errorf("expected copied key with mode 0600, found %v", fi.Mode())
+	}
+}
+
+func TestRepositoryURL(t *testing.T) {
+	cases := map[string]struct {
+		backend api.Backend
+		prefix  string
+		url     string
+	}{
+		"local":          {api.Backend{Local: &api.LocalSpec{Path: "/safe/data"}}, "deployment/app", "/safe/data/deployment/app"},
+		"s3":             {api.Backend{S3: &api.S3Spec{Endpoint: "s3.amazonaws.com", Bucket: "stash", Prefix: "demo"}}, "deployment/app", "s3:s3.amazonaws.com/stash/demo/deployment/app"},
+		"gcs":            {api.Backend{GCS: &api.GCSSpec{Bucket: "stash"}}, "deployment/app", "gs:stash:/deployment/app"},
+		"b2":             {api.Backend{B2: &api.B2Spec{Bucket: "stash", Prefix: "demo"}}, "deployment/app", "b2:stash:demo/deployment/app"},
+		"rest":           {api.Backend{Rest: &api.RestServerSpec{URL: "https://rest:8000/"}}, "deployment/app", "rest:https://rest:8000/deployment/app"},
+		"rest no prefix": {api.Backend{Rest: &api.RestServerSpec{URL: "https://rest:8000/"}}, "", "rest:https://rest:8000"},
+		"sftp":           {api.Backend{SFTP: &api.SFTPSpec{Host: "nas", User: "backup", Path: "/srv/restic"}}, "deployment/app", "sftp:backup@nas:/srv/restic/deployment/app"},
+		// the prefix of containers created by stash refers to an environment variable
+		"raw env prefix": {api.Backend{Raw: &api.RawSpec{URL: "rclone:remote:stash/"}}, "$(REPOSITORY_PREFIX)", "rclone:remote:stash/$(REPOSITORY_PREFIX)"},
+		"no backend":     {api.Backend{}, "deployment/app", ""},
+	}
+	for name, c := range cases {
+		url, err := RepositoryURL(c.backend, c.prefix)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", name, err)
+		} else if url != c.url {
+			t.Errorf("%s: expected %q, found %q", name, c.url, url)
+		}
+	}
+
+	for name, backend := range map[string]api.Backend{
+		"s3 without endpoint":     {S3: &api.S3Spec{Bucket: "stash"}},
+		"azure without container": {Azure: &api.AzureSpec{}},
+		"local without path":      {Local: &api.LocalSpec{}},
+		"raw without url":         {Raw: &api.RawSpec{}},
+		"swift without container": {Swift: &api.SwiftSpec{}},
+	} {
+		if _, err := RepositoryURL(backend, "deployment/app"); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
+
+func TestSetupEnvWithoutCredentials(t *testing.T) {
+	scratchDir, err := ioutil.TempDir("", "stash-credentials")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(scratchDir)
+
+	secret := &core.Secret{Data: map[string][]byte{RESTIC_PASSWORD: []byte("changeit")}}
+
+	// the IAM role of the pod is used
+	restic := &api.Restic{}
+	restic.Spec.Backend.S3 = &api.S3Spec{Endpoint: "s3.amazonaws.com", Bucket: "stash"}
+	w := New(scratchDir, false, "")
+	if err := w.SetupEnv(restic, secret, "deployment/app"); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY} {
+		if v, ok := w.sh.Env[key]; ok {
+			t.Errorf("expected no %s, found %q", key, v)
+		}
+	}
+
+	// the default credentials of the pod are used, not the optional mounted key
+	restic = &api.Restic{}
+	restic.Spec.Backend.GCS = &api.GCSSpec{Bucket: "stash"}
+	w = New(scratchDir, false, "")
+	if err := w.SetupEnv(restic, secret, "deployment/app"); err != nil {
+		t.Fatal(err)
+	}
+	if v, ok := w.sh.Env[GOOGLE_APPLICATION_CREDENTIALS]; !ok || v != "" {
+		t.Errorf("expected empty %s, found %q", GOOGLE_APPLICATION_CREDENTIALS, v)
+	}
+	if _, err := os.Stat(filepath.Join(scratchDir, "gcs_sa.json")); !os.IsNotExist(err) {
+		t.Errorf("expected no json key to be written, found %v", err)
+	}
+}
+
+func TestSetupEnvRestTLS(t *testing.T) {
+	scratchDir, err := ioutil.TempDir("", "stash-rest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(scratchDir)
+
+	restic := &api.Restic{}
+	restic.Spec.Backend.Rest = &api.RestServerSpec{URL: "https://rest:8000/", TLSSecretName: "rest-tls"}
+	secret := &core.Secret{Data: map[string][]byte{RESTIC_PASSWORD: []byte("changeit")}}
+
+	// without the mounted TLS secret, e.g. in the operator, no client certificate is passed
+	w := New(scratchDir, false, "")
+	w.restTLSMountDir = scratchDir
+	if err := w.SetupEnv(restic, secret, "deployment/app"); err != nil {
+		t.Fatal(err)
+	}
+	if args := w.appendGlobalFlags([]interface{}{"check"}); len(args) != 2 {
+		t.Errorf("expected no client certificate, found %v", args)
+	}
+
+	cert := filepath.Join(scratchDir, RestTLSClientCertFile)
+	if err := ioutil.WriteFile(cert, []byte("cert and key"), 0444); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.SetupEnv(restic, secret, "deployment/app"); err != nil {
+		t.Fatal(err)
+	}
+	args := w.appendGlobalFlags([]interface{}{"check"})
+	if len(args) != 4 || args[1] != "--tls-client-cert" || args[2] != cert {
+		t.Errorf("expected client certificate %s, found %v", cert, args)
+	}
+
+	// the mounted TLS secret belongs to the primary backend
+	if err := w.SetupMirrorEnv(restic.Spec.Backend, secret, "deployment/app"); err != nil {
+		t.Fatal(err)
+	}
+	if args := w.appendGlobalFlags([]interface{}{"check"}); len(args) != 2 {
+		t.Errorf("expected no client certificate for mirror, found %v", args)
+	}
+}
+
+func TestSetupEnvPasswordSource(t *testing.T) {
+	scratchDir, err := ioutil.TempDir("", "stash-password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(scratchDir)
+
+	restic := &api.Restic{}
+	restic.Spec.Backend.Local = &api.LocalSpec{Path: filepath.Join(scratchDir, "repository")}
+	secret := &core.Secret{Data: map[string][]byte{RESTIC_PASSWORD: []byte("changeit")}}
+
+	w := New(scratchDir, false, "")
+	if err := w.SetupEnv(restic, secret, ""); err != nil {
+		t.Fatal(err)
+	}
+	if p, f := w.sh.Env[RESTIC_PASSWORD], w.sh.Env[RESTIC_PASSWORD_FILE]; p != "changeit" || f != "" {
+		t.Errorf("expected password in %s, found %q, %s %q", RESTIC_PASSWORD, p, RESTIC_PASSWORD_FILE, f)
+	}
+
+	// without a mounted password file, e.g. in the operator, the password is passed in the env of
+	// the session, so that concurrent sessions don't share a file
+	restic.Spec.Backend.PasswordSource = api.PasswordSourceFile
+	w = New(scratchDir, false, "")
+	if err := w.SetupEnv(restic, secret, ""); err != nil {
+		t.Fatal(err)
+	}
+	if p, f := w.sh.Env[RESTIC_PASSWORD], w.sh.Env[RESTIC_PASSWORD_FILE]; p != "changeit" || f != "" {
+		t.Errorf("expected password in %s, found %q, %s %q", RESTIC_PASSWORD, p, RESTIC_PASSWORD_FILE, f)
+	}
+
+	passwordFile := filepath.Join(scratchDir, "restic_password")
+	os.Setenv(RESTIC_PASSWORD_FILE, passwordFile)
+	defer os.Unsetenv(RESTIC_PASSWORD_FILE)
+	w = New(scratchDir, false, "")
+	if err := w.SetupEnv(restic, secret, ""); err != nil {
+		t.Fatal(err)
+	}
+	if p, ok := w.sh.Env[RESTIC_PASSWORD]; ok {
+		t.Errorf("expected no %s with mounted password file, found %q", RESTIC_PASSWORD, p)
+	}
+	if _, err := os.Stat(passwordFile); !os.IsNotExist(err) {
+		t.Errorf("expected mounted password file to be left alone, found %v", err)
+	}
+}
+
+func TestSetupMirrorEnv(t *testing.T) {
+	scratchDir, err := ioutil.TempDir("", "stash-mirror")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(scratchDir)
+
+	mirror := api.Backend{
+		StorageSecretName: "mirror",
+		PasswordSource:    api.PasswordSourceFile,
+		S3:                &api.S3Spec{Endpoint: "s3.amazonaws.com", Bucket: "stash", Prefix: "mirror"},
+	}
+	secret := &core.Secret{Data: map[string][]byte{
+		RESTIC_PASSWORD:       []byte("mirror-password"),
+		AWS_ACCESS_KEY_ID:     []byte("key-id"),
+		AWS_SECRET_ACCESS_KEY: []byte("secret-key"),
+	}}
+
+	w := New(scratchDir, false, "")
+	if err := w.SetupMirrorEnv(mirror, secret, "deployment/app"); err != nil {
+		t.Fatal(err)
+	}
+	if r := w.sh.Env[RESTIC_REPOSITORY]; r != "s3:s3.amazonaws.com/stash/mirror/deployment/app" {
+		t.Errorf("unexpected %s %q", RESTIC_REPOSITORY, r)
+	}
+	if id := w.sh.Env[AWS_ACCESS_KEY_ID]; id != "key-id" {
+		t.Errorf("unexpected %s %q", AWS_ACCESS_KEY_ID, id)
+	}
+	// the password file of the container belongs to the primary backend
+	if p, f := w.sh.Env[RESTIC_PASSWORD], w.sh.Env[RESTIC_PASSWORD_FILE]; p != "mirror-password" || f != "" {
+		t.Errorf("expected mirror password in %s, found %q, %s %q", RESTIC_PASSWORD, p, RESTIC_PASSWORD_FILE, f)
+	}
+	if _, ok := w.sh.Env[RESTIC_PASSWORD_FILE]; !ok {
+		t.Errorf("expected %s of primary backend to be cleared", RESTIC_PASSWORD_FILE)
+	}
+}
+
+func TestParseRestoreSummary(t *testing.T) {
+	out := []byte(`{"message_type":"status","percent_done":0.5,"files_restored":1}
+{"message_type":"summary","seconds_elapsed":2,"total_files":3,"files_restored":3,"total_bytes":2048,"bytes_restored":2048}
+`)
+	stats := parseRestoreSummary(out)
+	if stats == nil || stats.FilesRestored != 3 || stats.BytesRestored != 2048 {
+		t.Errorf("unexpected summary %+v", stats)
+	}
+
+	if stats := parseRestoreSummary([]byte("restoring <Snapshot 1c2d3e4f of [/data]> to /data\n")); stats != nil {
+		t.Errorf("expected no summary, got %+v", stats)
+	}
+}
+
+func TestParseResticVersion(t *testing.T) {
+	major, minor, ok := parseResticVersion("restic 0.8.0 compiled with go1.9.2 on linux/amd64\n")
+	if !ok || major != 0 || minor != 8 {
+		t.Errorf("unexpected version %d.%d", major, minor)
+	}
+	major, minor, ok = parseResticVersion("restic 0.17.1 compiled with go1.23.1 on linux/amd64")
+	if !ok || major != 0 || minor != 17 {
+		t.Errorf("unexpected version %d.%d", major, minor)
+	}
+	if _, _, ok = parseResticVersion("sh: restic: not found"); ok {
+		t.Error("expected unparsable version")
+	}
+}
+
+func TestParseRestoreErrors(t *testing.T) {
+	stderr := "restoring <Snapshot 1a2b3c4d of [/source/data]> to /source/data\n" +
+		"ignoring error for /source/data/db: open /source/data/db: permission denied\n" +
+		`{"message_type":"error","error":{"message":"lchown /source/data/log: operation not permitted"},"during":"restore","item":"/source/data/log"}` + "\n" +
+		"There were 2 errors\n"
+	errs := parseRestoreErrors(stderr)
+	expected := []string{
+		"/source/data/db: open /source/data/db: permission denied",
+		"/source/data/log: lchown /source/data/log: operation not permitted",
+	}
+	if !reflect.DeepEqual(errs, expected) {
+		t.Errorf("expected errors %v, found %v", expected, errs)
+	}
+	if errs = parseRestoreErrors("Fatal: wrong password or no key found\n"); len(errs) != 0 {
+		t.Errorf("expected no skipped files for a failed restore, found %v", errs)
+	}
+}
+
+func TestRestoreArgsTags(t *testing.T) {
+	args := restoreArgs("/source/data", "host-0", "latest", RestoreOptions{Tags: []string{"app=db", "daily"}})
+	expected := []interface{}{"restore", "latest", "--path", "/source/data", "--host", "host-0", "--tag", "app=db,daily", "--target", "/source/data"}
+	if len(args) != len(expected) {
+		t.Fatalf("expected args %v, found %v", expected, args)
+	}
+	for i := range expected {
+		if args[i] != expected[i] {
+			t.Errorf("expected args %v, found %v", expected, args)
+			break
+		}
+	}
+}
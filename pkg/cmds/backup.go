@@ -19,11 +19,12 @@ func NewCmdBackup() *cobra.Command {
 	var (
 		masterURL      string
 		kubeconfigPath string
+		resources      = resourceOptions{}
 		opt            = backup.Options{
 			Namespace:      meta.Namespace(),
 			ScratchDir:     "/tmp",
 			PushgatewayURL: "http://stash-operator.kube-system.svc:56789",
-			PodLabelsPath:  "/etc/stash/labels",
+			PodLabelsPath:  util.PodinfoMountPath + "/labels",
 			ResyncPeriod:   5 * time.Minute,
 			MaxNumRequeues: 5,
 		}
@@ -60,6 +61,9 @@ func NewCmdBackup() *cobra.Command {
 				log.Fatalf(err.Error())
 			}
 			opt.ScratchDir = strings.TrimSuffix(opt.ScratchDir, "/") // make ScratchDir in setup()
+			if opt.JobDefaultResources, err = resources.resources(); err != nil {
+				log.Fatalf("Invalid job default resources. Reason: %v", err)
+			}
 
 			ctrl := backup.New(kubeClient, stashClient, opt)
 
@@ -83,11 +87,22 @@ func NewCmdBackup() *cobra.Command {
 	cmd.Flags().StringVar(&opt.Workload.Name, "workload-name", opt.Workload.Name, "Name of workload where sidecar pod is added.")
 	cmd.Flags().StringVar(&opt.ResticName, "restic-name", opt.ResticName, "Name of the Restic used as configuration.")
 	cmd.Flags().StringVar(&opt.ScratchDir, "scratch-dir", opt.ScratchDir, "Directory used to store temporary files. Use an `emptyDir` in Kubernetes.")
+	cmd.Flags().StringVar(&opt.PodLabelsPath, "pod-labels-path", opt.PodLabelsPath, "Path of the file with the pod labels exposed by the downward API.")
 	cmd.Flags().StringVar(&opt.PushgatewayURL, "pushgateway-url", opt.PushgatewayURL, "URL of Prometheus pushgateway used to cache backup metrics")
 	cmd.Flags().DurationVar(&opt.ResyncPeriod, "resync-period", opt.ResyncPeriod, "If non-zero, will re-list this often. Otherwise, re-list will be delayed aslong as possible (until the upstream source closes the watch or times out.")
 	cmd.Flags().BoolVar(&opt.RunViaCron, "run-via-cron", opt.RunViaCron, "Run backup periodically via cron.")
 	cmd.Flags().StringVar(&opt.ImageTag, "image-tag", opt.ImageTag, "Check job image tag.")
 	cmd.Flags().BoolVar(&opt.EnableRBAC, "enable-rbac", opt.EnableRBAC, "Enable RBAC")
+	cmd.Flags().IntVar(&opt.Limits.Upload, "limit-upload", opt.Limits.Upload, "Upload rate limit of restic in KiB/s. Not limited if 0.")
+	cmd.Flags().IntVar(&opt.Limits.Download, "limit-download", opt.Limits.Download, "Download rate limit of restic in KiB/s. Not limited if 0.")
+	cmd.Flags().DurationVar(&opt.Limits.Timeout, "restic-timeout", opt.Limits.Timeout, "Maximum duration of a restic command. Not limited if 0.")
+	cmd.Flags().StringArrayVar(&opt.Excludes.Patterns, "exclude", opt.Excludes.Patterns, "Skip files matching this pattern while backing up. Can be repeated.")
+	cmd.Flags().BoolVar(&opt.Excludes.Caches, "exclude-caches", opt.Excludes.Caches, "Skip directories marked with a CACHEDIR.TAG file while backing up.")
+	cmd.Flags().BoolVar(&opt.OneFileSystem, "one-file-system", opt.OneFileSystem, "Don't cross filesystem boundaries while backing up.")
+	cmd.Flags().StringVar(&resources.CPURequest, "job-default-cpu-request", resources.CPURequest, "CPU request of check and forget jobs of Restics without one. Empty sets none.")
+	cmd.Flags().StringVar(&resources.MemoryRequest, "job-default-memory-request", resources.MemoryRequest, "Memory request of check and forget jobs of Restics without one. Empty sets none.")
+	cmd.Flags().StringVar(&resources.CPULimit, "job-default-cpu-limit", resources.CPULimit, "CPU limit of check and forget jobs of Restics without one. Empty sets none.")
+	cmd.Flags().StringVar(&resources.MemoryLimit, "job-default-memory-limit", resources.MemoryLimit, "Memory limit of check and forget jobs of Restics without one. Empty sets none.")
 
 	return cmd
 }
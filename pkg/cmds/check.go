@@ -44,6 +44,9 @@ func NewCmdCheck() *cobra.Command {
 	cmd.Flags().StringVar(&opt.ResticName, "restic-name", opt.ResticName, "Name of the Restic CRD.")
 	cmd.Flags().StringVar(&opt.HostName, "host-name", opt.HostName, "Host name for workload.")
 	cmd.Flags().StringVar(&opt.SmartPrefix, "smart-prefix", opt.SmartPrefix, "Smart prefix for workload")
+	cmd.Flags().IntVar(&opt.Limits.Upload, "limit-upload", opt.Limits.Upload, "Upload rate limit of restic in KiB/s. Not limited if 0.")
+	cmd.Flags().IntVar(&opt.Limits.Download, "limit-download", opt.Limits.Download, "Download rate limit of restic in KiB/s. Not limited if 0.")
+	cmd.Flags().DurationVar(&opt.Limits.Timeout, "restic-timeout", opt.Limits.Timeout, "Maximum duration of a restic command. Not limited if 0.")
 
 	return cmd
 }
@@ -0,0 +1,52 @@
+package cmds
+
+import (
+	"github.com/appscode/go/log"
+	"github.com/appscode/kutil/meta"
+	"github.com/appscode/stash/client/typed/stash/v1alpha1"
+	"github.com/appscode/stash/pkg/forget"
+	"github.com/spf13/cobra"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+func NewCmdForget() *cobra.Command {
+	var (
+		masterURL      string
+		kubeconfigPath string
+		opt            = forget.Options{
+			Namespace: meta.Namespace(),
+		}
+	)
+
+	cmd := &cobra.Command{
+		Use:               "forget",
+		Short:             "Apply retention policies of restic backup",
+		DisableAutoGenTag: true,
+		Run: func(cmd *cobra.Command, args []string) {
+			config, err := clientcmd.BuildConfigFromFlags(masterURL, kubeconfigPath)
+			if err != nil {
+				log.Fatalln(err)
+			}
+			c := forget.New(
+				kubernetes.NewForConfigOrDie(config),
+				v1alpha1.NewForConfigOrDie(config),
+				opt,
+			)
+			if err = c.Run(); err != nil {
+				log.Fatal(err)
+			}
+			log.Infoln("Exiting stash forget")
+		},
+	}
+	cmd.Flags().StringVar(&masterURL, "master", masterURL, "The address of the Kubernetes API server (overrides any value in kubeconfig)")
+	cmd.Flags().StringVar(&kubeconfigPath, "kubeconfig", kubeconfigPath, "Path to kubeconfig file with authorization information (the master location is set by the master flag).")
+	cmd.Flags().StringVar(&opt.ResticName, "restic-name", opt.ResticName, "Name of the Restic CRD.")
+	cmd.Flags().StringVar(&opt.HostName, "host-name", opt.HostName, "Host name for workload.")
+	cmd.Flags().StringVar(&opt.SmartPrefix, "smart-prefix", opt.SmartPrefix, "Smart prefix for workload")
+	cmd.Flags().IntVar(&opt.Limits.Upload, "limit-upload", opt.Limits.Upload, "Upload rate limit of restic in KiB/s. Not limited if 0.")
+	cmd.Flags().IntVar(&opt.Limits.Download, "limit-download", opt.Limits.Download, "Download rate limit of restic in KiB/s. Not limited if 0.")
+	cmd.Flags().DurationVar(&opt.Limits.Timeout, "restic-timeout", opt.Limits.Timeout, "Maximum duration of a restic command. Not limited if 0.")
+
+	return cmd
+}
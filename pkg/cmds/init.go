@@ -0,0 +1,48 @@
+package cmds
+
+import (
+	"github.com/appscode/go/log"
+	"github.com/appscode/kutil/meta"
+	"github.com/appscode/stash/client/typed/stash/v1alpha1"
+	"github.com/appscode/stash/pkg/initrepo"
+	"github.com/spf13/cobra"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+func NewCmdInit() *cobra.Command {
+	var (
+		masterURL      string
+		kubeconfigPath string
+		opt            = initrepo.Options{
+			Namespace: meta.Namespace(),
+		}
+	)
+
+	cmd := &cobra.Command{
+		Use:               "init",
+		Short:             "Initialize restic repositories",
+		DisableAutoGenTag: true,
+		Run: func(cmd *cobra.Command, args []string) {
+			config, err := clientcmd.BuildConfigFromFlags(masterURL, kubeconfigPath)
+			if err != nil {
+				log.Fatalln(err)
+			}
+			c := initrepo.New(
+				kubernetes.NewForConfigOrDie(config),
+				v1alpha1.NewForConfigOrDie(config),
+				opt,
+			)
+			if err = c.Run(); err != nil {
+				log.Fatal(err)
+			}
+			log.Infoln("Exiting stash init")
+		},
+	}
+	cmd.Flags().StringVar(&masterURL, "master", masterURL, "The address of the Kubernetes API server (overrides any value in kubeconfig)")
+	cmd.Flags().StringVar(&kubeconfigPath, "kubeconfig", kubeconfigPath, "Path to kubeconfig file with authorization information (the master location is set by the master flag).")
+	cmd.Flags().StringVar(&opt.ResticName, "restic-name", opt.ResticName, "Name of the Restic CRD.")
+	cmd.Flags().StringArrayVar(&opt.SmartPrefixes, "smart-prefix", opt.SmartPrefixes, "Smart prefix of a workload repository, can be repeated")
+
+	return cmd
+}
@@ -1,9 +1,12 @@
 package cmds
 
 import (
+	"time"
+
 	"github.com/appscode/go/log"
 	"github.com/appscode/kutil/meta"
 	"github.com/appscode/stash/client/typed/stash/v1alpha1"
+	"github.com/appscode/stash/pkg/cli"
 	"github.com/appscode/stash/pkg/recovery"
 	"github.com/spf13/cobra"
 	"k8s.io/client-go/kubernetes"
@@ -15,6 +18,14 @@ func NewCmdRecover() *cobra.Command {
 		masterURL      string
 		kubeconfigPath string
 		recoveryName   string
+		snapshotID     string
+		tags           []string
+		before         string
+		include        []string
+		exclude        []string
+		target         string
+		limits         cli.Limits
+		volumePath     string
 	)
 
 	cmd := &cobra.Command{
@@ -26,11 +37,28 @@ func NewCmdRecover() *cobra.Command {
 			if err != nil {
 				log.Fatalln(err)
 			}
+			opt := cli.RestoreOptions{
+				SnapshotID: snapshotID,
+				Tags:       tags,
+				Include:    include,
+				Exclude:    exclude,
+				Target:     target,
+			}
+			if before != "" {
+				t, err := time.Parse(time.RFC3339, before)
+				if err != nil {
+					log.Fatalln(err)
+				}
+				opt.Before = &t
+			}
 			c := recovery.New(
 				kubernetes.NewForConfigOrDie(config),
 				v1alpha1.NewForConfigOrDie(config),
 				meta.Namespace(),
 				recoveryName,
+				opt,
+				limits,
+				volumePath,
 			)
 			c.Run()
 		},
@@ -38,6 +66,16 @@ func NewCmdRecover() *cobra.Command {
 	cmd.Flags().StringVar(&masterURL, "master", masterURL, "The address of the Kubernetes API server (overrides any value in kubeconfig)")
 	cmd.Flags().StringVar(&kubeconfigPath, "kubeconfig", kubeconfigPath, "Path to kubeconfig file with authorization information (the master location is set by the master flag).")
 	cmd.Flags().StringVar(&recoveryName, "recovery-name", recoveryName, "Name of the Recovery CRD.")
+	cmd.Flags().StringVar(&snapshotID, "snapshot", snapshotID, "ID of the snapshot to recover. Defaults to the latest snapshot.")
+	cmd.Flags().StringArrayVar(&tags, "tag", tags, "Recover the latest snapshot having all of these comma separated tags. Can be repeated.")
+	cmd.Flags().StringVar(&before, "before", before, "Recover the latest snapshot taken at or before this time (RFC3339).")
+	cmd.Flags().StringArrayVar(&include, "include", include, "Recover only files matching this pattern. Can be repeated.")
+	cmd.Flags().StringArrayVar(&exclude, "exclude", exclude, "Skip files matching this pattern while recovering. Can be repeated.")
+	cmd.Flags().StringVar(&target, "target", target, "Directory to restore into. Files keep their original path below it. Defaults to restoring in place.")
+	cmd.Flags().StringVar(&volumePath, "volume-mount-path", volumePath, "Recover only the FileGroups below this mount path. Used by parallel recovery jobs.")
+	cmd.Flags().IntVar(&limits.Upload, "limit-upload", limits.Upload, "Upload rate limit of restic in KiB/s. Not limited if 0.")
+	cmd.Flags().IntVar(&limits.Download, "limit-download", limits.Download, "Download rate limit of restic in KiB/s. Not limited if 0.")
+	cmd.Flags().DurationVar(&limits.Timeout, "restic-timeout", limits.Timeout, "Maximum duration of a restic command. Not limited if 0.")
 
 	return cmd
 }
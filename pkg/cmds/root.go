@@ -49,5 +49,7 @@ func NewCmdStash(version string) *cobra.Command {
 	rootCmd.AddCommand(NewCmdBackup())
 	rootCmd.AddCommand(NewCmdRecover())
 	rootCmd.AddCommand(NewCmdCheck())
+	rootCmd.AddCommand(NewCmdForget())
+	rootCmd.AddCommand(NewCmdInit())
 	return rootCmd
 }
@@ -3,19 +3,26 @@ package cmds
 import (
 	"fmt"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/appscode/go/log"
 	stringz "github.com/appscode/go/strings"
+	"github.com/appscode/kutil/meta"
 	"github.com/appscode/pat"
 	api "github.com/appscode/stash/apis/stash"
 	cs "github.com/appscode/stash/client/typed/stash/v1alpha1"
 	"github.com/appscode/stash/pkg/controller"
 	"github.com/appscode/stash/pkg/docker"
 	"github.com/appscode/stash/pkg/migrator"
+	"github.com/appscode/stash/pkg/util"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/spf13/cobra"
+	core "k8s.io/api/core/v1"
 	crd_cs "k8s.io/apiextensions-apiserver/pkg/client/clientset/clientset/typed/apiextensions/v1beta1"
+	"k8s.io/apimachinery/pkg/api/resource"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/tools/clientcmd"
 )
@@ -31,11 +38,26 @@ func NewCmdRun(version string) *cobra.Command {
 	var (
 		masterURL      string
 		kubeconfigPath string
+		pinImageDigest bool
 		address        string = ":56790"
+		webhook               = webhookOptions{Address: ":8443"}
+		resources             = resourceOptions{}
 		opts                  = controller.Options{
-			SidecarImageTag: stringz.Val(version, "canary"),
-			ResyncPeriod:    5 * time.Minute,
-			MaxNumRequeues:  5,
+			SidecarImageTag:             stringz.Val(version, "canary"),
+			ResyncPeriod:                5 * time.Minute,
+			MaxNumRequeues:              5,
+			RestartStrategy:             util.RestartStrategyDelete,
+			SidecarWaitBackoff:          util.DefaultSidecarWaitBackoff,
+			RecoveryJobCheckInterval:    3 * time.Minute,
+			RecoveryWorkers:             1,
+			RecoveryQueueBaseDelay:      controller.DefaultRecoveryQueueBaseDelay,
+			RecoveryQueueMaxDelay:       controller.DefaultRecoveryQueueMaxDelay,
+			RecoveryJobLogLines:         20,
+			LogLevel:                    util.DefaultLogLevel,
+			LeaderElectionLockNamespace: meta.Namespace(),
+			LeaderElectionLeaseDuration: 15 * time.Second,
+			SlackWebhookSecretNamespace: meta.Namespace(),
+			BackupStalenessThreshold:    24 * time.Hour,
 		}
 	)
 
@@ -44,9 +66,41 @@ func NewCmdRun(version string) *cobra.Command {
 		Short:             "Run Stash operator",
 		DisableAutoGenTag: true,
 		Run: func(cmd *cobra.Command, args []string) {
+			if opts.RestartStrategy != util.RestartStrategyDelete && opts.RestartStrategy != util.RestartStrategyRollout {
+				log.Fatalf(`Invalid restart strategy %q. Use "%s" or "%s".`, opts.RestartStrategy, util.RestartStrategyDelete, util.RestartStrategyRollout)
+			}
+			if opts.RecoveryWorkers < 1 {
+				log.Fatalf("Invalid number of recovery workers %d.", opts.RecoveryWorkers)
+			}
+			if opts.RecoveryQueueMaxDelay < opts.RecoveryQueueBaseDelay {
+				log.Fatalf("Invalid recovery queue max delay %s, must not be less than base delay %s.", opts.RecoveryQueueMaxDelay, opts.RecoveryQueueBaseDelay)
+			}
+			if opts.LeaderElectionLockName != "" && opts.LeaderElectionLeaseDuration <= 0 {
+				log.Fatalf("Invalid leader election lease duration %s.", opts.LeaderElectionLeaseDuration)
+			}
+			if opts.BackupStalenessThreshold <= 0 {
+				log.Fatalf("Invalid backup staleness threshold %s.", opts.BackupStalenessThreshold)
+			}
+			var err error
+			if opts.SidecarDefaultResources, err = resources.resources(); err != nil {
+				log.Fatalf("Invalid sidecar default resources. Reason: %v", err)
+			}
+			if opts.RecoveryImage != "" {
+				if err := docker.ValidateImageReference(opts.RecoveryImage); err != nil {
+					log.Fatalf("Invalid recovery image %q. Reason: %v", opts.RecoveryImage, err)
+				}
+			}
 			if err := docker.CheckDockerImageVersion(docker.ImageOperator, opts.SidecarImageTag); err != nil {
 				log.Fatalf(`Image %v:%v not found.`, docker.ImageOperator, opts.SidecarImageTag)
 			}
+			if pinImageDigest {
+				imageDigest, err := docker.ResolveDockerImageDigest(docker.ImageOperator, opts.SidecarImageTag)
+				if err != nil {
+					log.Fatalf("Failed to resolve digest of image %v:%v. Reason: %v", docker.ImageOperator, opts.SidecarImageTag, err)
+				}
+				log.Infof("Using image %v@%v for sidecars", docker.ImageOperator, imageDigest)
+				opts.SidecarImageDigest = imageDigest
+			}
 
 			config, err := clientcmd.BuildConfigFromFlags(masterURL, kubeconfigPath)
 			if err != nil {
@@ -81,8 +135,28 @@ func NewCmdRun(version string) *cobra.Command {
 			log.Infoln("Starting operator...")
 			// Now let's start the controller
 			stop := make(chan struct{})
-			defer close(stop)
-			go ctrl.Run(1, stop)
+			done := make(chan struct{})
+			go func() {
+				ctrl.Run(1, stop)
+				close(done)
+			}()
+			go func() {
+				// let in-flight recoveries finish before exiting, eg. during a rolling upgrade
+				sigCh := make(chan os.Signal, 1)
+				signal.Notify(sigCh, syscall.SIGTERM, os.Interrupt)
+				<-sigCh
+				log.Infoln("Stopping operator...")
+				close(stop)
+				<-done
+				os.Exit(0)
+			}()
+
+			if webhook.Enable {
+				if webhook.CertFile == "" || webhook.KeyFile == "" {
+					log.Fatalln("TLS certificate and private key are required for the mutating webhook")
+				}
+				go serveMutatingWebhook(ctrl, webhook)
+			}
 
 			m := pat.New()
 			m.Get("/metrics", promhttp.Handler())
@@ -90,6 +164,7 @@ func NewCmdRun(version string) *cobra.Command {
 			pattern := fmt.Sprintf("/%s/v1beta1/namespaces/%s/restics/%s/metrics", api.GroupName, PathParamNamespace, PathParamName)
 			log.Infof("URL pattern: %s", pattern)
 			m.Get(pattern, http.HandlerFunc(ExportSnapshots))
+			m.Get("/backups/stale", http.HandlerFunc(ctrl.ServeStaleBackups))
 
 			http.Handle("/", m)
 			log.Infoln("Listening on", address)
@@ -100,8 +175,91 @@ func NewCmdRun(version string) *cobra.Command {
 	cmd.Flags().StringVar(&kubeconfigPath, "kubeconfig", kubeconfigPath, "Path to kubeconfig file with authorization information (the master location is set by the master flag).")
 	cmd.Flags().StringVar(&address, "address", address, "Address to listen on for web interface and telemetry.")
 	cmd.Flags().BoolVar(&opts.EnableRBAC, "rbac", opts.EnableRBAC, "Enable RBAC for operator")
+	cmd.Flags().StringVar(&opts.WatchNamespace, "watch-namespace", opts.WatchNamespace, "If set, only Restics, Recoveries, workloads and jobs in this namespace are watched. Otherwise all namespaces are watched.")
 	cmd.Flags().StringVar(&scratchDir, "scratch-dir", scratchDir, "Directory used to store temporary files. Use an `emptyDir` in Kubernetes.")
+	cmd.Flags().StringVar((*string)(&opts.RestartStrategy), "restart-strategy", string(opts.RestartStrategy), `Strategy used to restart pods after sidecar is added or removed. Use "rollout" to patch the owning workload for a rolling update instead of deleting pods.`)
+	cmd.Flags().DurationVar(&opts.SidecarWaitBackoff.InitialInterval, "sidecar-wait-initial-interval", opts.SidecarWaitBackoff.InitialInterval, "Initial interval between checks that pods were restarted after the sidecar is added or removed. The interval grows exponentially with jitter.")
+	cmd.Flags().DurationVar(&opts.SidecarWaitBackoff.MaxInterval, "sidecar-wait-max-interval", opts.SidecarWaitBackoff.MaxInterval, "Maximum interval between checks that pods were restarted after the sidecar is added or removed.")
+	cmd.Flags().DurationVar(&opts.SidecarWaitBackoff.MaxElapsedTime, "sidecar-wait-timeout", opts.SidecarWaitBackoff.MaxElapsedTime, "Time to wait for pods to be restarted after the sidecar is added or removed before giving up.")
 	cmd.Flags().DurationVar(&opts.ResyncPeriod, "resync-period", opts.ResyncPeriod, "If non-zero, will re-list this often. Otherwise, re-list will be delayed aslong as possible (until the upstream source closes the watch or times out.")
+	cmd.Flags().DurationVar(&opts.BackupStalenessThreshold, "backup-staleness-threshold", opts.BackupStalenessThreshold, "Restics whose scheduled backup is overdue by longer than this are listed at /backups/stale.")
+	cmd.Flags().StringVar(&opts.RecoveryImage, "recovery-image", opts.RecoveryImage, "Image of recovery jobs, e.g. registry.example.com/stash-recovery:0.5.1. If empty, the stash operator image is used.")
+	cmd.Flags().DurationVar(&opts.RecoveryJobCheckInterval, "recovery-job-check-interval", opts.RecoveryJobCheckInterval, "Interval to check status of running recovery jobs.")
+	cmd.Flags().DurationVar(&opts.RecoveryJobTimeout, "recovery-job-timeout", opts.RecoveryJobTimeout, "If non-zero, recovery jobs running longer than this are deleted and the Recovery is marked as failed.")
+	cmd.Flags().Int64Var(&opts.RecoveryJobLogLines, "recovery-job-log-lines", opts.RecoveryJobLogLines, "Number of lines of logs of a failed recovery job pod recorded as event on the Recovery. Zero disables it.")
+	cmd.Flags().DurationVar(&opts.RecoveryQueueBaseDelay, "recovery-queue-base-delay", opts.RecoveryQueueBaseDelay, "Delay before a failed Recovery is processed again. The delay doubles with every failure.")
+	cmd.Flags().DurationVar(&opts.RecoveryQueueMaxDelay, "recovery-queue-max-delay", opts.RecoveryQueueMaxDelay, "Maximum delay before a failed Recovery is processed again.")
+	cmd.Flags().IntVar(&opts.MaxConcurrentRecoveries, "max-concurrent-recoveries", opts.MaxConcurrentRecoveries, "Maximum number of Recoveries running recovery jobs at the same time. Further Recoveries wait until one completes. Zero means no limit.")
+	cmd.Flags().IntVar(&opts.RecoveryWorkers, "recovery-workers", opts.RecoveryWorkers, "Number of Recoveries processed concurrently. The same Recovery is never processed by more than one worker at a time.")
+	cmd.Flags().IntVar(&opts.LogLevel, "sidecar-log-level", opts.LogLevel, "Log level (--v) of stash sidecar and recovery containers. If negative, built-in defaults are used.")
+	cmd.Flags().BoolVar(&pinImageDigest, "pin-sidecar-image-digest", pinImageDigest, "If true, sidecars use the stash image pinned by the digest the image tag resolves to at startup instead of the tag.")
+	cmd.Flags().BoolVar(&opts.EnableDefaultSidecarSecurityContext, "sidecar-default-security-context", opts.EnableDefaultSidecarSecurityContext, "If true, sidecars and init containers of Restics without a security context run as non-root user 65534 with a read-only root filesystem and no capabilities.")
+	cmd.Flags().StringVar(&resources.CPURequest, "sidecar-default-cpu-request", resources.CPURequest, "CPU request of sidecars and stash jobs of Restics without one, e.g. 100m. Empty sets none.")
+	cmd.Flags().StringVar(&resources.MemoryRequest, "sidecar-default-memory-request", resources.MemoryRequest, "Memory request of sidecars and stash jobs of Restics without one, e.g. 128Mi. Empty sets none.")
+	cmd.Flags().StringVar(&resources.CPULimit, "sidecar-default-cpu-limit", resources.CPULimit, "CPU limit of sidecars and stash jobs of Restics without one, e.g. for namespaces with a LimitRange requiring limits. Empty sets none.")
+	cmd.Flags().StringVar(&resources.MemoryLimit, "sidecar-default-memory-limit", resources.MemoryLimit, "Memory limit of sidecars and stash jobs of Restics without one, e.g. for namespaces with a LimitRange requiring limits. Empty sets none.")
+	cmd.Flags().StringVar(&opts.RecoveryWebhookURL, "recovery-webhook-url", opts.RecoveryWebhookURL, "URL notified with a JSON POST request whenever a Recovery fails. If empty, no notification is sent.")
+	cmd.Flags().StringVar(&opts.SlackWebhookSecretName, "slack-webhook-secret-name", opts.SlackWebhookSecretName, "Name of the Secret holding the Slack incoming webhook URL in key SLACK_WEBHOOK_URL. If set, Slack is notified whenever a Recovery succeeds or fails.")
+	cmd.Flags().StringVar(&opts.SlackWebhookSecretNamespace, "slack-webhook-secret-namespace", opts.SlackWebhookSecretNamespace, "Namespace of the Slack webhook Secret.")
+	cmd.Flags().BoolVar(&webhook.Enable, "enable-mutating-webhook", webhook.Enable, "If true, serve a mutating admission webhook injecting the stash sidecar, for clusters without initializers.")
+	cmd.Flags().StringVar(&webhook.Address, "webhook-address", webhook.Address, "Address the mutating admission webhook listens on with TLS.")
+	cmd.Flags().StringVar(&webhook.CertFile, "webhook-tls-cert-file", webhook.CertFile, "File containing the TLS certificate of the mutating admission webhook.")
+	cmd.Flags().StringVar(&webhook.KeyFile, "webhook-tls-private-key-file", webhook.KeyFile, "File containing the TLS private key of the mutating admission webhook.")
+	cmd.Flags().BoolVar(&opts.SkipVersionTagCheck, "skip-version-tag-check", opts.SkipVersionTagCheck, "If true, the stash image tag set by annotation restic.appscode.com/tag of Restics is not checked in the registry, e.g. for air-gapped clusters.")
+	cmd.Flags().DurationVar(&docker.ManifestCacheTTL, "image-check-cache-ttl", docker.ManifestCacheTTL, "Duration the result of a successful check that a Docker image exists is reused. Zero checks the registry every time.")
+	cmd.Flags().StringVar(&opts.LeaderElectionLockName, "leader-elect-lock-name", opts.LeaderElectionLockName, "Name of the ConfigMap used for leader election among operator replicas. If empty, leader election is disabled.")
+	cmd.Flags().StringVar(&opts.LeaderElectionLockNamespace, "leader-elect-lock-namespace", opts.LeaderElectionLockNamespace, "Namespace of the leader election ConfigMap.")
+	cmd.Flags().DurationVar(&opts.LeaderElectionLeaseDuration, "leader-elect-lease-duration", opts.LeaderElectionLeaseDuration, "Duration non-leader replicas wait before trying to acquire a lease that was not renewed.")
 
 	return cmd
 }
+
+type webhookOptions struct {
+	Enable   bool
+	Address  string
+	CertFile string
+	KeyFile  string
+}
+
+// resourceOptions holds default requests and limits as quantities, e.g. 100m or 128Mi.
+type resourceOptions struct {
+	CPURequest    string
+	MemoryRequest string
+	CPULimit      string
+	MemoryLimit   string
+}
+
+func (o resourceOptions) resources() (core.ResourceRequirements, error) {
+	var resources core.ResourceRequirements
+	for _, q := range []struct {
+		list  *core.ResourceList
+		name  core.ResourceName
+		value string
+	}{
+		{&resources.Requests, core.ResourceCPU, o.CPURequest},
+		{&resources.Requests, core.ResourceMemory, o.MemoryRequest},
+		{&resources.Limits, core.ResourceCPU, o.CPULimit},
+		{&resources.Limits, core.ResourceMemory, o.MemoryLimit},
+	} {
+		if q.value == "" {
+			continue
+		}
+		quantity, err := resource.ParseQuantity(q.value)
+		if err != nil {
+			return resources, fmt.Errorf("invalid %s quantity %q. Reason: %v", q.name, q.value, err)
+		}
+		if *q.list == nil {
+			*q.list = core.ResourceList{}
+		}
+		(*q.list)[q.name] = quantity
+	}
+	return resources, nil
+}
+
+// serveMutatingWebhook serves the sidecar injecting admission webhook of ctrl at /mutate.
+func serveMutatingWebhook(ctrl *controller.StashController, opt webhookOptions) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/mutate", ctrl.ServeMutatingWebhook)
+	log.Infoln("Mutating webhook listening on", opt.Address)
+	log.Fatal(http.ListenAndServeTLS(opt.Address, opt.CertFile, opt.KeyFile, mux))
+}
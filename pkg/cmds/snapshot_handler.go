@@ -6,9 +6,8 @@ import (
 	_ "net/http/pprof"
 
 	"github.com/appscode/pat"
-	api "github.com/appscode/stash/apis/stash/v1alpha1"
 	"github.com/appscode/stash/pkg/cli"
-	core "k8s.io/api/core/v1"
+	"github.com/appscode/stash/pkg/util"
 	kerr "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
@@ -35,9 +34,6 @@ func ExportSnapshots(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Missing parameter:"+PathParamName, http.StatusBadRequest)
 		return
 	}
-	resticCLI := cli.New(scratchDir, true, "")
-
-	var resource *api.Restic
 	resource, err := stashClient.Restics(namespace).Get(name, metav1.GetOptions{})
 	if kerr.IsNotFound(err) {
 		http.Error(w, err.Error(), http.StatusNotFound)
@@ -46,28 +42,19 @@ func ExportSnapshots(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-
 	if resource.Spec.Backend.StorageSecretName == "" {
 		http.Error(w, "Missing repository secret name", http.StatusBadRequest)
 		return
 	}
-	var secret *core.Secret
-	secret, err = kubeClient.CoreV1().Secrets(resource.Namespace).Get(resource.Spec.Backend.StorageSecretName, metav1.GetOptions{})
+
+	snapshots, err := util.ListSnapshots(kubeClient, resource, scratchDir, r.URL.Query().Get(QueryParamAutoPrefix))
 	if kerr.IsNotFound(err) {
 		http.Error(w, err.Error(), http.StatusNotFound)
 		return
-	} else if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	} else if err == cli.ErrRepositoryAuth {
+		http.Error(w, err.Error(), http.StatusForbidden)
 		return
-	}
-	err = resticCLI.SetupEnv(resource, secret, r.URL.Query().Get(QueryParamAutoPrefix))
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-
-	snapshots, err := resticCLI.ListSnapshots()
-	if err != nil {
+	} else if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
@@ -2,6 +2,16 @@ package controller
 
 import (
 	"time"
+
+	"github.com/appscode/stash/pkg/util"
+	"github.com/juju/ratelimit"
+	core "k8s.io/api/core/v1"
+	"k8s.io/client-go/util/workqueue"
+)
+
+const (
+	DefaultRecoveryQueueBaseDelay = 5 * time.Millisecond
+	DefaultRecoveryQueueMaxDelay  = 1000 * time.Second
 )
 
 type Options struct {
@@ -10,4 +20,88 @@ type Options struct {
 	KubectlImageTag string
 	ResyncPeriod    time.Duration
 	MaxNumRequeues  int
+	RestartStrategy util.RestartStrategy
+	// Digest of the stash image with SidecarImageTag. If set, sidecars use the image pinned by digest.
+	SidecarImageDigest string
+	// Image of recovery jobs, e.g. one with more resources or tools for heavy recoveries. If empty,
+	// recovery jobs use the stash image with SidecarImageTag.
+	RecoveryImage string
+	// Backoff used while waiting for pods to be restarted after the sidecar is added or removed
+	SidecarWaitBackoff util.SidecarWaitBackoff
+	// Interval to re-check running recovery jobs
+	RecoveryJobCheckInterval time.Duration
+	// Number of workers processing Recoveries concurrently. A Recovery is never processed by two
+	// workers at the same time. Values below 1 use the threadiness passed to Run.
+	RecoveryWorkers int
+	// Base and maximum delay of the per item exponential backoff of the recovery queue. Zero values
+	// use the defaults of workqueue.DefaultControllerRateLimiter.
+	RecoveryQueueBaseDelay time.Duration
+	RecoveryQueueMaxDelay  time.Duration
+	// Maximum number of Recoveries with running recovery jobs. Further Recoveries wait until one of them
+	// completes. Zero means no limit.
+	MaxConcurrentRecoveries int
+	// Maximum duration a recovery job may run before the Recovery is marked as failed. Zero means no limit.
+	RecoveryJobTimeout time.Duration
+	// Number of lines of logs of a failed recovery job pod recorded as event on the Recovery. Zero disables it.
+	RecoveryJobLogLines int64
+	// Log level of sidecar and recovery containers, unless set in Restic. Negative means built-in defaults.
+	LogLevel int
+	// Name of the ConfigMap used as leader election lock. If set, only the elected leader
+	// among operator instances processes the work queues. Empty disables leader election.
+	LeaderElectionLockName      string
+	LeaderElectionLockNamespace string
+	LeaderElectionLeaseDuration time.Duration
+	// If true, sidecars and init containers of Restics without a security context run with
+	// util.DefaultSidecarSecurityContext
+	EnableDefaultSidecarSecurityContext bool
+	// Requests and limits of sidecars and stash jobs for resources not set in the Restic, so that they
+	// are admitted in namespaces with a LimitRange requiring them.
+	SidecarDefaultResources core.ResourceRequirements
+	// URL receiving a JSON POST request whenever a Recovery fails. Empty disables the webhook.
+	RecoveryWebhookURL string
+	// Secret holding the Slack incoming webhook URL notified when a Recovery succeeds or fails,
+	// see notifier.SlackWebhookURLKey. Empty disables Slack notifications.
+	SlackWebhookSecretName      string
+	SlackWebhookSecretNamespace string
+	// If set, Restics, Recoveries, workloads and jobs are only watched in this namespace, so that the
+	// operator can run with namespace scoped RBAC for them. Namespaces are not watched then, and
+	// Recoveries of Restics in other namespaces are rejected. Empty watches all namespaces.
+	WatchNamespace string
+	// Restics whose scheduled backup is overdue by longer than this are reported as stale, see ServeStaleBackups.
+	BackupStalenessThreshold time.Duration
+	// If true, the image tag set by the api.VersionTag annotation of Restics is not checked in the
+	// registry, e.g. for air-gapped clusters.
+	SkipVersionTagCheck bool
+}
+
+// watchNamespace returns the namespace watched by the controller, core.NamespaceAll unless WatchNamespace is set.
+func (o Options) watchNamespace() string {
+	if o.WatchNamespace != "" {
+		return o.WatchNamespace
+	}
+	return core.NamespaceAll
+}
+
+// recoveryRateLimiter returns the rate limiter of the recovery queue. Like workqueue.DefaultControllerRateLimiter,
+// it combines a per item exponential backoff with an overall token bucket.
+func (o Options) recoveryRateLimiter() workqueue.RateLimiter {
+	baseDelay, maxDelay := o.RecoveryQueueBaseDelay, o.RecoveryQueueMaxDelay
+	if baseDelay <= 0 {
+		baseDelay = DefaultRecoveryQueueBaseDelay
+	}
+	if maxDelay <= 0 {
+		maxDelay = DefaultRecoveryQueueMaxDelay
+	}
+	return workqueue.NewMaxOfRateLimiter(
+		workqueue.NewItemExponentialFailureRateLimiter(baseDelay, maxDelay),
+		// 10 qps, 100 bucket size
+		&workqueue.BucketRateLimiter{Bucket: ratelimit.NewBucketWithRate(float64(10), int64(100))},
+	)
+}
+
+func (o Options) defaultSidecarSecurityContext() *core.SecurityContext {
+	if o.EnableDefaultSidecarSecurityContext {
+		return util.DefaultSidecarSecurityContext()
+	}
+	return nil
 }
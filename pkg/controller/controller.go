@@ -2,16 +2,22 @@ package controller
 
 import (
 	"fmt"
+	"os"
+	"sync"
 	"time"
 
+	"github.com/appscode/go/log"
 	apiext_util "github.com/appscode/kutil/apiextensions/v1beta1"
 	api "github.com/appscode/stash/apis/stash/v1alpha1"
 	cs "github.com/appscode/stash/client/typed/stash/v1alpha1"
 	stash_listers "github.com/appscode/stash/listers/stash/v1alpha1"
 	"github.com/appscode/stash/pkg/eventer"
+	"github.com/appscode/stash/pkg/notifier"
 	"github.com/golang/glog"
 	crd_api "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1beta1"
 	crd_cs "k8s.io/apiextensions-apiserver/pkg/client/clientset/clientset/typed/apiextensions/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/clock"
 	"k8s.io/apimachinery/pkg/util/runtime"
 	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/client-go/kubernetes"
@@ -20,6 +26,8 @@ import (
 	core_listers "k8s.io/client-go/listers/core/v1"
 	ext_listers "k8s.io/client-go/listers/extensions/v1beta1"
 	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
 	"k8s.io/client-go/tools/record"
 	"k8s.io/client-go/util/workqueue"
 )
@@ -30,6 +38,10 @@ type StashController struct {
 	crdClient   crd_cs.ApiextensionsV1beta1Interface
 	options     Options
 	recorder    record.EventRecorder
+	// Notified when a Recovery succeeds or fails, nil if notifications are disabled
+	notifier notifier.Notifier
+	// Used to time running recovery jobs, a fake clock in tests
+	clock clock.Clock
 
 	// Namespace
 	nsIndexer  cache.Indexer
@@ -46,6 +58,11 @@ type StashController struct {
 	recIndexer  cache.Indexer
 	recInformer cache.Controller
 	recLister   stash_listers.RecoveryLister
+	// Tracks running Recovery workers, so that in-flight recoveries finish before Run returns
+	recWorkers sync.WaitGroup
+	// Held by workers while counting running Recoveries and creating recovery jobs, so that concurrent
+	// workers don't exceed MaxConcurrentRecoveries
+	recThrottleLock sync.Mutex
 
 	// Deployment
 	dpQueue    workqueue.RateLimitingInterface
@@ -65,6 +82,9 @@ type StashController struct {
 	ssInformer cache.Controller
 	ssLister   apps_listers.StatefulSetLister
 
+	// DeploymentConfig, not watched, see initDeploymentConfigQueue
+	dcQueue workqueue.RateLimitingInterface
+
 	// ReplicationController
 	rcQueue    workqueue.RateLimitingInterface
 	rcIndexer  cache.Indexer
@@ -91,6 +111,7 @@ func New(kubeClient kubernetes.Interface, crdClient crd_cs.ApiextensionsV1beta1I
 		crdClient:   crdClient,
 		options:     options,
 		recorder:    eventer.NewEventRecorder(kubeClient, "stash-controller"),
+		clock:       clock.RealClock{},
 	}
 }
 
@@ -103,18 +124,49 @@ func (c *StashController) Setup() error {
 			return err
 		}
 	}
-	c.initNamespaceWatcher()
+	if err := c.setupNotifier(); err != nil {
+		return err
+	}
+	if c.options.WatchNamespace == "" {
+		// namespaces are cluster scoped, deleting the watched namespace deletes its Restics anyway
+		c.initNamespaceWatcher()
+	}
 	c.initResticWatcher()
 	c.initRecoveryWatcher()
 	c.initDeploymentWatcher()
 	c.initDaemonSetWatcher()
 	c.initStatefulSetWatcher()
+	c.initDeploymentConfigQueue()
 	c.initRCWatcher()
 	c.initReplicaSetWatcher()
 	c.initJobWatcher()
 	return nil
 }
 
+// setupNotifier configures the notifiers of Recovery results. The recovery webhook is only notified
+// of failures, Slack is notified of failures and successes.
+func (c *StashController) setupNotifier() error {
+	var notifiers notifier.Multi
+	if c.options.RecoveryWebhookURL != "" {
+		notifiers = append(notifiers, notifier.FailuresOnly{N: notifier.NewWebhook(c.options.RecoveryWebhookURL)})
+	}
+	if c.options.SlackWebhookSecretName != "" {
+		secret, err := c.k8sClient.CoreV1().Secrets(c.options.SlackWebhookSecretNamespace).Get(c.options.SlackWebhookSecretName, metav1.GetOptions{})
+		if err != nil {
+			return fmt.Errorf("failed to get slack secret, reason: %s", err)
+		}
+		url, ok := secret.Data[notifier.SlackWebhookURLKey]
+		if !ok || len(url) == 0 {
+			return fmt.Errorf("secret %s/%s is missing key %s", secret.Namespace, secret.Name, notifier.SlackWebhookURLKey)
+		}
+		notifiers = append(notifiers, notifier.NewSlack(string(url)))
+	}
+	if len(notifiers) > 0 {
+		c.notifier = notifiers
+	}
+	return nil
+}
+
 func (c *StashController) ensureCustomResourceDefinitions() error {
 	crds := []*crd_api.CustomResourceDefinition{
 		api.Restic{}.CustomResourceDefinition(),
@@ -132,12 +184,15 @@ func (c *StashController) Run(threadiness int, stopCh chan struct{}) {
 	defer c.dpQueue.ShutDown()
 	defer c.dsQueue.ShutDown()
 	defer c.ssQueue.ShutDown()
+	defer c.dcQueue.ShutDown()
 	defer c.rcQueue.ShutDown()
 	defer c.rsQueue.ShutDown()
 	defer c.jobQueue.ShutDown()
 	glog.Info("Starting Stash controller")
 
-	go c.nsInformer.Run(stopCh)
+	if c.nsInformer != nil {
+		go c.nsInformer.Run(stopCh)
+	}
 	go c.rstInformer.Run(stopCh)
 	go c.recInformer.Run(stopCh)
 	go c.dpInformer.Run(stopCh)
@@ -148,7 +203,7 @@ func (c *StashController) Run(threadiness int, stopCh chan struct{}) {
 	go c.jobInformer.Run(stopCh)
 
 	// Wait for all involved caches to be synced, before processing items from the queue is started
-	if !cache.WaitForCacheSync(stopCh, c.nsInformer.HasSynced) {
+	if c.nsInformer != nil && !cache.WaitForCacheSync(stopCh, c.nsInformer.HasSynced) {
 		runtime.HandleError(fmt.Errorf("timed out waiting for caches to sync"))
 		return
 	}
@@ -185,17 +240,69 @@ func (c *StashController) Run(threadiness int, stopCh chan struct{}) {
 		return
 	}
 
+	// Workers are stopped with stopCh. Losing leadership exits the process, see electLeader.
+	if c.options.LeaderElectionLockName == "" {
+		c.runWorkers(threadiness, stopCh)
+	} else if err := c.electLeader(func(<-chan struct{}) { c.runWorkers(threadiness, stopCh) }); err != nil {
+		runtime.HandleError(err)
+		return
+	}
+
+	<-stopCh
+	glog.Info("Stopping Stash controller")
+	c.recWorkers.Wait()
+}
+
+func (c *StashController) runWorkers(threadiness int, stopCh <-chan struct{}) {
 	for i := 0; i < threadiness; i++ {
 		go wait.Until(c.runResticWatcher, time.Second, stopCh)
-		go wait.Until(c.runRecoveryWatcher, time.Second, stopCh)
 		go wait.Until(c.runDeploymentWatcher, time.Second, stopCh)
 		go wait.Until(c.runDaemonSetWatcher, time.Second, stopCh)
 		go wait.Until(c.runStatefulSetWatcher, time.Second, stopCh)
+		go wait.Until(c.runDeploymentConfigWatcher, time.Second, stopCh)
 		go wait.Until(c.runRCWatcher, time.Second, stopCh)
 		go wait.Until(c.runReplicaSetWatcher, time.Second, stopCh)
 		go wait.Until(c.runJobWatcher, time.Second, stopCh)
 	}
+	recoveryWorkers := c.options.RecoveryWorkers
+	if recoveryWorkers < 1 {
+		recoveryWorkers = threadiness
+	}
+	c.startRecoveryWatchers(recoveryWorkers, stopCh)
+	go wait.Until(c.deleteExpiredRecoveries, recoveryCleanupInterval, stopCh)
+}
 
-	<-stopCh
-	glog.Info("Stopping Stash controller")
+// electLeader calls run once this instance acquires the leader election lock. Informers keep
+// running on every instance, so a newly elected leader starts with synced caches. The process
+// exits if the lease is lost, as workers of a former leader can not be stopped safely.
+func (c *StashController) electLeader(run func(stop <-chan struct{})) error {
+	id, err := os.Hostname()
+	if err != nil {
+		return fmt.Errorf("error during leader election: %s", err)
+	}
+	rlc := resourcelock.ResourceLockConfig{
+		Identity:      id,
+		EventRecorder: c.recorder,
+	}
+	resLock, err := resourcelock.New(resourcelock.ConfigMapsResourceLock, c.options.LeaderElectionLockNamespace, c.options.LeaderElectionLockName, c.k8sClient.CoreV1(), rlc)
+	if err != nil {
+		return fmt.Errorf("error during leader election: %s", err)
+	}
+	lease := c.options.LeaderElectionLeaseDuration
+	go leaderelection.RunOrDie(leaderelection.LeaderElectionConfig{
+		Lock:          resLock,
+		LeaseDuration: lease,
+		RenewDeadline: lease * 2 / 3,
+		RetryPeriod:   lease / 3,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: func(stop <-chan struct{}) {
+				log.Infoln("Got leadership, starting Stash controller workers")
+				run(stop)
+			},
+			OnStoppedLeading: func() {
+				log.Fatalln("Lost leadership, exiting")
+			},
+		},
+	})
+	return nil
 }
@@ -0,0 +1,253 @@
+package controller
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	api "github.com/appscode/stash/apis/stash/v1alpha1"
+	stash_fake "github.com/appscode/stash/client/fake"
+	core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/workqueue"
+)
+
+const (
+	testLockName      = "stash-operator"
+	testLockNamespace = "kube-system"
+)
+
+func newLeaderElectionTestController(lease time.Duration, objects ...runtime.Object) *StashController {
+	return &StashController{
+		k8sClient: fake.NewSimpleClientset(objects...),
+		recorder:  record.NewFakeRecorder(100),
+		options: Options{
+			LeaderElectionLockName:      testLockName,
+			LeaderElectionLockNamespace: testLockNamespace,
+			LeaderElectionLeaseDuration: lease,
+		},
+	}
+}
+
+// drain returns a worker that empties queue once it is started.
+func drain(queue workqueue.Interface) func(stop <-chan struct{}) {
+	return func(stop <-chan struct{}) {
+		for queue.Len() > 0 {
+			key, _ := queue.Get()
+			queue.Done(key)
+		}
+	}
+}
+
+func TestNonLeaderDoesNotDequeue(t *testing.T) {
+	leader, err := json.Marshal(resourcelock.LeaderElectionRecord{
+		HolderIdentity:       "other-operator",
+		LeaseDurationSeconds: 3,
+		AcquireTime:          metav1.Now(),
+		RenewTime:            metav1.Now(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the lease of the other operator is not renewed, so it is only respected for the lease duration
+	c := newLeaderElectionTestController(3*time.Second, &core.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        testLockName,
+			Namespace:   testLockNamespace,
+			Annotations: map[string]string{resourcelock.LeaderElectionRecordAnnotationKey: string(leader)},
+		},
+	})
+
+	queue := workqueue.New()
+	queue.Add("default/stash-demo")
+	if err = c.electLeader(drain(queue)); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Second)
+	if n := queue.Len(); n != 1 {
+		t.Errorf("expected non-leader to leave the queue untouched, found %d items", n)
+	}
+}
+
+func TestLeaderDequeues(t *testing.T) {
+	c := newLeaderElectionTestController(300 * time.Millisecond)
+
+	queue := workqueue.New()
+	queue.Add("default/stash-demo")
+	if err := c.electLeader(drain(queue)); err != nil {
+		t.Fatal(err)
+	}
+	err := wait.Poll(50*time.Millisecond, 2*time.Second, func() (bool, error) { return queue.Len() == 0, nil })
+	if err != nil {
+		t.Errorf("expected leader to process the queue, found %d items", queue.Len())
+	}
+}
+
+func TestRecoveryWatchersStop(t *testing.T) {
+	c := &StashController{
+		recQueue:   workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "recovery"),
+		recIndexer: cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{}),
+	}
+	stop := make(chan struct{})
+	c.startRecoveryWatchers(2, stop)
+	c.recQueue.Add("default/missing")
+
+	close(stop)
+	done := make(chan struct{})
+	go func() {
+		c.recWorkers.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("recovery watchers did not stop after stop channel was closed")
+	}
+	if !c.recQueue.ShuttingDown() {
+		t.Error("expected recovery queue to be shut down")
+	}
+}
+
+func TestRecoveryWatchersConcurrency(t *testing.T) {
+	// events are not buffered, so each worker blocks while reporting its failed Recovery
+	recorder := record.NewFakeRecorder(0)
+	c := &StashController{
+		k8sClient:   fake.NewSimpleClientset(),
+		stashClient: stash_fake.NewSimpleClientset().StashV1alpha1(),
+		recorder:    recorder,
+		recQueue:    workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "recovery"),
+		recIndexer:  cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{}),
+	}
+	names := []string{"db", "web", "cache"}
+	for _, name := range names {
+		c.recIndexer.Add(&api.Recovery{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+			Spec:       api.RecoverySpec{Restic: "missing"},
+		})
+		c.recQueue.Add("default/" + name)
+	}
+
+	stop := make(chan struct{})
+	defer close(stop)
+	c.startRecoveryWatchers(len(names), stop)
+
+	// a single worker would leave the other Recoveries queued while it is blocked
+	if err := wait.Poll(10*time.Millisecond, 5*time.Second, func() (bool, error) { return c.recQueue.Len() == 0, nil }); err != nil {
+		t.Fatalf("expected %d Recoveries to be processed concurrently, found %d queued", len(names), c.recQueue.Len())
+	}
+	// the same Recovery is not handed to another worker while it is processed
+	c.recQueue.Add("default/db")
+	if n := c.recQueue.Len(); n != 0 {
+		t.Errorf("expected Recovery in process to be held back, found %d queued", n)
+	}
+
+	for i := 0; i <= len(names); i++ {
+		select {
+		case <-recorder.Events:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("expected %d failure events, found %d", len(names)+1, i)
+		}
+	}
+}
+
+func TestRecoveryRateLimiterMaxDelay(t *testing.T) {
+	cases := map[string]struct {
+		opts     Options
+		maxDelay time.Duration
+	}{
+		"default":    {Options{}, DefaultRecoveryQueueMaxDelay},
+		"configured": {Options{RecoveryQueueBaseDelay: 100 * time.Millisecond, RecoveryQueueMaxDelay: 30 * time.Second}, 30 * time.Second},
+	}
+	for name, c := range cases {
+		limiter := c.opts.recoveryRateLimiter()
+		var delay time.Duration
+		for i := 0; i < 40; i++ {
+			delay = limiter.When("default/stash-demo")
+		}
+		if delay != c.maxDelay {
+			t.Errorf("%s: expected max delay %s, found %s", name, c.maxDelay, delay)
+		}
+	}
+
+	opts := Options{RecoveryQueueBaseDelay: 100 * time.Millisecond, RecoveryQueueMaxDelay: 30 * time.Second}
+	if delay := opts.recoveryRateLimiter().When("default/stash-demo"); delay != opts.RecoveryQueueBaseDelay {
+		t.Errorf("expected first delay %s, found %s", opts.RecoveryQueueBaseDelay, delay)
+	}
+}
+
+func TestWatchNamespace(t *testing.T) {
+	newRecovery := func(namespace string) *api.Recovery {
+		return &api.Recovery{
+			ObjectMeta: metav1.ObjectMeta{Name: "db", Namespace: namespace},
+			Spec: api.RecoverySpec{
+				Restic:   "db-backup",
+				Workload: api.LocalTypedReference{Kind: api.KindDeployment, Name: "db"},
+				Volumes:  []core.Volume{{Name: "data"}},
+			},
+		}
+	}
+	stashClient := stash_fake.NewSimpleClientset(newRecovery("team-a"), newRecovery("team-b"))
+
+	for namespace, expected := range map[string][]string{
+		"":       {"team-a/db", "team-b/db"},
+		"team-a": {"team-a/db"},
+	} {
+		c := &StashController{
+			stashClient: stashClient.StashV1alpha1(),
+			recorder:    record.NewFakeRecorder(10),
+			options:     Options{WatchNamespace: namespace},
+		}
+		c.initRecoveryWatcher()
+		stopCh := make(chan struct{})
+		go c.recInformer.Run(stopCh)
+		if !cache.WaitForCacheSync(stopCh, c.recInformer.HasSynced) {
+			t.Fatal("timed out waiting for caches to sync")
+		}
+		close(stopCh)
+
+		keys := c.recIndexer.ListKeys()
+		sort.Strings(keys)
+		if !reflect.DeepEqual(keys, expected) {
+			t.Errorf("watch namespace %q: expected Recoveries %v, found %v", namespace, expected, keys)
+		}
+		if n := c.recQueue.Len(); n != len(expected) {
+			t.Errorf("watch namespace %q: expected %d queued Recoveries, found %d", namespace, len(expected), n)
+		}
+		c.recQueue.ShutDown()
+	}
+}
+
+func TestWatchNamespaceResticNamespace(t *testing.T) {
+	rec := &api.Recovery{
+		ObjectMeta: metav1.ObjectMeta{Name: "db", Namespace: "team-a"},
+		Spec: api.RecoverySpec{
+			Restic:          "db-backup",
+			ResticNamespace: "team-b",
+			Workload:        api.LocalTypedReference{Kind: api.KindDeployment, Name: "db"},
+			Volumes:         []core.Volume{{Name: "data"}},
+		},
+	}
+	stashClient := stash_fake.NewSimpleClientset(rec)
+	c := &StashController{
+		stashClient: stashClient.StashV1alpha1(),
+		recorder:    record.NewFakeRecorder(10),
+		options:     Options{WatchNamespace: "team-a"},
+	}
+	if err := c.runRecoveryJob(rec); err == nil || !strings.Contains(err.Error(), "not watched") {
+		t.Errorf("expected Recovery of a Restic outside the watched namespace to be rejected, found %v", err)
+	}
+	for _, action := range stashClient.Actions() {
+		if action.GetVerb() == "get" && action.GetResource().Resource == "restics" {
+			t.Errorf("expected Restic outside the watched namespace not to be read, found %v", action)
+		}
+	}
+}
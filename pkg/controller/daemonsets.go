@@ -5,13 +5,11 @@ import (
 
 	"github.com/appscode/go/log"
 	stringz "github.com/appscode/go/strings"
-	core_util "github.com/appscode/kutil/core/v1"
 	ext_util "github.com/appscode/kutil/extensions/v1beta1"
 	"github.com/appscode/kutil/meta"
 	api "github.com/appscode/stash/apis/stash/v1alpha1"
 	"github.com/appscode/stash/pkg/util"
 	"github.com/golang/glog"
-	core "k8s.io/api/core/v1"
 	extensions "k8s.io/api/extensions/v1beta1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	rt "k8s.io/apimachinery/pkg/runtime"
@@ -28,11 +26,11 @@ func (c *StashController) initDaemonSetWatcher() {
 	lw := &cache.ListWatch{
 		ListFunc: func(options metav1.ListOptions) (rt.Object, error) {
 			options.IncludeUninitialized = true
-			return c.k8sClient.ExtensionsV1beta1().DaemonSets(core.NamespaceAll).List(options)
+			return c.k8sClient.ExtensionsV1beta1().DaemonSets(c.options.watchNamespace()).List(options)
 		},
 		WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
 			options.IncludeUninitialized = true
-			return c.k8sClient.ExtensionsV1beta1().DaemonSets(core.NamespaceAll).Watch(options)
+			return c.k8sClient.ExtensionsV1beta1().DaemonSets(c.options.watchNamespace()).Watch(options)
 		},
 	}
 
@@ -134,15 +132,20 @@ func (c *StashController) runDaemonSetInjector(key string) error {
 			return nil
 		}
 
-		oldRestic, err := util.GetAppliedRestic(ds.Annotations)
+		ref, _ := reference.GetReference(scheme.Scheme, ds)
+		oldRestic, err := util.GetAppliedResticWithEvent(ds.Annotations, c.recorder, ref)
 		if err != nil {
 			return err
 		}
-		newRestic, err := util.FindRestic(c.rstLister, ds.ObjectMeta)
+		newRestic, err := util.FindRestic(c.rstLister, api.KindDaemonSet, ds.ObjectMeta)
 		if err != nil {
 			log.Errorf("Error while searching Restic for DaemonSet %s/%s.", ds.Name, ds.Namespace)
 			return err
 		}
+		if newRestic != nil && util.SkipInjection(ds.Annotations, ds.Spec.Template.Annotations) {
+			log.Infof("Skipping stash sidecar for DaemonSet %s/%s", ds.Namespace, ds.Name)
+			newRestic = nil
+		}
 		if util.ResticEqual(oldRestic, newRestic) {
 			return nil
 		}
@@ -194,6 +197,15 @@ func (c *StashController) EnsureDaemonSetSidecar(resource *extensions.DaemonSet,
 		}
 	}
 
+	workload := api.LocalTypedReference{
+		Kind: api.KindDaemonSet,
+		Name: resource.Name,
+	}
+	template := resource.Spec.Template.DeepCopy()
+	if err = c.upsertSidecar(template, workload, old, new); err != nil {
+		return
+	}
+
 	resource, err = ext_util.PatchDaemonSet(c.k8sClient, resource, func(obj *extensions.DaemonSet) *extensions.DaemonSet {
 		if util.ToBeInitializedBySelf(obj.Initializers) {
 			fmt.Println("Removing pending stash initializer for", obj.Name)
@@ -204,18 +216,7 @@ func (c *StashController) EnsureDaemonSetSidecar(resource *extensions.DaemonSet,
 			}
 		}
 
-		workload := api.LocalTypedReference{
-			Kind: api.KindDaemonSet,
-			Name: obj.Name,
-		}
-		if new.Spec.Type == api.BackupOffline {
-			obj.Spec.Template.Spec.InitContainers = core_util.UpsertContainer(obj.Spec.Template.Spec.InitContainers, util.CreateInitContainer(new, c.options.SidecarImageTag, workload, c.options.EnableRBAC))
-		} else {
-			obj.Spec.Template.Spec.Containers = core_util.UpsertContainer(obj.Spec.Template.Spec.Containers, util.CreateSidecarContainer(new, c.options.SidecarImageTag, workload))
-		}
-		obj.Spec.Template.Spec.Volumes = util.UpsertScratchVolume(obj.Spec.Template.Spec.Volumes)
-		obj.Spec.Template.Spec.Volumes = util.UpsertDownwardVolume(obj.Spec.Template.Spec.Volumes)
-		obj.Spec.Template.Spec.Volumes = util.MergeLocalVolume(obj.Spec.Template.Spec.Volumes, old, new)
+		obj.Spec.Template = *template
 
 		if obj.Annotations == nil {
 			obj.Annotations = make(map[string]string)
@@ -242,7 +243,7 @@ func (c *StashController) EnsureDaemonSetSidecar(resource *extensions.DaemonSet,
 	if err != nil {
 		return
 	}
-	err = util.WaitUntilSidecarAdded(c.k8sClient, resource.Namespace, resource.Spec.Selector, new.Spec.Type)
+	err = c.waitUntilSidecarAdded(api.KindDaemonSet, resource.Namespace, resource.Spec.Selector, new.Spec.Type)
 	return
 }
 
@@ -255,16 +256,7 @@ func (c *StashController) EnsureDaemonSetSidecarDeleted(resource *extensions.Dae
 	}
 
 	resource, err = ext_util.PatchDaemonSet(c.k8sClient, resource, func(obj *extensions.DaemonSet) *extensions.DaemonSet {
-		if restic.Spec.Type == api.BackupOffline {
-			obj.Spec.Template.Spec.InitContainers = core_util.EnsureContainerDeleted(obj.Spec.Template.Spec.InitContainers, util.StashContainer)
-		} else {
-			obj.Spec.Template.Spec.Containers = core_util.EnsureContainerDeleted(obj.Spec.Template.Spec.Containers, util.StashContainer)
-		}
-		obj.Spec.Template.Spec.Volumes = util.EnsureVolumeDeleted(obj.Spec.Template.Spec.Volumes, util.ScratchDirVolumeName)
-		obj.Spec.Template.Spec.Volumes = util.EnsureVolumeDeleted(obj.Spec.Template.Spec.Volumes, util.PodinfoVolumeName)
-		if restic.Spec.Backend.Local != nil {
-			obj.Spec.Template.Spec.Volumes = util.EnsureVolumeDeleted(obj.Spec.Template.Spec.Volumes, util.LocalVolumeName)
-		}
+		c.removeSidecar(&obj.Spec.Template, restic)
 		if obj.Annotations != nil {
 			delete(obj.Annotations, api.LastAppliedConfiguration)
 			delete(obj.Annotations, api.VersionTag)
@@ -279,6 +271,6 @@ func (c *StashController) EnsureDaemonSetSidecarDeleted(resource *extensions.Dae
 	if err != nil {
 		return
 	}
-	err = util.WaitUntilSidecarRemoved(c.k8sClient, resource.Namespace, resource.Spec.Selector, restic.Spec.Type)
+	err = c.waitUntilSidecarRemoved(api.KindDaemonSet, resource.Namespace, resource.Spec.Selector, restic.Spec.Type)
 	return
 }
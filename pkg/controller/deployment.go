@@ -6,13 +6,11 @@ import (
 	"github.com/appscode/go/log"
 	stringz "github.com/appscode/go/strings"
 	apps_util "github.com/appscode/kutil/apps/v1beta1"
-	core_util "github.com/appscode/kutil/core/v1"
 	"github.com/appscode/kutil/meta"
 	api "github.com/appscode/stash/apis/stash/v1alpha1"
 	"github.com/appscode/stash/pkg/util"
 	"github.com/golang/glog"
 	apps "k8s.io/api/apps/v1beta1"
-	core "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	rt "k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/util/runtime"
@@ -28,11 +26,11 @@ func (c *StashController) initDeploymentWatcher() {
 	lw := &cache.ListWatch{
 		ListFunc: func(options metav1.ListOptions) (rt.Object, error) {
 			options.IncludeUninitialized = true
-			return c.k8sClient.AppsV1beta1().Deployments(core.NamespaceAll).List(options)
+			return c.k8sClient.AppsV1beta1().Deployments(c.options.watchNamespace()).List(options)
 		},
 		WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
 			options.IncludeUninitialized = true
-			return c.k8sClient.AppsV1beta1().Deployments(core.NamespaceAll).Watch(options)
+			return c.k8sClient.AppsV1beta1().Deployments(c.options.watchNamespace()).Watch(options)
 		},
 	}
 
@@ -140,15 +138,20 @@ func (c *StashController) runDeploymentInjector(key string) error {
 			return nil
 		}
 
-		oldRestic, err := util.GetAppliedRestic(dp.Annotations)
+		ref, _ := reference.GetReference(scheme.Scheme, dp)
+		oldRestic, err := util.GetAppliedResticWithEvent(dp.Annotations, c.recorder, ref)
 		if err != nil {
 			return err
 		}
-		newRestic, err := util.FindRestic(c.rstLister, dp.ObjectMeta)
+		newRestic, err := util.FindRestic(c.rstLister, api.KindDeployment, dp.ObjectMeta)
 		if err != nil {
 			log.Errorf("Error while searching Restic for Deployment %s/%s.", dp.Name, dp.Namespace)
 			return err
 		}
+		if newRestic != nil && util.SkipInjection(dp.Annotations, dp.Spec.Template.Annotations) {
+			log.Infof("Skipping stash sidecar for Deployment %s/%s", dp.Namespace, dp.Name)
+			newRestic = nil
+		}
 		if util.ResticEqual(oldRestic, newRestic) {
 			return nil
 		}
@@ -203,6 +206,15 @@ func (c *StashController) EnsureDeploymentSidecar(resource *apps.Deployment, old
 		}
 	}
 
+	workload := api.LocalTypedReference{
+		Kind: api.KindDeployment,
+		Name: resource.Name,
+	}
+	template := resource.Spec.Template.DeepCopy()
+	if err = c.upsertSidecar(template, workload, old, new); err != nil {
+		return
+	}
+
 	resource, err = apps_util.PatchDeployment(c.k8sClient, resource, func(obj *apps.Deployment) *apps.Deployment {
 		if util.ToBeInitializedBySelf(obj.Initializers) {
 			fmt.Println("Removing pending stash initializer for", obj.Name)
@@ -213,18 +225,7 @@ func (c *StashController) EnsureDeploymentSidecar(resource *apps.Deployment, old
 			}
 		}
 
-		workload := api.LocalTypedReference{
-			Kind: api.KindDeployment,
-			Name: obj.Name,
-		}
-		if new.Spec.Type == api.BackupOffline {
-			obj.Spec.Template.Spec.InitContainers = core_util.UpsertContainer(obj.Spec.Template.Spec.InitContainers, util.CreateInitContainer(new, c.options.SidecarImageTag, workload, c.options.EnableRBAC))
-		} else {
-			obj.Spec.Template.Spec.Containers = core_util.UpsertContainer(obj.Spec.Template.Spec.Containers, util.CreateSidecarContainer(new, c.options.SidecarImageTag, workload))
-		}
-		obj.Spec.Template.Spec.Volumes = util.UpsertScratchVolume(obj.Spec.Template.Spec.Volumes)
-		obj.Spec.Template.Spec.Volumes = util.UpsertDownwardVolume(obj.Spec.Template.Spec.Volumes)
-		obj.Spec.Template.Spec.Volumes = util.MergeLocalVolume(obj.Spec.Template.Spec.Volumes, old, new)
+		obj.Spec.Template = *template
 
 		if obj.Annotations == nil {
 			obj.Annotations = make(map[string]string)
@@ -251,7 +252,7 @@ func (c *StashController) EnsureDeploymentSidecar(resource *apps.Deployment, old
 	if err != nil {
 		return
 	}
-	err = util.WaitUntilSidecarAdded(c.k8sClient, resource.Namespace, resource.Spec.Selector, new.Spec.Type)
+	err = c.waitUntilSidecarAdded(api.KindDeployment, resource.Namespace, resource.Spec.Selector, new.Spec.Type)
 	return err
 }
 
@@ -264,16 +265,7 @@ func (c *StashController) EnsureDeploymentSidecarDeleted(resource *apps.Deployme
 	}
 
 	resource, err = apps_util.PatchDeployment(c.k8sClient, resource, func(obj *apps.Deployment) *apps.Deployment {
-		if restic.Spec.Type == api.BackupOffline {
-			obj.Spec.Template.Spec.InitContainers = core_util.EnsureContainerDeleted(obj.Spec.Template.Spec.InitContainers, util.StashContainer)
-		} else {
-			obj.Spec.Template.Spec.Containers = core_util.EnsureContainerDeleted(obj.Spec.Template.Spec.Containers, util.StashContainer)
-		}
-		obj.Spec.Template.Spec.Volumes = util.EnsureVolumeDeleted(obj.Spec.Template.Spec.Volumes, util.ScratchDirVolumeName)
-		obj.Spec.Template.Spec.Volumes = util.EnsureVolumeDeleted(obj.Spec.Template.Spec.Volumes, util.PodinfoVolumeName)
-		if restic.Spec.Backend.Local != nil {
-			obj.Spec.Template.Spec.Volumes = util.EnsureVolumeDeleted(obj.Spec.Template.Spec.Volumes, util.LocalVolumeName)
-		}
+		c.removeSidecar(&obj.Spec.Template, restic)
 		if obj.Annotations != nil {
 			delete(obj.Annotations, api.LastAppliedConfiguration)
 			delete(obj.Annotations, api.VersionTag)
@@ -288,7 +280,7 @@ func (c *StashController) EnsureDeploymentSidecarDeleted(resource *apps.Deployme
 	if err != nil {
 		return
 	}
-	err = util.WaitUntilSidecarRemoved(c.k8sClient, resource.Namespace, resource.Spec.Selector, restic.Spec.Type)
+	err = c.waitUntilSidecarRemoved(api.KindDeployment, resource.Namespace, resource.Spec.Selector, restic.Spec.Type)
 	if err != nil {
 		return
 	}
@@ -0,0 +1,157 @@
+package controller
+
+import (
+	"fmt"
+
+	"github.com/appscode/go/log"
+	stringz "github.com/appscode/go/strings"
+	"github.com/appscode/kutil/meta"
+	api "github.com/appscode/stash/apis/stash/v1alpha1"
+	"github.com/appscode/stash/pkg/util"
+	"github.com/golang/glog"
+	core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+)
+
+// initDeploymentConfigQueue creates the queue of OpenShift DeploymentConfigs. They are not watched, as
+// the OpenShift client is not available to the operator. Instead, a DeploymentConfig is enqueued when
+// a Restic targeting it changes, see enqueueTarget.
+func (c *StashController) initDeploymentConfigQueue() {
+	c.dcQueue = workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "deploymentconfig")
+}
+
+func (c *StashController) runDeploymentConfigWatcher() {
+	for c.processNextDeploymentConfig() {
+	}
+}
+
+func (c *StashController) processNextDeploymentConfig() bool {
+	key, quit := c.dcQueue.Get()
+	if quit {
+		return false
+	}
+	defer c.dcQueue.Done(key)
+
+	err := c.runDeploymentConfigInjector(key.(string))
+	if err == nil {
+		c.dcQueue.Forget(key)
+		return true
+	}
+	log.Errorf("Failed to process DeploymentConfig %v. Reason: %s", key, err)
+
+	if c.dcQueue.NumRequeues(key) < c.options.MaxNumRequeues {
+		glog.Infof("Error syncing DeploymentConfig %v: %v", key, err)
+		c.dcQueue.AddRateLimited(key)
+		return true
+	}
+
+	c.dcQueue.Forget(key)
+	runtime.HandleError(err)
+	glog.Infof("Dropping DeploymentConfig %q out of the queue: %v", key, err)
+	return true
+}
+
+func (c *StashController) runDeploymentConfigInjector(key string) error {
+	namespace, name, err := cache.SplitMetaNamespaceKey(key)
+	if err != nil {
+		return err
+	}
+	dc, err := util.GetDeploymentConfig(c.k8sClient, namespace, name)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Sync/Add/Update for DeploymentConfig %s\n", dc.GetName())
+
+	ref := deploymentConfigReference(dc)
+	oldRestic, err := util.GetAppliedResticWithEvent(dc.Annotations, c.recorder, ref)
+	if err != nil {
+		return err
+	}
+	newRestic, err := util.FindRestic(c.rstLister, api.KindDeploymentConfig, dc.ObjectMeta)
+	if err != nil {
+		log.Errorf("Error while searching Restic for DeploymentConfig %s/%s.", dc.Name, dc.Namespace)
+		return err
+	}
+	if newRestic != nil && util.SkipInjection(dc.Annotations, dc.Spec.Template.Annotations) {
+		log.Infof("Skipping stash sidecar for DeploymentConfig %s/%s", dc.Namespace, dc.Name)
+		newRestic = nil
+	}
+	if util.ResticEqual(oldRestic, newRestic) {
+		return nil
+	}
+	if newRestic != nil {
+		return c.EnsureDeploymentConfigSidecar(dc, oldRestic, newRestic)
+	} else if oldRestic != nil {
+		return c.EnsureDeploymentConfigSidecarDeleted(dc, oldRestic)
+	}
+	return nil
+}
+
+func (c *StashController) EnsureDeploymentConfigSidecar(resource *util.DeploymentConfig, old, new *api.Restic) error {
+	if new.Spec.Backend.StorageSecretName == "" {
+		return fmt.Errorf("missing repository secret name for Restic %s/%s", new.Namespace, new.Name)
+	}
+	if _, err := c.k8sClient.CoreV1().Secrets(resource.Namespace).Get(new.Spec.Backend.StorageSecretName, metav1.GetOptions{}); err != nil {
+		return err
+	}
+
+	if c.options.EnableRBAC {
+		sa := stringz.Val(resource.Spec.Template.Spec.ServiceAccountName, "default")
+		if err := c.ensureRoleBinding(deploymentConfigReference(resource), sa); err != nil {
+			return err
+		}
+	}
+
+	mod := resource.DeepCopy()
+	workload := api.LocalTypedReference{Kind: api.KindDeploymentConfig, Name: resource.Name}
+	if err := c.upsertSidecar(&mod.Spec.Template, workload, old, new); err != nil {
+		return err
+	}
+	if mod.Annotations == nil {
+		mod.Annotations = make(map[string]string)
+	}
+	r := &api.Restic{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: api.SchemeGroupVersion.String(),
+			Kind:       api.ResourceKindRestic,
+		},
+		ObjectMeta: new.ObjectMeta,
+		Spec:       new.Spec,
+	}
+	data, _ := meta.MarshalToJson(r, api.SchemeGroupVersion)
+	mod.Annotations[api.LastAppliedConfiguration] = string(data)
+	mod.Annotations[api.VersionTag] = c.options.SidecarImageTag
+	return util.PatchDeploymentConfig(c.k8sClient, resource, mod)
+}
+
+func (c *StashController) EnsureDeploymentConfigSidecarDeleted(resource *util.DeploymentConfig, restic *api.Restic) error {
+	if c.options.EnableRBAC {
+		if err := c.ensureRoleBindingDeleted(resource.ObjectMeta); err != nil {
+			return err
+		}
+	}
+
+	mod := resource.DeepCopy()
+	c.removeSidecar(&mod.Spec.Template, restic)
+	delete(mod.Annotations, api.LastAppliedConfiguration)
+	delete(mod.Annotations, api.VersionTag)
+	if err := util.PatchDeploymentConfig(c.k8sClient, resource, mod); err != nil {
+		return err
+	}
+	util.DeleteConfigmapLock(c.k8sClient, resource.Namespace, api.LocalTypedReference{Kind: api.KindDeploymentConfig, Name: resource.Name})
+	return nil
+}
+
+func deploymentConfigReference(dc *util.DeploymentConfig) *core.ObjectReference {
+	return &core.ObjectReference{
+		APIVersion:      util.OpenShiftAppsGroupVersion,
+		Kind:            api.KindDeploymentConfig,
+		Namespace:       dc.Namespace,
+		Name:            dc.Name,
+		UID:             dc.UID,
+		ResourceVersion: dc.ResourceVersion,
+	}
+}
@@ -0,0 +1,114 @@
+package controller
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	api "github.com/appscode/stash/apis/stash/v1alpha1"
+	stash_scheme "github.com/appscode/stash/client/scheme"
+	stash_listers "github.com/appscode/stash/listers/stash/v1alpha1"
+	"github.com/appscode/stash/pkg/admission"
+	"github.com/appscode/stash/pkg/util"
+	core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	clientsetscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+)
+
+// newDeploymentConfigServer fakes the OpenShift apps API for the DeploymentConfig dc and the repository
+// secret in namespace default. JSON patches of dc are sent to patches.
+func newDeploymentConfigServer(t *testing.T, dc *util.DeploymentConfig, patches chan<- []admission.PatchOperation) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case r.URL.Path == "/apis/"+util.OpenShiftAppsGroupVersion:
+			w.Write([]byte(`{"kind":"APIResourceList","groupVersion":"apps.openshift.io/v1","resources":[{"name":"deploymentconfigs","namespaced":true,"kind":"DeploymentConfig"}]}`))
+		case r.URL.Path == "/apis/"+util.OpenShiftAppsGroupVersion+"/namespaces/default/deploymentconfigs/"+dc.Name:
+			var patch []admission.PatchOperation
+			if r.Method == http.MethodPatch {
+				if err := json.NewDecoder(r.Body).Decode(&patch); err != nil {
+					t.Error(err)
+				}
+			}
+			json.NewEncoder(w).Encode(dc)
+			if patch != nil {
+				patches <- patch
+			}
+		case r.URL.Path == "/api/v1/namespaces/default/secrets/backend-secret":
+			w.Write([]byte(`{"kind":"Secret","apiVersion":"v1","metadata":{"name":"backend-secret","namespace":"default"}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"kind":"Status","apiVersion":"v1","status":"Failure","reason":"NotFound","code":404}`))
+		}
+	}))
+}
+
+func TestRunDeploymentConfigInjector(t *testing.T) {
+	// the applied Restic is recorded in the client-go encoding
+	stash_scheme.AddToScheme(clientsetscheme.Scheme)
+	restic := &api.Restic{
+		ObjectMeta: metav1.ObjectMeta{Name: "app-backup", Namespace: "default"},
+		Spec: api.ResticSpec{
+			Target: &api.LocalTypedReference{Kind: "dc", Name: "app"},
+			Backend: api.Backend{
+				StorageSecretName: "backend-secret",
+				Local: &api.LocalSpec{
+					VolumeSource: core.VolumeSource{HostPath: &core.HostPathVolumeSource{Path: "/data/stash"}},
+					Path:         "/safe/data",
+				},
+			},
+		},
+	}
+	dc := &util.DeploymentConfig{ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "default", ResourceVersion: "7"}}
+	dc.Spec.Template.Spec.Containers = []core.Container{{Name: "app"}}
+
+	patches := make(chan []admission.PatchOperation, 1)
+	server := newDeploymentConfigServer(t, dc, patches)
+	defer server.Close()
+	client, err := kubernetes.NewForConfig(&rest.Config{Host: server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	indexer.Add(restic)
+	c := &StashController{
+		k8sClient: client,
+		recorder:  record.NewFakeRecorder(10),
+		rstLister: stash_listers.NewResticLister(indexer),
+		jobLister: initializedJobLister(t, restic, api.LocalTypedReference{Kind: api.KindDeploymentConfig, Name: "app"}),
+	}
+
+	// the sidecar is added to the DeploymentConfig targeted by the Restic
+	if err := c.runDeploymentConfigInjector("default/app"); err != nil {
+		t.Fatal(err)
+	}
+	patch := <-patches
+	if len(patch) != 3 || patch[0].Op != "test" || patch[0].Value != "7" {
+		t.Fatalf("expected patch guarded by the resourceVersion, found %+v", patch)
+	}
+	data, _ := json.Marshal(patch[1].Value)
+	json.Unmarshal(data, &dc.Annotations)
+	data, _ = json.Marshal(patch[2].Value)
+	json.Unmarshal(data, &dc.Spec.Template)
+	if !util.HasStashSidecar(dc.Spec.Template.Spec) || dc.Annotations[api.LastAppliedConfiguration] == "" {
+		t.Fatalf("expected sidecar and applied Restic, found %+v", dc)
+	}
+
+	// retargeting the Restic removes the sidecar again
+	restic.Spec.Target = &api.LocalTypedReference{Kind: "dc", Name: "other"}
+	if err := c.runDeploymentConfigInjector("default/app"); err != nil {
+		t.Fatal(err)
+	}
+	patch = <-patches
+	data, _ = json.Marshal(patch[2].Value)
+	dc.Spec.Template = core.PodTemplateSpec{}
+	json.Unmarshal(data, &dc.Spec.Template)
+	if util.HasStashSidecar(dc.Spec.Template.Spec) || len(dc.Spec.Template.Spec.Containers) != 1 {
+		t.Errorf("expected sidecar to be removed, found %+v", dc.Spec.Template.Spec)
+	}
+}
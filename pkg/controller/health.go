@@ -0,0 +1,72 @@
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/appscode/go/log"
+	"github.com/appscode/stash/pkg/util"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// staleBackup is a Restic reported by ServeStaleBackups.
+type staleBackup struct {
+	Namespace                string       `json:"namespace"`
+	Name                     string       `json:"name"`
+	LastSuccessfulBackupTime *metav1.Time `json:"lastSuccessfulBackupTime"`
+}
+
+// ServeStaleBackups lists Restics whose backup scheduled after the last successful one is overdue by
+// longer than the staleness threshold as JSON, for external alerting. The threshold defaults to Options.BackupStalenessThreshold and can be
+// overridden by the query parameter threshold, e.g. ?threshold=6h.
+func (c *StashController) ServeStaleBackups(w http.ResponseWriter, r *http.Request) {
+	threshold := c.options.BackupStalenessThreshold
+	if v := r.URL.Query().Get("threshold"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			http.Error(w, fmt.Sprintf("invalid threshold %q", v), http.StatusBadRequest)
+			return
+		}
+		threshold = d
+	}
+	stale, err := c.staleBackups(threshold)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(stale); err != nil {
+		log.Errorln("Failed to write stale backups. Reason:", err)
+	}
+}
+
+// staleBackups returns the Restics whose scheduled backup is overdue by more than threshold, sorted by namespace and name.
+func (c *StashController) staleBackups(threshold time.Duration) ([]staleBackup, error) {
+	restics, err := c.rstLister.List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+	now := c.clock.Now()
+	stale := make([]staleBackup, 0)
+	for _, restic := range restics {
+		if util.IsBackupStale(restic, threshold, now) {
+			stale = append(stale, staleBackup{
+				Namespace:                restic.Namespace,
+				Name:                     restic.Name,
+				LastSuccessfulBackupTime: restic.Status.LastSuccessfulBackupTime,
+			})
+		}
+	}
+	sort.Slice(stale, func(i, j int) bool {
+		if stale[i].Namespace != stale[j].Namespace {
+			return stale[i].Namespace < stale[j].Namespace
+		}
+		return stale[i].Name < stale[j].Name
+	})
+	return stale, nil
+}
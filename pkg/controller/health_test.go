@@ -0,0 +1,78 @@
+package controller
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	api "github.com/appscode/stash/apis/stash/v1alpha1"
+	stash_listers "github.com/appscode/stash/listers/stash/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/clock"
+	"k8s.io/client-go/tools/cache"
+)
+
+func TestServeStaleBackups(t *testing.T) {
+	now := time.Date(2018, 1, 2, 0, 0, 0, 0, time.UTC)
+	created := metav1.NewTime(now.Add(-48 * time.Hour))
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	for name, lastBackup := range map[string]time.Duration{"fresh": time.Hour, "daily": 7 * time.Hour, "stale": 30 * time.Hour} {
+		indexer.Add(&api.Restic{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", CreationTimestamp: created},
+			Status:     api.ResticStatus{LastSuccessfulBackupTime: &metav1.Time{Time: now.Add(-lastBackup)}},
+		})
+	}
+	indexer.Add(&api.Restic{ObjectMeta: metav1.ObjectMeta{Name: "never", Namespace: "db", CreationTimestamp: created}})
+	// the next weekly backup is not due yet
+	indexer.Add(&api.Restic{
+		ObjectMeta: metav1.ObjectMeta{Name: "weekly", Namespace: "default", CreationTimestamp: created},
+		Spec:       api.ResticSpec{Schedule: "@weekly"},
+		Status:     api.ResticStatus{LastSuccessfulBackupTime: &metav1.Time{Time: now.Add(-30 * time.Hour)}},
+	})
+	c := &StashController{
+		options:   Options{BackupStalenessThreshold: 24 * time.Hour},
+		clock:     clock.NewFakeClock(now),
+		rstLister: stash_listers.NewResticLister(indexer),
+	}
+
+	cases := map[string]struct {
+		url   string
+		stale []string
+	}{
+		"default threshold": {"/backups/stale", []string{"db/never", "default/stale"}},
+		"query threshold":   {"/backups/stale?threshold=6h", []string{"db/never", "default/daily", "default/stale"}},
+	}
+	for name, tc := range cases {
+		w := httptest.NewRecorder()
+		c.ServeStaleBackups(w, httptest.NewRequest(http.MethodGet, tc.url, nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: unexpected status %d: %s", name, w.Code, w.Body.String())
+		}
+		var stale []staleBackup
+		if err := json.Unmarshal(w.Body.Bytes(), &stale); err != nil {
+			t.Fatal(err)
+		}
+		var found []string
+		for _, s := range stale {
+			found = append(found, s.Namespace+"/"+s.Name)
+		}
+		if len(found) != len(tc.stale) {
+			t.Errorf("%s: expected stale backups %v, found %v", name, tc.stale, found)
+			continue
+		}
+		for i := range found {
+			if found[i] != tc.stale[i] {
+				t.Errorf("%s: expected stale backups %v, found %v", name, tc.stale, found)
+				break
+			}
+		}
+	}
+
+	w := httptest.NewRecorder()
+	c.ServeStaleBackups(w, httptest.NewRequest(http.MethodGet, "/backups/stale?threshold=daily", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected status %d for invalid threshold, found %d", http.StatusBadRequest, w.Code)
+	}
+}
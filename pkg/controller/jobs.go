@@ -2,8 +2,13 @@ package controller
 
 import (
 	"fmt"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/appscode/go/log"
+	api "github.com/appscode/stash/apis/stash/v1alpha1"
+	"github.com/appscode/stash/pkg/eventer"
 	"github.com/appscode/stash/pkg/util"
 	"github.com/golang/glog"
 	batch "k8s.io/api/batch/v1"
@@ -11,6 +16,7 @@ import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/labels"
 	rt "k8s.io/apimachinery/pkg/runtime"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
 	"k8s.io/apimachinery/pkg/util/runtime"
 	"k8s.io/apimachinery/pkg/watch"
 	batch_listers "k8s.io/client-go/listers/batch/v1"
@@ -26,11 +32,11 @@ func (c *StashController) initJobWatcher() {
 	lw := &cache.ListWatch{
 		ListFunc: func(options metav1.ListOptions) (rt.Object, error) {
 			options.LabelSelector = selector.String()
-			return c.k8sClient.BatchV1().Jobs(core.NamespaceAll).List(options)
+			return c.k8sClient.BatchV1().Jobs(c.options.watchNamespace()).List(options)
 		},
 		WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
 			options.LabelSelector = selector.String()
-			return c.k8sClient.BatchV1().Jobs(core.NamespaceAll).Watch(options)
+			return c.k8sClient.BatchV1().Jobs(c.options.watchNamespace()).Watch(options)
 		},
 	}
 
@@ -107,14 +113,206 @@ func (c *StashController) runJobInjector(key string) error {
 		job := obj.(*batch.Job)
 		fmt.Printf("Sync/Add/Update for Job %s\n", job.GetName())
 
-		if job.Status.Succeeded > 0 {
+		result := util.GetJobResult(job)
+		if job.Annotations[util.AnnotationOperation] == util.OperationRecovery {
+			if result != util.JobResultRunning && job.Annotations[util.AnnotationParallelJobs] != "" {
+				return c.checkParallelRecoveryJobs(job)
+			} else if result == util.JobResultSucceeded {
+				c.setRecoveryPhase(job, api.RecoverySucceeded, core.EventTypeNormal, eventer.EventReasonSuccessfulRecovery,
+					fmt.Sprintf("Recovery job %s succeeded", job.Name))
+			} else if result == util.JobResultFailed {
+				c.setRecoveryPhase(job, api.RecoveryFailed, core.EventTypeWarning, eventer.EventReasonFailedToRecover,
+					recoveryJobFailedMessage(job))
+				return nil
+			}
+		}
+
+		if job.Annotations[util.AnnotationOperation] == util.OperationInit {
+			// init jobs are kept, so that the Restic is not initialized again before its condition is observed
+			if result != util.JobResultRunning {
+				c.setRepositoryInitialized(job, result)
+			}
+			return nil
+		}
+
+		if job.Annotations[util.AnnotationOperation] == util.OperationForget && result == util.JobResultFailed {
+			return c.deleteFailedForgetJob(job)
+		}
+
+		if result == util.JobResultSucceeded {
 			fmt.Printf("Deleting succeeded job %s\n", job.GetName())
 			if err = util.DeleteStashJob(c.k8sClient, *job); err != nil {
 				fmt.Printf("Failed to delete stash job: %s, reason: %s\n", job.GetName(), err)
 				return err
 			}
 			fmt.Printf("Deleted stash job: %s\n", job.GetName())
+		} else if job.Annotations[util.AnnotationOperation] == util.OperationRecovery && result == util.JobResultRunning {
+			return c.checkRecoveryJob(key, job)
+		}
+	}
+	return nil
+}
+
+// recoveryJobTimeoutGrace is how long a recovery job that ran longer than RecoveryJobTimeout according to
+// the job cache is waited for, before it is considered timed out.
+var recoveryJobTimeoutGrace = 5 * time.Second
+
+// checkRecoveryJob re-checks a running recovery job every RecoveryJobCheckInterval. If the job runs
+// longer than RecoveryJobTimeout, the Recovery is marked as failed and the job is deleted.
+func (c *StashController) checkRecoveryJob(key string, job *batch.Job) error {
+	if c.options.RecoveryJobTimeout > 0 && job.Status.StartTime != nil &&
+		c.clock.Since(job.Status.StartTime.Time) > c.options.RecoveryJobTimeout {
+		// the cached job may be outdated, don't fail a recovery that just completed
+		result, err := util.WaitUntilJobCompleted(c.k8sClient, job.Namespace, job.Name, recoveryJobTimeoutGrace)
+		if err != nil {
+			return err
+		} else if result != util.JobResultTimeout {
+			// the update of the completed job sets the phase of the Recovery
+			return nil
+		}
+		c.setRecoveryPhase(job, api.RecoveryFailed, core.EventTypeWarning, eventer.EventReasonFailedToRecover,
+			fmt.Sprintf("Recovery job %s did not complete within %s", job.Name, c.options.RecoveryJobTimeout))
+		if job.Annotations[util.AnnotationParallelJobs] != "" {
+			// the other parallel jobs can't complete the Recovery anymore
+			jobs, err := c.parallelRecoveryJobs(job)
+			if err != nil {
+				return err
+			}
+			return c.deleteRecoveryJobs(jobs)
 		}
+		return util.DeleteStashJob(c.k8sClient, *job)
+	}
+	if c.options.RecoveryJobCheckInterval > 0 {
+		c.jobQueue.AddAfter(key, c.options.RecoveryJobCheckInterval)
 	}
 	return nil
 }
+
+// checkParallelRecoveryJobs sets the phase of the Recovery of job once all of its parallel recovery jobs
+// completed and deletes them. The Recovery succeeds if all jobs succeeded, otherwise it fails with the
+// reasons of all failed jobs. Completed jobs are kept until then, so that they can be counted.
+func (c *StashController) checkParallelRecoveryJobs(job *batch.Job) error {
+	total, err := strconv.Atoi(job.Annotations[util.AnnotationParallelJobs])
+	if err != nil {
+		return fmt.Errorf("invalid annotation %s on job %s/%s, reason: %s", util.AnnotationParallelJobs, job.Namespace, job.Name, err)
+	}
+	jobs, err := c.parallelRecoveryJobs(job)
+	if err != nil {
+		return err
+	}
+	var succeeded, failed []*batch.Job
+	for _, j := range jobs {
+		switch util.GetJobResult(j) {
+		case util.JobResultSucceeded:
+			succeeded = append(succeeded, j)
+		case util.JobResultFailed:
+			failed = append(failed, j)
+		}
+	}
+	if len(succeeded)+len(failed) < total {
+		log.Infof("%d of %d recovery jobs of Recovery %s/%s completed\n", len(succeeded)+len(failed), total, job.Namespace, job.Annotations[util.AnnotationRecovery])
+		return nil
+	}
+
+	if len(failed) == 0 {
+		c.setRecoveryPhase(job, api.RecoverySucceeded, core.EventTypeNormal, eventer.EventReasonSuccessfulRecovery,
+			fmt.Sprintf("All %d recovery jobs of Recovery %s succeeded", total, job.Annotations[util.AnnotationRecovery]))
+	} else {
+		reasons := make([]string, 0, len(failed))
+		for _, j := range failed {
+			reasons = append(reasons, recoveryJobFailedMessage(j))
+		}
+		c.setRecoveryPhase(failed[0], api.RecoveryFailed, core.EventTypeWarning, eventer.EventReasonFailedToRecover,
+			fmt.Sprintf("%d of %d recovery jobs of Recovery %s failed: %s", len(failed), total, job.Annotations[util.AnnotationRecovery], strings.Join(reasons, "; ")))
+	}
+	return c.deleteRecoveryJobs(jobs)
+}
+
+// parallelRecoveryJobs returns the recovery jobs of the Recovery of job.
+func (c *StashController) parallelRecoveryJobs(job *batch.Job) ([]*batch.Job, error) {
+	jobs, err := c.jobLister.Jobs(job.Namespace).List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+	result := make([]*batch.Job, 0, len(jobs))
+	for _, j := range jobs {
+		if util.IsRecoveryJobOf(j, job.Annotations[util.AnnotationRecovery]) {
+			result = append(result, j)
+		}
+	}
+	return result, nil
+}
+
+func (c *StashController) deleteRecoveryJobs(jobs []*batch.Job) error {
+	var errs []error
+	for _, j := range jobs {
+		if err := util.DeleteStashJob(c.k8sClient, *j); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return utilerrors.NewAggregate(errs)
+}
+
+// deleteFailedForgetJob records a warning event on the Restic of a forget job that exhausted its
+// retries and deletes the job, so that the next offline backup can create it again.
+func (c *StashController) deleteFailedForgetJob(job *batch.Job) error {
+	msg := fmt.Sprintf("Forget job %s failed after %d attempts", job.Name, job.Status.Failed)
+	if restic, err := c.rstLister.Restics(job.Namespace).Get(job.Annotations[util.AnnotationRestic]); err != nil {
+		log.Errorf("Failed to get Restic for job %s/%s. Reason: %s", job.Namespace, job.Name, err)
+	} else {
+		log.Infoln(msg)
+		c.recorder.Event(restic.ObjectReference(), core.EventTypeWarning, eventer.EventReasonFailedToRetention, msg)
+	}
+	return util.DeleteStashJob(c.k8sClient, *job)
+}
+
+// setRecoveryPhase updates the phase and conditions of the Recovery that created the job and records an event.
+// The new phase is also sent to the configured notifier.
+// Nothing is done if the Recovery is already in the given phase. The recover command exits
+// successfully after reporting its own failure or partial recovery, so a failed or partially
+// recovered Recovery is never marked as succeeded.
+func (c *StashController) setRecoveryPhase(job *batch.Job, phase api.RecoveryPhase, eventType, reason, msg string) {
+	rec, err := c.stashClient.Recoveries(job.Namespace).Get(job.Annotations[util.AnnotationRecovery], metav1.GetOptions{})
+	if err != nil {
+		log.Errorf("Failed to get Recovery for job %s/%s. Reason: %s", job.Namespace, job.Name, err)
+		return
+	}
+	if rec.Status.Phase == phase || (phase == api.RecoverySucceeded && (rec.Status.Phase == api.RecoveryFailed || rec.Status.Phase == api.RecoveryPartial)) {
+		return
+	}
+	log.Infoln(msg)
+	c.setRecoveryStatusPhase(rec, phase, eventType, reason, msg, util.RecoveryPhaseConditions(phase, reason, msg)...)
+	if phase == api.RecoveryFailed {
+		c.recordRecoveryJobLogs(rec, job)
+	}
+	c.notifyRecovery(rec, phase, msg)
+}
+
+// recordRecoveryJobLogs records the last lines of logs of the recovery job pod as warning event on rec,
+// so that they are still available after the job and its pods are deleted.
+func (c *StashController) recordRecoveryJobLogs(rec *api.Recovery, job *batch.Job) {
+	if c.options.RecoveryJobLogLines <= 0 {
+		return
+	}
+	pod, logs, err := util.GetJobPodLogs(c.k8sClient, job, c.options.RecoveryJobLogLines)
+	if err != nil {
+		log.Errorf("Failed to get logs of recovery job %s/%s. Reason: %s", job.Namespace, job.Name, err)
+		return
+	}
+	c.recorder.Eventf(rec.ObjectReference(), core.EventTypeWarning, eventer.EventReasonRecoveryJobLogs,
+		"Last %d lines of logs of pod %s:\n%s", c.options.RecoveryJobLogLines, pod, logs)
+}
+
+// jobReasonDeadlineExceeded is the reason of the failed condition the job controller sets on
+// jobs that were active longer than their activeDeadlineSeconds.
+const jobReasonDeadlineExceeded = "DeadlineExceeded"
+
+// recoveryJobFailedMessage describes why the job controller gave up on a failed recovery job.
+func recoveryJobFailedMessage(job *batch.Job) string {
+	for _, cond := range job.Status.Conditions {
+		if cond.Type == batch.JobFailed && cond.Status == core.ConditionTrue && cond.Reason == jobReasonDeadlineExceeded {
+			return fmt.Sprintf("Recovery job %s failed, reason: %s", job.Name, cond.Message)
+		}
+	}
+	return fmt.Sprintf("Recovery job %s failed after %d attempts", job.Name, job.Status.Failed)
+}
@@ -0,0 +1,1064 @@
+package controller
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	core_util "github.com/appscode/kutil/core/v1"
+	api "github.com/appscode/stash/apis/stash/v1alpha1"
+	stash_fake "github.com/appscode/stash/client/fake"
+	stash_listers "github.com/appscode/stash/listers/stash/v1alpha1"
+	"github.com/appscode/stash/pkg/cli"
+	"github.com/appscode/stash/pkg/eventer"
+	"github.com/appscode/stash/pkg/notifier"
+	"github.com/appscode/stash/pkg/util"
+	jsonpatch "github.com/evanphx/json-patch"
+	apps "k8s.io/api/apps/v1beta1"
+	authorization "k8s.io/api/authorization/v1"
+	batch "k8s.io/api/batch/v1"
+	core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/clock"
+	"k8s.io/client-go/kubernetes/fake"
+	batch_listers "k8s.io/client-go/listers/batch/v1"
+	clienttesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/workqueue"
+)
+
+func TestRecoveryJobDeadlineExceeded(t *testing.T) {
+	rec := &api.Recovery{
+		ObjectMeta: metav1.ObjectMeta{Name: "stash-demo", Namespace: "default"},
+		Status:     api.RecoveryStatus{Phase: api.RecoveryRunning},
+	}
+	deadline := int64(600)
+	job := &batch.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      util.RecoveryJobPrefix + rec.Name,
+			Namespace: rec.Namespace,
+			Annotations: map[string]string{
+				util.AnnotationRecovery:  rec.Name,
+				util.AnnotationOperation: util.OperationRecovery,
+			},
+		},
+		Spec: batch.JobSpec{ActiveDeadlineSeconds: &deadline},
+		Status: batch.JobStatus{
+			Conditions: []batch.JobCondition{{
+				Type:    batch.JobFailed,
+				Status:  core.ConditionTrue,
+				Reason:  jobReasonDeadlineExceeded,
+				Message: "Job was active longer than specified deadline",
+			}},
+		},
+	}
+
+	stashClient := stash_fake.NewSimpleClientset(rec)
+	c := &StashController{
+		k8sClient:   fake.NewSimpleClientset(job),
+		stashClient: stashClient.StashV1alpha1(),
+		recorder:    record.NewFakeRecorder(10),
+		jobIndexer:  cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{}),
+	}
+	if err := c.jobIndexer.Add(job); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.runJobInjector(rec.Namespace + "/" + job.Name); err != nil {
+		t.Fatal(err)
+	}
+
+	// the fake tracker can't apply patches, so check the patch sent for the recovery status
+	patched := false
+	for _, action := range stashClient.Actions() {
+		if a, ok := action.(clienttesting.PatchAction); ok && a.GetName() == rec.Name {
+			patched = strings.Contains(string(a.GetPatch()), `"phase":"Failed"`)
+		}
+	}
+	if !patched {
+		t.Errorf("expected recovery phase to be patched to %s, found actions %v", api.RecoveryFailed, stashClient.Actions())
+	}
+	if msg := recoveryJobFailedMessage(job); msg != "Recovery job stash-recovery-stash-demo failed, reason: Job was active longer than specified deadline" {
+		t.Errorf("unexpected failure message %q", msg)
+	}
+}
+
+func TestCheckRecoveryJob(t *testing.T) {
+	rec := &api.Recovery{
+		ObjectMeta: metav1.ObjectMeta{Name: "stash-demo", Namespace: "default"},
+		Status:     api.RecoveryStatus{Phase: api.RecoveryRunning},
+	}
+	fakeClock := clock.NewFakeClock(time.Now())
+	startTime := metav1.NewTime(fakeClock.Now())
+	job := &batch.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      util.RecoveryJobPrefix + rec.Name,
+			Namespace: rec.Namespace,
+			Annotations: map[string]string{
+				util.AnnotationRecovery:  rec.Name,
+				util.AnnotationOperation: util.OperationRecovery,
+			},
+		},
+		Status: batch.JobStatus{Active: 1, StartTime: &startTime},
+	}
+	key := rec.Namespace + "/" + job.Name
+
+	newController := func() (*StashController, *stash_fake.Clientset, *fake.Clientset) {
+		stashClient := stash_fake.NewSimpleClientset(rec)
+		k8sClient := fake.NewSimpleClientset(job)
+		c := &StashController{
+			k8sClient:   k8sClient,
+			stashClient: stashClient.StashV1alpha1(),
+			recorder:    record.NewFakeRecorder(10),
+			clock:       fakeClock,
+			options:     Options{RecoveryJobCheckInterval: 3 * time.Minute, RecoveryJobTimeout: 10 * time.Minute},
+			jobQueue:    workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter()),
+			jobIndexer:  cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{}),
+		}
+		c.jobIndexer.Add(job.DeepCopy())
+		return c, stashClient, k8sClient
+	}
+	patchedPhase := func(stashClient *stash_fake.Clientset, phase api.RecoveryPhase) bool {
+		for _, action := range stashClient.Actions() {
+			if a, ok := action.(clienttesting.PatchAction); ok && strings.Contains(string(a.GetPatch()), `"phase":"`+string(phase)+`"`) {
+				return true
+			}
+		}
+		return false
+	}
+
+	// the running job is requeued once the check interval passed and then succeeds
+	c, stashClient, _ := newController()
+	defer c.jobQueue.ShutDown()
+	c.options.RecoveryJobCheckInterval = 100 * time.Millisecond
+	if err := c.runJobInjector(key); err != nil {
+		t.Fatal(err)
+	}
+	if n := c.jobQueue.Len(); n != 0 {
+		t.Fatalf("expected job to be requeued after the check interval, found %d queued", n)
+	}
+	if queued, _ := c.jobQueue.Get(); queued != key {
+		t.Fatalf("expected %s to be requeued, found %v", key, queued)
+	}
+	c.jobQueue.Done(key)
+	succeeded := job.DeepCopy()
+	succeeded.Status.Active, succeeded.Status.Succeeded = 0, 1
+	c.jobIndexer.Update(succeeded)
+	if err := c.runJobInjector(key); err != nil {
+		t.Fatal(err)
+	}
+	if !patchedPhase(stashClient, api.RecoverySucceeded) {
+		t.Errorf("expected recovery phase to be patched to %s, found actions %v", api.RecoverySucceeded, stashClient.Actions())
+	}
+
+	defer func(grace time.Duration) { recoveryJobTimeoutGrace = grace }(recoveryJobTimeoutGrace)
+	recoveryJobTimeoutGrace = 100 * time.Millisecond
+
+	// the cached job is outdated, the job succeeded just before the timeout
+	c, stashClient, k8sClient := newController()
+	defer c.jobQueue.ShutDown()
+	if _, err := k8sClient.BatchV1().Jobs(rec.Namespace).Update(succeeded); err != nil {
+		t.Fatal(err)
+	}
+	fakeClock.Step(c.options.RecoveryJobTimeout + time.Minute)
+	if err := c.runJobInjector(key); err != nil {
+		t.Fatal(err)
+	}
+	if patchedPhase(stashClient, api.RecoveryFailed) {
+		t.Errorf("expected completed recovery job not to time out, found actions %v", stashClient.Actions())
+	}
+
+	// the job still runs after the timeout, the recovery fails and the job is deleted
+	c, stashClient, k8sClient = newController()
+	defer c.jobQueue.ShutDown()
+	if err := c.runJobInjector(key); err != nil {
+		t.Fatal(err)
+	}
+	if !patchedPhase(stashClient, api.RecoveryFailed) {
+		t.Errorf("expected recovery phase to be patched to %s, found actions %v", api.RecoveryFailed, stashClient.Actions())
+	}
+	if _, err := k8sClient.BatchV1().Jobs(rec.Namespace).Get(job.Name, metav1.GetOptions{}); err == nil {
+		t.Error("expected timed out recovery job to be deleted")
+	}
+}
+
+func TestParallelRecoveryJobs(t *testing.T) {
+	rec := &api.Recovery{
+		ObjectMeta: metav1.ObjectMeta{Name: "stash-demo", Namespace: "default"},
+		Spec:       api.RecoverySpec{ParallelVolumes: true},
+		Status:     api.RecoveryStatus{Phase: api.RecoveryRunning},
+	}
+	restic := &api.Restic{Spec: api.ResticSpec{
+		VolumeMounts: []core.VolumeMount{{Name: "a", MountPath: "/a"}, {Name: "b", MountPath: "/b"}},
+	}}
+	jobs := util.CreateRecoveryJobs(rec, restic, "canary", util.DefaultLogLevel)
+	if len(jobs) != 2 {
+		t.Fatalf("expected 2 recovery jobs, found %d", len(jobs))
+	}
+
+	stashClient := stash_fake.NewSimpleClientset(rec)
+	k8sClient := fake.NewSimpleClientset(jobs[0], jobs[1])
+	c := &StashController{
+		k8sClient:   k8sClient,
+		stashClient: stashClient.StashV1alpha1(),
+		recorder:    record.NewFakeRecorder(10),
+		jobIndexer:  cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc}),
+	}
+	c.jobLister = batch_listers.NewJobLister(c.jobIndexer)
+	patchedPhase := func() bool {
+		for _, action := range stashClient.Actions() {
+			if a, ok := action.(clienttesting.PatchAction); ok && strings.Contains(string(a.GetPatch()), `"phase":"Succeeded"`) {
+				return true
+			}
+		}
+		return false
+	}
+
+	// only one of the jobs succeeded, the recovery is still running
+	jobs[0].Status.Succeeded = 1
+	c.jobIndexer.Add(jobs[0])
+	c.jobIndexer.Add(jobs[1])
+	if err := c.runJobInjector(rec.Namespace + "/" + jobs[0].Name); err != nil {
+		t.Fatal(err)
+	}
+	if patchedPhase() {
+		t.Errorf("expected recovery to keep running until all jobs succeeded, found actions %v", stashClient.Actions())
+	}
+	if _, err := k8sClient.BatchV1().Jobs(rec.Namespace).Get(jobs[0].Name, metav1.GetOptions{}); err != nil {
+		t.Errorf("expected succeeded job to be kept, found %v", err)
+	}
+
+	jobs[1].Status.Succeeded = 1
+	c.jobIndexer.Update(jobs[1])
+	if err := c.runJobInjector(rec.Namespace + "/" + jobs[1].Name); err != nil {
+		t.Fatal(err)
+	}
+	if !patchedPhase() {
+		t.Errorf("expected recovery phase to be patched to %s, found actions %v", api.RecoverySucceeded, stashClient.Actions())
+	}
+	if list, err := k8sClient.BatchV1().Jobs(rec.Namespace).List(metav1.ListOptions{}); err != nil || len(list.Items) != 0 {
+		t.Errorf("expected all recovery jobs to be deleted, found %v, %v", list, err)
+	}
+}
+
+func TestParallelRecoveryJobFailed(t *testing.T) {
+	rec := &api.Recovery{
+		ObjectMeta: metav1.ObjectMeta{Name: "stash-demo", Namespace: "default"},
+		Spec:       api.RecoverySpec{ParallelVolumes: true},
+		Status:     api.RecoveryStatus{Phase: api.RecoveryRunning},
+	}
+	restic := &api.Restic{Spec: api.ResticSpec{
+		VolumeMounts: []core.VolumeMount{{Name: "a", MountPath: "/a"}, {Name: "b", MountPath: "/b"}},
+	}}
+	jobs := util.CreateRecoveryJobs(rec, restic, "canary", util.DefaultLogLevel)
+
+	stashClient := stash_fake.NewSimpleClientset(rec)
+	k8sClient := fake.NewSimpleClientset(jobs[0], jobs[1])
+	c := &StashController{
+		k8sClient:   k8sClient,
+		stashClient: stashClient.StashV1alpha1(),
+		recorder:    record.NewFakeRecorder(10),
+		jobIndexer:  cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc}),
+	}
+	c.jobLister = batch_listers.NewJobLister(c.jobIndexer)
+	patchedFailed := func() string {
+		for _, action := range stashClient.Actions() {
+			if a, ok := action.(clienttesting.PatchAction); ok && strings.Contains(string(a.GetPatch()), `"phase":"Failed"`) {
+				return string(a.GetPatch())
+			}
+		}
+		return ""
+	}
+
+	// the failed job is kept until the other job completed
+	jobs[0].Status.Failed = 1
+	jobs[0].Status.Conditions = []batch.JobCondition{{Type: batch.JobFailed, Status: core.ConditionTrue}}
+	c.jobIndexer.Add(jobs[0])
+	c.jobIndexer.Add(jobs[1])
+	if err := c.runJobInjector(rec.Namespace + "/" + jobs[0].Name); err != nil {
+		t.Fatal(err)
+	}
+	if patch := patchedFailed(); patch != "" {
+		t.Errorf("expected recovery to keep running until all jobs completed, found patch %s", patch)
+	}
+	if _, err := k8sClient.BatchV1().Jobs(rec.Namespace).Get(jobs[0].Name, metav1.GetOptions{}); err != nil {
+		t.Errorf("expected failed job to be kept, found %v", err)
+	}
+
+	jobs[1].Status.Succeeded = 1
+	c.jobIndexer.Update(jobs[1])
+	if err := c.runJobInjector(rec.Namespace + "/" + jobs[1].Name); err != nil {
+		t.Fatal(err)
+	}
+	if patch := patchedFailed(); !strings.Contains(patch, "1 of 2 recovery jobs") || !strings.Contains(patch, jobs[0].Name) {
+		t.Errorf("expected recovery to fail with the reason of job %s, found patch %q", jobs[0].Name, patch)
+	}
+	if list, err := k8sClient.BatchV1().Jobs(rec.Namespace).List(metav1.ListOptions{}); err != nil || len(list.Items) != 0 {
+		t.Errorf("expected all recovery jobs to be deleted, found %v, %v", list, err)
+	}
+}
+
+type fakeNotifier struct {
+	events []notifier.Event
+}
+
+func (n *fakeNotifier) Notify(e notifier.Event) error {
+	n.events = append(n.events, e)
+	return nil
+}
+
+func TestRecoveryFailureNotification(t *testing.T) {
+	rec := &api.Recovery{
+		ObjectMeta: metav1.ObjectMeta{Name: "stash-demo", Namespace: "default"},
+		Spec:       api.RecoverySpec{Restic: "missing"},
+	}
+	n := &fakeNotifier{}
+	c := &StashController{
+		k8sClient:   fake.NewSimpleClientset(),
+		stashClient: stash_fake.NewSimpleClientset(rec).StashV1alpha1(),
+		recorder:    record.NewFakeRecorder(10),
+		notifier:    n,
+	}
+	if err := c.runRecoveryJob(rec); err == nil {
+		t.Fatal("expected error for missing restic")
+	}
+	if len(n.events) != 1 {
+		t.Fatalf("expected 1 notification, found %v", n.events)
+	}
+	if e := n.events[0]; e.Name != rec.Name || e.Namespace != rec.Namespace || e.Phase != api.RecoveryFailed || !strings.Contains(e.Reason, "missing") {
+		t.Errorf("unexpected notification %+v", e)
+	}
+
+	job := &batch.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        util.RecoveryJobPrefix + rec.Name,
+			Namespace:   rec.Namespace,
+			Annotations: map[string]string{util.AnnotationRecovery: rec.Name},
+		},
+	}
+	c.setRecoveryPhase(job, api.RecoverySucceeded, core.EventTypeNormal, "", "succeeded")
+	if len(n.events) != 2 || n.events[1].Phase != api.RecoverySucceeded || n.events[1].Restic != "missing" {
+		t.Errorf("expected notification for succeeded recovery, found %v", n.events)
+	}
+}
+
+func TestRecoveryResticNamespace(t *testing.T) {
+	restic := &api.Restic{
+		ObjectMeta: metav1.ObjectMeta{Name: "db-backup", Namespace: "backup"},
+		Spec: api.ResticSpec{
+			Selector: metav1.LabelSelector{MatchLabels: map[string]string{"app": "db"}},
+			Schedule: "@every 1h",
+			Backend: api.Backend{
+				StorageSecretName: "secret",
+				Local: &api.LocalSpec{
+					VolumeSource: core.VolumeSource{HostPath: &core.HostPathVolumeSource{Path: "/data/stash"}},
+					Path:         "/repository",
+				},
+			},
+		},
+	}
+	rec := &api.Recovery{
+		ObjectMeta: metav1.ObjectMeta{Name: "db", Namespace: "app"},
+		Spec: api.RecoverySpec{
+			Restic:          restic.Name,
+			ResticNamespace: restic.Namespace,
+			Workload:        api.LocalTypedReference{Kind: api.KindDeployment, Name: "db"},
+			Volumes:         []core.Volume{{Name: "data"}},
+		},
+	}
+	deployment := &apps.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "db", Namespace: "app"}}
+	secret := &core.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "secret", Namespace: restic.Namespace},
+		Data:       map[string][]byte{cli.RESTIC_PASSWORD: []byte("changeit")},
+	}
+
+	for _, allowed := range []bool{true, false} {
+		k8sClient := fake.NewSimpleClientset(deployment, secret)
+		var reviews []*authorization.SubjectAccessReview
+		k8sClient.PrependReactor("create", "subjectaccessreviews", func(action clienttesting.Action) (bool, runtime.Object, error) {
+			review := action.(clienttesting.CreateAction).GetObject().(*authorization.SubjectAccessReview)
+			reviews = append(reviews, review)
+			review.Status.Allowed = allowed
+			return true, review, nil
+		})
+		c := &StashController{
+			k8sClient:   k8sClient,
+			stashClient: stash_fake.NewSimpleClientset(rec, restic).StashV1alpha1(),
+			recorder:    record.NewFakeRecorder(10),
+			rstLister:   stash_listers.NewResticLister(cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})),
+		}
+
+		err := c.runRecoveryJob(rec)
+		if len(reviews) == 0 || reviews[0].Spec.User != "system:serviceaccount:app:default" ||
+			reviews[0].Spec.ResourceAttributes.Namespace != restic.Namespace {
+			t.Errorf("allowed=%v: expected access review for the Restic namespace, found %v", allowed, reviews)
+		}
+		_, jobErr := k8sClient.BatchV1().Jobs(rec.Namespace).Get(util.RecoveryJobPrefix+rec.Name, metav1.GetOptions{})
+		if allowed && (err != nil || jobErr != nil) {
+			t.Errorf("expected recovery job for Restic %s/%s, found %v, %v", restic.Namespace, restic.Name, err, jobErr)
+		} else if !allowed && (err == nil || jobErr == nil) {
+			t.Errorf("expected no recovery job if the recovery job can't read the Restic, found %v, %v", err, jobErr)
+		}
+	}
+}
+
+func TestRecoveryResticNamespaceSecret(t *testing.T) {
+	restic := &api.Restic{
+		ObjectMeta: metav1.ObjectMeta{Name: "db-backup", Namespace: "backup"},
+		Spec: api.ResticSpec{
+			Selector: metav1.LabelSelector{MatchLabels: map[string]string{"app": "db"}},
+			Schedule: "@every 1h",
+			Backend: api.Backend{
+				StorageSecretName: "s3-secret",
+				S3:                &api.S3Spec{Endpoint: "s3.amazonaws.com", Bucket: "stash"},
+			},
+		},
+	}
+	rec := &api.Recovery{
+		ObjectMeta: metav1.ObjectMeta{Name: "db", Namespace: "app"},
+		Spec: api.RecoverySpec{
+			Restic:          restic.Name,
+			ResticNamespace: restic.Namespace,
+			Workload:        api.LocalTypedReference{Kind: api.KindDeployment, Name: "db"},
+			Volumes:         []core.Volume{{Name: "data"}},
+		},
+	}
+	deployment := &apps.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "db", Namespace: "app"}}
+	secret := func(namespace string) *core.Secret {
+		return &core.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "s3-secret", Namespace: namespace},
+			Data: map[string][]byte{
+				cli.RESTIC_PASSWORD:       []byte("changeit"),
+				cli.AWS_ACCESS_KEY_ID:     []byte("id"),
+				cli.AWS_SECRET_ACCESS_KEY: []byte("key"),
+			},
+		}
+	}
+
+	for _, copied := range []bool{false, true} {
+		objects := []runtime.Object{deployment, secret(restic.Namespace)}
+		if copied {
+			objects = append(objects, secret(rec.Namespace))
+		}
+		k8sClient := fake.NewSimpleClientset(objects...)
+		k8sClient.PrependReactor("create", "subjectaccessreviews", func(action clienttesting.Action) (bool, runtime.Object, error) {
+			review := action.(clienttesting.CreateAction).GetObject().(*authorization.SubjectAccessReview)
+			review.Status.Allowed = true
+			return true, review, nil
+		})
+		c := &StashController{
+			k8sClient:   k8sClient,
+			stashClient: stash_fake.NewSimpleClientset(rec, restic).StashV1alpha1(),
+			recorder:    record.NewFakeRecorder(10),
+			rstLister:   stash_listers.NewResticLister(cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})),
+		}
+
+		err := c.runRecoveryJob(rec)
+		_, jobErr := k8sClient.BatchV1().Jobs(rec.Namespace).Get(util.RecoveryJobPrefix+rec.Name, metav1.GetOptions{})
+		if copied && (err != nil || jobErr != nil) {
+			t.Errorf("expected recovery job with the secret in namespace %s, found %v, %v", rec.Namespace, err, jobErr)
+		} else if !copied && (err == nil || jobErr == nil) {
+			t.Errorf("expected no recovery job without the secret in namespace %s, found %v, %v", rec.Namespace, err, jobErr)
+		}
+	}
+}
+
+func TestRecoverySourcePathsMounted(t *testing.T) {
+	restic := &api.Restic{
+		ObjectMeta: metav1.ObjectMeta{Name: "db-backup", Namespace: "default"},
+		Spec: api.ResticSpec{
+			Selector:          metav1.LabelSelector{MatchLabels: map[string]string{"app": "db"}},
+			Schedule:          "@every 1h",
+			FileGroups:        []api.FileGroup{{Path: "/source/data", RetentionPolicyName: "keep-last-5"}},
+			RetentionPolicies: []api.RetentionPolicy{{Name: "keep-last-5", KeepLast: 5}},
+			VolumeMounts:      []core.VolumeMount{{Name: "data", MountPath: "/source/data"}},
+			Backend: api.Backend{
+				StorageSecretName: "secret",
+				Local: &api.LocalSpec{
+					VolumeSource: core.VolumeSource{HostPath: &core.HostPathVolumeSource{Path: "/data/stash"}},
+					Path:         "/repository",
+				},
+			},
+		},
+	}
+	cases := map[string]struct {
+		sourcePaths []string
+		target      *api.RecoveryTarget
+		valid       bool
+	}{
+		"file groups":         {nil, nil, true},
+		"mounted source path": {[]string{"/source/data/db"}, nil, true},
+		"unmounted path":      {[]string{"/var/lib/db"}, nil, false},
+		"target volume":       {[]string{"/var/lib/db"}, &api.RecoveryTarget{Volume: "data", MountPath: "/restore"}, true},
+	}
+	for name, tc := range cases {
+		rec := &api.Recovery{
+			ObjectMeta: metav1.ObjectMeta{Name: "db", Namespace: restic.Namespace},
+			Spec: api.RecoverySpec{
+				Restic:      restic.Name,
+				Workload:    api.LocalTypedReference{Kind: api.KindDeployment, Name: "db"},
+				Volumes:     []core.Volume{{Name: "data"}},
+				SourcePaths: tc.sourcePaths,
+				SnapshotID:  "1a2b3c4d",
+				Target:      tc.target,
+			},
+		}
+		k8sClient := fake.NewSimpleClientset(
+			&apps.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "db", Namespace: restic.Namespace}},
+			&core.Secret{
+				ObjectMeta: metav1.ObjectMeta{Name: "secret", Namespace: restic.Namespace},
+				Data:       map[string][]byte{cli.RESTIC_PASSWORD: []byte("changeit")},
+			},
+		)
+		c := &StashController{
+			k8sClient:   k8sClient,
+			stashClient: stash_fake.NewSimpleClientset(rec, restic).StashV1alpha1(),
+			recorder:    record.NewFakeRecorder(10),
+			rstLister:   stash_listers.NewResticLister(cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})),
+		}
+
+		err := c.runRecoveryJob(rec)
+		_, jobErr := k8sClient.BatchV1().Jobs(rec.Namespace).Get(util.RecoveryJobPrefix+rec.Name, metav1.GetOptions{})
+		if tc.valid && (err != nil || jobErr != nil) {
+			t.Errorf("%s: expected recovery job, found %v, %v", name, err, jobErr)
+		} else if !tc.valid && (err == nil || jobErr == nil) {
+			t.Errorf("%s: expected no recovery job, found %v, %v", name, err, jobErr)
+		}
+	}
+}
+
+func TestMaxConcurrentRecoveries(t *testing.T) {
+	restic := &api.Restic{
+		ObjectMeta: metav1.ObjectMeta{Name: "db-backup", Namespace: "default"},
+		Spec: api.ResticSpec{
+			Selector: metav1.LabelSelector{MatchLabels: map[string]string{"app": "db"}},
+			Schedule: "@every 1h",
+			Backend: api.Backend{
+				StorageSecretName: "secret",
+				Local: &api.LocalSpec{
+					VolumeSource: core.VolumeSource{HostPath: &core.HostPathVolumeSource{Path: "/data/stash"}},
+					Path:         "/repository",
+				},
+			},
+		},
+	}
+	stashObjects := []runtime.Object{restic}
+	var recs []*api.Recovery
+	for _, name := range []string{"a", "b", "c", "d", "e"} {
+		rec := &api.Recovery{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: restic.Namespace},
+			Spec: api.RecoverySpec{
+				Restic:   restic.Name,
+				Workload: api.LocalTypedReference{Kind: api.KindDeployment, Name: "db"},
+				Volumes:  []core.Volume{{Name: "data"}},
+			},
+		}
+		recs = append(recs, rec)
+		stashObjects = append(stashObjects, rec)
+	}
+	k8sClient := fake.NewSimpleClientset(
+		&apps.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "db", Namespace: restic.Namespace}},
+		&core.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "secret", Namespace: restic.Namespace},
+			Data:       map[string][]byte{cli.RESTIC_PASSWORD: []byte("changeit")},
+		},
+	)
+	recorder := record.NewFakeRecorder(10)
+	c := &StashController{
+		k8sClient:   k8sClient,
+		stashClient: stash_fake.NewSimpleClientset(stashObjects...).StashV1alpha1(),
+		recorder:    recorder,
+		options:     Options{MaxConcurrentRecoveries: 2},
+		recQueue:    workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter()),
+		rstLister:   stash_listers.NewResticLister(cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})),
+	}
+	defer c.recQueue.ShutDown()
+	runningJobs := func() []batch.Job {
+		list, err := k8sClient.BatchV1().Jobs(restic.Namespace).List(metav1.ListOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return list.Items
+	}
+
+	// workers start recoveries concurrently, only two of them get to create their job
+	var wg sync.WaitGroup
+	for _, rec := range recs[:4] {
+		wg.Add(1)
+		go func(rec *api.Recovery) {
+			defer wg.Done()
+			if err := c.runRecoveryJob(rec); err != nil {
+				t.Error(err)
+			}
+		}(rec)
+	}
+	wg.Wait()
+	if jobs := runningJobs(); len(jobs) != 2 {
+		t.Fatalf("expected 2 recovery jobs, found %d", len(jobs))
+	}
+	throttled := 0
+	for len(recorder.Events) > 0 {
+		if strings.Contains(<-recorder.Events, eventer.EventReasonRecoveryThrottled) {
+			throttled++
+		}
+	}
+	if throttled != 2 {
+		t.Errorf("expected 2 throttled recoveries, found %d", throttled)
+	}
+
+	// once a recovery job completed, the next recovery runs
+	job := runningJobs()[0]
+	job.Status.Succeeded = 1
+	if _, err := k8sClient.BatchV1().Jobs(job.Namespace).Update(&job); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.runRecoveryJob(recs[4]); err != nil {
+		t.Fatal(err)
+	}
+	if jobs := runningJobs(); len(jobs) != 3 {
+		t.Errorf("expected 3 recovery jobs, found %d", len(jobs))
+	}
+}
+
+// fakeRecoveryStore makes client serve rec and apply updates and merge patches to it, since the fake
+// tracker can't apply patches. It returns a func to get the current Recovery.
+func fakeRecoveryStore(client *stash_fake.Clientset, rec *api.Recovery) func() *api.Recovery {
+	cur := rec.DeepCopy()
+	client.PrependReactor("get", "recoveries", func(action clienttesting.Action) (bool, runtime.Object, error) {
+		return true, cur.DeepCopy(), nil
+	})
+	client.PrependReactor("update", "recoveries", func(action clienttesting.Action) (bool, runtime.Object, error) {
+		cur = action.(clienttesting.UpdateAction).GetObject().(*api.Recovery).DeepCopy()
+		return true, cur.DeepCopy(), nil
+	})
+	client.PrependReactor("patch", "recoveries", func(action clienttesting.Action) (bool, runtime.Object, error) {
+		data, err := json.Marshal(cur)
+		if err != nil {
+			return true, nil, err
+		}
+		if data, err = jsonpatch.MergePatch(data, action.(clienttesting.PatchAction).GetPatch()); err != nil {
+			return true, nil, err
+		}
+		patched := &api.Recovery{}
+		if err = json.Unmarshal(data, patched); err != nil {
+			return true, nil, err
+		}
+		cur = patched
+		return true, cur.DeepCopy(), nil
+	})
+	return func() *api.Recovery { return cur.DeepCopy() }
+}
+
+func TestRecoveryPhaseEvents(t *testing.T) {
+	restic := &api.Restic{
+		ObjectMeta: metav1.ObjectMeta{Name: "db-backup", Namespace: "default"},
+		Spec: api.ResticSpec{
+			Selector: metav1.LabelSelector{MatchLabels: map[string]string{"app": "db"}},
+			Schedule: "@every 1h",
+			Backend: api.Backend{
+				StorageSecretName: "secret",
+				Local: &api.LocalSpec{
+					VolumeSource: core.VolumeSource{HostPath: &core.HostPathVolumeSource{Path: "/data/stash"}},
+					Path:         "/repository",
+				},
+			},
+		},
+	}
+	rec := &api.Recovery{
+		ObjectMeta: metav1.ObjectMeta{Name: "db", Namespace: restic.Namespace},
+		Spec: api.RecoverySpec{
+			Restic:   restic.Name,
+			Workload: api.LocalTypedReference{Kind: api.KindDeployment, Name: "db"},
+			Volumes:  []core.Volume{{Name: "data"}},
+		},
+	}
+	stashClient := stash_fake.NewSimpleClientset(restic)
+	current := fakeRecoveryStore(stashClient, rec)
+	k8sClient := fake.NewSimpleClientset(
+		&apps.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "db", Namespace: restic.Namespace}},
+		&core.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "secret", Namespace: restic.Namespace},
+			Data:       map[string][]byte{cli.RESTIC_PASSWORD: []byte("changeit")},
+		},
+	)
+	recorder := record.NewFakeRecorder(20)
+	c := &StashController{
+		k8sClient:   k8sClient,
+		stashClient: stashClient.StashV1alpha1(),
+		recorder:    recorder,
+		rstLister:   stash_listers.NewResticLister(cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})),
+		jobIndexer:  cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{}),
+	}
+	reasons := func() []string {
+		var found []string
+		for len(recorder.Events) > 0 {
+			// events are formatted as "<type> <reason> <message>"
+			found = append(found, strings.Fields(<-recorder.Events)[1])
+		}
+		return found
+	}
+	expectEvents := func(step string, expected ...string) {
+		if found := reasons(); !reflect.DeepEqual(found, expected) {
+			t.Errorf("%s: expected events %v, found %v", step, expected, found)
+		}
+	}
+
+	if err := c.runRecoveryJob(current()); err != nil {
+		t.Fatal(err)
+	}
+	expectEvents("job created", eventer.EventReasonJobCreated, eventer.EventReasonRecoveryRunning)
+	if err := c.runRecoveryJob(current()); err != nil {
+		t.Fatal(err)
+	}
+	expectEvents("requeued running")
+
+	job, err := k8sClient.BatchV1().Jobs(rec.Namespace).Get(util.RecoveryJobPrefix+rec.Name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	job.Status.Succeeded = 1
+	c.jobIndexer.Add(job)
+	for i := 0; i < 2; i++ {
+		if err := c.runJobInjector(rec.Namespace + "/" + job.Name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expectEvents("job succeeded", eventer.EventReasonSuccessfulRecovery)
+	if phase := current().Status.Phase; phase != api.RecoverySucceeded {
+		t.Errorf("expected phase %s, found %s", api.RecoverySucceeded, phase)
+	}
+
+	// a failing Recovery is reported once, however often it is processed again
+	failing := rec.DeepCopy()
+	failing.Spec.Restic = "missing"
+	current = fakeRecoveryStore(stashClient, failing)
+	for i := 0; i < 3; i++ {
+		if err := c.runRecoveryJob(current()); err == nil {
+			t.Fatal("expected error for missing Restic")
+		}
+	}
+	expectEvents("requeued failure", eventer.EventReasonFailedToRecover)
+}
+
+func TestRecoveryRunningWithExistingJobs(t *testing.T) {
+	restic := &api.Restic{
+		ObjectMeta: metav1.ObjectMeta{Name: "db-backup", Namespace: "default"},
+		Spec: api.ResticSpec{
+			Selector: metav1.LabelSelector{MatchLabels: map[string]string{"app": "db"}},
+			Schedule: "@every 1h",
+			Backend: api.Backend{
+				StorageSecretName: "secret",
+				Local: &api.LocalSpec{
+					VolumeSource: core.VolumeSource{HostPath: &core.HostPathVolumeSource{Path: "/data/stash"}},
+					Path:         "/repository",
+				},
+			},
+		},
+	}
+	rec := &api.Recovery{
+		ObjectMeta: metav1.ObjectMeta{Name: "db", Namespace: restic.Namespace},
+		Spec: api.RecoverySpec{
+			Restic:   restic.Name,
+			Workload: api.LocalTypedReference{Kind: api.KindDeployment, Name: "db"},
+			Volumes:  []core.Volume{{Name: "data"}},
+		},
+	}
+	stashClient := stash_fake.NewSimpleClientset(restic)
+	current := fakeRecoveryStore(stashClient, rec)
+	// the job was created before, but the Recovery was never marked Running
+	k8sClient := fake.NewSimpleClientset(
+		&apps.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "db", Namespace: restic.Namespace}},
+		&core.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "secret", Namespace: restic.Namespace},
+			Data:       map[string][]byte{cli.RESTIC_PASSWORD: []byte("changeit")},
+		},
+		&batch.Job{ObjectMeta: metav1.ObjectMeta{Name: util.RecoveryJobPrefix + rec.Name, Namespace: rec.Namespace}},
+	)
+	recorder := record.NewFakeRecorder(10)
+	c := &StashController{
+		k8sClient:   k8sClient,
+		stashClient: stashClient.StashV1alpha1(),
+		recorder:    recorder,
+		rstLister:   stash_listers.NewResticLister(cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})),
+	}
+
+	if err := c.runRecoveryJob(current()); err != nil {
+		t.Fatal(err)
+	}
+	cur := current()
+	if cur.Status.Phase != api.RecoveryRunning {
+		t.Errorf("expected phase %s, found %s", api.RecoveryRunning, cur.Status.Phase)
+	}
+	if len(cur.Status.Conditions) != 1 || cur.Status.Conditions[0].Type != api.RecoveryConditionJobCreated {
+		t.Errorf("expected %s condition, found %v", api.RecoveryConditionJobCreated, cur.Status.Conditions)
+	}
+	if len(recorder.Events) != 1 || !strings.Contains(<-recorder.Events, eventer.EventReasonRecoveryRunning) {
+		t.Errorf("expected a single %s event", eventer.EventReasonRecoveryRunning)
+	}
+}
+
+func TestSetupNotifierSlackSecret(t *testing.T) {
+	secret := &core.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "slack", Namespace: "kube-system"},
+		Data:       map[string][]byte{notifier.SlackWebhookURLKey: []byte("https://hooks.slack.com/services/T000/B000/XXXX")},
+	}
+	c := &StashController{
+		k8sClient: fake.NewSimpleClientset(secret),
+		options:   Options{SlackWebhookSecretName: "slack", SlackWebhookSecretNamespace: "kube-system"},
+	}
+	if err := c.setupNotifier(); err != nil {
+		t.Fatal(err)
+	}
+	notifiers, ok := c.notifier.(notifier.Multi)
+	if !ok || len(notifiers) != 1 {
+		t.Fatalf("expected a single notifier, found %v", c.notifier)
+	}
+	if s, ok := notifiers[0].(*notifier.Slack); !ok || s.WebhookURL != "https://hooks.slack.com/services/T000/B000/XXXX" {
+		t.Errorf("expected slack notifier with URL from secret, found %v", notifiers[0])
+	}
+
+	c = &StashController{
+		k8sClient: fake.NewSimpleClientset(),
+		options:   Options{SlackWebhookSecretName: "slack", SlackWebhookSecretNamespace: "kube-system"},
+	}
+	if err := c.setupNotifier(); err == nil {
+		t.Error("expected error for missing slack secret")
+	}
+}
+
+func TestRecoveryFailureCondition(t *testing.T) {
+	rec := &api.Recovery{
+		ObjectMeta: metav1.ObjectMeta{Name: "stash-demo", Namespace: "default"},
+		Spec:       api.RecoverySpec{Restic: "missing"},
+		Status:     api.RecoveryStatus{Stats: []api.RestoreStats{{Path: "/data"}}},
+	}
+	client := stash_fake.NewSimpleClientset(rec)
+	c := &StashController{
+		k8sClient:   fake.NewSimpleClientset(),
+		stashClient: client.StashV1alpha1(),
+		recorder:    record.NewFakeRecorder(10),
+	}
+	if err := c.runRecoveryJob(rec); err == nil {
+		t.Fatal("expected error for missing restic")
+	}
+
+	var patch *api.Recovery
+	for _, action := range client.Actions() {
+		if p, ok := action.(clienttesting.PatchAction); ok {
+			patch = &api.Recovery{}
+			if err := json.Unmarshal(p.GetPatch(), patch); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if patch == nil {
+		t.Fatal("expected Recovery status to be patched")
+	}
+	if patch.Status.Phase != api.RecoveryFailed || patch.Status.Stats != nil {
+		t.Errorf("expected only phase and conditions to be patched, found %+v", patch.Status)
+	}
+	if len(patch.Status.Conditions) != 1 {
+		t.Fatalf("expected a single condition, found %+v", patch.Status.Conditions)
+	}
+	if cond := patch.Status.Conditions[0]; cond.Type != api.RecoveryConditionFailed || cond.Status != core.ConditionTrue ||
+		!strings.Contains(cond.Message, "missing") || cond.LastTransitionTime.IsZero() {
+		t.Errorf("unexpected condition %+v", cond)
+	}
+}
+
+func TestRecoveryFinalizer(t *testing.T) {
+	rec := &api.Recovery{
+		ObjectMeta: metav1.ObjectMeta{Name: "stash-demo", Namespace: "default"},
+		Spec:       api.RecoverySpec{Restic: "stash-demo"},
+	}
+	job := &batch.Job{
+		ObjectMeta: metav1.ObjectMeta{Name: util.RecoveryJobPrefix + rec.Name, Namespace: rec.Namespace},
+	}
+	stashClient := stash_fake.NewSimpleClientset(rec)
+	k8sClient := fake.NewSimpleClientset(job)
+	c := &StashController{
+		k8sClient:   k8sClient,
+		stashClient: stashClient.StashV1alpha1(),
+		recorder:    record.NewFakeRecorder(10),
+		recIndexer:  cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{}),
+	}
+
+	updated, err := c.ensureRecoveryFinalizer(rec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !core_util.HasFinalizer(updated.ObjectMeta, util.RecoveryFinalizer) {
+		t.Fatalf("expected finalizer %s, found %v", util.RecoveryFinalizer, updated.Finalizers)
+	}
+
+	// the informer sees the Recovery marked for deletion
+	deleting := updated.DeepCopy()
+	now := metav1.Now()
+	deleting.DeletionTimestamp = &now
+	c.recIndexer.Add(deleting)
+	if err = c.runRecoveryInjector(rec.Namespace + "/" + rec.Name); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = k8sClient.BatchV1().Jobs(job.Namespace).Get(job.Name, metav1.GetOptions{}); err == nil {
+		t.Error("expected recovery job to be deleted")
+	}
+	cur, err := stashClient.StashV1alpha1().Recoveries(rec.Namespace).Get(rec.Name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if core_util.HasFinalizer(cur.ObjectMeta, util.RecoveryFinalizer) {
+		t.Errorf("expected finalizer to be removed, found %v", cur.Finalizers)
+	}
+}
+
+func TestDeleteExpiredRecoveries(t *testing.T) {
+	fakeClock := clock.NewFakeClock(time.Now())
+	ttl := int32(60)
+	finishedRecovery := func(name string, phase api.RecoveryPhase, finished time.Time, ttl *int32) *api.Recovery {
+		condType := api.RecoveryConditionComplete
+		if phase == api.RecoveryFailed {
+			condType = api.RecoveryConditionFailed
+		}
+		return &api.Recovery{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+			Spec:       api.RecoverySpec{TTLSecondsAfterFinished: ttl},
+			Status: api.RecoveryStatus{
+				Phase:      phase,
+				Conditions: []api.RecoveryCondition{{Type: condType, Status: core.ConditionTrue, LastTransitionTime: metav1.NewTime(finished)}},
+			},
+		}
+	}
+	expired := finishedRecovery("expired", api.RecoverySucceeded, fakeClock.Now().Add(-2*time.Minute), &ttl)
+	recent := finishedRecovery("recent", api.RecoveryFailed, fakeClock.Now().Add(-30*time.Second), &ttl)
+	noTTL := finishedRecovery("no-ttl", api.RecoverySucceeded, fakeClock.Now().Add(-time.Hour), nil)
+	running := &api.Recovery{
+		ObjectMeta: metav1.ObjectMeta{Name: "running", Namespace: "default"},
+		Spec:       api.RecoverySpec{TTLSecondsAfterFinished: new(int32)},
+		Status:     api.RecoveryStatus{Phase: api.RecoveryRunning},
+	}
+	job := &batch.Job{ObjectMeta: metav1.ObjectMeta{Name: util.RecoveryJobPrefix + expired.Name, Namespace: expired.Namespace}}
+
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	for _, rec := range []*api.Recovery{expired, recent, noTTL, running} {
+		indexer.Add(rec)
+	}
+	k8sClient := fake.NewSimpleClientset(job)
+	stashClient := stash_fake.NewSimpleClientset(expired, recent, noTTL, running)
+	c := &StashController{
+		k8sClient:   k8sClient,
+		stashClient: stashClient.StashV1alpha1(),
+		recLister:   stash_listers.NewRecoveryLister(indexer),
+		clock:       fakeClock,
+	}
+
+	deleted := func() []string {
+		var names []string
+		for _, action := range stashClient.Actions() {
+			if action.GetVerb() == "delete" && action.GetResource().Resource == "recoveries" {
+				names = append(names, action.(clienttesting.DeleteAction).GetName())
+			}
+		}
+		return names
+	}
+	c.deleteExpiredRecoveries()
+	if names := deleted(); !reflect.DeepEqual(names, []string{expired.Name}) {
+		t.Errorf("expected only Recovery %s to be deleted, found %v", expired.Name, names)
+	}
+	if _, err := k8sClient.BatchV1().Jobs(job.Namespace).Get(job.Name, metav1.GetOptions{}); err == nil {
+		t.Errorf("expected recovery job %s of expired Recovery to be deleted", job.Name)
+	}
+
+	// the failed Recovery expires once its ttl passed
+	fakeClock.Step(time.Minute)
+	stashClient.ClearActions()
+	indexer.Delete(expired)
+	c.deleteExpiredRecoveries()
+	if names := deleted(); !reflect.DeepEqual(names, []string{recent.Name}) {
+		t.Errorf("expected Recovery %s to be deleted after its ttl, found %v", recent.Name, names)
+	}
+}
+
+func TestProcessNextRecovery(t *testing.T) {
+	rec := &api.Recovery{
+		ObjectMeta: metav1.ObjectMeta{Name: "stash-demo", Namespace: "default"},
+		Status:     api.RecoveryStatus{Phase: api.RecoverySucceeded},
+	}
+	c := &StashController{
+		recQueue:   workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter()),
+		recIndexer: cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc}),
+	}
+	defer c.recQueue.ShutDown()
+	c.recIndexer.Add(rec)
+
+	// a finished Recovery and a deleted one are processed without error
+	for _, key := range []string{"default/stash-demo", "default/deleted"} {
+		c.recQueue.Add(key)
+		if !c.processNextRecovery() {
+			t.Fatalf("expected key %s to be processed", key)
+		}
+		if n := c.recQueue.Len(); n != 0 {
+			t.Errorf("expected key %s not to be requeued, found %d keys", key, n)
+		}
+		if n := c.recQueue.NumRequeues(key); n != 0 {
+			t.Errorf("expected key %s to be processed without error, found %d requeues", key, n)
+		}
+	}
+}
+
+func TestRecoveryImage(t *testing.T) {
+	restic := &api.Restic{
+		ObjectMeta: metav1.ObjectMeta{Name: "db-backup", Namespace: "default"},
+		Spec: api.ResticSpec{
+			Selector: metav1.LabelSelector{MatchLabels: map[string]string{"app": "db"}},
+			Schedule: "@every 1h",
+			Backend: api.Backend{
+				StorageSecretName: "secret",
+				Local: &api.LocalSpec{
+					VolumeSource: core.VolumeSource{HostPath: &core.HostPathVolumeSource{Path: "/data/stash"}},
+					Path:         "/repository",
+				},
+			},
+		},
+	}
+	deployment := &apps.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "db", Namespace: "default"}}
+	secret := &core.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "secret", Namespace: restic.Namespace},
+		Data:       map[string][]byte{cli.RESTIC_PASSWORD: []byte("changeit")},
+	}
+
+	for image, expected := range map[string]string{
+		"": "appscode/stash:0.5.1",
+		"registry.example.com/stash-recovery:1.0": "registry.example.com/stash-recovery:1.0",
+	} {
+		rec := &api.Recovery{
+			ObjectMeta: metav1.ObjectMeta{Name: "db", Namespace: "default"},
+			Spec: api.RecoverySpec{
+				Restic:   restic.Name,
+				Workload: api.LocalTypedReference{Kind: api.KindDeployment, Name: "db"},
+				Volumes:  []core.Volume{{Name: "data"}},
+			},
+		}
+		k8sClient := fake.NewSimpleClientset(deployment, secret)
+		c := &StashController{
+			k8sClient:   k8sClient,
+			stashClient: stash_fake.NewSimpleClientset(rec, restic).StashV1alpha1(),
+			recorder:    record.NewFakeRecorder(10),
+			rstLister:   stash_listers.NewResticLister(cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})),
+			options:     Options{SidecarImageTag: "0.5.1", RecoveryImage: image},
+		}
+		if err := c.runRecoveryJob(rec); err != nil {
+			t.Fatal(err)
+		}
+		job, err := k8sClient.BatchV1().Jobs(rec.Namespace).Get(util.RecoveryJobPrefix+rec.Name, metav1.GetOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := job.Spec.Template.Spec.Containers[0].Image; got != expected {
+			t.Errorf("recovery image %q: expected job image %s, found %s", image, expected, got)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package controller
+
+import (
+	"time"
+
+	api "github.com/appscode/stash/apis/stash/v1alpha1"
+	"github.com/appscode/stash/pkg/util"
+	"github.com/prometheus/client_golang/prometheus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	sidecarOpAdd    = "add"
+	sidecarOpRemove = "remove"
+)
+
+var (
+	sidecarInjectionTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "stash",
+		Subsystem: "sidecar",
+		Name:      "injection_total",
+		Help:      "Number of attempts to add or remove the stash sidecar of a workload",
+	}, []string{"kind", "namespace", "op"})
+	sidecarInjectionFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "stash",
+		Subsystem: "sidecar",
+		Name:      "injection_failures_total",
+		Help:      "Number of attempts to add or remove the stash sidecar of a workload that failed or timed out",
+	}, []string{"kind", "namespace", "op"})
+	sidecarWaitDurationSeconds = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "stash",
+		Subsystem: "sidecar",
+		Name:      "wait_duration_seconds",
+		Help:      "Seconds taken until the pods of a workload run with the stash sidecar added or removed",
+		Buckets:   prometheus.ExponentialBuckets(1, 2, 11),
+	}, []string{"kind", "namespace", "op"})
+)
+
+func init() {
+	prometheus.MustRegister(sidecarInjectionTotal, sidecarInjectionFailures, sidecarWaitDurationSeconds)
+}
+
+// waitUntilSidecarAdded waits until the pods of a workload of the given kind run the stash sidecar
+// and records the attempt in the sidecar metrics.
+func (c *StashController) waitUntilSidecarAdded(kind, namespace string, selector *metav1.LabelSelector, backupType api.BackupType) error {
+	return observeSidecarWait(kind, namespace, sidecarOpAdd, func() error {
+		return util.WaitUntilSidecarAdded(c.k8sClient, namespace, selector, backupType, c.options.RestartStrategy, c.options.SidecarWaitBackoff)
+	})
+}
+
+// waitUntilSidecarRemoved waits until no pod of a workload of the given kind runs the stash sidecar
+// and records the attempt in the sidecar metrics.
+func (c *StashController) waitUntilSidecarRemoved(kind, namespace string, selector *metav1.LabelSelector, backupType api.BackupType) error {
+	return observeSidecarWait(kind, namespace, sidecarOpRemove, func() error {
+		return util.WaitUntilSidecarRemoved(c.k8sClient, namespace, selector, backupType, c.options.RestartStrategy, c.options.SidecarWaitBackoff)
+	})
+}
+
+func observeSidecarWait(kind, namespace, op string, wait func() error) error {
+	startTime := time.Now()
+	err := wait()
+	sidecarWaitDurationSeconds.WithLabelValues(kind, namespace, op).Observe(time.Since(startTime).Seconds())
+	sidecarInjectionTotal.WithLabelValues(kind, namespace, op).Inc()
+	if err != nil {
+		sidecarInjectionFailures.WithLabelValues(kind, namespace, op).Inc()
+	}
+	return err
+}
@@ -0,0 +1,67 @@
+package controller
+
+import (
+	"testing"
+	"time"
+
+	api "github.com/appscode/stash/apis/stash/v1alpha1"
+	"github.com/appscode/stash/pkg/util"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	clienttesting "k8s.io/client-go/testing"
+)
+
+func counterValue(t *testing.T, vec *prometheus.CounterVec, lvs ...string) float64 {
+	var m dto.Metric
+	if err := vec.WithLabelValues(lvs...).Write(&m); err != nil {
+		t.Fatal(err)
+	}
+	return m.GetCounter().GetValue()
+}
+
+func TestSidecarInjectionMetrics(t *testing.T) {
+	pod := &core.Pod{ObjectMeta: metav1.ObjectMeta{Name: "db-0", Namespace: "metrics", Labels: map[string]string{"app": "db"}}}
+	client := fake.NewSimpleClientset(pod)
+	// pods are never recreated with the sidecar, pretend deletes succeed without removing the pod
+	client.PrependReactor("delete", "pods", func(action clienttesting.Action) (bool, runtime.Object, error) {
+		return true, nil, nil
+	})
+	c := &StashController{
+		k8sClient: client,
+		options: Options{
+			RestartStrategy: util.RestartStrategyDelete,
+			SidecarWaitBackoff: util.SidecarWaitBackoff{
+				InitialInterval: 10 * time.Millisecond,
+				MaxInterval:     20 * time.Millisecond,
+				MaxElapsedTime:  100 * time.Millisecond,
+			},
+		},
+	}
+	selector := &metav1.LabelSelector{MatchLabels: pod.Labels}
+
+	// the sidecar is never added, so the wait times out
+	if err := c.waitUntilSidecarAdded(api.KindStatefulSet, pod.Namespace, selector, api.BackupOnline); err == nil {
+		t.Fatal("expected timeout error")
+	}
+	if v := counterValue(t, sidecarInjectionTotal, api.KindStatefulSet, pod.Namespace, sidecarOpAdd); v != 1 {
+		t.Errorf("expected 1 injection attempt, found %v", v)
+	}
+	if v := counterValue(t, sidecarInjectionFailures, api.KindStatefulSet, pod.Namespace, sidecarOpAdd); v != 1 {
+		t.Errorf("expected 1 injection failure, found %v", v)
+	}
+
+	// the pod runs without sidecar, so removal succeeds right away
+	if err := c.waitUntilSidecarRemoved(api.KindStatefulSet, pod.Namespace, selector, api.BackupOnline); err != nil {
+		t.Fatal(err)
+	}
+	if v := counterValue(t, sidecarInjectionTotal, api.KindStatefulSet, pod.Namespace, sidecarOpRemove); v != 1 {
+		t.Errorf("expected 1 removal attempt, found %v", v)
+	}
+	if v := counterValue(t, sidecarInjectionFailures, api.KindStatefulSet, pod.Namespace, sidecarOpRemove); v != 0 {
+		t.Errorf("expected no removal failure, found %v", v)
+	}
+}
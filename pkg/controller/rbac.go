@@ -1,16 +1,20 @@
 package controller
 
 import (
+	"fmt"
+
 	"github.com/appscode/go/log"
 	"github.com/appscode/go/types"
 	core_util "github.com/appscode/kutil/core/v1"
 	rbac_util "github.com/appscode/kutil/rbac/v1beta1"
 	api "github.com/appscode/stash/apis/stash/v1alpha1"
 	apps "k8s.io/api/apps/v1beta1"
+	authorization "k8s.io/api/authorization/v1"
 	batch "k8s.io/api/batch/v1"
 	core "k8s.io/api/core/v1"
 	extensions "k8s.io/api/extensions/v1beta1"
 	rbac "k8s.io/api/rbac/v1beta1"
+	kerr "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
@@ -205,8 +209,9 @@ func (c *StashController) ensureKubectlRBAC(resourceName string, namespace strin
 	return err
 }
 
-// use sidecar-cluster-role
-func (c *StashController) ensureRecoveryRBAC(resourceName string, namespace string) error {
+// use sidecar-cluster-role. If the Restic is in another namespace, the service account is bound
+// to the role in resticNamespace too.
+func (c *StashController) ensureRecoveryRBAC(resourceName, namespace, resticNamespace string) error {
 	// ensure service account
 	meta := metav1.ObjectMeta{
 		Name:      resourceName,
@@ -224,7 +229,18 @@ func (c *StashController) ensureRecoveryRBAC(resourceName string, namespace stri
 	}
 
 	// ensure role binding
-	_, err = rbac_util.CreateOrPatchRoleBinding(c.k8sClient, meta, func(in *rbac.RoleBinding) *rbac.RoleBinding {
+	if err = c.ensureRecoveryRoleBinding(meta, meta); err != nil {
+		return err
+	}
+	if resticNamespace != namespace {
+		err = c.ensureRecoveryRoleBinding(recoveryRoleBindingMeta(resourceName, namespace, resticNamespace), meta)
+	}
+	return err
+}
+
+// ensureRecoveryRoleBinding binds the service account sa to the sidecar cluster role.
+func (c *StashController) ensureRecoveryRoleBinding(meta, sa metav1.ObjectMeta) error {
+	_, err := rbac_util.CreateOrPatchRoleBinding(c.k8sClient, meta, func(in *rbac.RoleBinding) *rbac.RoleBinding {
 		if in.Labels == nil {
 			in.Labels = map[string]string{}
 		}
@@ -238,11 +254,56 @@ func (c *StashController) ensureRecoveryRBAC(resourceName string, namespace stri
 		in.Subjects = []rbac.Subject{
 			{
 				Kind:      "ServiceAccount",
-				Name:      meta.Name,
-				Namespace: meta.Namespace,
+				Name:      sa.Name,
+				Namespace: sa.Namespace,
 			},
 		}
 		return in
 	})
 	return err
 }
+
+// recoveryRoleBindingMeta returns the meta of the role binding in resticNamespace that grants the
+// recovery service account access to a Restic in another namespace.
+func recoveryRoleBindingMeta(resourceName, namespace, resticNamespace string) metav1.ObjectMeta {
+	return metav1.ObjectMeta{
+		Name:      resourceName + "-" + namespace,
+		Namespace: resticNamespace,
+	}
+}
+
+// ensureRecoveryRoleBindingDeleted deletes the role binding created by ensureRecoveryRBAC in the
+// namespace of a Restic in another namespace. It is not an error if it does not exist.
+func (c *StashController) ensureRecoveryRoleBindingDeleted(resourceName, namespace, resticNamespace string) error {
+	meta := recoveryRoleBindingMeta(resourceName, namespace, resticNamespace)
+	err := c.k8sClient.RbacV1beta1().RoleBindings(meta.Namespace).Delete(meta.Name, nil)
+	if err != nil && !kerr.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+// checkRecoveryAccess checks that the recovery job service account sa in namespace can read the
+// Restic and its secrets in resticNamespace.
+func (c *StashController) checkRecoveryAccess(sa, namespace, resticNamespace string) error {
+	user := fmt.Sprintf("system:serviceaccount:%s:%s", namespace, sa)
+	for _, attr := range []authorization.ResourceAttributes{
+		{Namespace: resticNamespace, Verb: "get", Group: api.SchemeGroupVersion.Group, Resource: api.ResourceTypeRestic},
+		{Namespace: resticNamespace, Verb: "get", Resource: "secrets"},
+	} {
+		attr := attr
+		review, err := c.k8sClient.AuthorizationV1().SubjectAccessReviews().Create(&authorization.SubjectAccessReview{
+			Spec: authorization.SubjectAccessReviewSpec{
+				User:               user,
+				ResourceAttributes: &attr,
+			},
+		})
+		if err != nil {
+			return err
+		}
+		if !review.Status.Allowed {
+			return fmt.Errorf("service account %s/%s can't get %s in namespace %s", namespace, sa, attr.Resource, resticNamespace)
+		}
+	}
+	return nil
+}
@@ -26,11 +26,11 @@ func (c *StashController) initRCWatcher() {
 	lw := &cache.ListWatch{
 		ListFunc: func(options metav1.ListOptions) (rt.Object, error) {
 			options.IncludeUninitialized = true
-			return c.k8sClient.CoreV1().ReplicationControllers(core.NamespaceAll).List(options)
+			return c.k8sClient.CoreV1().ReplicationControllers(c.options.watchNamespace()).List(options)
 		},
 		WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
 			options.IncludeUninitialized = true
-			return c.k8sClient.CoreV1().ReplicationControllers(core.NamespaceAll).Watch(options)
+			return c.k8sClient.CoreV1().ReplicationControllers(c.options.watchNamespace()).Watch(options)
 		},
 	}
 
@@ -138,15 +138,20 @@ func (c *StashController) runRCInjector(key string) error {
 			return nil
 		}
 
-		oldRestic, err := util.GetAppliedRestic(rc.Annotations)
+		ref, _ := reference.GetReference(scheme.Scheme, rc)
+		oldRestic, err := util.GetAppliedResticWithEvent(rc.Annotations, c.recorder, ref)
 		if err != nil {
 			return err
 		}
-		newRestic, err := util.FindRestic(c.rstLister, rc.ObjectMeta)
+		newRestic, err := util.FindRestic(c.rstLister, api.KindReplicationController, rc.ObjectMeta)
 		if err != nil {
 			log.Errorf("Error while searching Restic for ReplicationController %s/%s.", rc.Name, rc.Namespace)
 			return err
 		}
+		if newRestic != nil && util.SkipInjection(rc.Annotations, rc.Spec.Template.Annotations) {
+			log.Infof("Skipping stash sidecar for ReplicationController %s/%s", rc.Namespace, rc.Name)
+			newRestic = nil
+		}
 		if util.ResticEqual(oldRestic, newRestic) {
 			return nil
 		}
@@ -201,6 +206,15 @@ func (c *StashController) EnsureReplicationControllerSidecar(resource *core.Repl
 		}
 	}
 
+	workload := api.LocalTypedReference{
+		Kind: api.KindReplicationController,
+		Name: resource.Name,
+	}
+	template := resource.Spec.Template.DeepCopy()
+	if err = c.upsertSidecar(template, workload, old, new); err != nil {
+		return
+	}
+
 	resource, err = core_util.PatchRC(c.k8sClient, resource, func(obj *core.ReplicationController) *core.ReplicationController {
 		if util.ToBeInitializedBySelf(obj.Initializers) {
 			fmt.Println("Removing pending stash initializer for", obj.Name)
@@ -211,18 +225,7 @@ func (c *StashController) EnsureReplicationControllerSidecar(resource *core.Repl
 			}
 		}
 
-		workload := api.LocalTypedReference{
-			Kind: api.KindReplicationController,
-			Name: obj.Name,
-		}
-		if new.Spec.Type == api.BackupOffline {
-			obj.Spec.Template.Spec.InitContainers = core_util.UpsertContainer(obj.Spec.Template.Spec.InitContainers, util.CreateInitContainer(new, c.options.SidecarImageTag, workload, c.options.EnableRBAC))
-		} else {
-			obj.Spec.Template.Spec.Containers = core_util.UpsertContainer(obj.Spec.Template.Spec.Containers, util.CreateSidecarContainer(new, c.options.SidecarImageTag, workload))
-		}
-		obj.Spec.Template.Spec.Volumes = util.UpsertScratchVolume(obj.Spec.Template.Spec.Volumes)
-		obj.Spec.Template.Spec.Volumes = util.UpsertDownwardVolume(obj.Spec.Template.Spec.Volumes)
-		obj.Spec.Template.Spec.Volumes = util.MergeLocalVolume(obj.Spec.Template.Spec.Volumes, old, new)
+		obj.Spec.Template = template
 
 		if obj.Annotations == nil {
 			obj.Annotations = make(map[string]string)
@@ -249,7 +252,7 @@ func (c *StashController) EnsureReplicationControllerSidecar(resource *core.Repl
 	if err != nil {
 		return
 	}
-	err = util.WaitUntilSidecarAdded(c.k8sClient, resource.Namespace, &metav1.LabelSelector{MatchLabels: resource.Spec.Selector}, new.Spec.Type)
+	err = c.waitUntilSidecarAdded(api.KindReplicationController, resource.Namespace, &metav1.LabelSelector{MatchLabels: resource.Spec.Selector}, new.Spec.Type)
 	return err
 }
 
@@ -262,16 +265,7 @@ func (c *StashController) EnsureReplicationControllerSidecarDeleted(resource *co
 	}
 
 	resource, err = core_util.PatchRC(c.k8sClient, resource, func(obj *core.ReplicationController) *core.ReplicationController {
-		if restic.Spec.Type == api.BackupOffline {
-			obj.Spec.Template.Spec.InitContainers = core_util.EnsureContainerDeleted(obj.Spec.Template.Spec.InitContainers, util.StashContainer)
-		} else {
-			obj.Spec.Template.Spec.Containers = core_util.EnsureContainerDeleted(obj.Spec.Template.Spec.Containers, util.StashContainer)
-		}
-		obj.Spec.Template.Spec.Volumes = util.EnsureVolumeDeleted(obj.Spec.Template.Spec.Volumes, util.ScratchDirVolumeName)
-		obj.Spec.Template.Spec.Volumes = util.EnsureVolumeDeleted(obj.Spec.Template.Spec.Volumes, util.PodinfoVolumeName)
-		if restic.Spec.Backend.Local != nil {
-			obj.Spec.Template.Spec.Volumes = util.EnsureVolumeDeleted(obj.Spec.Template.Spec.Volumes, util.LocalVolumeName)
-		}
+		c.removeSidecar(obj.Spec.Template, restic)
 		if obj.Annotations != nil {
 			delete(obj.Annotations, api.LastAppliedConfiguration)
 			delete(obj.Annotations, api.VersionTag)
@@ -286,7 +280,7 @@ func (c *StashController) EnsureReplicationControllerSidecarDeleted(resource *co
 	if err != nil {
 		return
 	}
-	err = util.WaitUntilSidecarRemoved(c.k8sClient, resource.Namespace, &metav1.LabelSelector{MatchLabels: resource.Spec.Selector}, restic.Spec.Type)
+	err = c.waitUntilSidecarRemoved(api.KindReplicationController, resource.Namespace, &metav1.LabelSelector{MatchLabels: resource.Spec.Selector}, restic.Spec.Type)
 	if err != nil {
 		return
 	}
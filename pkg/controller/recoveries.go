@@ -2,36 +2,51 @@ package controller
 
 import (
 	"fmt"
+	"strings"
+	"time"
 
 	"github.com/appscode/go/log"
+	core_util "github.com/appscode/kutil/core/v1"
 	api "github.com/appscode/stash/apis/stash/v1alpha1"
 	stash_util "github.com/appscode/stash/client/typed/stash/v1alpha1/util"
 	stash_listers "github.com/appscode/stash/listers/stash/v1alpha1"
 	"github.com/appscode/stash/pkg/eventer"
+	"github.com/appscode/stash/pkg/notifier"
 	"github.com/appscode/stash/pkg/util"
 	"github.com/golang/glog"
+	batch "k8s.io/api/batch/v1"
 	core "k8s.io/api/core/v1"
 	kerr "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	rt "k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/apimachinery/pkg/watch"
 	"k8s.io/client-go/tools/cache"
 	"k8s.io/client-go/util/workqueue"
 )
 
+const (
+	// recoveryThrottleDelay is the delay before a Recovery throttled by MaxConcurrentRecoveries is processed again.
+	recoveryThrottleDelay = 30 * time.Second
+	// recoveryCleanupInterval is how often finished Recoveries are checked for an expired TTL.
+	recoveryCleanupInterval = 30 * time.Second
+)
+
 func (c *StashController) initRecoveryWatcher() {
 	lw := &cache.ListWatch{
 		ListFunc: func(options metav1.ListOptions) (rt.Object, error) {
-			return c.stashClient.Recoveries(core.NamespaceAll).List(options)
+			return c.stashClient.Recoveries(c.options.watchNamespace()).List(options)
 		},
 		WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
-			return c.stashClient.Recoveries(core.NamespaceAll).Watch(options)
+			return c.stashClient.Recoveries(c.options.watchNamespace()).Watch(options)
 		},
 	}
 
 	// create the workqueue
-	c.recQueue = workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "recovery")
+	c.recQueue = workqueue.NewNamedRateLimitingQueue(c.options.recoveryRateLimiter(), "recovery")
 
 	// Bind the workqueue to a cache with the help of an informer. This way we make sure that
 	// whenever the cache is updated, the pod key is added to the workqueue.
@@ -40,6 +55,13 @@ func (c *StashController) initRecoveryWatcher() {
 	c.recIndexer, c.recInformer = cache.NewIndexerInformer(lw, &api.Recovery{}, c.options.ResyncPeriod, cache.ResourceEventHandlerFuncs{
 		AddFunc: func(obj interface{}) {
 			if r, ok := obj.(*api.Recovery); ok {
+				if r.DeletionTimestamp != nil {
+					// finalize Recoveries deleted while the operator was down
+					if key, err := cache.MetaNamespaceKeyFunc(obj); err == nil {
+						c.recQueue.Add(key)
+					}
+					return
+				}
 				if err := r.IsValid(); err != nil {
 					c.recorder.Eventf(
 						r.ObjectReference(),
@@ -68,6 +90,12 @@ func (c *StashController) initRecoveryWatcher() {
 				log.Errorln("Invalid Recovery object")
 				return
 			}
+			if newObj.DeletionTimestamp != nil {
+				if key, err := cache.MetaNamespaceKeyFunc(new); err == nil {
+					c.recQueue.Add(key)
+				}
+				return
+			}
 			if err := newObj.IsValid(); err != nil {
 				c.recorder.Eventf(
 					newObj.ObjectReference(),
@@ -96,8 +124,33 @@ func (c *StashController) initRecoveryWatcher() {
 	c.recLister = stash_listers.NewRecoveryLister(c.recIndexer)
 }
 
-func (c *StashController) runRecoveryWatcher() {
-	for c.processNextRecovery() {
+// startRecoveryWatchers runs Recovery workers until stopCh is closed. The queue is shut down on stop,
+// so idle workers return immediately while busy workers finish their current Recovery first.
+// Keys left in the queue are picked up again from the informer cache on the next start.
+func (c *StashController) startRecoveryWatchers(threadiness int, stopCh <-chan struct{}) {
+	go func() {
+		<-stopCh
+		c.recQueue.ShutDown()
+	}()
+	for i := 0; i < threadiness; i++ {
+		c.recWorkers.Add(1)
+		go func() {
+			defer c.recWorkers.Done()
+			wait.Until(func() { c.runRecoveryWatcher(stopCh) }, time.Second, stopCh)
+		}()
+	}
+}
+
+func (c *StashController) runRecoveryWatcher(stopCh <-chan struct{}) {
+	for {
+		select {
+		case <-stopCh:
+			return
+		default:
+		}
+		if !c.processNextRecovery() {
+			return
+		}
 	}
 }
 
@@ -152,55 +205,352 @@ func (c *StashController) runRecoveryInjector(key string) error {
 
 	if !exists {
 		// Below we will warm up our cache with a Recovery, so that we will see a delete for one d
-		fmt.Printf("Recovery %s does not exist anymore\n", key)
+		glog.Infof("Recovery %s does not exist anymore", key)
 		return nil
 	}
 
 	d := obj.(*api.Recovery)
-	fmt.Printf("Sync/Add/Update for Recovery %s\n", d.GetName())
+	glog.Infof("Sync/Add/Update for Recovery %s/%s", d.Namespace, d.Name)
+	if d.DeletionTimestamp != nil {
+		return c.finalizeRecovery(d)
+	}
 	return c.runRecoveryJob(d)
 }
 
+// ensureRecoveryFinalizer adds util.RecoveryFinalizer to rec, so that rec is not removed before
+// its recovery job is deleted by finalizeRecovery.
+func (c *StashController) ensureRecoveryFinalizer(rec *api.Recovery) (*api.Recovery, error) {
+	if core_util.HasFinalizer(rec.ObjectMeta, util.RecoveryFinalizer) {
+		return rec, nil
+	}
+	return stash_util.TryUpdateRecovery(c.stashClient, rec.ObjectMeta, func(in *api.Recovery) *api.Recovery {
+		in.ObjectMeta = core_util.AddFinalizer(in.ObjectMeta, util.RecoveryFinalizer)
+		return in
+	})
+}
+
+// finalizeRecovery deletes the recovery job of a Recovery marked for deletion and then removes
+// util.RecoveryFinalizer, which lets the apiserver remove the Recovery.
+func (c *StashController) finalizeRecovery(rec *api.Recovery) error {
+	if !core_util.HasFinalizer(rec.ObjectMeta, util.RecoveryFinalizer) {
+		return nil
+	}
+	if err := util.DeleteRecoveryJob(c.k8sClient, rec); err != nil {
+		return err
+	}
+	if rec.GetResticNamespace() != rec.Namespace {
+		if err := c.ensureRecoveryRoleBindingDeleted(util.RecoveryJobPrefix+rec.Name, rec.Namespace, rec.GetResticNamespace()); err != nil {
+			return err
+		}
+	}
+	_, err := stash_util.TryUpdateRecovery(c.stashClient, rec.ObjectMeta, func(in *api.Recovery) *api.Recovery {
+		in.ObjectMeta = core_util.RemoveFinalizer(in.ObjectMeta, util.RecoveryFinalizer)
+		return in
+	})
+	if kerr.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+// checkRecoverySecrets checks that the secrets the recovery job refers to exist in the namespace
+// of the Recovery. Kubernetes resolves them there, so a Recovery of a Restic in another namespace
+// needs a copy of the repository secret in its own namespace.
+func (c *StashController) checkRecoverySecrets(rec *api.Recovery, restic *api.Restic, job *batch.Job) error {
+	for _, name := range util.PodSecretNames(job.Spec.Template.Spec) {
+		var err error
+		if name == restic.Spec.Backend.StorageSecretName {
+			err = util.ValidateBackendSecret(c.k8sClient, rec.Namespace, restic.Spec.Backend)
+		} else {
+			_, err = c.k8sClient.CoreV1().Secrets(rec.Namespace).Get(name, metav1.GetOptions{})
+		}
+		if err != nil {
+			return fmt.Errorf("recovery job needs secret %s of Restic %s/%s in namespace %s, reason: %s", name, restic.Namespace, restic.Name, rec.Namespace, err)
+		}
+	}
+	return nil
+}
+
 func (c *StashController) runRecoveryJob(rec *api.Recovery) error {
-	if rec.Status.Phase == api.RecoverySucceeded || rec.Status.Phase == api.RecoveryRunning {
+	if rec.Status.Phase == api.RecoverySucceeded || rec.Status.Phase == api.RecoveryPartial || rec.Status.Phase == api.RecoveryRunning {
 		return nil
 	}
 
-	restic, err := c.stashClient.Restics(rec.Namespace).Get(rec.Spec.Restic, metav1.GetOptions{})
+	if ns := c.options.WatchNamespace; ns != "" && rec.GetResticNamespace() != ns {
+		err := fmt.Errorf("resticNamespace %s is not watched, the operator only watches namespace %s", rec.GetResticNamespace(), ns)
+		log.Errorln(err)
+		c.setRecoveryFailed(rec, eventer.EventReasonInvalidRecovery, err.Error())
+		return err
+	}
+
+	restic, err := c.stashClient.Restics(rec.GetResticNamespace()).Get(rec.Spec.Restic, metav1.GetOptions{})
 	if err != nil {
 		log.Errorln(err)
-		stash_util.SetRecoveryStatusPhase(c.stashClient, rec, api.RecoveryFailed)
-		c.recorder.Event(rec.ObjectReference(), core.EventTypeWarning, eventer.EventReasonFailedToRecover, err.Error())
+		c.setRecoveryFailed(rec, eventer.EventReasonFailedToRecover, err.Error())
 		return err
 	}
 
 	if err = restic.IsValid(); err != nil {
 		log.Errorln(err)
-		stash_util.SetRecoveryStatusPhase(c.stashClient, rec, api.RecoveryFailed)
-		c.recorder.Event(rec.ObjectReference(), core.EventTypeWarning, eventer.EventReasonFailedToRecover, err.Error())
+		c.setRecoveryFailed(rec, eventer.EventReasonFailedToRecover, err.Error())
 		return err
 	}
 
-	job := util.CreateRecoveryJob(rec, restic, c.options.SidecarImageTag)
+	if err = util.ValidateBackendSecret(c.k8sClient, restic.Namespace, restic.Spec.Backend); err != nil {
+		log.Errorln(err)
+		c.setRecoveryFailed(rec, eventer.EventReasonFailedToRecover, err.Error())
+		return err
+	}
+
+	meta, err := util.GetWorkloadMeta(c.k8sClient, rec.Namespace, rec.Spec.Workload)
+	if err != nil {
+		log.Errorln(err)
+		c.setRecoveryFailed(rec, eventer.EventReasonFailedToRecover, err.Error())
+		return err
+	}
+
+	// workload matching multiple restics is ambiguous, report the conflicting restics
+	if _, err = util.FindRestic(c.rstLister, rec.Spec.Workload.Kind, *meta); err != nil {
+		log.Errorln(err)
+		c.setRecoveryFailed(rec, eventer.EventReasonInvalidRecovery, err.Error())
+		return err
+	}
+
+	// paths are restored in place, so they must be below a volume mount of the recovery job. Otherwise they
+	// are restored into the container filesystem and lost. Each parallel job only recovers the paths below
+	// its volume mount. A target volume is mounted at a path prefixing the restored paths.
+	if rec.Spec.Target == nil {
+		if paths := util.UnmountedPaths(util.RecoverySourcePaths(rec, restic), restic.Spec.VolumeMounts); len(paths) > 0 {
+			err = fmt.Errorf("paths %s are not below any volume mount of Restic %s/%s", strings.Join(paths, ", "), restic.Namespace, restic.Name)
+			log.Errorln(err)
+			c.setRecoveryFailed(rec, eventer.EventReasonInvalidRecovery, err.Error())
+			return err
+		}
+	}
+
+	jobs := util.CreateRecoveryJobs(rec, restic, c.options.SidecarImageTag, c.options.LogLevel)
+	for _, job := range jobs {
+		if c.options.RecoveryImage != "" {
+			job.Spec.Template.Spec.Containers[0].Image = c.options.RecoveryImage
+		}
+		util.ApplyJobDefaultResources(job, c.options.SidecarDefaultResources)
+	}
+	if rec.Spec.DryRun {
+		return c.dryRunRecoveryJob(rec, jobs)
+	}
+	sa := "default"
 	if c.options.EnableRBAC {
-		if err = c.ensureRecoveryRBAC(job.Name, job.Namespace); err != nil {
-			return fmt.Errorf("error ensuring rbac for recovery job %s, reason: %s\n", job.Name, err)
+		// parallel recovery jobs share the service account of the recovery
+		sa = util.RecoveryJobPrefix + rec.Name
+		if err = c.ensureRecoveryRBAC(sa, rec.Namespace, restic.Namespace); err != nil {
+			return fmt.Errorf("error ensuring rbac for recovery job %s, reason: %s\n", sa, err)
+		}
+		for _, job := range jobs {
+			job.Spec.Template.Spec.ServiceAccountName = sa
 		}
-		job.Spec.Template.Spec.ServiceAccountName = job.Name
 	}
-	if job, err = c.k8sClient.BatchV1().Jobs(rec.Namespace).Create(job); err != nil {
-		if kerr.IsAlreadyExists(err) {
+	if restic.Namespace != rec.Namespace {
+		if err = c.checkRecoveryAccess(sa, rec.Namespace, restic.Namespace); err == nil {
+			err = c.checkRecoverySecrets(rec, restic, jobs[0])
+		}
+		if err != nil {
+			log.Errorln(err)
+			c.setRecoveryFailed(rec, eventer.EventReasonInvalidRecovery, err.Error())
+			return err
+		}
+	}
+	if c.options.MaxConcurrentRecoveries > 0 {
+		c.recThrottleLock.Lock()
+		defer c.recThrottleLock.Unlock()
+		running, err := c.countRunningRecoveries(rec)
+		if err != nil {
+			return err
+		}
+		if running >= c.options.MaxConcurrentRecoveries {
+			msg := fmt.Sprintf("Recovery throttled, %d of at most %d Recoveries are running", running, c.options.MaxConcurrentRecoveries)
+			log.Infof("%s/%s: %s\n", rec.Namespace, rec.Name, msg)
+			c.recorder.Event(rec.ObjectReference(), core.EventTypeNormal, eventer.EventReasonRecoveryThrottled, msg)
+			if key, err := cache.MetaNamespaceKeyFunc(rec); err == nil {
+				c.recQueue.AddAfter(key, recoveryThrottleDelay)
+			}
 			return nil
 		}
-		log.Errorln(err)
-		stash_util.SetRecoveryStatusPhase(c.stashClient, rec, api.RecoveryFailed)
-		c.recorder.Event(rec.ObjectReference(), core.EventTypeWarning, eventer.EventReasonFailedToRecover, err.Error())
-		return err
 	}
+	finalized, err := c.ensureRecoveryFinalizer(rec)
+	if err != nil {
+		return fmt.Errorf("error adding finalizer to recovery %s/%s, reason: %s", rec.Namespace, rec.Name, err)
+	}
+	rec = finalized
+	created := make([]string, 0, len(jobs))
+	for _, job := range jobs {
+		if _, err = c.k8sClient.BatchV1().Jobs(rec.Namespace).Create(job); err != nil {
+			if kerr.IsAlreadyExists(err) {
+				continue
+			}
+			log.Errorln(err)
+			c.setRecoveryFailed(rec, eventer.EventReasonFailedToRecover, err.Error())
+			return err
+		}
+		created = append(created, job.Name)
+	}
+	existing := len(created) == 0
+	if existing {
+		// all jobs were created before, but the Recovery may not have been marked Running,
+		// e.g. if the status update failed or the operator restarted in between
+		if rec.Status.Phase != "" && rec.Status.Phase != api.RecoveryPending {
+			return nil
+		}
+		for _, job := range jobs {
+			created = append(created, job.Name)
+		}
+	}
+
+	msg := fmt.Sprintf("Recovery job created: %s", created[0])
+	if len(created) > 1 {
+		msg = fmt.Sprintf("Recovery jobs created: %s", strings.Join(created, ", "))
+	}
+	if !existing {
+		log.Infoln(msg)
+		c.recorder.Event(rec.ObjectReference(), core.EventTypeNormal, eventer.EventReasonJobCreated, msg)
+	}
+	c.setRecoveryStatusPhase(rec, api.RecoveryRunning, core.EventTypeNormal, eventer.EventReasonRecoveryRunning,
+		fmt.Sprintf("Recovery phase changed from %s to %s", recoveryPhaseOrPending(rec.Status.Phase), api.RecoveryRunning),
+		api.RecoveryCondition{
+			Type:    api.RecoveryConditionJobCreated,
+			Status:  core.ConditionTrue,
+			Reason:  eventer.EventReasonJobCreated,
+			Message: msg,
+		})
+
+	return nil
+}
+
+// deleteExpiredRecoveries deletes the Recoveries that finished longer than their ttlSecondsAfterFinished
+// ago, together with their recovery jobs.
+func (c *StashController) deleteExpiredRecoveries() {
+	recoveries, err := c.recLister.List(labels.Everything())
+	if err != nil {
+		log.Errorln("Failed to list Recoveries. Reason:", err)
+		return
+	}
+	for _, rec := range recoveries {
+		if rec.Spec.TTLSecondsAfterFinished == nil || rec.DeletionTimestamp != nil {
+			continue
+		}
+		finished, ok := util.RecoveryFinishedTime(rec)
+		if !ok || c.clock.Since(finished) < time.Duration(*rec.Spec.TTLSecondsAfterFinished)*time.Second {
+			continue
+		}
+		if err = util.DeleteRecoveryJob(c.k8sClient, rec); err != nil {
+			log.Errorf("Failed to delete recovery jobs of expired Recovery %s/%s. Reason: %s", rec.Namespace, rec.Name, err)
+			continue
+		}
+		if err = c.stashClient.Recoveries(rec.Namespace).Delete(rec.Name, &metav1.DeleteOptions{}); err != nil && !kerr.IsNotFound(err) {
+			log.Errorf("Failed to delete expired Recovery %s/%s. Reason: %s", rec.Namespace, rec.Name, err)
+			continue
+		}
+		log.Infof("Deleted Recovery %s/%s, it finished as %s more than %d seconds ago\n", rec.Namespace, rec.Name, rec.Status.Phase, *rec.Spec.TTLSecondsAfterFinished)
+	}
+}
 
-	log.Infoln("Recovery job created:", job.Name)
-	c.recorder.Eventf(rec.ObjectReference(), core.EventTypeNormal, eventer.EventReasonJobCreated, "Recovery job created: %s", job.Name)
-	stash_util.SetRecoveryStatusPhase(c.stashClient, rec, api.RecoveryRunning)
+// countRunningRecoveries returns the number of Recoveries other than rec with running recovery jobs.
+// Jobs are listed from the apiserver, since the job cache may not yet hold jobs created by other workers.
+func (c *StashController) countRunningRecoveries(rec *api.Recovery) (int, error) {
+	jobs, err := c.k8sClient.BatchV1().Jobs(c.options.watchNamespace()).List(metav1.ListOptions{
+		LabelSelector: labels.SelectorFromSet(map[string]string{"app": util.AppLabelStash}).String(),
+	})
+	if err != nil {
+		return 0, err
+	}
+	running := sets.NewString()
+	for i := range jobs.Items {
+		job := &jobs.Items[i]
+		if job.Annotations[util.AnnotationOperation] != util.OperationRecovery || util.GetJobResult(job) != util.JobResultRunning {
+			continue
+		}
+		if job.Namespace == rec.Namespace && util.IsRecoveryJobOf(job, rec.Name) {
+			continue
+		}
+		running.Insert(job.Namespace + "/" + job.Annotations[util.AnnotationRecovery])
+	}
+	return running.Len(), nil
+}
 
+// setRecoveryFailed marks rec as failed, records a warning event and notifies about the failure.
+// Recoveries that already failed, e.g. when processed again after a requeue, are not reported again.
+func (c *StashController) setRecoveryFailed(rec *api.Recovery, reason, msg string) {
+	if c.setRecoveryStatusPhase(rec, api.RecoveryFailed, core.EventTypeWarning, reason, msg, util.RecoveryPhaseConditions(api.RecoveryFailed, reason, msg)...) {
+		c.notifyRecovery(rec, api.RecoveryFailed, msg)
+	}
+}
+
+// setRecoveryStatusPhase sets the phase of rec and records an event for the phase change. If rec already
+// is in phase, only the conditions are updated and false is returned.
+func (c *StashController) setRecoveryStatusPhase(rec *api.Recovery, phase api.RecoveryPhase, eventType, reason, msg string, conditions ...api.RecoveryCondition) bool {
+	changed := rec.Status.Phase != phase
+	stash_util.SetRecoveryStatusPhase(c.stashClient, rec, phase, conditions...)
+	if changed {
+		c.recorder.Event(rec.ObjectReference(), eventType, reason, msg)
+	}
+	return changed
+}
+
+// recoveryPhaseOrPending returns phase, or Pending for Recoveries that were not processed yet.
+func recoveryPhaseOrPending(phase api.RecoveryPhase) api.RecoveryPhase {
+	if phase == "" {
+		return api.RecoveryPending
+	}
+	return phase
+}
+
+// notifyRecovery sends a notification about rec if a notifier is configured. Failures to notify are only logged.
+func (c *StashController) notifyRecovery(rec *api.Recovery, phase api.RecoveryPhase, reason string) {
+	if c.notifier == nil {
+		return
+	}
+	err := c.notifier.Notify(notifier.Event{
+		Name:      rec.Name,
+		Namespace: rec.Namespace,
+		Restic:    rec.Spec.Restic,
+		Phase:     phase,
+		Reason:    reason,
+	})
+	if err != nil {
+		log.Errorf("Failed to send notification for Recovery %s/%s. Reason: %s", rec.Namespace, rec.Name, err)
+	}
+}
+
+// dryRunRecoveryJob validates that jobs could be run for rec and records the jobs
+// that would have been created, without creating them.
+func (c *StashController) dryRunRecoveryJob(rec *api.Recovery, jobs []*batch.Job) error {
+	job := jobs[0]
+	if c.options.EnableRBAC {
+		if _, err := c.k8sClient.RbacV1beta1().ClusterRoles().Get(SidecarClusterRole, metav1.GetOptions{}); err != nil {
+			log.Errorln(err)
+			c.setRecoveryFailed(rec, eventer.EventReasonFailedToRecover, err.Error())
+			return err
+		}
+		job.Spec.Template.Spec.ServiceAccountName = util.RecoveryJobPrefix + rec.Name
+	}
+
+	msg := fmt.Sprintf("Recovery job %s/%s would be created with image %s", job.Namespace, job.Name, job.Spec.Template.Spec.Containers[0].Image)
+	if len(jobs) > 1 {
+		names := make([]string, 0, len(jobs))
+		for _, j := range jobs {
+			names = append(names, j.Name)
+		}
+		msg = fmt.Sprintf("Recovery jobs %s/{%s} would be created with image %s", job.Namespace, strings.Join(names, ","), job.Spec.Template.Spec.Containers[0].Image)
+	}
+	if sa := job.Spec.Template.Spec.ServiceAccountName; sa != "" {
+		msg += fmt.Sprintf(" and service account %s", sa)
+	}
+	log.Infoln(msg)
+	stash_util.SetRecoveryCondition(c.stashClient, rec, api.RecoveryCondition{
+		Type:    api.RecoveryConditionDryRun,
+		Status:  core.ConditionTrue,
+		Reason:  eventer.EventReasonRecoveryDryRun,
+		Message: msg,
+	})
+	c.recorder.Event(rec.ObjectReference(), core.EventTypeNormal, eventer.EventReasonRecoveryDryRun, msg)
 	return nil
 }
@@ -5,13 +5,11 @@ import (
 
 	"github.com/appscode/go/log"
 	stringz "github.com/appscode/go/strings"
-	core_util "github.com/appscode/kutil/core/v1"
 	ext_util "github.com/appscode/kutil/extensions/v1beta1"
 	"github.com/appscode/kutil/meta"
 	api "github.com/appscode/stash/apis/stash/v1alpha1"
 	"github.com/appscode/stash/pkg/util"
 	"github.com/golang/glog"
-	core "k8s.io/api/core/v1"
 	extensions "k8s.io/api/extensions/v1beta1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	rt "k8s.io/apimachinery/pkg/runtime"
@@ -28,11 +26,11 @@ func (c *StashController) initReplicaSetWatcher() {
 	lw := &cache.ListWatch{
 		ListFunc: func(options metav1.ListOptions) (rt.Object, error) {
 			options.IncludeUninitialized = true
-			return c.k8sClient.ExtensionsV1beta1().ReplicaSets(core.NamespaceAll).List(options)
+			return c.k8sClient.ExtensionsV1beta1().ReplicaSets(c.options.watchNamespace()).List(options)
 		},
 		WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
 			options.IncludeUninitialized = true
-			return c.k8sClient.ExtensionsV1beta1().ReplicaSets(core.NamespaceAll).Watch(options)
+			return c.k8sClient.ExtensionsV1beta1().ReplicaSets(c.options.watchNamespace()).Watch(options)
 		},
 	}
 
@@ -141,15 +139,20 @@ func (c *StashController) runReplicaSetInjector(key string) error {
 		}
 
 		if !ext_util.IsOwnedByDeployment(rs) {
-			oldRestic, err := util.GetAppliedRestic(rs.Annotations)
+			ref, _ := reference.GetReference(scheme.Scheme, rs)
+			oldRestic, err := util.GetAppliedResticWithEvent(rs.Annotations, c.recorder, ref)
 			if err != nil {
 				return err
 			}
-			newRestic, err := util.FindRestic(c.rstLister, rs.ObjectMeta)
+			newRestic, err := util.FindRestic(c.rstLister, api.KindReplicaSet, rs.ObjectMeta)
 			if err != nil {
 				log.Errorf("Error while searching Restic for ReplicaSet %s/%s.", rs.Name, rs.Namespace)
 				return err
 			}
+			if newRestic != nil && util.SkipInjection(rs.Annotations, rs.Spec.Template.Annotations) {
+				log.Infof("Skipping stash sidecar for ReplicaSet %s/%s", rs.Namespace, rs.Name)
+				newRestic = nil
+			}
 			if util.ResticEqual(oldRestic, newRestic) {
 				return nil
 			}
@@ -205,6 +208,15 @@ func (c *StashController) EnsureReplicaSetSidecar(resource *extensions.ReplicaSe
 		}
 	}
 
+	workload := api.LocalTypedReference{
+		Kind: api.KindReplicaSet,
+		Name: resource.Name,
+	}
+	template := resource.Spec.Template.DeepCopy()
+	if err = c.upsertSidecar(template, workload, old, new); err != nil {
+		return
+	}
+
 	resource, err = ext_util.PatchReplicaSet(c.k8sClient, resource, func(obj *extensions.ReplicaSet) *extensions.ReplicaSet {
 		if util.ToBeInitializedBySelf(obj.Initializers) {
 			fmt.Println("Removing pending stash initializer for", obj.Name)
@@ -215,18 +227,7 @@ func (c *StashController) EnsureReplicaSetSidecar(resource *extensions.ReplicaSe
 			}
 		}
 
-		workload := api.LocalTypedReference{
-			Kind: api.KindReplicaSet,
-			Name: obj.Name,
-		}
-		if new.Spec.Type == api.BackupOffline {
-			obj.Spec.Template.Spec.InitContainers = core_util.UpsertContainer(obj.Spec.Template.Spec.InitContainers, util.CreateInitContainer(new, c.options.SidecarImageTag, workload, c.options.EnableRBAC))
-		} else {
-			obj.Spec.Template.Spec.Containers = core_util.UpsertContainer(obj.Spec.Template.Spec.Containers, util.CreateSidecarContainer(new, c.options.SidecarImageTag, workload))
-		}
-		obj.Spec.Template.Spec.Volumes = util.UpsertScratchVolume(obj.Spec.Template.Spec.Volumes)
-		obj.Spec.Template.Spec.Volumes = util.UpsertDownwardVolume(obj.Spec.Template.Spec.Volumes)
-		obj.Spec.Template.Spec.Volumes = util.MergeLocalVolume(obj.Spec.Template.Spec.Volumes, old, new)
+		obj.Spec.Template = *template
 
 		if obj.Annotations == nil {
 			obj.Annotations = make(map[string]string)
@@ -253,7 +254,7 @@ func (c *StashController) EnsureReplicaSetSidecar(resource *extensions.ReplicaSe
 	if err != nil {
 		return
 	}
-	err = util.WaitUntilSidecarAdded(c.k8sClient, resource.Namespace, resource.Spec.Selector, new.Spec.Type)
+	err = c.waitUntilSidecarAdded(api.KindReplicaSet, resource.Namespace, resource.Spec.Selector, new.Spec.Type)
 	return err
 }
 
@@ -266,16 +267,7 @@ func (c *StashController) EnsureReplicaSetSidecarDeleted(resource *extensions.Re
 	}
 
 	resource, err = ext_util.PatchReplicaSet(c.k8sClient, resource, func(obj *extensions.ReplicaSet) *extensions.ReplicaSet {
-		if restic.Spec.Type == api.BackupOffline {
-			obj.Spec.Template.Spec.InitContainers = core_util.EnsureContainerDeleted(obj.Spec.Template.Spec.InitContainers, util.StashContainer)
-		} else {
-			obj.Spec.Template.Spec.Containers = core_util.EnsureContainerDeleted(obj.Spec.Template.Spec.Containers, util.StashContainer)
-		}
-		obj.Spec.Template.Spec.Volumes = util.EnsureVolumeDeleted(obj.Spec.Template.Spec.Volumes, util.ScratchDirVolumeName)
-		obj.Spec.Template.Spec.Volumes = util.EnsureVolumeDeleted(obj.Spec.Template.Spec.Volumes, util.PodinfoVolumeName)
-		if restic.Spec.Backend.Local != nil {
-			obj.Spec.Template.Spec.Volumes = util.EnsureVolumeDeleted(obj.Spec.Template.Spec.Volumes, util.LocalVolumeName)
-		}
+		c.removeSidecar(&obj.Spec.Template, restic)
 		if obj.Annotations != nil {
 			delete(obj.Annotations, api.LastAppliedConfiguration)
 			delete(obj.Annotations, api.VersionTag)
@@ -290,7 +282,7 @@ func (c *StashController) EnsureReplicaSetSidecarDeleted(resource *extensions.Re
 	if err != nil {
 		return
 	}
-	err = util.WaitUntilSidecarRemoved(c.k8sClient, resource.Namespace, resource.Spec.Selector, restic.Spec.Type)
+	err = c.waitUntilSidecarRemoved(api.KindReplicaSet, resource.Namespace, resource.Spec.Selector, restic.Spec.Type)
 	if err != nil {
 		return
 	}
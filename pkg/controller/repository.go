@@ -0,0 +1,147 @@
+package controller
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/appscode/go/log"
+	stringz "github.com/appscode/go/strings"
+	ext_util "github.com/appscode/kutil/extensions/v1beta1"
+	api "github.com/appscode/stash/apis/stash/v1alpha1"
+	stash_util "github.com/appscode/stash/client/typed/stash/v1alpha1/util"
+	"github.com/appscode/stash/pkg/eventer"
+	"github.com/appscode/stash/pkg/util"
+	batch "k8s.io/api/batch/v1"
+	core "k8s.io/api/core/v1"
+	kerr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+// ensureRepositoryInitialized runs a job initializing the repository of workload, if its replicas share
+// one. It returns false while the job is running, so that the sidecar is added after the repository
+// exists. The job initializes the shared repositories of all workloads of restic at once and is kept
+// until the Restic is deleted. It is replaced if it failed, or if it doesn't cover workload, i.e. the
+// workload was matched by restic after the job was created.
+func (c *StashController) ensureRepositoryInitialized(restic *api.Restic, workload api.LocalTypedReference) (bool, error) {
+	if restic.Spec.Type == api.BackupOffline || !util.SharesRepository(workload.Kind) {
+		return true, nil
+	}
+	_, prefix, err := workload.HostnamePrefix("", "")
+	if err != nil {
+		return false, err
+	}
+
+	job, err := c.jobLister.Jobs(restic.Namespace).Get(util.InitJobPrefix + restic.Name)
+	if err == nil {
+		result := util.GetJobResult(job)
+		if result == util.JobResultRunning {
+			return false, nil
+		} else if result == util.JobResultSucceeded && stringz.Contains(util.InitJobPrefixes(job), prefix) {
+			return true, nil
+		}
+		policy := metav1.DeletePropagationBackground
+		err = c.k8sClient.BatchV1().Jobs(job.Namespace).Delete(job.Name, &metav1.DeleteOptions{PropagationPolicy: &policy})
+		if err != nil && !kerr.IsNotFound(err) {
+			return false, err
+		}
+	} else if !kerr.IsNotFound(err) {
+		return false, err
+	}
+
+	prefixes := c.sharedRepositoryPrefixes(restic)
+	if !stringz.Contains(prefixes, prefix) {
+		prefixes = append(prefixes, prefix)
+		sort.Strings(prefixes)
+	}
+	if job, err = util.CreateInitJob(restic, prefixes, c.options.SidecarImageTag); err != nil {
+		return false, err
+	}
+	util.ApplyJobDefaultResources(job, c.options.SidecarDefaultResources)
+	if c.options.EnableRBAC {
+		if err = c.ensureRecoveryRBAC(job.Name, job.Namespace, job.Namespace); err != nil {
+			return false, fmt.Errorf("error ensuring rbac for init job %s, reason: %s", job.Name, err)
+		}
+		job.Spec.Template.Spec.ServiceAccountName = job.Name
+	}
+	if _, err = c.k8sClient.BatchV1().Jobs(job.Namespace).Create(job); kerr.IsAlreadyExists(err) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	c.recorder.Eventf(
+		restic.ObjectReference(),
+		core.EventTypeNormal,
+		eventer.EventReasonInitJobCreated,
+		"Created init job %s for repositories %s",
+		job.Name,
+		strings.Join(prefixes, ", "),
+	)
+	return false, nil
+}
+
+// sharedRepositoryPrefixes returns the smart prefixes of the repositories shared by the replicas of
+// the workloads backed up by restic. Repositories of StatefulSet pods and DaemonSet nodes are used by
+// a single sidecar each, so they are left to the sidecar.
+func (c *StashController) sharedRepositoryPrefixes(restic *api.Restic) []string {
+	var workloads []api.LocalTypedReference
+	if restic.Spec.Target != nil {
+		target := *restic.Spec.Target
+		if err := target.Canonicalize(); err == nil {
+			workloads = append(workloads, target)
+		}
+	} else if sel, err := metav1.LabelSelectorAsSelector(&restic.Spec.Selector); err == nil {
+		if resources, err := c.dpLister.Deployments(restic.Namespace).List(sel); err == nil {
+			for _, resource := range resources {
+				workloads = append(workloads, api.LocalTypedReference{Kind: api.KindDeployment, Name: resource.Name})
+			}
+		}
+		if resources, err := c.rcLister.ReplicationControllers(restic.Namespace).List(sel); err == nil {
+			for _, resource := range resources {
+				workloads = append(workloads, api.LocalTypedReference{Kind: api.KindReplicationController, Name: resource.Name})
+			}
+		}
+		if resources, err := c.rsLister.ReplicaSets(restic.Namespace).List(sel); err == nil {
+			for _, resource := range resources {
+				// If owned by a Deployment, skip it.
+				if !ext_util.IsOwnedByDeployment(resource) {
+					workloads = append(workloads, api.LocalTypedReference{Kind: api.KindReplicaSet, Name: resource.Name})
+				}
+			}
+		}
+	}
+
+	var prefixes []string
+	for _, workload := range workloads {
+		if !util.SharesRepository(workload.Kind) {
+			continue
+		}
+		if _, prefix, err := workload.HostnamePrefix("", ""); err == nil {
+			prefixes = append(prefixes, prefix)
+		}
+	}
+	sort.Strings(prefixes)
+	return prefixes
+}
+
+// setRepositoryInitialized records the result of the finished init job in the RepositoryInitialized
+// condition of its Restic and requeues the Restic, so that its sidecars are added or a failed job
+// is replaced.
+func (c *StashController) setRepositoryInitialized(job *batch.Job, result util.JobResult) {
+	restic, err := c.rstLister.Restics(job.Namespace).Get(job.Annotations[util.AnnotationRestic])
+	if err != nil {
+		log.Errorf("Failed to get Restic of init job %s/%s. Reason: %s", job.Namespace, job.Name, err)
+		return
+	}
+	msg := fmt.Sprintf("Init job %s succeeded", job.Name)
+	if result != util.JobResultSucceeded {
+		msg = fmt.Sprintf("Init job %s failed after %d attempts", job.Name, job.Status.Failed)
+	}
+	if cond := util.RepositoryInitializedCondition(result, msg); !util.HasResticCondition(restic, cond.Type, cond.Status) {
+		stash_util.SetResticCondition(c.stashClient, restic, cond)
+	}
+	if key, err := cache.MetaNamespaceKeyFunc(restic); err == nil {
+		c.rstQueue.Add(key)
+	}
+}
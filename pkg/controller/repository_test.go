@@ -0,0 +1,170 @@
+package controller
+
+import (
+	"testing"
+
+	api "github.com/appscode/stash/apis/stash/v1alpha1"
+	stash_fake "github.com/appscode/stash/client/fake"
+	stash_listers "github.com/appscode/stash/listers/stash/v1alpha1"
+	"github.com/appscode/stash/pkg/util"
+	apps "k8s.io/api/apps/v1beta1"
+	batch "k8s.io/api/batch/v1"
+	core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	apps_listers "k8s.io/client-go/listers/apps/v1beta1"
+	batch_listers "k8s.io/client-go/listers/batch/v1"
+	core_listers "k8s.io/client-go/listers/core/v1"
+	ext_listers "k8s.io/client-go/listers/extensions/v1beta1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/workqueue"
+)
+
+func TestRepositoryInitializedOnce(t *testing.T) {
+	restic := &api.Restic{
+		ObjectMeta: metav1.ObjectMeta{Name: "db-backup", Namespace: "default"},
+		Spec: api.ResticSpec{
+			Selector: metav1.LabelSelector{MatchLabels: map[string]string{"app": "db"}},
+			Type:     api.BackupOnline,
+			Backend: api.Backend{
+				StorageSecretName: "secret",
+				Local: &api.LocalSpec{
+					VolumeSource: core.VolumeSource{HostPath: &core.HostPathVolumeSource{Path: "/data/stash"}},
+					Path:         "/repository",
+				},
+			},
+		},
+	}
+	deployment := &apps.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "db", Namespace: "default", Labels: map[string]string{"app": "db"}}}
+
+	newIndexer := func(objs ...interface{}) cache.Indexer {
+		indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+		for _, obj := range objs {
+			indexer.Add(obj)
+		}
+		return indexer
+	}
+	k8sClient := fake.NewSimpleClientset()
+	stashClient := stash_fake.NewSimpleClientset(restic)
+	c := &StashController{
+		k8sClient:   k8sClient,
+		stashClient: stashClient.StashV1alpha1(),
+		recorder:    record.NewFakeRecorder(10),
+		rstQueue:    workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter()),
+		rstLister:   stash_listers.NewResticLister(newIndexer(restic)),
+		dpLister:    apps_listers.NewDeploymentLister(newIndexer(deployment)),
+		rcLister:    core_listers.NewReplicationControllerLister(newIndexer()),
+		rsLister:    ext_listers.NewReplicaSetLister(newIndexer()),
+	}
+	defer c.rstQueue.ShutDown()
+	c.jobIndexer = newIndexer()
+	c.jobLister = batch_listers.NewJobLister(c.jobIndexer)
+	createdJobs := func() int {
+		n := 0
+		for _, action := range k8sClient.Actions() {
+			if action.GetVerb() == "create" && action.GetResource().Resource == "jobs" {
+				n++
+			}
+		}
+		return n
+	}
+	// initJob returns the init job and adds it to the job cache
+	initJob := func() *batch.Job {
+		job, err := k8sClient.BatchV1().Jobs(restic.Namespace).Get(util.InitJobPrefix+restic.Name, metav1.GetOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.jobIndexer.Add(job)
+		return job
+	}
+	db := api.LocalTypedReference{Kind: api.KindDeployment, Name: "db"}
+
+	if initialized, err := c.ensureRepositoryInitialized(restic, db); err != nil || initialized {
+		t.Fatalf("expected sidecars to wait for the init job, found %v, %v", initialized, err)
+	}
+	job := initJob()
+	if initialized, err := c.ensureRepositoryInitialized(restic, db); err != nil || initialized {
+		t.Fatalf("expected sidecars to wait for the running init job, found %v, %v", initialized, err)
+	}
+	if n := createdJobs(); n != 1 {
+		t.Fatalf("expected 1 init job, found %d", n)
+	}
+	if prefixes := util.InitJobPrefixes(job); len(prefixes) != 1 || prefixes[0] != "deployment/db" {
+		t.Errorf("expected init job for repository deployment/db, found %v", prefixes)
+	}
+
+	// the finished job sets the condition and requeues the Restic
+	job.Status.Succeeded = 1
+	if job, err := k8sClient.BatchV1().Jobs(job.Namespace).UpdateStatus(job); err != nil {
+		t.Fatal(err)
+	} else {
+		c.jobIndexer.Update(job)
+	}
+	if err := c.runJobInjector(restic.Namespace + "/" + job.Name); err != nil {
+		t.Fatal(err)
+	}
+	patched := false
+	for _, action := range stashClient.Actions() {
+		if action.GetVerb() == "patch" && action.GetResource().Resource == "restics" {
+			patched = true
+		}
+	}
+	if !patched || c.rstQueue.Len() != 1 {
+		t.Errorf("expected Restic to be patched and requeued, found actions %v", stashClient.Actions())
+	}
+	k8sClient.ClearActions()
+	if initialized, err := c.ensureRepositoryInitialized(restic, db); err != nil || !initialized {
+		t.Errorf("expected repository to be initialized once the init job succeeded, found %v, %v", initialized, err)
+	}
+	if len(k8sClient.Actions()) != 0 {
+		t.Errorf("expected the init job to be kept, found actions %v", k8sClient.Actions())
+	}
+
+	// repositories of StatefulSet pods are initialized by their sidecars
+	if initialized, err := c.ensureRepositoryInitialized(restic, api.LocalTypedReference{Kind: api.KindStatefulSet, Name: "db"}); err != nil || !initialized {
+		t.Errorf("expected no init job for StatefulSet, found %v, %v", initialized, err)
+	}
+
+	// a workload matched later gets a new job initializing its repository
+	web := api.LocalTypedReference{Kind: api.KindDeployment, Name: "web"}
+	if initialized, err := c.ensureRepositoryInitialized(restic, web); err != nil || initialized {
+		t.Fatalf("expected sidecar of %s to wait for the init job, found %v, %v", web.Name, initialized, err)
+	}
+	job = initJob()
+	if prefixes := util.InitJobPrefixes(job); len(prefixes) != 2 || prefixes[0] != "deployment/db" || prefixes[1] != "deployment/web" {
+		t.Errorf("expected init job for repositories deployment/db and deployment/web, found %v", prefixes)
+	}
+
+	// a failed job is replaced
+	job.Status.Conditions = []batch.JobCondition{{Type: batch.JobFailed, Status: core.ConditionTrue}}
+	c.jobIndexer.Update(job)
+	k8sClient.ClearActions()
+	if initialized, err := c.ensureRepositoryInitialized(restic, db); err != nil || initialized {
+		t.Fatalf("expected sidecars to wait for the new init job, found %v, %v", initialized, err)
+	}
+	if n := createdJobs(); n != 1 {
+		t.Errorf("expected failed init job to be replaced, found actions %v", k8sClient.Actions())
+	}
+}
+
+// initializedJobLister returns a job lister with a succeeded init job of restic for the shared
+// repositories of workloads.
+func initializedJobLister(t *testing.T, restic *api.Restic, workloads ...api.LocalTypedReference) batch_listers.JobLister {
+	var prefixes []string
+	for _, workload := range workloads {
+		_, prefix, err := workload.HostnamePrefix("", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		prefixes = append(prefixes, prefix)
+	}
+	job, err := util.CreateInitJob(restic, prefixes, "canary")
+	if err != nil {
+		t.Fatal(err)
+	}
+	job.Status.Succeeded = 1
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	indexer.Add(job)
+	return batch_listers.NewJobLister(indexer)
+}
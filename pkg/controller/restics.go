@@ -2,11 +2,14 @@ package controller
 
 import (
 	"fmt"
+	"reflect"
+	"time"
 
 	"github.com/appscode/go/log"
 	ext_util "github.com/appscode/kutil/extensions/v1beta1"
 	api "github.com/appscode/stash/apis/stash/v1alpha1"
 	stash_listers "github.com/appscode/stash/listers/stash/v1alpha1"
+	"github.com/appscode/stash/pkg/docker"
 	"github.com/appscode/stash/pkg/eventer"
 	"github.com/appscode/stash/pkg/util"
 	"github.com/golang/glog"
@@ -25,10 +28,10 @@ import (
 func (c *StashController) initResticWatcher() {
 	lw := &cache.ListWatch{
 		ListFunc: func(options metav1.ListOptions) (rt.Object, error) {
-			return c.stashClient.Restics(core.NamespaceAll).List(options)
+			return c.stashClient.Restics(c.options.watchNamespace()).List(options)
 		},
 		WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
-			return c.stashClient.Restics(core.NamespaceAll).Watch(options)
+			return c.stashClient.Restics(c.options.watchNamespace()).Watch(options)
 		},
 	}
 
@@ -70,6 +73,10 @@ func (c *StashController) initResticWatcher() {
 				log.Errorln("Invalid Restic object")
 				return
 			}
+			// EnsureSidecarDeleted only finds the former target if it is watched, so enqueue it right away
+			if oldObj.Spec.Target != nil && !reflect.DeepEqual(oldObj.Spec.Target, newObj.Spec.Target) {
+				c.enqueueTarget(oldObj)
+			}
 			if err := newObj.IsValid(); err != nil {
 				c.recorder.Eventf(
 					newObj.ObjectReference(),
@@ -87,6 +94,9 @@ func (c *StashController) initResticWatcher() {
 			}
 		},
 		DeleteFunc: func(obj interface{}) {
+			if r, ok := obj.(*api.Restic); ok && r.Spec.Target != nil {
+				c.enqueueTarget(r)
+			}
 			// IndexerInformer uses a delta queue, therefore for deletes we have to use this
 			// key function.
 			key, err := cache.DeletionHandlingMetaNamespaceKeyFunc(obj)
@@ -98,6 +108,36 @@ func (c *StashController) initResticWatcher() {
 	c.rstLister = stash_listers.NewResticLister(c.rstIndexer)
 }
 
+// checkImageVersion checks that a tag of a stash image exists. It is a variable, so that tests don't
+// access Docker Hub.
+var checkImageVersion = docker.CheckDockerImageVersion
+
+// versionTagRecheckInterval is how long a Restic waits before its version tag is checked again when
+// the registry could not be asked, e.g. because it was unreachable.
+var versionTagRecheckInterval = time.Minute
+
+// checkVersionTag checks that the stash image with the tag set by the api.VersionTag annotation of r
+// exists, as a typo in the annotation would make the sidecar crash-loop. It returns ok false if the
+// image does not exist. Other registry errors, e.g. the registry being unreachable, are returned as
+// err, so that the check is retried. It is skipped if Options.SkipVersionTagCheck is set.
+func (c *StashController) checkVersionTag(r *api.Restic) (ok bool, err error) {
+	if c.options.SkipVersionTagCheck {
+		return true, nil
+	}
+	// the tag of the operator is checked on startup
+	tag, found := r.Annotations[api.VersionTag]
+	if !found || tag == c.options.SidecarImageTag {
+		return true, nil
+	}
+	if err = checkImageVersion(docker.ImageOperator, tag); err != nil {
+		if docker.IsManifestUnknown(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
 func (c *StashController) runResticWatcher() {
 	for c.processNextRestic() {
 	}
@@ -165,6 +205,23 @@ func (c *StashController) runResticInjector(key string) error {
 		d := obj.(*api.Restic)
 		fmt.Printf("Sync/Add/Update for Restic %s\n", d.GetName())
 
+		if ok, err := c.checkVersionTag(d); err != nil {
+			log.Warningf("Failed to check image tag of Restic %s, retrying in %s. Reason: %s", key, versionTagRecheckInterval, err)
+			c.rstQueue.AddAfter(key, versionTagRecheckInterval)
+			return nil
+		} else if !ok {
+			c.recorder.Eventf(
+				d.ObjectReference(),
+				core.EventTypeWarning,
+				eventer.EventReasonInvalidRestic,
+				"Reason image %s:%s of annotation %s not found",
+				docker.ImageOperator,
+				d.Annotations[api.VersionTag],
+				api.VersionTag,
+			)
+			return nil
+		}
+
 		if d.Spec.Type == api.BackupOffline {
 			job := util.CreateCronJobForDeletingPods(d, c.options.KubectlImageTag)
 
@@ -187,6 +244,10 @@ func (c *StashController) runResticInjector(key string) error {
 }
 
 func (c *StashController) EnsureSidecar(restic *api.Restic) {
+	if restic.Spec.Target != nil {
+		c.ensureTargetSidecar(restic)
+		return
+	}
 	sel, err := metav1.LabelSelectorAsSelector(&restic.Spec.Selector)
 	if err != nil {
 		c.recorder.Eventf(
@@ -254,21 +315,77 @@ func (c *StashController) EnsureSidecar(restic *api.Restic) {
 	}
 }
 
+// ensureTargetSidecar enqueues the workload referred by spec.target of restic.
+func (c *StashController) ensureTargetSidecar(restic *api.Restic) {
+	target := *restic.Spec.Target
+	if err := target.Canonicalize(); err != nil {
+		c.recorder.Eventf(
+			restic.ObjectReference(),
+			core.EventTypeWarning,
+			eventer.EventReasonInvalidRestic,
+			"Reason: %s",
+			err.Error(),
+		)
+		return
+	}
+	if target.Kind == api.KindPod {
+		c.ensurePodSidecar(restic, target.Name)
+		return
+	}
+	c.enqueueTarget(restic)
+}
+
+// enqueueTarget adds the workload referred by spec.target of restic to the queue of its kind, so that
+// its sidecar is added or removed. Bare pods can't be changed and have no queue.
+func (c *StashController) enqueueTarget(restic *api.Restic) {
+	target := *restic.Spec.Target
+	if err := target.Canonicalize(); err != nil {
+		return
+	}
+	key := restic.Namespace + "/" + target.Name
+	switch target.Kind {
+	case api.KindDeployment:
+		c.dpQueue.Add(key)
+	case api.KindDaemonSet:
+		c.dsQueue.Add(key)
+	case api.KindStatefulSet:
+		c.ssQueue.Add(key)
+	case api.KindReplicationController:
+		c.rcQueue.Add(key)
+	case api.KindReplicaSet:
+		c.rsQueue.Add(key)
+	case api.KindDeploymentConfig:
+		c.dcQueue.Add(key)
+	}
+}
+
+// ensurePodSidecar warns when a bare pod targeted by restic is missing the sidecar.
+// Containers of a running pod can not be changed, so the pod has to be recreated by the user.
+func (c *StashController) ensurePodSidecar(restic *api.Restic, name string) {
+	pod, err := c.k8sClient.CoreV1().Pods(restic.Namespace).Get(name, metav1.GetOptions{})
+	if err != nil {
+		log.Errorf("Failed to get pod %s/%s. Reason: %s", restic.Namespace, name, err)
+		return
+	}
+	if util.HasStashSidecar(pod.Spec) {
+		return
+	}
+	c.recorder.Eventf(
+		restic.ObjectReference(),
+		core.EventTypeWarning,
+		eventer.EventReasonPodRecreationRequired,
+		"Pod %s/%s must be recreated manually with the stash sidecar to start backup",
+		pod.Namespace,
+		pod.Name,
+	)
+}
+
 func (c *StashController) EnsureSidecarDeleted(namespace, name string) {
 	if resources, err := c.dpLister.Deployments(namespace).List(labels.Everything()); err == nil {
 		for _, resource := range resources {
-			restic, err := util.GetAppliedRestic(resource.Annotations)
-			if err != nil {
-				if ref, e2 := reference.GetReference(scheme.Scheme, resource); e2 == nil {
-					c.recorder.Eventf(
-						ref,
-						core.EventTypeWarning,
-						eventer.EventReasonInvalidRestic,
-						"Reason: %s",
-						err.Error(),
-					)
-				}
-			} else if restic != nil && restic.Namespace == namespace && restic.Name == name {
+			ref, _ := reference.GetReference(scheme.Scheme, resource)
+			restic, err := util.GetAppliedResticWithEvent(resource.Annotations, c.recorder, ref)
+			if err == nil && restic != nil && restic.Namespace == namespace && restic.Name == name {
 				key, err := cache.MetaNamespaceKeyFunc(resource)
 				if err == nil {
 					c.dpQueue.Add(key)
@@ -278,18 +395,9 @@ func (c *StashController) EnsureSidecarDeleted(namespace, name string) {
 	}
 	if resources, err := c.dsLister.DaemonSets(namespace).List(labels.Everything()); err == nil {
 		for _, resource := range resources {
-			restic, err := util.GetAppliedRestic(resource.Annotations)
-			if err != nil {
-				if ref, e2 := reference.GetReference(scheme.Scheme, resource); e2 == nil {
-					c.recorder.Eventf(
-						ref,
-						core.EventTypeWarning,
-						eventer.EventReasonInvalidRestic,
-						"Reason: %s",
-						err.Error(),
-					)
-				}
-			} else if restic != nil && restic.Namespace == namespace && restic.Name == name {
+			ref, _ := reference.GetReference(scheme.Scheme, resource)
+			restic, err := util.GetAppliedResticWithEvent(resource.Annotations, c.recorder, ref)
+			if err == nil && restic != nil && restic.Namespace == namespace && restic.Name == name {
 				key, err := cache.MetaNamespaceKeyFunc(resource)
 				if err == nil {
 					c.dsQueue.Add(key)
@@ -318,18 +426,9 @@ func (c *StashController) EnsureSidecarDeleted(namespace, name string) {
 	//}
 	if resources, err := c.rcLister.ReplicationControllers(namespace).List(labels.Everything()); err == nil {
 		for _, resource := range resources {
-			restic, err := util.GetAppliedRestic(resource.Annotations)
-			if err != nil {
-				if ref, e2 := reference.GetReference(scheme.Scheme, resource); e2 == nil {
-					c.recorder.Eventf(
-						ref,
-						core.EventTypeWarning,
-						eventer.EventReasonInvalidRestic,
-						"Reason: %s",
-						err.Error(),
-					)
-				}
-			} else if restic != nil && restic.Namespace == namespace && restic.Name == name {
+			ref, _ := reference.GetReference(scheme.Scheme, resource)
+			restic, err := util.GetAppliedResticWithEvent(resource.Annotations, c.recorder, ref)
+			if err == nil && restic != nil && restic.Namespace == namespace && restic.Name == name {
 				key, err := cache.MetaNamespaceKeyFunc(resource)
 				if err == nil {
 					c.rcQueue.Add(key)
@@ -339,18 +438,9 @@ func (c *StashController) EnsureSidecarDeleted(namespace, name string) {
 	}
 	if resources, err := c.rsLister.ReplicaSets(namespace).List(labels.Everything()); err == nil {
 		for _, resource := range resources {
-			restic, err := util.GetAppliedRestic(resource.Annotations)
-			if err != nil {
-				if ref, e2 := reference.GetReference(scheme.Scheme, resource); e2 == nil {
-					c.recorder.Eventf(
-						ref,
-						core.EventTypeWarning,
-						eventer.EventReasonInvalidRestic,
-						"Reason: %s",
-						err.Error(),
-					)
-				}
-			} else if restic != nil && restic.Namespace == namespace && restic.Name == name {
+			ref, _ := reference.GetReference(scheme.Scheme, resource)
+			restic, err := util.GetAppliedResticWithEvent(resource.Annotations, c.recorder, ref)
+			if err == nil && restic != nil && restic.Namespace == namespace && restic.Name == name {
 				key, err := cache.MetaNamespaceKeyFunc(resource)
 				if err == nil {
 					c.rsQueue.Add(key)
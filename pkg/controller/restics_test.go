@@ -0,0 +1,148 @@
+package controller
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	api "github.com/appscode/stash/apis/stash/v1alpha1"
+	"github.com/appscode/stash/pkg/eventer"
+	"github.com/appscode/stash/pkg/util"
+	registry "github.com/heroku/docker-registry-client/registry"
+	core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/workqueue"
+)
+
+func TestEnsureSidecarPodTarget(t *testing.T) {
+	restic := &api.Restic{
+		ObjectMeta: metav1.ObjectMeta{Name: "db-backup", Namespace: "default"},
+		Spec: api.ResticSpec{
+			Target: &api.LocalTypedReference{Kind: "pod", Name: "db"},
+		},
+	}
+	bare := &core.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "db", Namespace: "default"},
+		Spec:       core.PodSpec{Containers: []core.Container{{Name: "db"}}},
+	}
+
+	recorder := record.NewFakeRecorder(10)
+	c := &StashController{k8sClient: fake.NewSimpleClientset(bare), recorder: recorder}
+	c.EnsureSidecar(restic)
+	select {
+	case e := <-recorder.Events:
+		if !strings.Contains(e, eventer.EventReasonPodRecreationRequired) || !strings.Contains(e, "default/db") {
+			t.Errorf("unexpected event %q", e)
+		}
+	default:
+		t.Fatal("expected warning event for pod without sidecar")
+	}
+
+	injected := bare.DeepCopy()
+	injected.Spec.Containers = append(injected.Spec.Containers, core.Container{Name: util.StashContainer})
+	recorder = record.NewFakeRecorder(10)
+	c = &StashController{k8sClient: fake.NewSimpleClientset(injected), recorder: recorder}
+	c.EnsureSidecar(restic)
+	select {
+	case e := <-recorder.Events:
+		t.Errorf("unexpected event %q", e)
+	default:
+	}
+}
+
+func TestEnqueueTarget(t *testing.T) {
+	newQueue := func() workqueue.RateLimitingInterface {
+		return workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter())
+	}
+	c := &StashController{
+		dpQueue: newQueue(),
+		dsQueue: newQueue(),
+		ssQueue: newQueue(),
+		rcQueue: newQueue(),
+		rsQueue: newQueue(),
+		dcQueue: newQueue(),
+	}
+	cases := map[string]workqueue.RateLimitingInterface{
+		"deployments":       c.dpQueue,
+		"ds":                c.dsQueue,
+		"StatefulSet":       c.ssQueue,
+		"rc":                c.rcQueue,
+		"ReplicaSet":        c.rsQueue,
+		"deploymentconfigs": c.dcQueue,
+	}
+	for kind, queue := range cases {
+		restic := &api.Restic{
+			ObjectMeta: metav1.ObjectMeta{Name: "db-backup", Namespace: "default"},
+			Spec:       api.ResticSpec{Target: &api.LocalTypedReference{Kind: kind, Name: "db"}},
+		}
+		c.enqueueTarget(restic)
+		if queue.Len() != 1 {
+			t.Fatalf("%s: expected target to be enqueued", kind)
+		}
+		if key, _ := queue.Get(); key != "default/db" {
+			t.Errorf("%s: unexpected key %v", kind, key)
+		}
+		queue.Done("default/db")
+	}
+}
+
+func TestCheckVersionTag(t *testing.T) {
+	defer func(f func(string, string) error) { checkImageVersion = f }(checkImageVersion)
+	var checked []string
+	checkImageVersion = func(repository, tag string) error {
+		checked = append(checked, repository+":"+tag)
+		switch tag {
+		case "0.5.1":
+			return nil
+		case "0.5.2":
+			return &url.Error{Op: "Get", URL: "https://registry-1.docker.io/", Err: errors.New("connection refused")}
+		}
+		return &url.Error{Op: "Get", URL: "https://registry-1.docker.io/", Err: &registry.HttpStatusError{
+			Response: &http.Response{StatusCode: http.StatusNotFound},
+		}}
+	}
+
+	cases := map[string]struct {
+		tag     string
+		skip    bool
+		ok      bool
+		err     bool
+		checked bool
+	}{
+		"no annotation":  {"", false, true, false, false},
+		"existing tag":   {"0.5.1", false, true, false, true},
+		"missing tag":    {"0.5.l", false, false, false, true},
+		"registry down":  {"0.5.2", false, false, true, true},
+		"operator tag":   {"0.6.0", false, true, false, false},
+		"check disabled": {"0.5.l", true, true, false, false},
+	}
+	for name, tc := range cases {
+		checked = nil
+		restic := &api.Restic{
+			ObjectMeta: metav1.ObjectMeta{Name: "db-backup", Namespace: "default"},
+			Spec: api.ResticSpec{
+				Selector: metav1.LabelSelector{MatchLabels: map[string]string{"app": "db"}},
+				Schedule: "@every 1m",
+				Backend:  api.Backend{StorageSecretName: "secret"},
+			},
+		}
+		if tc.tag != "" {
+			restic.Annotations = map[string]string{api.VersionTag: tc.tag}
+		}
+		c := &StashController{options: Options{SidecarImageTag: "0.6.0", SkipVersionTagCheck: tc.skip}}
+		ok, err := c.checkVersionTag(restic)
+		if tc.ok != ok {
+			t.Errorf("%s: expected ok %v, found %v", name, tc.ok, ok)
+		}
+		if tc.err != (err != nil) {
+			t.Errorf("%s: expected error %v, found %v", name, tc.err, err)
+		}
+		if tc.checked != (len(checked) == 1) {
+			t.Errorf("%s: expected image check %v, found checks %v", name, tc.checked, checked)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+
+	core_util "github.com/appscode/kutil/core/v1"
+	api "github.com/appscode/stash/apis/stash/v1alpha1"
+	"github.com/appscode/stash/pkg/eventer"
+	"github.com/appscode/stash/pkg/util"
+	"github.com/ghodss/yaml"
+	core "k8s.io/api/core/v1"
+)
+
+// upsertSidecar adds the stash sidecar, or init container for offline backup, of Restic new to the
+// pod template of workload, along with the volumes it needs. old is the Restic applied before, if any.
+// It is shared by the workload controllers and the mutating webhook. The template is left unchanged
+// if the sidecar can't be created, or if the repository shared by the replicas of workload is not
+// initialized yet. The Restic is requeued once its init job finished.
+func (c *StashController) upsertSidecar(template *core.PodTemplateSpec, workload api.LocalTypedReference, old, new *api.Restic) error {
+	// every pod of these kinds backs up on its own, their restic processes would share one cache
+	if new.Spec.Cache != nil && (workload.Kind == api.KindStatefulSet || workload.Kind == api.KindDaemonSet) {
+		err := fmt.Errorf("spec.cache can't be used for %s %s/%s, its pods back up concurrently", workload.Kind, new.Namespace, workload.Name)
+		c.recorder.Event(new.ObjectReference(), core.EventTypeWarning, eventer.EventReasonInvalidRestic, err.Error())
+		return err
+	}
+
+	container, err := c.stashContainer(new, workload)
+	if err != nil {
+		return err
+	}
+	if initialized, err := c.ensureRepositoryInitialized(new, workload); err != nil {
+		return err
+	} else if !initialized {
+		return fmt.Errorf("repository of %s %s/%s is not initialized yet", workload.Kind, new.Namespace, workload.Name)
+	}
+	if new.Spec.Type == api.BackupOffline {
+		template.Spec.InitContainers = core_util.UpsertContainer(template.Spec.InitContainers, container)
+	} else {
+		template.Spec.Containers = core_util.UpsertContainer(template.Spec.Containers, container)
+	}
+	template.Spec.Volumes = util.UpsertScratchVolume(template.Spec.Volumes, new)
+	template.Spec.Volumes = util.UpsertDownwardVolume(template.Spec.Volumes)
+	template.Spec.Volumes = util.MergeCacheVolume(template.Spec.Volumes, new)
+	template.Spec.Volumes = util.MergeBackendVolumes(template.Spec.Volumes, old, new)
+	template.Spec.PriorityClassName = util.MergePriorityClassName(template.Spec.PriorityClassName, old, new)
+	return nil
+}
+
+// stashContainer returns the stash sidecar, or init container for offline backup, of restic for workload,
+// using the image, log level, security context and default resources configured for the operator.
+func (c *StashController) stashContainer(restic *api.Restic, workload api.LocalTypedReference) (core.Container, error) {
+	var container core.Container
+	var err error
+	if restic.Spec.Type == api.BackupOffline {
+		if container, err = util.CreateInitContainer(restic, c.options.SidecarImageTag, c.options.SidecarImageDigest, workload, c.options.EnableRBAC, c.options.defaultSidecarSecurityContext()); err != nil {
+			return container, err
+		}
+		container.Args = append(container.Args, util.JobDefaultResourceArgs(c.options.SidecarDefaultResources)...)
+	} else if container, err = util.CreateSidecarContainer(restic, c.options.SidecarImageTag, c.options.SidecarImageDigest, workload, c.options.LogLevel, c.options.defaultSidecarSecurityContext()); err != nil {
+		return container, err
+	}
+	container.Resources = util.ApplyDefaultResources(container.Resources, c.options.SidecarDefaultResources)
+	return container, nil
+}
+
+// RenderSidecar returns the stash container upsertSidecar injects into workload for restic as indented
+// JSON, or as YAML if asYAML is set, the way kubectl prints objects, so that users can see what is injected.
+func (c *StashController) RenderSidecar(restic *api.Restic, workload api.LocalTypedReference, asYAML bool) ([]byte, error) {
+	container, err := c.stashContainer(restic, workload)
+	if err != nil {
+		return nil, err
+	}
+	if asYAML {
+		return yaml.Marshal(container)
+	}
+	return json.MarshalIndent(container, "", "    ")
+}
+
+// removeSidecar removes the stash sidecar, or init container for offline backup, of restic from the
+// pod template along with the scratch, podinfo, cache and backend volumes added by upsertSidecar.
+func (c *StashController) removeSidecar(template *core.PodTemplateSpec, restic *api.Restic) {
+	if restic.Spec.Type == api.BackupOffline {
+		template.Spec.InitContainers = core_util.EnsureContainerDeleted(template.Spec.InitContainers, util.StashContainer)
+	} else {
+		template.Spec.Containers = core_util.EnsureContainerDeleted(template.Spec.Containers, util.StashContainer)
+	}
+	template.Spec.Volumes = util.EnsureVolumeDeleted(template.Spec.Volumes, util.ScratchDirVolumeName)
+	template.Spec.Volumes = util.EnsureVolumeDeleted(template.Spec.Volumes, util.PodinfoVolumeName)
+	template.Spec.Volumes = util.EnsureVolumeDeleted(template.Spec.Volumes, util.CacheVolumeName)
+	template.Spec.Volumes = util.EnsureBackendVolumesDeleted(template.Spec.Volumes, restic)
+	template.Spec.PriorityClassName = util.EnsurePriorityClassNameDeleted(template.Spec.PriorityClassName, restic)
+}
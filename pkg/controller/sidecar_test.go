@@ -0,0 +1,161 @@
+package controller
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	api "github.com/appscode/stash/apis/stash/v1alpha1"
+	core "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+func TestRemoveSidecar(t *testing.T) {
+	workload := api.LocalTypedReference{Kind: api.KindDeployment, Name: "db"}
+	for _, backupType := range []api.BackupType{api.BackupOnline, api.BackupOffline} {
+		restic := &api.Restic{ObjectMeta: metav1.ObjectMeta{Name: "db-backup", Namespace: "default"}, Spec: api.ResticSpec{
+			Type: backupType,
+			Backend: api.Backend{
+				StorageSecretName: "backend-secret",
+				Local: &api.LocalSpec{
+					VolumeSource: core.VolumeSource{HostPath: &core.HostPathVolumeSource{Path: "/data/stash"}},
+					Path:         "/safe/data",
+				},
+			},
+		}}
+		original := core.PodTemplateSpec{Spec: core.PodSpec{
+			Containers: []core.Container{{Name: "db"}},
+			Volumes:    []core.Volume{{Name: "data"}},
+		}}
+
+		c := &StashController{jobLister: initializedJobLister(t, restic, workload)}
+		template := original.DeepCopy()
+		if err := c.upsertSidecar(template, workload, nil, restic); err != nil {
+			t.Fatalf("%s: unexpected error: %s", backupType, err)
+		}
+		if len(template.Spec.Containers)+len(template.Spec.InitContainers) != 2 || len(template.Spec.Volumes) != 4 {
+			t.Fatalf("%s: expected stash container and volumes, found %+v", backupType, template.Spec)
+		}
+		c.removeSidecar(template, restic)
+		if !podSpecEqualNames(template.Spec, original.Spec) {
+			t.Errorf("%s: expected pod spec %+v after removing sidecar, found %+v", backupType, original.Spec, template.Spec)
+		}
+
+		// removing again leaves the template unchanged
+		c.removeSidecar(template, restic)
+		if !podSpecEqualNames(template.Spec, original.Spec) {
+			t.Errorf("%s: expected pod spec to be unchanged without sidecar, found %+v", backupType, template.Spec)
+		}
+	}
+}
+
+func TestUpsertSidecarError(t *testing.T) {
+	restic := &api.Restic{Spec: api.ResticSpec{
+		Backend: api.Backend{Local: &api.LocalSpec{}},
+	}}
+	original := core.PodTemplateSpec{Spec: core.PodSpec{
+		Containers: []core.Container{{Name: "db"}},
+	}}
+
+	c := &StashController{}
+	template := original.DeepCopy()
+	if err := c.upsertSidecar(template, api.LocalTypedReference{Kind: api.KindDeployment, Name: "db"}, nil, restic); err == nil {
+		t.Fatal("expected error for local backend without path")
+	}
+	if !podSpecEqualNames(template.Spec, original.Spec) {
+		t.Errorf("expected pod spec to be unchanged on error, found %+v", template.Spec)
+	}
+}
+
+func TestUpsertSidecarCache(t *testing.T) {
+	restic := &api.Restic{ObjectMeta: metav1.ObjectMeta{Name: "db-backup", Namespace: "default"}, Spec: api.ResticSpec{
+		Backend: api.Backend{
+			StorageSecretName: "backend-secret",
+			Local: &api.LocalSpec{
+				VolumeSource: core.VolumeSource{HostPath: &core.HostPathVolumeSource{Path: "/data/stash"}},
+				Path:         "/safe/data",
+			},
+		},
+		Cache: &api.CacheSpec{ClaimName: "restic-cache"},
+	}}
+	for kind, valid := range map[string]bool{
+		api.KindDeployment:  true,
+		api.KindStatefulSet: false,
+		api.KindDaemonSet:   false,
+	} {
+		workload := api.LocalTypedReference{Kind: kind, Name: "db"}
+		c := &StashController{recorder: record.NewFakeRecorder(10)}
+		if valid {
+			c.jobLister = initializedJobLister(t, restic, workload)
+		}
+		template := &core.PodTemplateSpec{Spec: core.PodSpec{Containers: []core.Container{{Name: "db"}}}}
+		err := c.upsertSidecar(template, workload, nil, restic)
+		if valid && err != nil {
+			t.Errorf("%s: unexpected error: %s", kind, err)
+		} else if !valid && (err == nil || len(template.Spec.Containers) != 1) {
+			t.Errorf("%s: expected shared cache to be rejected, found %v", kind, err)
+		}
+	}
+}
+
+// podSpecEqualNames compares the names of the containers and volumes of pod specs.
+func podSpecEqualNames(x, y core.PodSpec) bool {
+	names := func(spec core.PodSpec) []string {
+		var result []string
+		for _, c := range spec.InitContainers {
+			result = append(result, "init/"+c.Name)
+		}
+		for _, c := range spec.Containers {
+			result = append(result, "container/"+c.Name)
+		}
+		for _, v := range spec.Volumes {
+			result = append(result, "volume/"+v.Name)
+		}
+		return result
+	}
+	return reflect.DeepEqual(names(x), names(y)) && x.PriorityClassName == y.PriorityClassName
+}
+
+func TestRenderSidecar(t *testing.T) {
+	r := &api.Restic{
+		ObjectMeta: metav1.ObjectMeta{Name: "stash-demo", Namespace: "default"},
+		Spec: api.ResticSpec{
+			FileGroups: []api.FileGroup{{Path: "/source/data"}},
+			Backend: api.Backend{
+				StorageSecretName: "stash-demo",
+				Local: &api.LocalSpec{
+					Path:         "/safe/data",
+					VolumeSource: core.VolumeSource{HostPath: &core.HostPathVolumeSource{Path: "/data/stash-test/restic-repo"}},
+				},
+			},
+			VolumeMounts: []core.VolumeMount{{Name: "source-data", MountPath: "/source/data"}},
+		},
+	}
+	c := &StashController{options: Options{
+		SidecarImageTag:                     "0.5.1",
+		SidecarImageDigest:                  "sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef",
+		LogLevel:                            5,
+		EnableDefaultSidecarSecurityContext: true,
+		SidecarDefaultResources: core.ResourceRequirements{
+			Requests: core.ResourceList{core.ResourceCPU: resource.MustParse("100m")},
+		},
+	}}
+	workload := api.LocalTypedReference{Kind: api.KindDeployment, Name: "stash-demo"}
+	for golden, asYAML := range map[string]bool{"sidecar.json": false, "sidecar.yaml": true} {
+		rendered, err := c.RenderSidecar(r, workload, asYAML)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected, err := ioutil.ReadFile(filepath.Join("testdata", golden))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.TrimSuffix(string(rendered), "\n") != strings.TrimSuffix(string(expected), "\n") {
+			t.Errorf("rendered sidecar differs from testdata/%s, found:\n%s", golden, rendered)
+		}
+	}
+}
@@ -6,13 +6,11 @@ import (
 	"github.com/appscode/go/log"
 	stringz "github.com/appscode/go/strings"
 	apps_util "github.com/appscode/kutil/apps/v1beta1"
-	core_util "github.com/appscode/kutil/core/v1"
 	"github.com/appscode/kutil/meta"
 	api "github.com/appscode/stash/apis/stash/v1alpha1"
 	"github.com/appscode/stash/pkg/util"
 	"github.com/golang/glog"
 	apps "k8s.io/api/apps/v1beta1"
-	core "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	rt "k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/util/runtime"
@@ -28,11 +26,11 @@ func (c *StashController) initStatefulSetWatcher() {
 	lw := &cache.ListWatch{
 		ListFunc: func(options metav1.ListOptions) (rt.Object, error) {
 			options.IncludeUninitialized = true
-			return c.k8sClient.AppsV1beta1().StatefulSets(core.NamespaceAll).List(options)
+			return c.k8sClient.AppsV1beta1().StatefulSets(c.options.watchNamespace()).List(options)
 		},
 		WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
 			options.IncludeUninitialized = true
-			return c.k8sClient.AppsV1beta1().StatefulSets(core.NamespaceAll).Watch(options)
+			return c.k8sClient.AppsV1beta1().StatefulSets(c.options.watchNamespace()).Watch(options)
 		},
 	}
 
@@ -136,15 +134,20 @@ func (c *StashController) runStatefulSetInjector(key string) error {
 
 		if util.ToBeInitializedBySelf(ss.Initializers) {
 			// StatefulSets are supported during initializer phase
-			oldRestic, err := util.GetAppliedRestic(ss.Annotations)
+			ref, _ := reference.GetReference(scheme.Scheme, ss)
+			oldRestic, err := util.GetAppliedResticWithEvent(ss.Annotations, c.recorder, ref)
 			if err != nil {
 				return err
 			}
-			newRestic, err := util.FindRestic(c.rstLister, ss.ObjectMeta)
+			newRestic, err := util.FindRestic(c.rstLister, api.KindStatefulSet, ss.ObjectMeta)
 			if err != nil {
 				log.Errorf("Error while searching Restic for StatefulSet %s/%s.", ss.Name, ss.Namespace)
 				return err
 			}
+			if newRestic != nil && util.SkipInjection(ss.Annotations, ss.Spec.Template.Annotations) {
+				log.Infof("Skipping stash sidecar for StatefulSet %s/%s", ss.Namespace, ss.Name)
+				newRestic = nil
+			}
 			if util.ResticEqual(oldRestic, newRestic) {
 				return nil
 			}
@@ -195,6 +198,15 @@ func (c *StashController) EnsureStatefulSetSidecar(resource *apps.StatefulSet, o
 		}
 	}
 
+	workload := api.LocalTypedReference{
+		Kind: api.KindStatefulSet,
+		Name: resource.Name,
+	}
+	template := resource.Spec.Template.DeepCopy()
+	if err = c.upsertSidecar(template, workload, old, new); err != nil {
+		return
+	}
+
 	resource, err = apps_util.PatchStatefulSet(c.k8sClient, resource, func(obj *apps.StatefulSet) *apps.StatefulSet {
 		if util.ToBeInitializedBySelf(obj.Initializers) {
 			fmt.Println("Removing pending stash initializer for", obj.Name)
@@ -205,18 +217,7 @@ func (c *StashController) EnsureStatefulSetSidecar(resource *apps.StatefulSet, o
 			}
 		}
 
-		workload := api.LocalTypedReference{
-			Kind: api.KindStatefulSet,
-			Name: obj.Name,
-		}
-		if new.Spec.Type == api.BackupOffline {
-			obj.Spec.Template.Spec.InitContainers = core_util.UpsertContainer(obj.Spec.Template.Spec.InitContainers, util.CreateInitContainer(new, c.options.SidecarImageTag, workload, c.options.EnableRBAC))
-		} else {
-			obj.Spec.Template.Spec.Containers = core_util.UpsertContainer(obj.Spec.Template.Spec.Containers, util.CreateSidecarContainer(new, c.options.SidecarImageTag, workload))
-		}
-		obj.Spec.Template.Spec.Volumes = util.UpsertScratchVolume(obj.Spec.Template.Spec.Volumes)
-		obj.Spec.Template.Spec.Volumes = util.UpsertDownwardVolume(obj.Spec.Template.Spec.Volumes)
-		obj.Spec.Template.Spec.Volumes = util.MergeLocalVolume(obj.Spec.Template.Spec.Volumes, old, new)
+		obj.Spec.Template = *template
 
 		if obj.Annotations == nil {
 			obj.Annotations = make(map[string]string)
@@ -243,7 +244,7 @@ func (c *StashController) EnsureStatefulSetSidecar(resource *apps.StatefulSet, o
 	if err != nil {
 		return
 	}
-	err = util.WaitUntilSidecarAdded(c.k8sClient, resource.Namespace, resource.Spec.Selector, new.Spec.Type)
+	err = c.waitUntilSidecarAdded(api.KindStatefulSet, resource.Namespace, resource.Spec.Selector, new.Spec.Type)
 	return err
 }
 
@@ -256,16 +257,7 @@ func (c *StashController) EnsureStatefulSetSidecarDeleted(resource *apps.Statefu
 	}
 
 	resource, err = apps_util.PatchStatefulSet(c.k8sClient, resource, func(obj *apps.StatefulSet) *apps.StatefulSet {
-		if restic.Spec.Type == api.BackupOffline {
-			obj.Spec.Template.Spec.InitContainers = core_util.EnsureContainerDeleted(obj.Spec.Template.Spec.InitContainers, util.StashContainer)
-		} else {
-			obj.Spec.Template.Spec.Containers = core_util.EnsureContainerDeleted(obj.Spec.Template.Spec.Containers, util.StashContainer)
-		}
-		obj.Spec.Template.Spec.Volumes = util.EnsureVolumeDeleted(obj.Spec.Template.Spec.Volumes, util.ScratchDirVolumeName)
-		obj.Spec.Template.Spec.Volumes = util.EnsureVolumeDeleted(obj.Spec.Template.Spec.Volumes, util.PodinfoVolumeName)
-		if restic.Spec.Backend.Local != nil {
-			obj.Spec.Template.Spec.Volumes = util.EnsureVolumeDeleted(obj.Spec.Template.Spec.Volumes, util.LocalVolumeName)
-		}
+		c.removeSidecar(&obj.Spec.Template, restic)
 		if obj.Annotations != nil {
 			delete(obj.Annotations, api.LastAppliedConfiguration)
 			delete(obj.Annotations, api.VersionTag)
@@ -280,6 +272,6 @@ func (c *StashController) EnsureStatefulSetSidecarDeleted(resource *apps.Statefu
 	if err != nil {
 		return
 	}
-	err = util.WaitUntilSidecarRemoved(c.k8sClient, resource.Namespace, resource.Spec.Selector, restic.Spec.Type)
+	err = c.waitUntilSidecarRemoved(api.KindStatefulSet, resource.Namespace, resource.Spec.Selector, restic.Spec.Type)
 	return err
 }
@@ -0,0 +1,108 @@
+package controller
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/appscode/go/log"
+	api "github.com/appscode/stash/apis/stash/v1alpha1"
+	"github.com/appscode/stash/pkg/admission"
+	"github.com/appscode/stash/pkg/util"
+	core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// workloadObject holds the fields of a workload needed to inject the sidecar. It decodes every
+// supported workload kind in any API version.
+type workloadObject struct {
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+	Spec              struct {
+		Replicas *int32               `json:"replicas,omitempty"`
+		Template core.PodTemplateSpec `json:"template"`
+	} `json:"spec"`
+}
+
+// ServeMutatingWebhook injects the stash sidecar into the pod template of workloads matching a Restic
+// when they are created or updated. It replaces the initializer on clusters without initializers.
+// Only the pod template is changed. The regular sync of the workload records the applied Restic
+// and sets up RBAC afterwards, without changing the template again.
+func (c *StashController) ServeMutatingWebhook(w http.ResponseWriter, r *http.Request) {
+	review := admission.AdmissionReview{}
+	if err := json.NewDecoder(r.Body).Decode(&review); err != nil || review.Request == nil {
+		http.Error(w, "invalid admission review", http.StatusBadRequest)
+		return
+	}
+	review.Response = c.mutateWorkload(review.Request)
+	review.Request = nil
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(review); err != nil {
+		log.Errorln("Failed to write admission response. Reason:", err)
+	}
+}
+
+// mutateWorkload returns the admission response for req. Workloads are always admitted; if the
+// sidecar can not be injected, the regular sync of the workload adds it later.
+func (c *StashController) mutateWorkload(req *admission.AdmissionRequest) *admission.AdmissionResponse {
+	resp := &admission.AdmissionResponse{UID: req.UID, Allowed: true}
+	if req.Operation != admission.Create && req.Operation != admission.Update {
+		return resp
+	}
+
+	var obj workloadObject
+	if err := json.Unmarshal(req.Object.Raw, &obj); err != nil {
+		log.Errorf("Failed to decode %s %s/%s. Reason: %s", req.Kind.Kind, req.Namespace, req.Name, err)
+		return resp
+	}
+	if obj.Namespace == "" {
+		obj.Namespace = req.Namespace
+	}
+	// name of objects using generateName is not known yet, but needed by the sidecar
+	workload := api.LocalTypedReference{Kind: req.Kind.Kind, Name: obj.Name}
+	if err := workload.Canonicalize(); err != nil {
+		return resp
+	}
+	switch workload.Kind {
+	case api.KindDeployment, api.KindDaemonSet, api.KindReplicationController, api.KindStatefulSet:
+	case api.KindReplicaSet:
+		// pod template is copied from the Deployment, which already has the sidecar
+		for _, ref := range obj.OwnerReferences {
+			if ref.Kind == api.KindDeployment {
+				return resp
+			}
+		}
+	default:
+		return resp
+	}
+
+	restic, err := util.FindRestic(c.rstLister, workload.Kind, obj.ObjectMeta)
+	if err != nil {
+		log.Errorf("Error while searching Restic for %s %s/%s. Reason: %s", workload.Kind, obj.Namespace, obj.Name, err)
+		return resp
+	}
+	if restic == nil || util.SkipInjection(obj.Annotations, obj.Spec.Template.Annotations) {
+		return resp
+	}
+	if restic.Spec.Type == api.BackupOffline && workload.Kind == api.KindDeployment && obj.Spec.Replicas != nil && *obj.Spec.Replicas > 1 {
+		return resp
+	}
+	old, err := util.GetAppliedRestic(obj.Annotations)
+	if err != nil {
+		old = nil
+	}
+
+	template := obj.Spec.Template.DeepCopy()
+	if err := c.upsertSidecar(template, workload, old, restic); err != nil {
+		log.Errorf("Failed to add sidecar to %s %s/%s. Reason: %s", workload.Kind, obj.Namespace, obj.Name, err)
+		return resp
+	}
+	patch, err := json.Marshal([]admission.PatchOperation{{Op: "replace", Path: "/spec/template", Value: template}})
+	if err != nil {
+		log.Errorf("Failed to create patch for %s %s/%s. Reason: %s", workload.Kind, obj.Namespace, obj.Name, err)
+		return resp
+	}
+	patchType := admission.PatchTypeJSONPatch
+	resp.Patch = patch
+	resp.PatchType = &patchType
+	return resp
+}
@@ -0,0 +1,117 @@
+package controller
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	api "github.com/appscode/stash/apis/stash/v1alpha1"
+	stash_listers "github.com/appscode/stash/listers/stash/v1alpha1"
+	"github.com/appscode/stash/pkg/admission"
+	"github.com/appscode/stash/pkg/util"
+	apps "k8s.io/api/apps/v1beta1"
+	core "k8s.io/api/core/v1"
+	extensions "k8s.io/api/extensions/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/cache"
+)
+
+func reviewWorkload(t *testing.T, c *StashController, kind string, obj interface{}) *admission.AdmissionResponse {
+	raw, err := json.Marshal(obj)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := json.Marshal(admission.AdmissionReview{Request: &admission.AdmissionRequest{
+		UID:       "review-1",
+		Kind:      metav1.GroupVersionKind{Group: "apps", Version: "v1beta1", Kind: kind},
+		Namespace: "default",
+		Operation: admission.Create,
+		Object:    runtime.RawExtension{Raw: raw},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	c.ServeMutatingWebhook(w, httptest.NewRequest(http.MethodPost, "/mutate", bytes.NewReader(body)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("unexpected status %d: %s", w.Code, w.Body.String())
+	}
+	var review admission.AdmissionReview
+	if err := json.Unmarshal(w.Body.Bytes(), &review); err != nil {
+		t.Fatal(err)
+	}
+	if review.Response == nil || review.Response.UID != "review-1" || !review.Response.Allowed {
+		t.Fatalf("expected allowed response for review-1, found %+v", review.Response)
+	}
+	return review.Response
+}
+
+func TestServeMutatingWebhook(t *testing.T) {
+	restic := &api.Restic{
+		ObjectMeta: metav1.ObjectMeta{Name: "db-backup", Namespace: "default"},
+		Spec: api.ResticSpec{
+			Selector: metav1.LabelSelector{MatchLabels: map[string]string{"app": "db"}},
+			Type:     api.BackupOnline,
+		},
+	}
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	indexer.Add(restic)
+	c := &StashController{
+		rstLister: stash_listers.NewResticLister(indexer),
+		jobLister: initializedJobLister(t, restic, api.LocalTypedReference{Kind: api.KindDeployment, Name: "db"}),
+	}
+
+	deployment := &apps.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: "db", Namespace: "default", Labels: map[string]string{"app": "db"}},
+		Spec: apps.DeploymentSpec{
+			Template: core.PodTemplateSpec{Spec: core.PodSpec{Containers: []core.Container{{Name: "db"}}}},
+		},
+	}
+	resp := reviewWorkload(t, c, api.KindDeployment, deployment)
+	if resp.PatchType == nil || *resp.PatchType != admission.PatchTypeJSONPatch {
+		t.Fatalf("expected JSON patch, found %v", resp.PatchType)
+	}
+	var patch []struct {
+		Op    string               `json:"op"`
+		Path  string               `json:"path"`
+		Value core.PodTemplateSpec `json:"value"`
+	}
+	if err := json.Unmarshal(resp.Patch, &patch); err != nil {
+		t.Fatal(err)
+	}
+	if len(patch) != 1 || patch[0].Op != "replace" || patch[0].Path != "/spec/template" {
+		t.Fatalf("unexpected patch %s", resp.Patch)
+	}
+	if !util.HasStashSidecar(patch[0].Value.Spec) {
+		t.Errorf("expected stash sidecar in patched template, found %+v", patch[0].Value.Spec.Containers)
+	}
+
+	unmatched := deployment.DeepCopy()
+	unmatched.Labels = map[string]string{"app": "web"}
+	if resp := reviewWorkload(t, c, api.KindDeployment, unmatched); resp.Patch != nil {
+		t.Errorf("expected no patch for workload without Restic, found %s", resp.Patch)
+	}
+
+	skipped := deployment.DeepCopy()
+	skipped.Annotations = map[string]string{api.SkipInjection: "true"}
+	if resp := reviewWorkload(t, c, api.KindDeployment, skipped); resp.Patch != nil {
+		t.Errorf("expected no patch for workload annotated with %s, found %s", api.SkipInjection, resp.Patch)
+	}
+
+	owned := &extensions.ReplicaSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            "db-5d8f7",
+			Namespace:       "default",
+			Labels:          deployment.Labels,
+			OwnerReferences: []metav1.OwnerReference{{Kind: api.KindDeployment, Name: "db"}},
+		},
+		Spec: extensions.ReplicaSetSpec{Template: deployment.Spec.Template},
+	}
+	if resp := reviewWorkload(t, c, api.KindReplicaSet, owned); resp.Patch != nil {
+		t.Errorf("expected no patch for ReplicaSet owned by Deployment, found %s", resp.Patch)
+	}
+}
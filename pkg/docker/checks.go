@@ -1,7 +1,18 @@
 package docker
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/docker/distribution/manifest/schema2"
+	"github.com/docker/distribution/reference"
 	docker "github.com/heroku/docker-registry-client/registry"
+	digest "github.com/opencontainers/go-digest"
 )
 
 const (
@@ -10,12 +21,156 @@ const (
 	ImageKubectl  = "appscode/kubectl"
 )
 
+// RegistryConfig contains the address and credentials of a docker registry.
+type RegistryConfig struct {
+	URL      string
+	Username string
+	Password string
+}
+
+// ManifestCacheTTL is how long a successful image check is reused before the registry is asked again.
+// Docker Hub rate-limits anonymous manifest requests. Zero disables the cache.
+var ManifestCacheTTL = 10 * time.Minute
+
+// manifestCache holds the time of successful image checks, keyed by a hash of registry, credentials and
+// image, see manifestCacheKey. Failed checks are not cached, so missing images are found once they are
+// pushed. Expired checks are evicted whenever a check is cached.
+var manifestCache = struct {
+	sync.Mutex
+	checked map[string]time.Time
+}{checked: map[string]time.Time{}}
+
+// ValidateImageReference checks that image is a valid image reference, e.g. appscode/stash:0.5.1
+// or registry.example.com/stash@sha256:...
+func ValidateImageReference(image string) error {
+	_, err := reference.ParseNormalizedNamed(image)
+	return err
+}
+
+// CheckDockerImageVersion checks that the image exists in Docker Hub.
 func CheckDockerImageVersion(repository, reference string) error {
-	hub, err := docker.New(registryUrl, "", "")
+	return CheckRegistryImageVersion(RegistryConfig{URL: registryUrl}, repository, reference)
+}
+
+// CheckRegistryImageVersion checks that the image exists in the given registry.
+// Docker Hub is used if no registry URL is set.
+func CheckRegistryImageVersion(registry RegistryConfig, repository, reference string) error {
+	return checkManifest(registry, repository, reference, func(hub *docker.Registry) error {
+		_, err := hub.Manifest(repository, reference)
+		return err
+	})
+}
+
+// CheckDockerImageDigest checks that the image pinned by digest, e.g. sha256:..., exists in Docker Hub.
+func CheckDockerImageDigest(repository, dgst string) error {
+	return CheckRegistryImageDigest(RegistryConfig{URL: registryUrl}, repository, dgst)
+}
+
+// CheckRegistryImageDigest checks that the manifest with the given digest exists in the registry
+// and that the digest reported by the registry for it matches.
+// Docker Hub is used if no registry URL is set.
+func CheckRegistryImageDigest(registry RegistryConfig, repository, dgst string) error {
+	expected, err := digest.Parse(dgst)
 	if err != nil {
+		return fmt.Errorf("invalid digest %s. Reason: %s", dgst, err)
+	}
+	return checkManifest(registry, repository, expected.String(), func(hub *docker.Registry) error {
+		actual, err := hub.ManifestDigest(repository, expected.String())
+		if err != nil {
+			return err
+		}
+		if actual != expected {
+			return fmt.Errorf("digest mismatch for image %s@%s, registry reported %s", repository, expected, actual)
+		}
+		return nil
+	})
+}
+
+// ResolveDockerImageDigest returns the digest of the image with tag reference in Docker Hub.
+func ResolveDockerImageDigest(repository, reference string) (string, error) {
+	return ResolveRegistryImageDigest(RegistryConfig{URL: registryUrl}, repository, reference)
+}
+
+// ResolveRegistryImageDigest returns the digest of the schema 2 manifest the registry serves for
+// repository:reference, to pin the image by digest. Docker Hub is used if no registry URL is set.
+func ResolveRegistryImageDigest(registry RegistryConfig, repository, reference string) (string, error) {
+	url := registry.URL
+	if url == "" {
+		url = registryUrl
+	}
+	hub, err := docker.New(url, registry.Username, registry.Password)
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequest(http.MethodHead, fmt.Sprintf("%s/v2/%s/manifests/%s", hub.URL, repository, reference), nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Accept", schema2.MediaTypeManifest)
+	resp, err := hub.Client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+	dgst, err := digest.Parse(resp.Header.Get("Docker-Content-Digest"))
+	if err != nil {
+		return "", fmt.Errorf("registry returned no valid digest for image %s:%s. Reason: %s", repository, reference, err)
+	}
+	return dgst.String(), nil
+}
+
+// IsManifestUnknown returns true if err reports that the registry does not know the checked image,
+// as opposed to e.g. the registry being unreachable.
+func IsManifestUnknown(err error) bool {
+	if urlErr, ok := err.(*url.Error); ok {
+		err = urlErr.Err
+	}
+	httpErr, ok := err.(*docker.HttpStatusError)
+	return ok && httpErr.Response.StatusCode == http.StatusNotFound
+}
+
+// checkManifest runs check against the registry unless the same image was checked successfully
+// within ManifestCacheTTL.
+func checkManifest(registry RegistryConfig, repository, reference string, check func(hub *docker.Registry) error) error {
+	url := registry.URL
+	if url == "" {
+		url = registryUrl
+	}
+	key := manifestCacheKey(url, registry, repository, reference)
+	manifestCache.Lock()
+	checked, found := manifestCache.checked[key]
+	manifestCache.Unlock()
+	if found && time.Since(checked) < ManifestCacheTTL {
+		return nil
+	}
+
+	hub, err := docker.New(url, registry.Username, registry.Password)
+	if err != nil {
+		return err
+	}
+	if err = check(hub); err != nil {
 		return err
 	}
 
-	_, err = hub.Manifest(repository, reference)
-	return err
+	now := time.Now()
+	manifestCache.Lock()
+	for k, checked := range manifestCache.checked {
+		if now.Sub(checked) >= ManifestCacheTTL {
+			delete(manifestCache.checked, k)
+		}
+	}
+	manifestCache.checked[key] = now
+	manifestCache.Unlock()
+	return nil
+}
+
+// manifestCacheKey returns the key of an image check in manifestCache. The key is hashed, so that the
+// registry password is not kept in memory beyond the check.
+func manifestCacheKey(url string, registry RegistryConfig, repository, reference string) string {
+	h := sha256.New()
+	for _, s := range []string{url, registry.Username, registry.Password, repository, reference} {
+		// the length separates the fields
+		fmt.Fprintf(h, "%d:%s", len(s), s)
+	}
+	return hex.EncodeToString(h.Sum(nil))
 }
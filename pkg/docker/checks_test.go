@@ -0,0 +1,198 @@
+package docker
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/docker/distribution/manifest"
+	"github.com/docker/distribution/manifest/schema1"
+	"github.com/docker/libtrust"
+	digest "github.com/opencontainers/go-digest"
+)
+
+var (
+	pinnedDigest   = digest.FromString("appscode/stash:0.5.1")
+	tamperedDigest = digest.FromString("appscode/stash:tampered")
+)
+
+func newFakeRegistry(t *testing.T, username, password string) *httptest.Server {
+	return newCountingFakeRegistry(t, username, password, new(int32))
+}
+
+// newCountingFakeRegistry returns a fake registry that counts the manifest requests in manifestRequests.
+func newCountingFakeRegistry(t *testing.T, username, password string, manifestRequests *int32) *httptest.Server {
+	pk, err := libtrust.GenerateECP256PrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed, err := schema1.Sign(&schema1.Manifest{
+		Versioned:    manifest.Versioned{SchemaVersion: 1},
+		Name:         ImageOperator,
+		Tag:          "0.5.1",
+		Architecture: "amd64",
+	}, pk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := signed.MarshalJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if u, p, _ := r.BasicAuth(); u != username || p != password {
+			w.Header().Set("WWW-Authenticate", `Basic realm="fake"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.Path {
+		case "/v2/":
+			w.WriteHeader(http.StatusOK)
+		case "/v2/" + ImageOperator + "/manifests/" + pinnedDigest.String():
+			w.Header().Set("Docker-Content-Digest", pinnedDigest.String())
+		case "/v2/" + ImageOperator + "/manifests/" + tamperedDigest.String():
+			// a misbehaving registry or mirror serving another manifest
+			w.Header().Set("Docker-Content-Digest", pinnedDigest.String())
+		case "/v2/" + ImageOperator + "/manifests/0.5.1":
+			if r.Method == http.MethodHead {
+				w.Header().Set("Docker-Content-Digest", pinnedDigest.String())
+				return
+			}
+			atomic.AddInt32(manifestRequests, 1)
+			w.Header().Set("Content-Type", schema1.MediaTypeSignedManifest)
+			w.Write(body)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+}
+
+func TestCheckRegistryImageVersion(t *testing.T) {
+	server := newFakeRegistry(t, "user", "pass")
+	defer server.Close()
+
+	registry := RegistryConfig{URL: server.URL, Username: "user", Password: "pass"}
+	if err := CheckRegistryImageVersion(registry, ImageOperator, "0.5.1"); err != nil {
+		t.Errorf("expected image to be found, got %v", err)
+	}
+	if err := CheckRegistryImageVersion(registry, ImageOperator, "0.0.0"); err == nil {
+		t.Error("expected error for missing tag")
+	}
+
+	registry.Password = "wrong"
+	if err := CheckRegistryImageVersion(registry, ImageOperator, "0.5.1"); err == nil {
+		t.Error("expected error for invalid credentials")
+	}
+}
+
+func TestIsManifestUnknown(t *testing.T) {
+	server := newFakeRegistry(t, "user", "pass")
+	registry := RegistryConfig{URL: server.URL, Username: "user", Password: "pass"}
+	if err := CheckRegistryImageVersion(registry, ImageOperator, "0.0.0"); !IsManifestUnknown(err) {
+		t.Errorf("expected manifest unknown for missing tag, got %v", err)
+	}
+	if err := CheckRegistryImageVersion(registry, ImageOperator, "0.5.1"); IsManifestUnknown(err) {
+		t.Errorf("expected image to be found, got %v", err)
+	}
+
+	server.Close()
+	if err := CheckRegistryImageVersion(registry, ImageOperator, "0.0.0"); err == nil || IsManifestUnknown(err) {
+		t.Errorf("expected unreachable registry not to report manifest unknown, got %v", err)
+	}
+}
+
+func TestCheckRegistryImageVersionCache(t *testing.T) {
+	var requests int32
+	server := newCountingFakeRegistry(t, "user", "pass", &requests)
+	defer server.Close()
+	registry := RegistryConfig{URL: server.URL, Username: "user", Password: "pass"}
+
+	for i := 0; i < 2; i++ {
+		if err := CheckRegistryImageVersion(registry, ImageOperator, "0.5.1"); err != nil {
+			t.Fatalf("expected image to be found, got %v", err)
+		}
+	}
+	if n := atomic.LoadInt32(&requests); n != 1 {
+		t.Errorf("expected second check within TTL to be cached, found %d manifest requests", n)
+	}
+
+	ttl := ManifestCacheTTL
+	defer func() { ManifestCacheTTL = ttl }()
+	ManifestCacheTTL = 0
+	if err := CheckRegistryImageVersion(registry, ImageOperator, "0.5.1"); err != nil {
+		t.Fatalf("expected image to be found, got %v", err)
+	}
+	if n := atomic.LoadInt32(&requests); n != 2 {
+		t.Errorf("expected registry to be asked again after TTL, found %d manifest requests", n)
+	}
+
+	// expired checks are evicted and the password is not part of the keys
+	manifestCache.Lock()
+	defer manifestCache.Unlock()
+	if n := len(manifestCache.checked); n != 1 {
+		t.Errorf("expected expired checks to be evicted, found %d cached checks", n)
+	}
+	for key := range manifestCache.checked {
+		if strings.Contains(key, registry.Password) {
+			t.Errorf("expected cache key without password, found %s", key)
+		}
+	}
+}
+
+func TestCheckRegistryImageDigest(t *testing.T) {
+	server := newFakeRegistry(t, "user", "pass")
+	defer server.Close()
+	registry := RegistryConfig{URL: server.URL, Username: "user", Password: "pass"}
+
+	if err := CheckRegistryImageDigest(registry, ImageOperator, pinnedDigest.String()); err != nil {
+		t.Errorf("expected pinned digest to be found, got %v", err)
+	}
+	if err := CheckRegistryImageDigest(registry, ImageOperator, tamperedDigest.String()); err == nil || !strings.Contains(err.Error(), "mismatch") {
+		t.Errorf("expected digest mismatch error, got %v", err)
+	}
+	if err := CheckRegistryImageDigest(registry, ImageOperator, digest.FromString("missing").String()); err == nil {
+		t.Error("expected error for missing digest")
+	}
+	if err := CheckRegistryImageDigest(registry, ImageOperator, "0.5.1"); err == nil {
+		t.Error("expected error for tag instead of digest")
+	}
+}
+
+func TestResolveRegistryImageDigest(t *testing.T) {
+	server := newFakeRegistry(t, "user", "pass")
+	defer server.Close()
+	registry := RegistryConfig{URL: server.URL, Username: "user", Password: "pass"}
+
+	dgst, err := ResolveRegistryImageDigest(registry, ImageOperator, "0.5.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dgst != pinnedDigest.String() {
+		t.Errorf("expected digest %s, found %s", pinnedDigest, dgst)
+	}
+	if _, err = ResolveRegistryImageDigest(registry, ImageOperator, "0.0.0"); err == nil {
+		t.Error("expected error for missing tag")
+	}
+}
+
+func TestValidateImageReference(t *testing.T) {
+	cases := map[string]bool{
+		"appscode/stash:0.5.1":                     true,
+		"registry.example.com:5000/stash-recovery": true,
+		"appscode/stash@" + pinnedDigest.String():  true,
+		"":                          false,
+		"AppsCode/stash":            false,
+		"appscode/stash:bad tag":    false,
+		"appscode/stash@sha256:abc": false,
+	}
+	for image, valid := range cases {
+		if err := ValidateImageReference(image); valid && err != nil {
+			t.Errorf("%q: unexpected error: %s", image, err)
+		} else if !valid && err == nil {
+			t.Errorf("%q: expected error", image)
+		}
+	}
+}
@@ -23,14 +23,25 @@ const (
 	EventReasonFailedToBackup                = "FailedBackup"
 	EventReasonSuccessfulRecovery            = "SuccessfulRecovery"
 	EventReasonFailedToRecover               = "FailedRecovery"
+	EventReasonPartialRecovery               = "PartialRecovery"
 	EventReasonSuccessfulCheck               = "SuccessfulCheck"
 	EventReasonFailedToCheck                 = "FailedCheck"
 	EventReasonFailedToRetention             = "FailedRetention"
+	EventReasonSuccessfulRetention           = "SuccessfulRetention"
 	EventReasonFailedToUpdate                = "FailedUpdateBackup"
 	EventReasonFailedCronJob                 = "FailedCronJob"
 	EventReasonFailedToDelete                = "FailedDelete"
 	EventReasonJobCreated                    = "RecoveryJobCreated"
 	EventReasonCheckJobCreated               = "CheckJobCreated"
+	EventReasonForgetJobCreated              = "ForgetJobCreated"
+	EventReasonRecoveryDryRun                = "RecoveryDryRun"
+	EventReasonRecoveryJobLogs               = "RecoveryJobLogs"
+	EventReasonPodRecreationRequired         = "PodRecreationRequired"
+	EventReasonRecoveryThrottled             = "RecoveryThrottled"
+	EventReasonRecoveryRunning               = "RecoveryRunning"
+	EventReasonInitJobCreated                = "InitJobCreated"
+	EventReasonSuccessfulInitRepository      = "SuccessfulInitRepository"
+	EventReasonFailedToInitRepository        = "FailedInitRepository"
 )
 
 func NewEventRecorder(client kubernetes.Interface, component string) record.EventRecorder {
@@ -0,0 +1,90 @@
+package forget
+
+import (
+	"fmt"
+
+	cs "github.com/appscode/stash/client/typed/stash/v1alpha1"
+	"github.com/appscode/stash/pkg/cli"
+	"github.com/appscode/stash/pkg/eventer"
+	core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/record"
+)
+
+const (
+	ForgetEventComponent = "stash-forget"
+)
+
+type Options struct {
+	Namespace   string
+	ResticName  string
+	HostName    string
+	SmartPrefix string
+	Limits      cli.Limits
+}
+
+type Controller struct {
+	k8sClient   kubernetes.Interface
+	stashClient cs.StashV1alpha1Interface
+	opt         Options
+	recorder    record.EventRecorder
+}
+
+func New(k8sClient kubernetes.Interface, stashClient cs.StashV1alpha1Interface, opt Options) *Controller {
+	return &Controller{
+		k8sClient:   k8sClient,
+		stashClient: stashClient,
+		opt:         opt,
+		recorder:    eventer.NewEventRecorder(k8sClient, ForgetEventComponent),
+	}
+}
+
+// Run applies the retention policy of every FileGroup of the Restic to the repository.
+func (c *Controller) Run() (err error) {
+	restic, err := c.stashClient.Restics(c.opt.Namespace).Get(c.opt.ResticName, metav1.GetOptions{})
+	if err != nil {
+		return
+	}
+
+	defer func() {
+		if err != nil {
+			eventer.CreateEventWithLog(
+				c.k8sClient,
+				ForgetEventComponent,
+				restic.ObjectReference(),
+				core.EventTypeWarning,
+				eventer.EventReasonFailedToRetention,
+				fmt.Sprintf("Failed to forget old snapshots for pod %s, reason: %s\n", c.opt.HostName, err),
+			)
+		} else {
+			eventer.CreateEventWithLog(
+				c.k8sClient,
+				ForgetEventComponent,
+				restic.ObjectReference(),
+				core.EventTypeNormal,
+				eventer.EventReasonSuccessfulRetention,
+				fmt.Sprintf("Applied retention policies for pod: %s\n", c.opt.HostName),
+			)
+		}
+	}()
+
+	secret, err := c.k8sClient.CoreV1().Secrets(c.opt.Namespace).Get(restic.Spec.Backend.StorageSecretName, metav1.GetOptions{})
+	if err != nil {
+		return
+	}
+
+	cli := cli.New(restic.GetScratchMountPath(), false, c.opt.HostName)
+	cli.SetLimits(c.opt.Limits)
+	if err = cli.SetupEnv(restic, secret, c.opt.SmartPrefix); err != nil {
+		return
+	}
+
+	for _, fg := range restic.Spec.FileGroups {
+		if err = cli.Forget(restic, fg); err != nil {
+			err = fmt.Errorf("failed to forget snapshots of FileGroup %s, reason: %s", fg.Path, err)
+			return
+		}
+	}
+	return
+}
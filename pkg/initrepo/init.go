@@ -0,0 +1,87 @@
+package initrepo
+
+import (
+	"fmt"
+	"strings"
+
+	cs "github.com/appscode/stash/client/typed/stash/v1alpha1"
+	"github.com/appscode/stash/pkg/cli"
+	"github.com/appscode/stash/pkg/eventer"
+	core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/record"
+)
+
+const (
+	InitEventComponent = "stash-init"
+)
+
+type Options struct {
+	Namespace     string
+	ResticName    string
+	SmartPrefixes []string
+}
+
+type Controller struct {
+	k8sClient   kubernetes.Interface
+	stashClient cs.StashV1alpha1Interface
+	opt         Options
+	recorder    record.EventRecorder
+}
+
+func New(k8sClient kubernetes.Interface, stashClient cs.StashV1alpha1Interface, opt Options) *Controller {
+	return &Controller{
+		k8sClient:   k8sClient,
+		stashClient: stashClient,
+		opt:         opt,
+		recorder:    eventer.NewEventRecorder(k8sClient, InitEventComponent),
+	}
+}
+
+// Run initializes the repository of the Restic for every smart prefix, unless it exists already.
+func (c *Controller) Run() (err error) {
+	restic, err := c.stashClient.Restics(c.opt.Namespace).Get(c.opt.ResticName, metav1.GetOptions{})
+	if err != nil {
+		return
+	}
+
+	defer func() {
+		if err != nil {
+			eventer.CreateEventWithLog(
+				c.k8sClient,
+				InitEventComponent,
+				restic.ObjectReference(),
+				core.EventTypeWarning,
+				eventer.EventReasonFailedToInitRepository,
+				fmt.Sprintf("Failed to initialize repository, reason: %s\n", err),
+			)
+		} else {
+			eventer.CreateEventWithLog(
+				c.k8sClient,
+				InitEventComponent,
+				restic.ObjectReference(),
+				core.EventTypeNormal,
+				eventer.EventReasonSuccessfulInitRepository,
+				fmt.Sprintf("Initialized repositories: %s\n", strings.Join(c.opt.SmartPrefixes, ", ")),
+			)
+		}
+	}()
+
+	secret, err := c.k8sClient.CoreV1().Secrets(c.opt.Namespace).Get(restic.Spec.Backend.StorageSecretName, metav1.GetOptions{})
+	if err != nil {
+		return
+	}
+
+	for _, prefix := range c.opt.SmartPrefixes {
+		w := cli.New(restic.GetScratchMountPath(), false, "")
+		if err = w.SetupEnv(restic, secret, prefix); err != nil {
+			return
+		}
+		if err = w.InitRepositoryIfAbsent(); err != nil {
+			err = fmt.Errorf("failed to initialize repository %s, reason: %s", prefix, err)
+			return
+		}
+	}
+	return
+}
@@ -0,0 +1,45 @@
+package notifier
+
+import (
+	api "github.com/appscode/stash/apis/stash/v1alpha1"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+)
+
+// Event describes a change of a Recovery that users are notified about.
+type Event struct {
+	Name      string            `json:"name"`
+	Namespace string            `json:"namespace"`
+	Restic    string            `json:"restic,omitempty"`
+	Phase     api.RecoveryPhase `json:"phase"`
+	Reason    string            `json:"reason,omitempty"`
+}
+
+// Notifier delivers events to a receiver outside the cluster.
+type Notifier interface {
+	Notify(e Event) error
+}
+
+// Multi delivers events to all of its notifiers.
+type Multi []Notifier
+
+func (m Multi) Notify(e Event) error {
+	var errs []error
+	for _, n := range m {
+		if err := n.Notify(e); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return utilerrors.NewAggregate(errs)
+}
+
+// FailuresOnly delivers only events of failed Recoveries to N.
+type FailuresOnly struct {
+	N Notifier
+}
+
+func (f FailuresOnly) Notify(e Event) error {
+	if e.Phase != api.RecoveryFailed {
+		return nil
+	}
+	return f.N.Notify(e)
+}
@@ -0,0 +1,90 @@
+package notifier
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	api "github.com/appscode/stash/apis/stash/v1alpha1"
+)
+
+const (
+	// SlackWebhookURLKey is the key of the incoming webhook URL in the Slack secret.
+	SlackWebhookURLKey = "SLACK_WEBHOOK_URL"
+
+	slackColorSucceeded = "good"
+	slackColorFailed    = "danger"
+)
+
+// Slack posts events to a Slack incoming webhook as color coded attachments.
+type Slack struct {
+	WebhookURL string
+	Client     *http.Client
+}
+
+var _ Notifier = &Slack{}
+
+func NewSlack(webhookURL string) *Slack {
+	return &Slack{
+		WebhookURL: webhookURL,
+		Client:     &http.Client{Timeout: DefaultWebhookTimeout},
+	}
+}
+
+type slackMessage struct {
+	Attachments []slackAttachment `json:"attachments"`
+}
+
+type slackAttachment struct {
+	Fallback string       `json:"fallback"`
+	Color    string       `json:"color"`
+	Title    string       `json:"title"`
+	Text     string       `json:"text,omitempty"`
+	Fields   []slackField `json:"fields"`
+}
+
+type slackField struct {
+	Title string `json:"title"`
+	Value string `json:"value"`
+	Short bool   `json:"short"`
+}
+
+func (s *Slack) Notify(e Event) error {
+	body, err := json.Marshal(newSlackMessage(e))
+	if err != nil {
+		return err
+	}
+	resp, err := s.Client.Post(s.WebhookURL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to notify slack, reason: %s", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("failed to notify slack, reason: unexpected status %s", resp.Status)
+	}
+	return nil
+}
+
+func newSlackMessage(e Event) slackMessage {
+	color := slackColorSucceeded
+	if e.Phase == api.RecoveryFailed {
+		color = slackColorFailed
+	}
+	title := fmt.Sprintf("Recovery %s/%s %s", e.Namespace, e.Name, e.Phase)
+	return slackMessage{
+		Attachments: []slackAttachment{
+			{
+				Fallback: title,
+				Color:    color,
+				Title:    title,
+				Text:     e.Reason,
+				Fields: []slackField{
+					{Title: "Recovery", Value: e.Name, Short: true},
+					{Title: "Restic", Value: e.Restic, Short: true},
+					{Title: "Namespace", Value: e.Namespace, Short: true},
+				},
+			},
+		},
+	}
+}
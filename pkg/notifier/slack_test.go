@@ -0,0 +1,83 @@
+package notifier
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+
+	api "github.com/appscode/stash/apis/stash/v1alpha1"
+)
+
+func TestSlackNotify(t *testing.T) {
+	var received map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&received); err != nil {
+			t.Error(err)
+		}
+	}))
+	defer server.Close()
+
+	cases := map[api.RecoveryPhase]string{
+		api.RecoverySucceeded: "good",
+		api.RecoveryFailed:    "danger",
+	}
+	for phase, color := range cases {
+		e := Event{Name: "stash-demo", Namespace: "default", Restic: "stash-restic", Phase: phase, Reason: "Recovery job stash-recovery-stash-demo " + string(phase)}
+		if err := NewSlack(server.URL).Notify(e); err != nil {
+			t.Fatal(err)
+		}
+
+		title := "Recovery default/stash-demo " + string(phase)
+		expected := map[string]interface{}{
+			"attachments": []interface{}{
+				map[string]interface{}{
+					"fallback": title,
+					"color":    color,
+					"title":    title,
+					"text":     e.Reason,
+					"fields": []interface{}{
+						map[string]interface{}{"title": "Recovery", "value": "stash-demo", "short": true},
+						map[string]interface{}{"title": "Restic", "value": "stash-restic", "short": true},
+						map[string]interface{}{"title": "Namespace", "value": "default", "short": true},
+					},
+				},
+			},
+		}
+		if !reflect.DeepEqual(received, expected) {
+			t.Errorf("%s: expected body %v, found %v", phase, expected, received)
+		}
+	}
+}
+
+func TestFailuresOnly(t *testing.T) {
+	var mu sync.Mutex
+	count := 0
+	requests := func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return count
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		count++
+	}))
+	defer server.Close()
+
+	n := Multi{FailuresOnly{NewWebhook(server.URL)}, NewSlack(server.URL)}
+	if err := n.Notify(Event{Name: "stash-demo", Phase: api.RecoverySucceeded}); err != nil {
+		t.Fatal(err)
+	}
+	if got := requests(); got != 1 {
+		t.Errorf("expected only slack to be notified of success, found %d requests", got)
+	}
+	if err := n.Notify(Event{Name: "stash-demo", Phase: api.RecoveryFailed}); err != nil {
+		t.Fatal(err)
+	}
+	if got := requests(); got != 3 {
+		t.Errorf("expected both notifiers to be notified of failure, found %d requests", got-1)
+	}
+}
@@ -0,0 +1,43 @@
+package notifier
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// DefaultWebhookTimeout bounds each webhook request, so a slow receiver can't block the operator.
+const DefaultWebhookTimeout = 10 * time.Second
+
+// Webhook POSTs events as JSON to URL.
+type Webhook struct {
+	URL    string
+	Client *http.Client
+}
+
+var _ Notifier = &Webhook{}
+
+func NewWebhook(url string) *Webhook {
+	return &Webhook{
+		URL:    url,
+		Client: &http.Client{Timeout: DefaultWebhookTimeout},
+	}
+}
+
+func (w *Webhook) Notify(e Event) error {
+	body, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	resp, err := w.Client.Post(w.URL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to notify webhook, reason: %s", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("failed to notify webhook, reason: unexpected status %s", resp.Status)
+	}
+	return nil
+}
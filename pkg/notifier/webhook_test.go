@@ -0,0 +1,47 @@
+package notifier
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	api "github.com/appscode/stash/apis/stash/v1alpha1"
+)
+
+func TestWebhookNotify(t *testing.T) {
+	var received Event
+	var contentType string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			t.Errorf("expected POST request, found %s", r.Method)
+		}
+		contentType = r.Header.Get("Content-Type")
+		if err := json.NewDecoder(r.Body).Decode(&received); err != nil {
+			t.Error(err)
+		}
+	}))
+	defer server.Close()
+
+	e := Event{Name: "stash-demo", Namespace: "default", Phase: api.RecoveryFailed, Reason: "Recovery job stash-recovery-stash-demo failed after 7 attempts"}
+	if err := NewWebhook(server.URL).Notify(e); err != nil {
+		t.Fatal(err)
+	}
+	if received != e {
+		t.Errorf("expected payload %+v, found %+v", e, received)
+	}
+	if contentType != "application/json" {
+		t.Errorf("expected content type application/json, found %s", contentType)
+	}
+}
+
+func TestWebhookNotifyErrorStatus(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	if err := NewWebhook(server.URL).Notify(Event{Name: "stash-demo", Phase: api.RecoveryFailed}); err == nil {
+		t.Error("expected error for unsuccessful response")
+	}
+}
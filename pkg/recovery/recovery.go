@@ -10,6 +10,7 @@ import (
 	stash_util "github.com/appscode/stash/client/typed/stash/v1alpha1/util"
 	"github.com/appscode/stash/pkg/cli"
 	"github.com/appscode/stash/pkg/eventer"
+	"github.com/appscode/stash/pkg/util"
 	core "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
@@ -21,20 +22,27 @@ type Controller struct {
 	stashClient  cs.StashV1alpha1Interface
 	namespace    string
 	recoveryName string
-	recorder     record.EventRecorder
+	restoreOpt   cli.RestoreOptions
+	limits       cli.Limits
+	// if set, only the FileGroups below this path are recovered
+	volumeMountPath string
+	recorder        record.EventRecorder
 }
 
 const (
 	RecoveryEventComponent = "stash-recovery"
 )
 
-func New(k8sClient kubernetes.Interface, stashClient cs.StashV1alpha1Interface, namespace, name string) *Controller {
+func New(k8sClient kubernetes.Interface, stashClient cs.StashV1alpha1Interface, namespace, name string, opt cli.RestoreOptions, limits cli.Limits, volumeMountPath string) *Controller {
 	return &Controller{
-		k8sClient:    k8sClient,
-		stashClient:  stashClient,
-		namespace:    namespace,
-		recoveryName: name,
-		recorder:     eventer.NewEventRecorder(k8sClient, RecoveryEventComponent),
+		k8sClient:       k8sClient,
+		stashClient:     stashClient,
+		namespace:       namespace,
+		recoveryName:    name,
+		restoreOpt:      opt,
+		limits:          limits,
+		volumeMountPath: volumeMountPath,
+		recorder:        eventer.NewEventRecorder(k8sClient, RecoveryEventComponent),
 	}
 }
 
@@ -47,46 +55,72 @@ func (c *Controller) Run() {
 
 	if err = recovery.IsValid(); err != nil {
 		log.Errorf("Failed to validate recovery %s, reason: %s\n", recovery.Name, err)
-		stash_util.SetRecoveryStatusPhase(c.stashClient, recovery, api.RecoveryFailed)
+		msg := fmt.Sprintf("Failed to validate recovery %s, reason: %s", recovery.Name, err)
+		stash_util.SetRecoveryStatusPhase(c.stashClient, recovery, api.RecoveryFailed,
+			util.RecoveryPhaseConditions(api.RecoveryFailed, eventer.EventReasonFailedToRecover, msg)...)
 		eventer.CreateEventWithLog(
 			c.k8sClient,
 			RecoveryEventComponent,
 			recovery.ObjectReference(),
 			core.EventTypeWarning,
 			eventer.EventReasonFailedToRecover,
-			fmt.Sprintf("Failed to validate recovery %s, reason: %s", recovery.Name, err),
+			msg,
 		)
 		return
 	}
 
-	if err = c.RecoverOrErr(recovery); err != nil {
+	if err = c.RecoverOrErr(recovery); err != nil && util.RecoveryPhaseForError(err) == api.RecoveryPartial {
+		log.Warningf("Recovery %s partially completed, reason: %s\n", recovery.Name, err)
+		msg := fmt.Sprintf("Recovery %s partially completed, some files could not be restored, reason: %s", recovery.Name, err)
+		stash_util.SetRecoveryStatusPhase(c.stashClient, recovery, api.RecoveryPartial,
+			util.RecoveryPhaseConditions(api.RecoveryPartial, eventer.EventReasonPartialRecovery, msg)...)
+		eventer.CreateEventWithLog(
+			c.k8sClient,
+			RecoveryEventComponent,
+			recovery.ObjectReference(),
+			core.EventTypeWarning,
+			eventer.EventReasonPartialRecovery,
+			msg,
+		)
+		return
+	} else if err != nil {
 		log.Errorf("Failed to complete recovery %s, reason: %s\n", recovery.Name, err)
-		stash_util.SetRecoveryStatusPhase(c.stashClient, recovery, api.RecoveryFailed)
+		msg := fmt.Sprintf("Failed to complete recovery %s, reason: %s", recovery.Name, err)
+		stash_util.SetRecoveryStatusPhase(c.stashClient, recovery, api.RecoveryFailed,
+			util.RecoveryPhaseConditions(api.RecoveryFailed, eventer.EventReasonFailedToRecover, msg)...)
 		eventer.CreateEventWithLog(
 			c.k8sClient,
 			RecoveryEventComponent,
 			recovery.ObjectReference(),
 			core.EventTypeWarning,
 			eventer.EventReasonFailedToRecover,
-			fmt.Sprintf("Failed to complete recovery %s, reason: %s", recovery.Name, err),
+			msg,
 		)
 		return
 	}
 
+	if c.volumeMountPath != "" {
+		// the operator sets the phase once all parallel recovery jobs are complete
+		log.Infof("Recovery %s of volume %s succeeded\n", recovery.Name, c.volumeMountPath)
+		return
+	}
+
 	log.Infof("Recovery %s succeeded\n", recovery.Name)
-	stash_util.SetRecoveryStatusPhase(c.stashClient, recovery, api.RecoverySucceeded) // TODO: status.Stats
+	msg := fmt.Sprintf("Recovery %s succeeded", recovery.Name)
+	stash_util.SetRecoveryStatusPhase(c.stashClient, recovery, api.RecoverySucceeded,
+		util.RecoveryPhaseConditions(api.RecoverySucceeded, eventer.EventReasonSuccessfulRecovery, msg)...)
 	eventer.CreateEventWithLog(
 		c.k8sClient,
 		RecoveryEventComponent,
 		recovery.ObjectReference(),
 		core.EventTypeNormal,
 		eventer.EventReasonSuccessfulRecovery,
-		fmt.Sprintf("Recovery %s succeeded", recovery.Name),
+		msg,
 	)
 }
 
 func (c *Controller) RecoverOrErr(recovery *api.Recovery) error {
-	restic, err := c.stashClient.Restics(c.namespace).Get(recovery.Spec.Restic, metav1.GetOptions{})
+	restic, err := c.stashClient.Restics(recovery.GetResticNamespace()).Get(recovery.Spec.Restic, metav1.GetOptions{})
 	if err != nil {
 		return err
 	}
@@ -97,7 +131,7 @@ func (c *Controller) RecoverOrErr(recovery *api.Recovery) error {
 		return fmt.Errorf("no backup found")
 	}
 
-	secret, err := c.k8sClient.CoreV1().Secrets(c.namespace).Get(restic.Spec.Backend.StorageSecretName, metav1.GetOptions{})
+	secret, err := c.k8sClient.CoreV1().Secrets(restic.Namespace).Get(restic.Spec.Backend.StorageSecretName, metav1.GetOptions{})
 	if err != nil {
 		return err
 	}
@@ -109,35 +143,77 @@ func (c *Controller) RecoverOrErr(recovery *api.Recovery) error {
 		return err
 	}
 
-	cli := cli.New("/tmp", false, hostname)
+	cli := cli.New(restic.GetScratchMountPath(), false, hostname)
+	cli.SetLimits(c.limits)
 	if err = cli.SetupEnv(restic, secret, smartPrefix); err != nil {
 		return err
 	}
 
+	host := sourceHost(recovery, hostname)
 	var errRec error
-	for _, fg := range restic.Spec.FileGroups {
-		d, err := c.measure(cli.Restore, fg.Path, hostname)
+	for _, path := range util.RecoverySourcePaths(recovery, restic) {
+		if !c.recoversPath(path) {
+			continue
+		}
+		d, restored, err := c.measure(cli.Restore, path, host)
+		stats := restoreStats(path, d, restored)
 		if err != nil {
-			errRec = err
+			// a failed FileGroup takes precedence over a partially recovered one
+			if errRec == nil || util.RecoveryPhaseForError(errRec) == api.RecoveryPartial {
+				errRec = err
+			}
 			eventer.CreateEventWithLog(
 				c.k8sClient,
 				RecoveryEventComponent,
 				recovery.ObjectReference(),
 				core.EventTypeWarning,
 				eventer.EventReasonFailedToRecover,
-				fmt.Sprintf("failed to recover FileGroup %s, reason: %v", fg.Path, err),
+				fmt.Sprintf("failed to recover path %s, reason: %v", path, err),
 			)
-			stash_util.SetRecoveryStats(c.stashClient, recovery, fg.Path, d, api.RecoveryFailed)
+			stats.Phase = util.RecoveryPhaseForError(err)
 		} else {
-			stash_util.SetRecoveryStats(c.stashClient, recovery, fg.Path, d, api.RecoverySucceeded)
+			stats.Phase = api.RecoverySucceeded
+		}
+		if updated, err := stash_util.SetRecoveryStats(c.stashClient, recovery, stats); err != nil {
+			log.Errorf("Failed to update stats of recovery %s for path %s, reason: %s\n", recovery.Name, path, err)
+		} else {
+			recovery = updated
 		}
 	}
 
 	return errRec
 }
 
-func (c *Controller) measure(f func(string, string) error, path, host string) (time.Duration, error) {
+// sourceHost returns the host whose snapshots are recovered. The repository is selected by the
+// workload, but snapshots of another host may be recovered from it.
+func sourceHost(recovery *api.Recovery, hostname string) string {
+	if recovery.Spec.SourceHost != "" {
+		return recovery.Spec.SourceHost
+	}
+	return hostname
+}
+
+// recoversPath returns true if path is below the volume mount path recovered by this controller.
+func (c *Controller) recoversPath(path string) bool {
+	return c.volumeMountPath == "" || util.IsPathBelow(path, c.volumeMountPath)
+}
+
+func (c *Controller) measure(f func(string, string, cli.RestoreOptions) (*cli.RestoreStats, error), path, host string) (time.Duration, *cli.RestoreStats, error) {
 	startTime := time.Now()
-	err := f(path, host)
-	return time.Now().Sub(startTime), err
+	restored, err := f(path, host, c.restoreOpt)
+	return time.Now().Sub(startTime), restored, err
+}
+
+// restoreStats returns the stats of restoring path. Restored files and bytes are left unset
+// if restic did not report them.
+func restoreStats(path string, d time.Duration, restored *cli.RestoreStats) api.RestoreStats {
+	stats := api.RestoreStats{
+		Path:     path,
+		Duration: d.String(),
+	}
+	if restored != nil {
+		stats.FilesRestored = &restored.FilesRestored
+		stats.BytesRestored = &restored.BytesRestored
+	}
+	return stats
 }
@@ -0,0 +1,18 @@
+package recovery
+
+import (
+	"testing"
+
+	api "github.com/appscode/stash/apis/stash/v1alpha1"
+)
+
+func TestSourceHost(t *testing.T) {
+	recovery := &api.Recovery{}
+	if host := sourceHost(recovery, "host-0"); host != "host-0" {
+		t.Errorf("expected host of the workload, found %s", host)
+	}
+	recovery.Spec.SourceHost = "host-1"
+	if host := sourceHost(recovery, "host-0"); host != "host-1" {
+		t.Errorf("expected source host host-1, found %s", host)
+	}
+}
@@ -5,7 +5,8 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"reflect"
+	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 
@@ -14,19 +15,33 @@ import (
 	"github.com/appscode/kutil/meta"
 	api "github.com/appscode/stash/apis/stash/v1alpha1"
 	stash_listers "github.com/appscode/stash/listers/stash/v1alpha1"
+	"github.com/appscode/stash/pkg/admission"
+	"github.com/appscode/stash/pkg/cli"
 	"github.com/appscode/stash/pkg/docker"
+	"github.com/appscode/stash/pkg/eventer"
 	"github.com/cenkalti/backoff"
 	"github.com/google/go-cmp/cmp"
+	"gopkg.in/robfig/cron.v2"
+	apps "k8s.io/api/apps/v1beta1"
 	batch "k8s.io/api/batch/v1"
 	batch_v1_beta "k8s.io/api/batch/v1beta1"
 	core "k8s.io/api/core/v1"
+	extensions "k8s.io/api/extensions/v1beta1"
+	policy "k8s.io/api/policy/v1beta1"
 	kerr "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/strategicpatch"
+	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/record"
+)
+
+const (
+	OpenShiftAppsGroupVersion = "apps.openshift.io/v1"
+	ResourceDeploymentConfigs = "deploymentconfigs"
 )
 
 const (
@@ -35,22 +50,89 @@ const (
 	LocalVolumeName      = "stash-local"
 	ScratchDirVolumeName = "stash-scratchdir"
 	PodinfoVolumeName    = "stash-podinfo"
+	CacheVolumeName      = "stash-cache"
 	StashInitializerName = "stash.appscode.com"
 
+	// PodinfoMountPath is the default mount path of the podinfo volume in the sidecar.
+	PodinfoMountPath = "/etc/stash"
+
+	GCSCredentialsVolumeName = "stash-gcs-credentials"
+	GCSCredentialsMountPath  = "/etc/stash-gcs"
+	GCSCredentialsFileName   = "gcs_sa.json"
+
+	RestTLSVolumeName = "stash-rest-tls"
+	RestTLSMountPath  = cli.RestTLSDir
+
+	SFTPSSHVolumeName = "stash-sftp-ssh"
+	SFTPSSHMountPath  = cli.SFTPKeyDir
+
+	PasswordVolumeName = "stash-password"
+	PasswordMountPath  = "/etc/stash-password"
+	PasswordFileName   = "restic_password"
+
+	// MirrorLocalVolumeName is the volume of the local mirror backend, see MirrorBackendToVolumes.
+	MirrorLocalVolumeName = "stash-mirror-local"
+
+	// DefaultLogLevel makes sidecar and recovery containers use their built-in log level.
+	DefaultLogLevel = -1
+
+	// RepositoryPrefixEnv holds the workload specific prefix of the restic repository.
+	RepositoryPrefixEnv = "REPOSITORY_PREFIX"
+
 	RecoveryJobPrefix = "stash-recovery-"
 	KubectlCronPrefix = "stash-kubectl-cron-"
 	CheckJobPrefix    = "stash-check-"
+	ForgetJobPrefix   = "stash-forget-"
+	InitJobPrefix     = "stash-init-"
+
+	// RecoveryFinalizer keeps a Recovery until its recovery job is deleted by the operator.
+	RecoveryFinalizer = "stash.appscode.com/recovery-job"
 
 	AnnotationRestic    = "restic"
 	AnnotationRecovery  = "recovery"
 	AnnotationOperation = "operation"
+	// AnnotationParallelJobs holds the number of recovery jobs restoring the volumes of a Recovery in parallel.
+	AnnotationParallelJobs = "parallel-jobs"
 
 	OperationRecovery   = "recovery"
 	OperationCheck      = "check"
+	OperationForget     = "forget"
+	OperationInit       = "init"
 	OperationDeletePods = "delete-pods"
 	AppLabelStash       = "stash"
 )
 
+// RestartStrategy decides how pods are restarted after the stash sidecar is added or removed.
+type RestartStrategy string
+
+const (
+	RestartStrategyDelete  RestartStrategy = "delete"  // default, deletes pods directly
+	RestartStrategyRollout RestartStrategy = "rollout" // patches owning workload to trigger rolling update
+)
+
+// SidecarWaitBackoff configures the exponential backoff, with jitter, used while waiting for
+// pods to be restarted with or without the stash sidecar.
+type SidecarWaitBackoff struct {
+	InitialInterval time.Duration
+	MaxInterval     time.Duration
+	MaxElapsedTime  time.Duration
+}
+
+var DefaultSidecarWaitBackoff = SidecarWaitBackoff{
+	InitialInterval: 3 * time.Second,
+	MaxInterval:     time.Minute,
+	MaxElapsedTime:  15 * time.Minute,
+}
+
+func (b SidecarWaitBackoff) newBackOff() backoff.BackOff {
+	bo := backoff.NewExponentialBackOff()
+	bo.InitialInterval = b.InitialInterval
+	bo.MaxInterval = b.MaxInterval
+	bo.MaxElapsedTime = b.MaxElapsedTime
+	bo.Reset()
+	return bo
+}
+
 func GetAppliedRestic(m map[string]string) (*api.Restic, error) {
 	data := GetString(m, api.LastAppliedConfiguration)
 	if data == "" {
@@ -67,7 +149,26 @@ func GetAppliedRestic(m map[string]string) (*api.Restic, error) {
 	return restic, nil
 }
 
-func FindRestic(lister stash_listers.ResticLister, obj metav1.ObjectMeta) (*api.Restic, error) {
+// GetAppliedResticWithEvent works like GetAppliedRestic, but also records a warning
+// event on ref if the annotation can't be decoded, so that users can see it via kubectl.
+func GetAppliedResticWithEvent(m map[string]string, recorder record.EventRecorder, ref *core.ObjectReference) (*api.Restic, error) {
+	restic, err := GetAppliedRestic(m)
+	if err != nil && ref != nil {
+		recorder.Eventf(
+			ref,
+			core.EventTypeWarning,
+			eventer.EventReasonInvalidRestic,
+			"Failed to decode %s annotation, reason: %s",
+			api.LastAppliedConfiguration,
+			err,
+		)
+	}
+	return restic, err
+}
+
+// FindAllRestics returns every Restic in the namespace of obj that applies to the workload of the given kind.
+// Restics targeting the workload explicitly take precedence over Restics whose selector matches its labels.
+func FindAllRestics(lister stash_listers.ResticLister, kind string, obj metav1.ObjectMeta) ([]*api.Restic, error) {
 	restics, err := lister.Restics(obj.Namespace).List(labels.Everything())
 	if kerr.IsNotFound(err) {
 		return nil, nil
@@ -75,8 +176,19 @@ func FindRestic(lister stash_listers.ResticLister, obj metav1.ObjectMeta) (*api.
 		return nil, err
 	}
 
+	targeted := make([]*api.Restic, 0)
 	result := make([]*api.Restic, 0)
 	for _, restic := range restics {
+		if restic.Spec.Target != nil {
+			if IsResticTarget(restic, kind, obj.Name) {
+				targeted = append(targeted, restic)
+			}
+			continue
+		}
+		// an empty selector matches everything, it only applies to workloads if explicitly asked for
+		if len(restic.Spec.Selector.MatchLabels) == 0 && len(restic.Spec.Selector.MatchExpressions) == 0 && !restic.Spec.SelectAll {
+			continue
+		}
 		selector, err := metav1.LabelSelectorAsSelector(&restic.Spec.Selector)
 		if err != nil {
 			return nil, err
@@ -85,6 +197,30 @@ func FindRestic(lister stash_listers.ResticLister, obj metav1.ObjectMeta) (*api.
 			result = append(result, restic)
 		}
 	}
+	if len(targeted) > 0 {
+		return targeted, nil
+	}
+	return result, nil
+}
+
+// IsResticTarget returns true if spec.target of restic refers to the workload of the given kind and name.
+func IsResticTarget(restic *api.Restic, kind, name string) bool {
+	if restic.Spec.Target == nil {
+		return false
+	}
+	target := *restic.Spec.Target
+	if err := target.Canonicalize(); err != nil {
+		return false
+	}
+	return target.Kind == kind && target.Name == name
+}
+
+// FindRestic returns the Restic matching obj, nil if none matches. It fails if obj matches multiple Restics.
+func FindRestic(lister stash_listers.ResticLister, kind string, obj metav1.ObjectMeta) (*api.Restic, error) {
+	result, err := FindAllRestics(lister, kind, obj)
+	if err != nil {
+		return nil, err
+	}
 	if len(result) > 1 {
 		var msg bytes.Buffer
 		msg.WriteString(fmt.Sprintf("Workload %s/%s matches multiple Restics:", obj.Namespace, obj.Name))
@@ -101,8 +237,34 @@ func FindRestic(lister stash_listers.ResticLister, obj metav1.ObjectMeta) (*api.
 	return nil, nil
 }
 
-func WaitUntilSidecarAdded(kubeClient kubernetes.Interface, namespace string, selector *metav1.LabelSelector, backupType api.BackupType) error {
-	return backoff.Retry(func() error {
+// SkipInjection returns true if any of the annotations of a workload or its pod template asks to skip the
+// stash sidecar.
+func SkipInjection(annotations ...map[string]string) bool {
+	for _, m := range annotations {
+		if skip, _ := meta.GetBool(m, api.SkipInjection); skip {
+			return true
+		}
+	}
+	return false
+}
+
+// WaitUntilSidecarAdded restarts pods selected by selector until all of them run the stash sidecar.
+// It gives up with a timeout error once bo.MaxElapsedTime has passed.
+func WaitUntilSidecarAdded(kubeClient kubernetes.Interface, namespace string, selector *metav1.LabelSelector, backupType api.BackupType, strategy RestartStrategy, bo SidecarWaitBackoff) error {
+	return waitForSidecar(kubeClient, namespace, selector, backupType, strategy, bo, true)
+}
+
+// WaitUntilSidecarRemoved restarts pods selected by selector until none of them runs the stash sidecar.
+// It gives up with a timeout error once bo.MaxElapsedTime has passed.
+func WaitUntilSidecarRemoved(kubeClient kubernetes.Interface, namespace string, selector *metav1.LabelSelector, backupType api.BackupType, strategy RestartStrategy, bo SidecarWaitBackoff) error {
+	return waitForSidecar(kubeClient, namespace, selector, backupType, strategy, bo, false)
+}
+
+var errCheckAgain = errors.New("check again")
+
+func waitForSidecar(kubeClient kubernetes.Interface, namespace string, selector *metav1.LabelSelector, backupType api.BackupType, strategy RestartStrategy, bo SidecarWaitBackoff, wantSidecar bool) error {
+	restarted := map[string]bool{}
+	err := backoff.Retry(func() error {
 		r, err := metav1.LabelSelectorAsSelector(selector)
 		if err != nil {
 			return err
@@ -130,61 +292,124 @@ func WaitUntilSidecarAdded(kubeClient kubernetes.Interface, namespace string, se
 					}
 				}
 			}
-			if !found {
+			if found != wantSidecar {
 				podsToRestart = append(podsToRestart, pod)
 			}
 		}
 		if len(podsToRestart) == 0 {
 			return nil
 		}
-		for _, pod := range podsToRestart {
-			kubeClient.CoreV1().Pods(namespace).Delete(pod.Name, &metav1.DeleteOptions{})
+		restartPods(kubeClient, namespace, podsToRestart, strategy, restarted)
+		return errCheckAgain
+	}, bo.newBackOff())
+	if err == errCheckAgain {
+		op := "added to"
+		if !wantSidecar {
+			op = "removed from"
 		}
-		return errors.New("check again")
-	}, backoff.NewConstantBackOff(3*time.Second))
+		return fmt.Errorf("timed out after %s waiting for stash sidecar to be %s pods in namespace %s", bo.MaxElapsedTime, op, namespace)
+	}
+	return err
 }
 
-func WaitUntilSidecarRemoved(kubeClient kubernetes.Interface, namespace string, selector *metav1.LabelSelector, backupType api.BackupType) error {
-	return backoff.Retry(func() error {
-		r, err := metav1.LabelSelectorAsSelector(selector)
+// restartPods restarts pods so that they pick up the current pod template. With the rollout strategy,
+// pods managed by a workload that supports rolling update are restarted by patching the pod template
+// of the owning workload once. Other pods are evicted, see evictPod.
+func restartPods(kubeClient kubernetes.Interface, namespace string, pods []core.Pod, strategy RestartStrategy, restarted map[string]bool) {
+	for _, pod := range pods {
+		if strategy == RestartStrategyRollout {
+			owner, err := findRolloutOwner(kubeClient, namespace, &pod)
+			if err != nil {
+				log.Errorf("Failed to find owner of pod %s/%s. Reason: %s", namespace, pod.Name, err)
+			} else if owner != nil {
+				key := owner.Kind + "/" + owner.Name
+				if !restarted[key] {
+					if err = rolloutWorkload(kubeClient, namespace, *owner); err != nil {
+						log.Errorf("Failed to rollout %s %s/%s. Reason: %s", owner.Kind, namespace, owner.Name, err)
+						continue
+					}
+					restarted[key] = true
+				}
+				continue
+			}
+		}
+		evictPod(kubeClient, &pod)
+	}
+}
+
+// evictPod restarts a pod managed by a controller via the Eviction API, so that PodDisruptionBudgets
+// are honored. An eviction blocked by a PodDisruptionBudget is retried when the pods are checked again.
+// Pods without controller are deleted. If the operator is not allowed to create pods/eviction, the pod
+// is deleted as well, since retrying the eviction can never succeed.
+func evictPod(kubeClient kubernetes.Interface, pod *core.Pod) {
+	if metav1.GetControllerOf(pod) == nil {
+		kubeClient.CoreV1().Pods(pod.Namespace).Delete(pod.Name, &metav1.DeleteOptions{})
+		return
+	}
+	err := kubeClient.CoreV1().Pods(pod.Namespace).Evict(&policy.Eviction{
+		ObjectMeta: metav1.ObjectMeta{Name: pod.Name, Namespace: pod.Namespace},
+	})
+	if kerr.IsTooManyRequests(err) {
+		log.Infof("Eviction of pod %s/%s is blocked by a PodDisruptionBudget, retrying later", pod.Namespace, pod.Name)
+	} else if kerr.IsForbidden(err) {
+		log.Errorf("Not allowed to evict pod %s/%s, deleting it instead. Reason: %s", pod.Namespace, pod.Name, err)
+		kubeClient.CoreV1().Pods(pod.Namespace).Delete(pod.Name, &metav1.DeleteOptions{})
+	} else if err != nil && !kerr.IsNotFound(err) {
+		log.Errorf("Failed to evict pod %s/%s. Reason: %s", pod.Namespace, pod.Name, err)
+	}
+}
+
+// findRolloutOwner returns the workload that can perform a rolling restart of the given pod.
+// It returns nil if the pod has no such controller.
+func findRolloutOwner(kubeClient kubernetes.Interface, namespace string, pod *core.Pod) (*api.LocalTypedReference, error) {
+	ref := metav1.GetControllerOf(pod)
+	if ref == nil {
+		return nil, nil
+	}
+	switch ref.Kind {
+	case api.KindReplicaSet:
+		rs, err := kubeClient.ExtensionsV1beta1().ReplicaSets(namespace).Get(ref.Name, metav1.GetOptions{})
 		if err != nil {
-			return err
+			return nil, err
 		}
-		pods, err := kubeClient.CoreV1().Pods(namespace).List(metav1.ListOptions{LabelSelector: r.String()})
+		if dp := metav1.GetControllerOf(rs); dp != nil && dp.Kind == api.KindDeployment {
+			return &api.LocalTypedReference{Kind: api.KindDeployment, Name: dp.Name}, nil
+		}
+	case api.KindStatefulSet:
+		ss, err := kubeClient.AppsV1beta1().StatefulSets(namespace).Get(ref.Name, metav1.GetOptions{})
 		if err != nil {
-			return err
+			return nil, err
 		}
-
-		var podsToRestart []core.Pod
-		for _, pod := range pods.Items {
-			found := false
-			if backupType == api.BackupOffline {
-				for _, c := range pod.Spec.InitContainers {
-					if c.Name == StashContainer {
-						found = true
-						break
-					}
-				}
-			} else {
-				for _, c := range pod.Spec.Containers {
-					if c.Name == StashContainer {
-						found = true
-						break
-					}
-				}
-			}
-			if found {
-				podsToRestart = append(podsToRestart, pod)
-			}
+		if ss.Spec.UpdateStrategy.Type == apps.RollingUpdateStatefulSetStrategyType {
+			return &api.LocalTypedReference{Kind: api.KindStatefulSet, Name: ss.Name}, nil
 		}
-		if len(podsToRestart) == 0 {
-			return nil
+	case api.KindDaemonSet:
+		ds, err := kubeClient.ExtensionsV1beta1().DaemonSets(namespace).Get(ref.Name, metav1.GetOptions{})
+		if err != nil {
+			return nil, err
 		}
-		for _, pod := range podsToRestart {
-			kubeClient.CoreV1().Pods(namespace).Delete(pod.Name, &metav1.DeleteOptions{})
+		if ds.Spec.UpdateStrategy.Type == extensions.RollingUpdateDaemonSetStrategyType {
+			return &api.LocalTypedReference{Kind: api.KindDaemonSet, Name: ds.Name}, nil
 		}
-		return errors.New("check again")
-	}, backoff.NewConstantBackOff(3*time.Second))
+	}
+	return nil, nil
+}
+
+// rolloutWorkload triggers a rolling restart of the workload by patching its pod template annotations.
+func rolloutWorkload(kubeClient kubernetes.Interface, namespace string, workload api.LocalTypedReference) error {
+	patch := fmt.Sprintf(`{"spec":{"template":{"metadata":{"annotations":{"%s":"%s"}}}}}`, api.RestartedAt, time.Now().UTC().Format(time.RFC3339))
+	var err error
+	switch workload.Kind {
+	case api.KindDeployment:
+		_, err = kubeClient.AppsV1beta1().Deployments(namespace).Patch(workload.Name, types.StrategicMergePatchType, []byte(patch))
+	case api.KindStatefulSet:
+		_, err = kubeClient.AppsV1beta1().StatefulSets(namespace).Patch(workload.Name, types.StrategicMergePatchType, []byte(patch))
+	case api.KindDaemonSet:
+		_, err = kubeClient.ExtensionsV1beta1().DaemonSets(namespace).Patch(workload.Name, types.StrategicMergePatchType, []byte(patch))
+	default:
+		err = fmt.Errorf(`unsupported workload "Kind" %v for rollout`, workload.Kind)
+	}
+	return err
 }
 
 func GetString(m map[string]string, key string) string {
@@ -194,8 +419,104 @@ func GetString(m map[string]string, key string) string {
 	return m[key]
 }
 
-func CreateInitContainer(r *api.Restic, tag string, workload api.LocalTypedReference, enableRBAC bool) core.Container {
-	container := CreateSidecarContainer(r, tag, workload)
+// HasStashSidecar reports whether the pod spec already runs the stash sidecar.
+func HasStashSidecar(spec core.PodSpec) bool {
+	for _, c := range spec.Containers {
+		if c.Name == StashContainer {
+			return true
+		}
+	}
+	return false
+}
+
+// DefaultSidecarSecurityContext returns a restrictive security context that runs the sidecar
+// as nobody. The sidecar can then only back up files readable by that user.
+func DefaultSidecarSecurityContext() *core.SecurityContext {
+	var (
+		nobody         int64 = 65534
+		runAsNonRoot         = true
+		readOnlyRootFs       = true
+		privEscalation       = false
+	)
+	return &core.SecurityContext{
+		RunAsUser:                &nobody,
+		RunAsNonRoot:             &runAsNonRoot,
+		ReadOnlyRootFilesystem:   &readOnlyRootFs,
+		AllowPrivilegeEscalation: &privEscalation,
+		Capabilities: &core.Capabilities{
+			Drop: []core.Capability{"ALL"},
+		},
+	}
+}
+
+// ApplyDefaultResources returns resources with the requests and limits of defaults added for every
+// resource whose request or limit is not set, e.g. so that sidecars are admitted in namespaces with a
+// LimitRange requiring limits. A default is skipped if it would make a request exceed its limit.
+func ApplyDefaultResources(resources, defaults core.ResourceRequirements) core.ResourceRequirements {
+	out := resources.DeepCopy()
+	for name, request := range defaults.Requests {
+		if _, ok := out.Requests[name]; ok {
+			continue
+		}
+		if limit, ok := out.Limits[name]; ok && request.Cmp(limit) > 0 {
+			continue
+		}
+		if out.Requests == nil {
+			out.Requests = core.ResourceList{}
+		}
+		out.Requests[name] = request
+	}
+	for name, limit := range defaults.Limits {
+		if _, ok := out.Limits[name]; ok {
+			continue
+		}
+		if request, ok := out.Requests[name]; ok && request.Cmp(limit) > 0 {
+			continue
+		}
+		if out.Limits == nil {
+			out.Limits = core.ResourceList{}
+		}
+		out.Limits[name] = limit
+	}
+	return *out
+}
+
+// ApplyJobDefaultResources applies ApplyDefaultResources with defaults to every container of job.
+func ApplyJobDefaultResources(job *batch.Job, defaults core.ResourceRequirements) {
+	for i := range job.Spec.Template.Spec.Containers {
+		container := &job.Spec.Template.Spec.Containers[i]
+		container.Resources = ApplyDefaultResources(container.Resources, defaults)
+	}
+}
+
+// JobDefaultResourceArgs returns the flags passing defaults to the backup command of the offline backup
+// init container, which applies them to the check and forget jobs it creates.
+func JobDefaultResourceArgs(defaults core.ResourceRequirements) []string {
+	var args []string
+	for _, q := range []struct {
+		list core.ResourceList
+		name core.ResourceName
+		flag string
+	}{
+		{defaults.Requests, core.ResourceCPU, "--job-default-cpu-request="},
+		{defaults.Requests, core.ResourceMemory, "--job-default-memory-request="},
+		{defaults.Limits, core.ResourceCPU, "--job-default-cpu-limit="},
+		{defaults.Limits, core.ResourceMemory, "--job-default-memory-limit="},
+	} {
+		if quantity, ok := q.list[q.name]; ok {
+			args = append(args, q.flag+quantity.String())
+		}
+	}
+	return args
+}
+
+// CreateInitContainer returns the stash init container for offline backup of workload. See
+// CreateSidecarContainer for imageDigest and defaultSecurityContext.
+func CreateInitContainer(r *api.Restic, tag, imageDigest string, workload api.LocalTypedReference, enableRBAC bool, defaultSecurityContext *core.SecurityContext) (core.Container, error) {
+	container, err := CreateSidecarContainer(r, tag, imageDigest, workload, DefaultLogLevel, defaultSecurityContext)
+	if err != nil {
+		return container, err
+	}
 	container.Args = []string{
 		"backup",
 		"--restic-name=" + r.Name,
@@ -206,18 +527,62 @@ func CreateInitContainer(r *api.Restic, tag string, workload api.LocalTypedRefer
 	if enableRBAC {
 		container.Args = append(container.Args, "--enable-rbac=true")
 	}
-	return container
+	container.Args = append(container.Args, resticLimitArgs(r)...)
+	container.Args = append(container.Args, podinfoArgs(r)...)
+	container.Args = append(container.Args, scratchArgs(r)...)
+	container.Args = append(container.Args, backupArgs(r)...)
+	return container, nil
+}
+
+// podinfoMountPath returns the mount path of the podinfo volume in the sidecar of r.
+func podinfoMountPath(r *api.Restic) string {
+	if r.Spec.PodinfoMountPath != "" {
+		return r.Spec.PodinfoMountPath
+	}
+	return PodinfoMountPath
+}
+
+// podinfoArgs tells the backup command where to find the pod labels if the podinfo volume is not
+// mounted at the default path.
+func podinfoArgs(r *api.Restic) []string {
+	if r.Spec.PodinfoMountPath == "" {
+		return nil
+	}
+	return []string{"--pod-labels-path=" + filepath.Join(r.Spec.PodinfoMountPath, "labels")}
+}
+
+// scratchArgs tells the backup command where the scratch volume is mounted if it is not mounted
+// at the default path, so that restic keeps its cache and temporary files on it.
+func scratchArgs(r *api.Restic) []string {
+	if r.Spec.ScratchMountPath == "" {
+		return nil
+	}
+	return []string{"--scratch-dir=" + r.Spec.ScratchMountPath}
+}
+
+// OperatorImage returns the reference of the stash image with tag, or pinned by imageDigest if set.
+func OperatorImage(tag, imageDigest string) string {
+	if imageDigest != "" {
+		return docker.ImageOperator + "@" + imageDigest
+	}
+	return docker.ImageOperator + ":" + tag
 }
 
-func CreateSidecarContainer(r *api.Restic, tag string, workload api.LocalTypedReference) core.Container {
+// CreateSidecarContainer returns the stash sidecar container for workload. The image is pinned by
+// imageDigest, the resolved digest of tag, unless it is empty or r requests another tag.
+// defaultSecurityContext is used if r does not specify a security context, nil leaves it to the
+// container runtime. An error is returned if the repository of workload or the backend of r can't
+// be resolved.
+func CreateSidecarContainer(r *api.Restic, tag, imageDigest string, workload api.LocalTypedReference, logLevel int, defaultSecurityContext *core.SecurityContext) (core.Container, error) {
 	if r.Annotations != nil {
-		if v, ok := r.Annotations[api.VersionTag]; ok {
+		if v, ok := r.Annotations[api.VersionTag]; ok && v != tag {
 			tag = v
+			imageDigest = ""
 		}
 	}
 	sidecar := core.Container{
 		Name:            StashContainer,
-		Image:           docker.ImageOperator + ":" + tag,
+		Image:           OperatorImage(tag, imageDigest),
 		ImagePullPolicy: core.PullIfNotPresent,
 		Args: []string{
 			"backup",
@@ -248,19 +613,38 @@ func CreateSidecarContainer(r *api.Restic, tag string, workload api.LocalTypedRe
 		VolumeMounts: []core.VolumeMount{
 			{
 				Name:      ScratchDirVolumeName,
-				MountPath: "/tmp",
+				MountPath: r.GetScratchMountPath(),
 			},
 			{
 				Name:      PodinfoVolumeName,
-				MountPath: "/etc/stash",
+				MountPath: podinfoMountPath(r),
 			},
 		},
 	}
 	if tag == "canary" {
 		sidecar.ImagePullPolicy = core.PullAlways
-		sidecar.Args = append(sidecar.Args, "--v=5")
+		sidecar.Args = append(sidecar.Args, fmt.Sprintf("--v=%d", resolveLogLevel(r, logLevel, 5)))
+	} else {
+		sidecar.Args = append(sidecar.Args, fmt.Sprintf("--v=%d", resolveLogLevel(r, logLevel, 3)))
+	}
+	sidecar.Args = append(sidecar.Args, resticLimitArgs(r)...)
+	sidecar.Args = append(sidecar.Args, podinfoArgs(r)...)
+	sidecar.Args = append(sidecar.Args, scratchArgs(r)...)
+	sidecar.Args = append(sidecar.Args, backupArgs(r)...)
+	if cacheDir := r.GetCacheMountPath(); cacheDir != "" {
+		sidecar.VolumeMounts = append(sidecar.VolumeMounts, core.VolumeMount{
+			Name:      CacheVolumeName,
+			MountPath: cacheDir,
+		})
+		sidecar.Env = append(sidecar.Env, core.EnvVar{Name: cli.RESTIC_CACHE_DIR, Value: cacheDir})
+	}
+	if r.Spec.ImagePullPolicy != "" {
+		sidecar.ImagePullPolicy = r.Spec.ImagePullPolicy
+	}
+	if r.Spec.SecurityContext != nil {
+		sidecar.SecurityContext = r.Spec.SecurityContext
 	} else {
-		sidecar.Args = append(sidecar.Args, "--v=3")
+		sidecar.SecurityContext = defaultSecurityContext
 	}
 	for _, srcVol := range r.Spec.VolumeMounts {
 		sidecar.VolumeMounts = append(sidecar.VolumeMounts, core.VolumeMount{
@@ -270,116 +654,565 @@ func CreateSidecarContainer(r *api.Restic, tag string, workload api.LocalTypedRe
 			ReadOnly:  true,
 		})
 	}
-	if r.Spec.Backend.Local != nil {
-		sidecar.VolumeMounts = append(sidecar.VolumeMounts, core.VolumeMount{
-			Name:      LocalVolumeName,
-			MountPath: r.Spec.Backend.Local.Path,
-		})
+	// POD_NAME and NODE_NAME are expanded by kubelet, so per pod repositories are resolved too.
+	_, prefix, err := workload.HostnamePrefix("$(POD_NAME)", "$(NODE_NAME)")
+	if err != nil {
+		return sidecar, err
 	}
-	return sidecar
-}
-
-func UpsertScratchVolume(volumes []core.Volume) []core.Volume {
-	return core_util.UpsertVolume(volumes, core.Volume{
-		Name: ScratchDirVolumeName,
-		VolumeSource: core.VolumeSource{
-			EmptyDir: &core.EmptyDirVolumeSource{},
-		},
-	})
+	_, mounts, env, err := BackendToVolumesAndEnv(r.Spec.Backend)
+	if err != nil {
+		return sidecar, err
+	}
+	sidecar.VolumeMounts = append(sidecar.VolumeMounts, mounts...)
+	_, mounts, err = MirrorBackendToVolumes(r)
+	if err != nil {
+		return sidecar, err
+	}
+	sidecar.VolumeMounts = append(sidecar.VolumeMounts, mounts...)
+	sidecar.Env = append(sidecar.Env, core.EnvVar{Name: RepositoryPrefixEnv, Value: prefix})
+	sidecar.Env = append(sidecar.Env, env...)
+	// set last, so that backend credentials missing in the repository secret can be provided
+	sidecar.Env = append(sidecar.Env, r.Spec.Env...)
+	sidecar.EnvFrom = append(BackendToEnvFrom(r.Spec.Backend), r.Spec.EnvFrom...)
+	return sidecar, nil
 }
 
-// https://kubernetes.io/docs/tasks/inject-data-application/downward-api-volume-expose-pod-information/#store-pod-fields
-func UpsertDownwardVolume(volumes []core.Volume) []core.Volume {
-	return core_util.UpsertVolume(volumes, core.Volume{
-		Name: PodinfoVolumeName,
-		VolumeSource: core.VolumeSource{
-			DownwardAPI: &core.DownwardAPIVolumeSource{
-				Items: []core.DownwardAPIVolumeFile{
-					{
-						Path: "labels",
-						FieldRef: &core.ObjectFieldSelector{
-							FieldPath: "metadata.labels",
+// BackendToVolumesAndEnv returns the volumes, volume mounts and environment variables a container
+// needs to access the repository of backend. The repository url refers to $(REPOSITORY_PREFIX),
+// so callers must define RepositoryPrefixEnv ahead of the returned variables.
+func BackendToVolumesAndEnv(backend api.Backend) ([]core.Volume, []core.VolumeMount, []core.EnvVar, error) {
+	var (
+		volumes []core.Volume
+		mounts  []core.VolumeMount
+		env     []core.EnvVar
+	)
+	if backend.PasswordSource == api.PasswordSourceFile {
+		// restic reads the password from the file instead of RESTIC_PASSWORD. The file is owned by
+		// root, so it must be readable by others for the non-root stash containers.
+		mode := int32(0444)
+		volumes = append(volumes, core.Volume{
+			Name: PasswordVolumeName,
+			VolumeSource: core.VolumeSource{
+				Secret: &core.SecretVolumeSource{
+					SecretName: backend.StorageSecretName,
+					Items: []core.KeyToPath{
+						{
+							Key:  cli.RESTIC_PASSWORD,
+							Path: PasswordFileName,
 						},
 					},
+					DefaultMode: &mode,
 				},
 			},
-		},
-	})
-}
+		})
+		mounts = append(mounts, core.VolumeMount{
+			Name:      PasswordVolumeName,
+			MountPath: PasswordMountPath,
+			ReadOnly:  true,
+		})
+		env = append(env, core.EnvVar{
+			Name:  cli.RESTIC_PASSWORD_FILE,
+			Value: filepath.Join(PasswordMountPath, PasswordFileName),
+		})
+	}
 
-func MergeLocalVolume(volumes []core.Volume, old, new *api.Restic) []core.Volume {
-	oldPos := -1
-	if old != nil && old.Spec.Backend.Local != nil {
-		for i, vol := range volumes {
-			if vol.Name == LocalVolumeName {
-				oldPos = i
-				break
-			}
-		}
+	repository, err := cli.RepositoryURL(backend, "$("+RepositoryPrefixEnv+")")
+	if err != nil {
+		return nil, nil, nil, err
+	} else if repository != "" {
+		env = append(env, core.EnvVar{Name: cli.RESTIC_REPOSITORY, Value: repository})
 	}
-	if new.Spec.Backend.Local != nil {
-		if oldPos != -1 {
-			volumes[oldPos] = core.Volume{Name: LocalVolumeName, VolumeSource: new.Spec.Backend.Local.VolumeSource}
-		} else {
-			volumes = core_util.UpsertVolume(volumes, core.Volume{Name: LocalVolumeName, VolumeSource: new.Spec.Backend.Local.VolumeSource})
+
+	switch {
+	case backend.Local != nil:
+		volumes = append(volumes, core.Volume{
+			Name:         LocalVolumeName,
+			VolumeSource: backend.Local.VolumeSource,
+		})
+		mounts = append(mounts, core.VolumeMount{
+			Name:      LocalVolumeName,
+			MountPath: backend.Local.Path,
+		})
+	case backend.S3 != nil:
+		env = append(env,
+			secretKeyEnv(cli.AWS_ACCESS_KEY_ID, backend.StorageSecretName),
+			secretKeyEnv(cli.AWS_SECRET_ACCESS_KEY, backend.StorageSecretName),
+		)
+	case backend.GCS != nil:
+		// mount service account json key from repository secret, if any
+		optional := true
+		volumes = append(volumes, core.Volume{
+			Name: GCSCredentialsVolumeName,
+			VolumeSource: core.VolumeSource{
+				Secret: &core.SecretVolumeSource{
+					SecretName: backend.StorageSecretName,
+					Items: []core.KeyToPath{
+						{
+							Key:  cli.GOOGLE_SERVICE_ACCOUNT_JSON_KEY,
+							Path: GCSCredentialsFileName,
+						},
+					},
+					Optional: &optional,
+				},
+			},
+		})
+		mounts = append(mounts, core.VolumeMount{
+			Name:      GCSCredentialsVolumeName,
+			MountPath: GCSCredentialsMountPath,
+			ReadOnly:  true,
+		})
+		env = append(env,
+			core.EnvVar{
+				Name:  cli.GOOGLE_APPLICATION_CREDENTIALS,
+				Value: filepath.Join(GCSCredentialsMountPath, GCSCredentialsFileName),
+			},
+			secretKeyEnv(cli.GOOGLE_PROJECT_ID, backend.StorageSecretName),
+		)
+	case backend.Azure != nil:
+		if backend.StorageSecretName == "" {
+			return nil, nil, nil, fmt.Errorf("missing repository secret name for azure backend")
 		}
-	} else {
-		if oldPos != -1 {
-			volumes = append(volumes[:oldPos], volumes[oldPos+1:]...)
+		env = append(env,
+			secretKeyEnv(cli.AZURE_ACCOUNT_NAME, backend.StorageSecretName),
+			secretKeyEnv(cli.AZURE_ACCOUNT_KEY, backend.StorageSecretName),
+		)
+	case backend.Swift != nil:
+		if backend.StorageSecretName == "" {
+			return nil, nil, nil, fmt.Errorf("missing repository secret name for swift backend")
+		}
+		// secret keys are optional, only the ones of the chosen authentication method are set
+		for _, key := range swiftSecretKeys {
+			env = append(env, secretKeyEnv(key, backend.StorageSecretName))
 		}
+	case backend.Rest != nil:
+		env = append(env,
+			secretKeyRefEnv(cli.RESTIC_REST_USERNAME, cli.REST_SERVER_USERNAME, backend.StorageSecretName),
+			secretKeyRefEnv(cli.RESTIC_REST_PASSWORD, cli.REST_SERVER_PASSWORD, backend.StorageSecretName),
+		)
+		if backend.Rest.TLSSecretName != "" {
+			volumes = append(volumes, core.Volume{
+				Name: RestTLSVolumeName,
+				VolumeSource: core.VolumeSource{
+					Secret: &core.SecretVolumeSource{
+						SecretName: backend.Rest.TLSSecretName,
+					},
+				},
+			})
+			mounts = append(mounts, core.VolumeMount{
+				Name:      RestTLSVolumeName,
+				MountPath: RestTLSMountPath,
+				ReadOnly:  true,
+			})
+		}
+	case backend.B2 != nil:
+		env = append(env,
+			secretKeyEnv(cli.B2_ACCOUNT_ID, backend.StorageSecretName),
+			secretKeyEnv(cli.B2_ACCOUNT_KEY, backend.StorageSecretName),
+		)
+	case backend.SFTP != nil:
+		if backend.SFTP.SSHSecretName == "" {
+			return nil, nil, nil, fmt.Errorf("missing ssh secret name for sftp backend")
+		}
+		// the files are owned by root, so they must be readable by others for the non-root stash
+		// containers. The private key is copied to a file only readable by the container user.
+		mode := int32(0444)
+		volumes = append(volumes, core.Volume{
+			Name: SFTPSSHVolumeName,
+			VolumeSource: core.VolumeSource{
+				Secret: &core.SecretVolumeSource{
+					SecretName:  backend.SFTP.SSHSecretName,
+					DefaultMode: &mode,
+				},
+			},
+		})
+		mounts = append(mounts, core.VolumeMount{
+			Name:      SFTPSSHVolumeName,
+			MountPath: SFTPSSHMountPath,
+			ReadOnly:  true,
+		})
 	}
-	return volumes
+	return volumes, mounts, env, nil
 }
 
-func EnsureVolumeDeleted(volumes []core.Volume, name string) []core.Volume {
-	for i, v := range volumes {
-		if v.Name == name {
-			return append(volumes[:i], volumes[i+1:]...)
-		}
+// MirrorBackendToVolumes returns the volumes and volume mounts the sidecar needs to access the
+// repository of the mirror backend of r. Only a local mirror needs a volume, the sidecar reads the
+// password and credentials of the mirror from its secrets.
+func MirrorBackendToVolumes(r *api.Restic) ([]core.Volume, []core.VolumeMount, error) {
+	if r.Spec.Mirror == nil || r.Spec.Mirror.Local == nil {
+		return nil, nil, nil
 	}
-	return volumes
+	volumes, mounts, _, err := BackendToVolumesAndEnv(api.Backend{Local: r.Spec.Mirror.Local})
+	if err != nil {
+		return nil, nil, err
+	}
+	for i := range volumes {
+		volumes[i].Name = MirrorLocalVolumeName
+	}
+	for i := range mounts {
+		mounts[i].Name = MirrorLocalVolumeName
+	}
+	return volumes, mounts, nil
 }
 
-func ResticEqual(old, new *api.Restic) bool {
-	var oldSpec, newSpec *api.ResticSpec
-	if old != nil {
-		oldSpec = &old.Spec
+// BackendToEnvFrom returns the sources of environment variables a container needs to access the
+// repository of backend, in addition to the variables returned by BackendToVolumesAndEnv.
+func BackendToEnvFrom(backend api.Backend) []core.EnvFromSource {
+	if backend.Raw == nil || backend.Raw.SecretName == "" {
+		return nil
 	}
-	if new != nil {
-		newSpec = &new.Spec
+	return []core.EnvFromSource{
+		{
+			SecretRef: &core.SecretEnvSource{
+				LocalObjectReference: core.LocalObjectReference{
+					Name: backend.Raw.SecretName,
+				},
+			},
+		},
 	}
-	return cmp.Equal(oldSpec, newSpec, cmp.Comparer(func(x, y resource.Quantity) bool {
-		return x.Cmp(y) == 0
-	}))
 }
 
-func RecoveryEqual(old, new *api.Recovery) bool {
-	var oldSpec, newSpec *api.RecoverySpec
-	if old != nil {
-		oldSpec = &old.Spec
+// PodSecretNames returns the names of the secrets the volumes, env and envFrom of the containers
+// of spec refer to, in order of their first reference.
+func PodSecretNames(spec core.PodSpec) []string {
+	var names []string
+	add := func(name string) {
+		for _, n := range names {
+			if n == name {
+				return
+			}
+		}
+		names = append(names, name)
 	}
-	if new != nil {
-		newSpec = &new.Spec
+	for _, v := range spec.Volumes {
+		if v.Secret != nil {
+			add(v.Secret.SecretName)
+		}
 	}
-	return reflect.DeepEqual(oldSpec, newSpec)
+	for _, c := range append(spec.InitContainers, spec.Containers...) {
+		for _, env := range c.Env {
+			if env.ValueFrom != nil && env.ValueFrom.SecretKeyRef != nil {
+				add(env.ValueFrom.SecretKeyRef.Name)
+			}
+		}
+		for _, env := range c.EnvFrom {
+			if env.SecretRef != nil {
+				add(env.SecretRef.Name)
+			}
+		}
+	}
+	return names
 }
 
-func CreateRecoveryJob(recovery *api.Recovery, restic *api.Restic, tag string) *batch.Job {
-	job := &batch.Job{
-		ObjectMeta: metav1.ObjectMeta{
-			Name:      RecoveryJobPrefix + recovery.Name,
+var (
+	swiftSecretKeys = []string{
+		// keystone v1 authentication
+		cli.ST_AUTH, cli.ST_USER, cli.ST_KEY,
+		// keystone v2 authentication
+		cli.OS_AUTH_URL, cli.OS_REGION_NAME, cli.OS_USERNAME, cli.OS_PASSWORD, cli.OS_TENANT_ID, cli.OS_TENANT_NAME,
+		// keystone v3 authentication
+		cli.OS_USER_DOMAIN_NAME, cli.OS_PROJECT_NAME, cli.OS_PROJECT_DOMAIN_NAME,
+		// token based authentication
+		cli.OS_STORAGE_URL, cli.OS_AUTH_TOKEN,
+	}
+	// swift authenticates if all keys of any of these sets are present
+	swiftAuthKeys = [][]string{
+		{cli.ST_AUTH, cli.ST_USER, cli.ST_KEY},
+		{cli.OS_AUTH_URL, cli.OS_USERNAME, cli.OS_PASSWORD},
+		{cli.OS_STORAGE_URL, cli.OS_AUTH_TOKEN},
+	}
+)
+
+// ValidateBackendSecret checks that the repository secret of backend exists and holds the
+// repository password and the credentials the backend requires.
+func ValidateBackendSecret(kubeClient kubernetes.Interface, namespace string, backend api.Backend) error {
+	secret, err := kubeClient.CoreV1().Secrets(namespace).Get(backend.StorageSecretName, metav1.GetOptions{})
+	if kerr.IsNotFound(err) {
+		return fmt.Errorf("repository secret %s/%s not found", namespace, backend.StorageSecretName)
+	} else if err != nil {
+		return err
+	}
+	if backend.Swift != nil {
+		if err = checkSwiftSecret(secret); err != nil {
+			return err
+		}
+	}
+	// credentials of s3 and gcs backends are optional, restic falls back to e.g. the IAM role or
+	// workload identity of the pod
+	keys := []string{cli.RESTIC_PASSWORD}
+	switch {
+	case backend.S3 != nil:
+		if len(secret.Data[cli.AWS_ACCESS_KEY_ID]) > 0 || len(secret.Data[cli.AWS_SECRET_ACCESS_KEY]) > 0 {
+			keys = append(keys, cli.AWS_ACCESS_KEY_ID, cli.AWS_SECRET_ACCESS_KEY)
+		}
+	case backend.Azure != nil:
+		keys = append(keys, cli.AZURE_ACCOUNT_NAME, cli.AZURE_ACCOUNT_KEY)
+	case backend.B2 != nil:
+		keys = append(keys, cli.B2_ACCOUNT_ID, cli.B2_ACCOUNT_KEY)
+	}
+	var missing []string
+	for _, key := range keys {
+		if len(secret.Data[key]) == 0 {
+			missing = append(missing, key)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("repository secret %s/%s is missing keys %s", namespace, secret.Name, strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+func checkSwiftSecret(secret *core.Secret) error {
+	sets := make([]string, 0, len(swiftAuthKeys))
+	for _, keys := range swiftAuthKeys {
+		if hasSecretKeys(secret, keys) {
+			return nil
+		}
+		sets = append(sets, strings.Join(keys, ", "))
+	}
+	return fmt.Errorf("secret %s/%s is missing swift credentials, one of [%s] must be set",
+		secret.Namespace, secret.Name, strings.Join(sets, "] or ["))
+}
+
+func hasSecretKeys(secret *core.Secret, keys []string) bool {
+	for _, key := range keys {
+		if len(secret.Data[key]) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// resolveLogLevel returns the log level set in the Restic, or else logLevel. def is used when
+// logLevel is negative.
+func resolveLogLevel(r *api.Restic, logLevel, def int) int {
+	if r.Spec.LogLevel != nil {
+		return int(*r.Spec.LogLevel)
+	}
+	if logLevel < 0 {
+		return def
+	}
+	return logLevel
+}
+
+// secretKeyEnv exposes a key of the repository secret as an environment variable of the same name.
+func secretKeyEnv(key, secretName string) core.EnvVar {
+	return secretKeyRefEnv(key, key, secretName)
+}
+
+// secretKeyRefEnv exposes a key of the repository secret as the named environment variable.
+func secretKeyRefEnv(name, key, secretName string) core.EnvVar {
+	optional := true
+	return core.EnvVar{
+		Name: name,
+		ValueFrom: &core.EnvVarSource{
+			SecretKeyRef: &core.SecretKeySelector{
+				LocalObjectReference: core.LocalObjectReference{
+					Name: secretName,
+				},
+				Key:      key,
+				Optional: &optional,
+			},
+		},
+	}
+}
+
+func UpsertScratchVolume(volumes []core.Volume, r *api.Restic) []core.Volume {
+	return core_util.UpsertVolume(volumes, core.Volume{
+		Name: ScratchDirVolumeName,
+		VolumeSource: core.VolumeSource{
+			EmptyDir: &core.EmptyDirVolumeSource{
+				Medium:    r.Spec.ScratchMedium,
+				SizeLimit: r.Spec.ScratchSizeLimit,
+			},
+		},
+	})
+}
+
+// MergeCacheVolume adds the cache volume claimed by restic r, or removes it if r keeps the restic
+// cache in the scratch volume.
+func MergeCacheVolume(volumes []core.Volume, r *api.Restic) []core.Volume {
+	if r.Spec.Cache == nil {
+		return EnsureVolumeDeleted(volumes, CacheVolumeName)
+	}
+	return core_util.UpsertVolume(volumes, core.Volume{
+		Name: CacheVolumeName,
+		VolumeSource: core.VolumeSource{
+			PersistentVolumeClaim: &core.PersistentVolumeClaimVolumeSource{
+				ClaimName: r.Spec.Cache.ClaimName,
+			},
+		},
+	})
+}
+
+// UpsertDownwardVolume adds the podinfo volume exposing the labels, annotations and namespace of the pod.
+// https://kubernetes.io/docs/tasks/inject-data-application/downward-api-volume-expose-pod-information/#store-pod-fields
+func UpsertDownwardVolume(volumes []core.Volume) []core.Volume {
+	return core_util.UpsertVolume(volumes, core.Volume{
+		Name: PodinfoVolumeName,
+		VolumeSource: core.VolumeSource{
+			DownwardAPI: &core.DownwardAPIVolumeSource{
+				Items: []core.DownwardAPIVolumeFile{
+					{
+						Path: "labels",
+						FieldRef: &core.ObjectFieldSelector{
+							FieldPath: "metadata.labels",
+						},
+					},
+					{
+						Path: "annotations",
+						FieldRef: &core.ObjectFieldSelector{
+							FieldPath: "metadata.annotations",
+						},
+					},
+					{
+						Path: "namespace",
+						FieldRef: &core.ObjectFieldSelector{
+							FieldPath: "metadata.namespace",
+						},
+					},
+				},
+			},
+		},
+	})
+}
+
+// backendVolumes returns the volumes of the backend and the mirror backend of r.
+func backendVolumes(r *api.Restic) ([]core.Volume, error) {
+	volumes, _, _, err := BackendToVolumesAndEnv(r.Spec.Backend)
+	if err != nil {
+		return nil, err
+	}
+	mirrorVolumes, _, err := MirrorBackendToVolumes(r)
+	return append(volumes, mirrorVolumes...), err
+}
+
+// MergeBackendVolumes replaces the backend volumes of old restic with those of new restic.
+// Volumes used by both are updated in place.
+func MergeBackendVolumes(volumes []core.Volume, old, new *api.Restic) []core.Volume {
+	newVolumes, err := backendVolumes(new)
+	if err != nil {
+		log.Errorln(err)
+	}
+	if old != nil {
+		oldVolumes, _ := backendVolumes(old)
+		for _, vol := range oldVolumes {
+			if !hasVolume(newVolumes, vol.Name) {
+				volumes = EnsureVolumeDeleted(volumes, vol.Name)
+			}
+		}
+	}
+	for _, vol := range newVolumes {
+		volumes = core_util.UpsertVolume(volumes, vol)
+	}
+	return volumes
+}
+
+// EnsureBackendVolumesDeleted removes the backend volumes of restic.
+func EnsureBackendVolumesDeleted(volumes []core.Volume, r *api.Restic) []core.Volume {
+	resticVolumes, _ := backendVolumes(r)
+	for _, vol := range resticVolumes {
+		volumes = EnsureVolumeDeleted(volumes, vol.Name)
+	}
+	return volumes
+}
+
+// MergePriorityClassName returns the priority class of workload pods using new. The priority
+// class set by old is removed if new doesn't set one.
+func MergePriorityClassName(current string, old, new *api.Restic) string {
+	if new.Spec.PriorityClassName != "" {
+		return new.Spec.PriorityClassName
+	}
+	if old != nil {
+		return EnsurePriorityClassNameDeleted(current, old)
+	}
+	return current
+}
+
+// EnsurePriorityClassNameDeleted removes the priority class set by restic.
+func EnsurePriorityClassNameDeleted(current string, r *api.Restic) string {
+	if current == r.Spec.PriorityClassName {
+		return ""
+	}
+	return current
+}
+
+func hasVolume(volumes []core.Volume, name string) bool {
+	for _, vol := range volumes {
+		if vol.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func EnsureVolumeDeleted(volumes []core.Volume, name string) []core.Volume {
+	for i, v := range volumes {
+		if v.Name == name {
+			return append(volumes[:i], volumes[i+1:]...)
+		}
+	}
+	return volumes
+}
+
+// quantityComparer treats equal quantities in different formats, e.g. 1Gi and 1024Mi, as equal.
+var quantityComparer = cmp.Comparer(func(x, y resource.Quantity) bool {
+	return x.Cmp(y) == 0
+})
+
+// resticPodAnnotations are the Restic annotations that change the rendered stash containers.
+// Other annotations, e.g. api.LastAppliedConfiguration, are ignored by ResticEqual.
+var resticPodAnnotations = []string{
+	api.VersionTag,
+}
+
+// ResticEqual reports whether old and new result in the same stash containers, i.e. whether
+// their specs and the annotations in resticPodAnnotations are equal.
+func ResticEqual(old, new *api.Restic) bool {
+	var oldSpec, newSpec *api.ResticSpec
+	var oldAnnotations, newAnnotations map[string]string
+	if old != nil {
+		oldSpec = &old.Spec
+		oldAnnotations = old.Annotations
+	}
+	if new != nil {
+		newSpec = &new.Spec
+		newAnnotations = new.Annotations
+	}
+	for _, key := range resticPodAnnotations {
+		if oldAnnotations[key] != newAnnotations[key] {
+			return false
+		}
+	}
+	return cmp.Equal(oldSpec, newSpec, quantityComparer)
+}
+
+func RecoveryEqual(old, new *api.Recovery) bool {
+	var oldSpec, newSpec *api.RecoverySpec
+	if old != nil {
+		oldSpec = &old.Spec
+	}
+	if new != nil {
+		newSpec = &new.Spec
+	}
+	return cmp.Equal(oldSpec, newSpec, quantityComparer)
+}
+
+func CreateRecoveryJob(recovery *api.Recovery, restic *api.Restic, tag string, logLevel int) *batch.Job {
+	if recovery.Spec.NodeName != "" && (len(recovery.Spec.NodeSelector) > 0 || len(recovery.Spec.Tolerations) > 0) {
+		log.Warningf("Recovery %s/%s has nodeName set, it takes precedence over nodeSelector and tolerations in scheduling", recovery.Namespace, recovery.Name)
+	}
+
+	job := &batch.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      RecoveryJobPrefix + recovery.Name,
 			Namespace: recovery.Namespace,
+			// the Recovery controls its jobs, so they are garbage collected when the Recovery is deleted
 			OwnerReferences: []metav1.OwnerReference{
-				{
-					APIVersion: api.SchemeGroupVersion.String(),
-					Kind:       api.ResourceKindRecovery,
-					Name:       recovery.Name,
-					UID:        recovery.UID,
-				},
+				*metav1.NewControllerRef(recovery, api.SchemeGroupVersion.WithKind(api.ResourceKindRecovery)),
 			},
-			Labels: map[string]string{
+			Labels: mergeStringMaps(recovery.Spec.JobLabels, map[string]string{
 				"app": AppLabelStash,
-			},
+			}),
 			Annotations: map[string]string{
 				AnnotationRestic:    restic.Name,
 				AnnotationRecovery:  recovery.Name,
@@ -387,80 +1220,428 @@ func CreateRecoveryJob(recovery *api.Recovery, restic *api.Restic, tag string) *
 			},
 		},
 		Spec: batch.JobSpec{
+			BackoffLimit:          recovery.Spec.BackoffLimit,
+			ActiveDeadlineSeconds: recovery.Spec.ActiveDeadlineSeconds,
 			Template: core.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels:      mergeStringMaps(recovery.Spec.PodLabels, map[string]string{"app": AppLabelStash}),
+					Annotations: mergeStringMaps(recovery.Spec.PodAnnotations, nil),
+				},
 				Spec: core.PodSpec{
 					Containers: []core.Container{
 						{
 							Name:  StashContainer,
 							Image: docker.ImageOperator + ":" + tag,
-							Args: []string{
+							Args: append([]string{
 								"recover",
 								"--recovery-name=" + recovery.Name,
-								"--v=10",
-							},
-							VolumeMounts: append(restic.Spec.VolumeMounts, core.VolumeMount{
+								fmt.Sprintf("--v=%d", resolveLogLevel(restic, logLevel, 10)),
+							}, append(append(snapshotSelectionArgs(recovery), restoreArgs(recovery)...), resticLimitArgs(restic)...)...),
+							VolumeMounts: append(recoveryVolumeMounts(recovery, restic), core.VolumeMount{
 								Name:      ScratchDirVolumeName,
-								MountPath: "/tmp",
-							}), // use volume mounts specified in restic
+								MountPath: restic.GetScratchMountPath(),
+							}),
+							LivenessProbe: recoveryLivenessProbe(recovery.Spec.LivenessProbe),
 						},
 					},
-					RestartPolicy: core.RestartPolicyOnFailure,
+					RestartPolicy: recoveryRestartPolicy(recovery),
 					Volumes: append(recovery.Spec.Volumes, core.Volume{
 						Name: ScratchDirVolumeName,
 						VolumeSource: core.VolumeSource{
 							EmptyDir: &core.EmptyDirVolumeSource{},
 						},
 					}),
-					NodeName: recovery.Spec.NodeName,
+					NodeName:          recovery.Spec.NodeName,
+					NodeSelector:      recovery.Spec.NodeSelector,
+					Tolerations:       recovery.Spec.Tolerations,
+					Affinity:          recovery.Spec.Affinity,
+					ImagePullSecrets:  recovery.Spec.ImagePullSecrets,
+					PriorityClassName: recovery.Spec.PriorityClassName,
 				},
 			},
 		},
 	}
 
-	// local backend
-	if restic.Spec.Backend.Local != nil {
-		job.Spec.Template.Spec.Containers[0].VolumeMounts = append(job.Spec.Template.Spec.Containers[0].VolumeMounts,
-			core.VolumeMount{
-				Name:      LocalVolumeName,
-				MountPath: restic.Spec.Backend.Local.Path,
-			})
+	// use resources specified in recovery, fallback to restic
+	if len(recovery.Spec.Resources.Limits) > 0 || len(recovery.Spec.Resources.Requests) > 0 {
+		job.Spec.Template.Spec.Containers[0].Resources = recovery.Spec.Resources
+	} else {
+		job.Spec.Template.Spec.Containers[0].Resources = restic.Spec.Resources
+	}
 
-		// user don't need to specify "stash-local" volume, we collect it from restic-spec
-		job.Spec.Template.Spec.Volumes = append(job.Spec.Template.Spec.Volumes,
-			core.Volume{
-				Name:         LocalVolumeName,
-				VolumeSource: restic.Spec.Backend.Local.VolumeSource,
-			})
+	podName, _ := api.StatefulSetPodName(recovery.Spec.Workload.Name, recovery.Spec.PodOrdinal) // ignore error for other kinds
+	if _, prefix, err := recovery.Spec.Workload.HostnamePrefix(podName, recovery.Spec.NodeName); err != nil {
+		log.Errorln(err)
+	} else if volumes, mounts, env, err := BackendToVolumesAndEnv(restic.Spec.Backend); err != nil {
+		log.Errorln(err)
+	} else {
+		// user don't need to specify backend volumes, we collect them from restic-spec
+		job.Spec.Template.Spec.Volumes = append(job.Spec.Template.Spec.Volumes, volumes...)
+		job.Spec.Template.Spec.Containers[0].VolumeMounts = append(job.Spec.Template.Spec.Containers[0].VolumeMounts, mounts...)
+		job.Spec.Template.Spec.Containers[0].Env = append(job.Spec.Template.Spec.Containers[0].Env, core.EnvVar{Name: RepositoryPrefixEnv, Value: prefix})
+		job.Spec.Template.Spec.Containers[0].Env = append(job.Spec.Template.Spec.Containers[0].Env, env...)
 	}
+	// set last, like in the sidecar, so that the job gets the same credentials
+	job.Spec.Template.Spec.Containers[0].Env = append(job.Spec.Template.Spec.Containers[0].Env, restic.Spec.Env...)
+	job.Spec.Template.Spec.Containers[0].EnvFrom = append(BackendToEnvFrom(restic.Spec.Backend), restic.Spec.EnvFrom...)
 
 	return job
 }
 
+// CreateRecoveryJobs returns the jobs recovering the files of restic. If recovery.Spec.ParallelVolumes
+// is set, one job per volume mount of restic is returned, restoring the FileGroups below its mount path.
+func CreateRecoveryJobs(recovery *api.Recovery, restic *api.Restic, tag string, logLevel int) []*batch.Job {
+	job := CreateRecoveryJob(recovery, restic, tag, logLevel)
+	mounts := restic.Spec.VolumeMounts
+	if !recovery.Spec.ParallelVolumes || recovery.Spec.Target != nil || len(mounts) < 2 {
+		return []*batch.Job{job}
+	}
+
+	jobs := make([]*batch.Job, 0, len(mounts))
+	for i, mount := range mounts {
+		j := job.DeepCopy()
+		j.Name = fmt.Sprintf("%s-%d", job.Name, i)
+		j.Annotations[AnnotationParallelJobs] = strconv.Itoa(len(mounts))
+		container := &j.Spec.Template.Spec.Containers[0]
+		container.Args = append(container.Args, "--volume-mount-path="+mount.MountPath)
+		// only mount the volume restored by this job
+		container.VolumeMounts = container.VolumeMounts[:0]
+		for _, m := range job.Spec.Template.Spec.Containers[0].VolumeMounts {
+			if m == mount || !containsVolumeMount(mounts, m) {
+				container.VolumeMounts = append(container.VolumeMounts, m)
+			}
+		}
+		j.Spec.Template.Spec.Volumes = j.Spec.Template.Spec.Volumes[:0]
+		for _, v := range job.Spec.Template.Spec.Volumes {
+			if hasVolumeMount(container.VolumeMounts, v.Name) {
+				j.Spec.Template.Spec.Volumes = append(j.Spec.Template.Spec.Volumes, v)
+			}
+		}
+		jobs = append(jobs, j)
+	}
+	return jobs
+}
+
+func containsVolumeMount(mounts []core.VolumeMount, mount core.VolumeMount) bool {
+	for _, m := range mounts {
+		if m == mount {
+			return true
+		}
+	}
+	return false
+}
+
+func hasVolumeMount(mounts []core.VolumeMount, name string) bool {
+	for _, m := range mounts {
+		if m.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// RecoverySourcePaths returns the paths recovered from the repository, the sourcePaths of recovery
+// or else the FileGroups of restic.
+func RecoverySourcePaths(recovery *api.Recovery, restic *api.Restic) []string {
+	if len(recovery.Spec.SourcePaths) > 0 {
+		return recovery.Spec.SourcePaths
+	}
+	paths := make([]string, 0, len(restic.Spec.FileGroups))
+	for _, fg := range restic.Spec.FileGroups {
+		paths = append(paths, fg.Path)
+	}
+	return paths
+}
+
+// UnmountedPaths returns the paths that are not below the mount path of any of mounts.
+func UnmountedPaths(paths []string, mounts []core.VolumeMount) []string {
+	var unmounted []string
+	for _, path := range paths {
+		found := false
+		for _, m := range mounts {
+			if IsPathBelow(path, m.MountPath) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			unmounted = append(unmounted, path)
+		}
+	}
+	return unmounted
+}
+
+// IsPathBelow returns true if path is dir or a path below dir.
+func IsPathBelow(path, dir string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, "../")
+}
+
+// mergeStringMaps returns a copy of user with reserved added, values in reserved take precedence.
+// It returns nil if both are empty.
+func mergeStringMaps(user, reserved map[string]string) map[string]string {
+	if len(user) == 0 && len(reserved) == 0 {
+		return nil
+	}
+	out := make(map[string]string, len(user)+len(reserved))
+	for k, v := range user {
+		out[k] = v
+	}
+	for k, v := range reserved {
+		out[k] = v
+	}
+	return out
+}
+
+// recoveryRestartPolicy returns the restart policy of recovery job pods, OnFailure unless set in recovery.
+func recoveryRestartPolicy(recovery *api.Recovery) core.RestartPolicy {
+	if recovery.Spec.RestartPolicy == "" {
+		return core.RestartPolicyOnFailure
+	}
+	return recovery.Spec.RestartPolicy
+}
+
+// recoveryVolumeMounts returns the volume mounts files are restored into. These are the mounts of
+// the target volume if set, otherwise the volume mounts specified in restic.
+func recoveryVolumeMounts(recovery *api.Recovery, restic *api.Restic) []core.VolumeMount {
+	if target := recovery.Spec.Target; target != nil {
+		return []core.VolumeMount{{Name: target.Volume, MountPath: target.MountPath}}
+	}
+	return restic.Spec.VolumeMounts
+}
+
+// resticLimitArgs returns the flags of the backup and recover commands limiting the restic commands they run.
+func resticLimitArgs(restic *api.Restic) []string {
+	var args []string
+	if restic.Spec.LimitUpload != nil {
+		args = append(args, fmt.Sprintf("--limit-upload=%d", *restic.Spec.LimitUpload))
+	}
+	if restic.Spec.LimitDownload != nil {
+		args = append(args, fmt.Sprintf("--limit-download=%d", *restic.Spec.LimitDownload))
+	}
+	if restic.Spec.Timeout != nil {
+		args = append(args, "--restic-timeout="+restic.Spec.Timeout.Duration.String())
+	}
+	return args
+}
+
+// backupArgs returns the flags of the backup command passed on to restic backup, e.g. selecting
+// the files skipped by restic.
+func backupArgs(restic *api.Restic) []string {
+	var args []string
+	for _, p := range restic.Spec.Excludes {
+		args = append(args, "--exclude="+p)
+	}
+	if restic.Spec.ExcludeCaches {
+		args = append(args, "--exclude-caches=true")
+	}
+	if restic.Spec.OneFileSystem {
+		args = append(args, "--one-file-system=true")
+	}
+	return args
+}
+
+func snapshotSelectionArgs(recovery *api.Recovery) []string {
+	var args []string
+	if recovery.Spec.SnapshotID != "" {
+		args = append(args, "--snapshot="+recovery.Spec.SnapshotID)
+	} else if recovery.Spec.Snapshot != "" {
+		args = append(args, "--snapshot="+recovery.Spec.Snapshot)
+	}
+	if len(recovery.Spec.Tags) > 0 {
+		args = append(args, "--tag="+strings.Join(recovery.Spec.Tags, ","))
+	}
+	if recovery.Spec.Time != nil {
+		args = append(args, "--before="+recovery.Spec.Time.UTC().Format(time.RFC3339))
+	}
+	return args
+}
+
+// restoreArgs returns the flags of the recover command selecting which files are restored and where.
+func restoreArgs(recovery *api.Recovery) []string {
+	var args []string
+	for _, p := range recovery.Spec.IncludePatterns {
+		args = append(args, "--include="+p)
+	}
+	for _, p := range recovery.Spec.ExcludePatterns {
+		args = append(args, "--exclude="+p)
+	}
+	if recovery.Spec.Target != nil {
+		args = append(args, "--target="+recovery.Spec.Target.MountPath)
+	}
+	return args
+}
+
+// recoveryLivenessProbe returns a copy of the probe with defaults applied to unset fields.
+// Without a handler, restic is considered unhealthy if it is stopped or a zombie.
+func recoveryLivenessProbe(probe *core.Probe) *core.Probe {
+	if probe == nil {
+		return nil
+	}
+	out := probe.DeepCopy()
+	if out.Exec == nil && out.HTTPGet == nil && out.TCPSocket == nil {
+		out.Exec = &core.ExecAction{
+			Command: []string{"/bin/sh", "-c", `! pid=$(pgrep -o -x restic) || ! grep -q '^State:[[:space:]]*[TZ]' /proc/$pid/status`},
+		}
+	}
+	if out.InitialDelaySeconds == 0 {
+		out.InitialDelaySeconds = 30
+	}
+	if out.PeriodSeconds == 0 {
+		out.PeriodSeconds = 60
+	}
+	if out.TimeoutSeconds == 0 {
+		out.TimeoutSeconds = 10
+	}
+	if out.FailureThreshold == 0 {
+		out.FailureThreshold = 3
+	}
+	return out
+}
+
 func WorkloadExists(k8sClient kubernetes.Interface, namespace string, workload api.LocalTypedReference) error {
+	_, err := GetWorkloadMeta(k8sClient, namespace, workload)
+	return err
+}
+
+// GetWorkloadMeta returns the ObjectMeta of the referenced workload.
+func GetWorkloadMeta(k8sClient kubernetes.Interface, namespace string, workload api.LocalTypedReference) (*metav1.ObjectMeta, error) {
 	if err := workload.Canonicalize(); err != nil {
-		return err
+		return nil, err
 	}
 
 	switch workload.Kind {
 	case api.KindDeployment:
-		_, err := k8sClient.AppsV1beta1().Deployments(namespace).Get(workload.Name, metav1.GetOptions{})
-		return err
+		obj, err := k8sClient.AppsV1beta1().Deployments(namespace).Get(workload.Name, metav1.GetOptions{})
+		if err != nil {
+			return nil, err
+		}
+		return &obj.ObjectMeta, nil
 	case api.KindReplicaSet:
-		_, err := k8sClient.ExtensionsV1beta1().ReplicaSets(namespace).Get(workload.Name, metav1.GetOptions{})
-		return err
+		obj, err := k8sClient.ExtensionsV1beta1().ReplicaSets(namespace).Get(workload.Name, metav1.GetOptions{})
+		if err != nil {
+			return nil, err
+		}
+		return &obj.ObjectMeta, nil
 	case api.KindReplicationController:
-		_, err := k8sClient.CoreV1().ReplicationControllers(namespace).Get(workload.Name, metav1.GetOptions{})
-		return err
+		obj, err := k8sClient.CoreV1().ReplicationControllers(namespace).Get(workload.Name, metav1.GetOptions{})
+		if err != nil {
+			return nil, err
+		}
+		return &obj.ObjectMeta, nil
 	case api.KindStatefulSet:
-		_, err := k8sClient.AppsV1beta1().StatefulSets(namespace).Get(workload.Name, metav1.GetOptions{})
-		return err
+		obj, err := k8sClient.AppsV1beta1().StatefulSets(namespace).Get(workload.Name, metav1.GetOptions{})
+		if err != nil {
+			return nil, err
+		}
+		return &obj.ObjectMeta, nil
 	case api.KindDaemonSet:
-		_, err := k8sClient.ExtensionsV1beta1().DaemonSets(namespace).Get(workload.Name, metav1.GetOptions{})
-		return err
+		obj, err := k8sClient.ExtensionsV1beta1().DaemonSets(namespace).Get(workload.Name, metav1.GetOptions{})
+		if err != nil {
+			return nil, err
+		}
+		return &obj.ObjectMeta, nil
+	case api.KindCronJob:
+		obj, err := k8sClient.BatchV1beta1().CronJobs(namespace).Get(workload.Name, metav1.GetOptions{})
+		if kerr.IsNotFound(err) {
+			return nil, fmt.Errorf("CronJob %s/%s not found", namespace, workload.Name)
+		} else if err != nil {
+			return nil, err
+		}
+		return &obj.ObjectMeta, nil
+	case api.KindPod:
+		obj, err := k8sClient.CoreV1().Pods(namespace).Get(workload.Name, metav1.GetOptions{})
+		if err != nil {
+			return nil, err
+		}
+		return &obj.ObjectMeta, nil
+	case api.KindDeploymentConfig:
+		return getDeploymentConfigMeta(k8sClient, namespace, workload.Name)
 	default:
-		fmt.Errorf(`unrecognized workload "Kind" %v`, workload.Kind)
+		return nil, fmt.Errorf(`unrecognized workload "Kind" %v`, workload.Kind)
 	}
-	return nil
+}
+
+// IsDeploymentConfigSupported reports whether the cluster serves OpenShift DeploymentConfigs.
+func IsDeploymentConfigSupported(k8sClient kubernetes.Interface) bool {
+	resources, err := k8sClient.Discovery().ServerResourcesForGroupVersion(OpenShiftAppsGroupVersion)
+	if err != nil || resources == nil {
+		return false
+	}
+	for _, r := range resources.APIResources {
+		if r.Name == ResourceDeploymentConfigs {
+			return true
+		}
+	}
+	return false
+}
+
+// DeploymentConfig holds the fields of an OpenShift DeploymentConfig used by the operator.
+type DeploymentConfig struct {
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+	Spec              struct {
+		Template core.PodTemplateSpec `json:"template"`
+	} `json:"spec"`
+}
+
+func (in *DeploymentConfig) DeepCopy() *DeploymentConfig {
+	out := new(DeploymentConfig)
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.Template.DeepCopyInto(&out.Spec.Template)
+	return out
+}
+
+// GetDeploymentConfig fetches a DeploymentConfig through the raw REST client,
+// since the OpenShift typed client is not available to the operator.
+func GetDeploymentConfig(k8sClient kubernetes.Interface, namespace, name string) (*DeploymentConfig, error) {
+	if !IsDeploymentConfigSupported(k8sClient) {
+		return nil, fmt.Errorf("workload kind %s is not supported by this cluster", api.KindDeploymentConfig)
+	}
+	data, err := k8sClient.Discovery().RESTClient().Get().
+		AbsPath("/apis", OpenShiftAppsGroupVersion, "namespaces", namespace, ResourceDeploymentConfigs, name).
+		DoRaw()
+	if kerr.IsNotFound(err) {
+		return nil, fmt.Errorf("DeploymentConfig %s/%s not found", namespace, name)
+	} else if err != nil {
+		return nil, err
+	}
+	var obj DeploymentConfig
+	if err := json.Unmarshal(data, &obj); err != nil {
+		return nil, err
+	}
+	return &obj, nil
+}
+
+func getDeploymentConfigMeta(k8sClient kubernetes.Interface, namespace, name string) (*metav1.ObjectMeta, error) {
+	obj, err := GetDeploymentConfig(k8sClient, namespace, name)
+	if err != nil {
+		return nil, err
+	}
+	return &obj.ObjectMeta, nil
+}
+
+// PatchDeploymentConfig replaces the annotations and the pod template of DeploymentConfig cur with those
+// of mod. It fails if cur was changed in the meantime. A changed pod template rolls out the
+// DeploymentConfig, if it has a config change trigger.
+func PatchDeploymentConfig(k8sClient kubernetes.Interface, cur, mod *DeploymentConfig) error {
+	annotations := mod.Annotations
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	patch, err := json.Marshal([]admission.PatchOperation{
+		{Op: "test", Path: "/metadata/resourceVersion", Value: cur.ResourceVersion},
+		{Op: "add", Path: "/metadata/annotations", Value: annotations},
+		{Op: "replace", Path: "/spec/template", Value: mod.Spec.Template},
+	})
+	if err != nil {
+		return err
+	}
+	log.Infof("Patching DeploymentConfig %s/%s with %s.", cur.Namespace, cur.Name, string(patch))
+	_, err = k8sClient.Discovery().RESTClient().Patch(types.JSONPatchType).
+		AbsPath("/apis", OpenShiftAppsGroupVersion, "namespaces", cur.Namespace, ResourceDeploymentConfigs, cur.Name).
+		Body(patch).
+		DoRaw()
+	return err
 }
 
 func ToBeInitializedByPeer(initializers *metav1.Initializers) bool {
@@ -578,21 +1759,205 @@ func CreateOrPatchCronJob(c kubernetes.Interface, job *batch_v1_beta.CronJob) (*
 	return c.BatchV1beta1().CronJobs(cur.Namespace).Patch(cur.Name, types.StrategicMergePatchType, patch)
 }
 
+// DeleteStashJob deletes a job with foreground propagation, so its pods are garbage collected
+// before the job itself is removed.
 func DeleteStashJob(client kubernetes.Interface, job batch.Job) error {
-	if err := client.BatchV1().Jobs(job.Namespace).Delete(job.Name, nil); err != nil && !kerr.IsNotFound(err) {
+	policy := metav1.DeletePropagationForeground
+	if err := client.BatchV1().Jobs(job.Namespace).Delete(job.Name, &metav1.DeleteOptions{PropagationPolicy: &policy}); err != nil && !kerr.IsNotFound(err) {
 		return fmt.Errorf("failed to delete job: %s, reason: %s", job.Name, err)
 	}
-	r, err := metav1.LabelSelectorAsSelector(job.Spec.Selector)
+	return nil
+}
+
+// JobResult is the outcome of a job run by stash.
+type JobResult string
+
+const (
+	JobResultRunning   JobResult = "Running"
+	JobResultSucceeded JobResult = "Succeeded"
+	JobResultFailed    JobResult = "Failed"
+	JobResultTimeout   JobResult = "Timeout"
+)
+
+// jobPollInterval is the interval WaitUntilJobCompleted checks the job.
+const jobPollInterval = 2 * time.Second
+
+// GetJobResult returns whether job succeeded, failed or is still running.
+func GetJobResult(job *batch.Job) JobResult {
+	if job.Status.Succeeded > 0 {
+		return JobResultSucceeded
+	} else if IsJobFailed(job) {
+		return JobResultFailed
+	}
+	return JobResultRunning
+}
+
+// IsJobFailed reports whether the job controller gave up on the job, i.e. the job has the condition
+// JobFailed, which the job controller sets once the backoff limit or the active deadline of the job is
+// exceeded, or the number of failed pods already exceeds the backoff limit.
+func IsJobFailed(job *batch.Job) bool {
+	for _, cond := range job.Status.Conditions {
+		if cond.Type == batch.JobFailed && cond.Status == core.ConditionTrue {
+			return true
+		}
+	}
+	return job.Spec.BackoffLimit != nil && job.Status.Failed > *job.Spec.BackoffLimit
+}
+
+// WaitUntilJobCompleted polls the job until it succeeded or failed. JobResultTimeout is returned
+// if the job is still running after timeout. A zero timeout waits forever.
+func WaitUntilJobCompleted(kubeClient kubernetes.Interface, namespace, name string, timeout time.Duration) (JobResult, error) {
+	result := JobResultRunning
+	err := wait.PollImmediate(jobPollInterval, timeout, func() (bool, error) {
+		job, err := kubeClient.BatchV1().Jobs(namespace).Get(name, metav1.GetOptions{})
+		if err != nil {
+			return false, err
+		}
+		result = GetJobResult(job)
+		return result != JobResultRunning, nil
+	})
+	if err == wait.ErrWaitTimeout {
+		return JobResultTimeout, nil
+	}
+	return result, err
+}
+
+// DeleteRecoveryJob deletes the recovery job created for recovery, any parallel recovery jobs and
+// their pods. It is not an error if no job exists.
+func DeleteRecoveryJob(client kubernetes.Interface, recovery *api.Recovery) error {
+	name := RecoveryJobPrefix + recovery.Name
+	err := DeleteStashJob(client, batch.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: recovery.Namespace,
+		},
+	})
 	if err != nil {
-		return fmt.Errorf("failed to select pods for job: %s, reason: %s", job.Name, err)
+		return err
 	}
-	if err = client.CoreV1().Pods(job.Namespace).DeleteCollection(&metav1.DeleteOptions{}, metav1.ListOptions{LabelSelector: r.String()}); err != nil {
-		return fmt.Errorf("failed to delete pods for job: %s, reason: %s", job.Name, err)
+	jobs, err := client.BatchV1().Jobs(recovery.Namespace).List(metav1.ListOptions{
+		LabelSelector: labels.SelectorFromSet(map[string]string{"app": AppLabelStash}).String(),
+	})
+	if err != nil {
+		return err
+	}
+	for _, job := range jobs.Items {
+		if job.Name != name && IsRecoveryJobOf(&job, recovery.Name) {
+			if err = DeleteStashJob(client, job); err != nil {
+				return err
+			}
+		}
 	}
 	return nil
 }
 
-func CreateCheckJob(restic *api.Restic, hostName string, smartPrefix string, tag string) *batch.Job {
+// IsRecoveryJobOf returns true if job was created to recover the Recovery with the given name.
+func IsRecoveryJobOf(job *batch.Job, recovery string) bool {
+	return job.Annotations[AnnotationOperation] == OperationRecovery && job.Annotations[AnnotationRecovery] == recovery
+}
+
+// RecoveryPhaseForError returns the phase of a Recovery whose restic restore returned err. Restic
+// skips files it can't restore, which is reported as cli.PartialRestoreError.
+func RecoveryPhaseForError(err error) api.RecoveryPhase {
+	switch {
+	case err == nil:
+		return api.RecoverySucceeded
+	case cli.IsPartialRestore(err):
+		return api.RecoveryPartial
+	}
+	return api.RecoveryFailed
+}
+
+// RecoveryPhaseConditions returns the conditions that describe a Recovery entering phase. A partially
+// recovered Recovery completed, but not successfully.
+func RecoveryPhaseConditions(phase api.RecoveryPhase, reason, msg string) []api.RecoveryCondition {
+	switch phase {
+	case api.RecoverySucceeded:
+		return []api.RecoveryCondition{{Type: api.RecoveryConditionComplete, Status: core.ConditionTrue, Reason: reason, Message: msg}}
+	case api.RecoveryPartial:
+		return []api.RecoveryCondition{{Type: api.RecoveryConditionComplete, Status: core.ConditionFalse, Reason: reason, Message: msg}}
+	case api.RecoveryFailed:
+		return []api.RecoveryCondition{{Type: api.RecoveryConditionFailed, Status: core.ConditionTrue, Reason: reason, Message: msg}}
+	}
+	return nil
+}
+
+// RecoveryFinishedTime returns when recovery succeeded, failed or partially recovered, i.e. the latest
+// transition time of its Complete and Failed conditions. It returns false if recovery did not finish.
+func RecoveryFinishedTime(recovery *api.Recovery) (time.Time, bool) {
+	switch recovery.Status.Phase {
+	case api.RecoverySucceeded, api.RecoveryFailed, api.RecoveryPartial:
+	default:
+		return time.Time{}, false
+	}
+	var finished time.Time
+	for _, cond := range recovery.Status.Conditions {
+		if (cond.Type == api.RecoveryConditionComplete || cond.Type == api.RecoveryConditionFailed) &&
+			cond.LastTransitionTime.Time.After(finished) {
+			finished = cond.LastTransitionTime.Time
+		}
+	}
+	return finished, !finished.IsZero()
+}
+
+// IsBackupStale returns true if the backup of restic scheduled after its last successful backup is
+// overdue by more than threshold at now, so that Restics backed up less often than threshold are not
+// always stale. A Restic that never backed up successfully is stale once its first backup is overdue.
+func IsBackupStale(restic *api.Restic, threshold time.Duration, now time.Time) bool {
+	last := restic.CreationTimestamp.Time
+	if restic.Status.LastSuccessfulBackupTime != nil {
+		last = restic.Status.LastSuccessfulBackupTime.Time
+	}
+	due := last
+	if restic.Spec.Schedule != "" {
+		if schedule, err := cron.Parse(restic.Spec.Schedule); err == nil {
+			due = schedule.Next(last)
+		}
+	}
+	return now.Sub(due) > threshold
+}
+
+// podLogs returns the logs of a pod. It is a variable as the fake clientset can't serve logs.
+var podLogs = func(kubeClient kubernetes.Interface, namespace, name string, opts *core.PodLogOptions) ([]byte, error) {
+	return kubeClient.CoreV1().Pods(namespace).GetLogs(name, opts).Do().Raw()
+}
+
+// GetJobPodLogs returns the name and the last tailLines lines of logs of the most recently created
+// pod of job.
+func GetJobPodLogs(kubeClient kubernetes.Interface, job *batch.Job, tailLines int64) (string, string, error) {
+	selector := labels.SelectorFromSet(map[string]string{"job-name": job.Name})
+	if job.Spec.Selector != nil {
+		var err error
+		if selector, err = metav1.LabelSelectorAsSelector(job.Spec.Selector); err != nil {
+			return "", "", err
+		}
+	}
+	pods, err := kubeClient.CoreV1().Pods(job.Namespace).List(metav1.ListOptions{LabelSelector: selector.String()})
+	if err != nil {
+		return "", "", err
+	}
+	var latest *core.Pod
+	for i := range pods.Items {
+		if pod := &pods.Items[i]; latest == nil || latest.CreationTimestamp.Before(&pod.CreationTimestamp) {
+			latest = pod
+		}
+	}
+	if latest == nil {
+		return "", "", fmt.Errorf("no pod found for job %s/%s", job.Namespace, job.Name)
+	}
+	logs, err := podLogs(kubeClient, latest.Namespace, latest.Name, &core.PodLogOptions{TailLines: &tailLines})
+	if err != nil {
+		return latest.Name, "", err
+	}
+	return latest.Name, string(logs), nil
+}
+
+func CreateCheckJob(restic *api.Restic, hostName string, smartPrefix string, tag string) (*batch.Job, error) {
+	volumes, mounts, env, err := BackendToVolumesAndEnv(restic.Spec.Backend)
+	if err != nil {
+		return nil, err
+	}
+
 	job := &batch.Job{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      CheckJobPrefix + restic.Name,
@@ -620,50 +1985,282 @@ func CreateCheckJob(restic *api.Restic, hostName string, smartPrefix string, tag
 						{
 							Name:  StashContainer,
 							Image: docker.ImageOperator + ":" + tag,
-							Args: []string{
+							Args: append([]string{
 								"check",
 								"--restic-name=" + restic.Name,
 								"--host-name=" + hostName,
 								"--smart-prefix=" + smartPrefix,
-								"--v=10",
-							},
-							VolumeMounts: []core.VolumeMount{
+								fmt.Sprintf("--v=%d", resolveLogLevel(restic, DefaultLogLevel, 10)),
+							}, resticLimitArgs(restic)...),
+							ImagePullPolicy: restic.Spec.ImagePullPolicy,
+							Env:             append(append([]core.EnvVar{{Name: RepositoryPrefixEnv, Value: smartPrefix}}, env...), restic.Spec.Env...),
+							EnvFrom:         append(BackendToEnvFrom(restic.Spec.Backend), restic.Spec.EnvFrom...),
+							VolumeMounts: append([]core.VolumeMount{
 								{
 									Name:      ScratchDirVolumeName,
-									MountPath: "/tmp",
+									MountPath: restic.GetScratchMountPath(),
 								},
-							},
+							}, mounts...),
 						},
 					},
 					RestartPolicy: core.RestartPolicyOnFailure,
-					Volumes: []core.Volume{
+					Volumes: append([]core.Volume{
 						{
 							Name: ScratchDirVolumeName,
 							VolumeSource: core.VolumeSource{
 								EmptyDir: &core.EmptyDirVolumeSource{},
 							},
 						},
+					}, volumes...),
+				},
+			},
+		},
+	}
+	return job, nil
+}
+
+// RepositoryHealthyCondition returns the RepositoryHealthy condition of a Restic
+// for the result of restic check run for the given host.
+func RepositoryHealthyCondition(hostName string, checkErr error) api.ResticCondition {
+	if checkErr != nil {
+		return api.ResticCondition{
+			Type:    api.ResticConditionRepositoryHealthy,
+			Status:  core.ConditionFalse,
+			Reason:  "CheckFailed",
+			Message: fmt.Sprintf("restic check failed for host %s, reason: %s", hostName, checkErr),
+		}
+	}
+	return api.ResticCondition{
+		Type:    api.ResticConditionRepositoryHealthy,
+		Status:  core.ConditionTrue,
+		Reason:  "CheckSucceeded",
+		Message: fmt.Sprintf("restic check succeeded for host %s", hostName),
+	}
+}
+
+// MirrorBackedUpCondition returns the MirrorBackedUp condition of a Restic for the result of the
+// backup to its mirror backend run by the given host.
+func MirrorBackedUpCondition(hostName string, mirrorErr error) api.ResticCondition {
+	if mirrorErr != nil {
+		return api.ResticCondition{
+			Type:    api.ResticConditionMirrorBackedUp,
+			Status:  core.ConditionFalse,
+			Reason:  "MirrorBackupFailed",
+			Message: fmt.Sprintf("backup to mirror failed for host %s, reason: %s", hostName, mirrorErr),
+		}
+	}
+	return api.ResticCondition{
+		Type:    api.ResticConditionMirrorBackedUp,
+		Status:  core.ConditionTrue,
+		Reason:  "MirrorBackupSucceeded",
+		Message: fmt.Sprintf("backup to mirror succeeded for host %s", hostName),
+	}
+}
+
+// HasRetentionPolicy reports whether any FileGroup of restic refers to a retention policy.
+func HasRetentionPolicy(restic *api.Restic) bool {
+	for _, fg := range restic.Spec.FileGroups {
+		if fg.RetentionPolicyName != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// CreateForgetJob returns a job that applies the retention policies of restic to the
+// repository of the given host, i.e. runs restic forget (and prune, if enabled).
+// The job is named per host, since every pod of a StatefulSet or DaemonSet has its own
+// snapshots. While the check job or the forget job of another host holds the repository
+// lock, restic forget fails and the container is restarted until it gets the lock.
+func CreateForgetJob(restic *api.Restic, hostName string, smartPrefix string, tag string) (*batch.Job, error) {
+	volumes, mounts, env, err := BackendToVolumesAndEnv(restic.Spec.Backend)
+	if err != nil {
+		return nil, err
+	}
+
+	job := &batch.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      ForgetJobPrefix + restic.Name + "-" + hostName,
+			Namespace: restic.Namespace,
+			OwnerReferences: []metav1.OwnerReference{
+				{
+					APIVersion: api.SchemeGroupVersion.String(),
+					Kind:       api.ResourceKindRestic,
+					Name:       restic.Name,
+					UID:        restic.UID,
+				},
+			},
+			Labels: map[string]string{
+				"app": AppLabelStash,
+			},
+			Annotations: map[string]string{
+				AnnotationRestic:    restic.Name,
+				AnnotationOperation: OperationForget,
+			},
+		},
+		Spec: batch.JobSpec{
+			Template: core.PodTemplateSpec{
+				Spec: core.PodSpec{
+					Containers: []core.Container{
+						{
+							Name:  StashContainer,
+							Image: docker.ImageOperator + ":" + tag,
+							Args: append([]string{
+								"forget",
+								"--restic-name=" + restic.Name,
+								"--host-name=" + hostName,
+								"--smart-prefix=" + smartPrefix,
+								fmt.Sprintf("--v=%d", resolveLogLevel(restic, DefaultLogLevel, 10)),
+							}, resticLimitArgs(restic)...),
+							ImagePullPolicy: restic.Spec.ImagePullPolicy,
+							Env:             append(append([]core.EnvVar{{Name: RepositoryPrefixEnv, Value: smartPrefix}}, env...), restic.Spec.Env...),
+							EnvFrom:         append(BackendToEnvFrom(restic.Spec.Backend), restic.Spec.EnvFrom...),
+							Resources:       restic.Spec.Resources,
+							VolumeMounts: append([]core.VolumeMount{
+								{
+									Name:      ScratchDirVolumeName,
+									MountPath: restic.GetScratchMountPath(),
+								},
+							}, mounts...),
+						},
 					},
+					RestartPolicy: core.RestartPolicyOnFailure,
+					Volumes: append([]core.Volume{
+						{
+							Name: ScratchDirVolumeName,
+							VolumeSource: core.VolumeSource{
+								EmptyDir: &core.EmptyDirVolumeSource{},
+							},
+						},
+					}, volumes...),
 				},
 			},
 		},
 	}
+	return job, nil
+}
 
-	// local backend
-	if restic.Spec.Backend.Local != nil {
-		job.Spec.Template.Spec.Containers[0].VolumeMounts = append(job.Spec.Template.Spec.Containers[0].VolumeMounts,
-			core.VolumeMount{
-				Name:      LocalVolumeName,
-				MountPath: restic.Spec.Backend.Local.Path,
-			})
+// SharesRepository returns true if all replicas of a workload of the given kind back up to the
+// same repository. Only the leader of their sidecars runs backups.
+func SharesRepository(kind string) bool {
+	switch kind {
+	case api.KindDeployment, api.KindReplicaSet, api.KindReplicationController, api.KindDeploymentConfig:
+		return true
+	}
+	return false
+}
 
-		// user don't need to specify "stash-local" volume, we collect it from restic-spec
-		job.Spec.Template.Spec.Volumes = append(job.Spec.Template.Spec.Volumes,
-			core.Volume{
-				Name:         LocalVolumeName,
-				VolumeSource: restic.Spec.Backend.Local.VolumeSource,
-			})
+// InitJobPrefixes returns the smart prefixes of the repositories initialized by the init job.
+func InitJobPrefixes(job *batch.Job) []string {
+	var prefixes []string
+	for _, c := range job.Spec.Template.Spec.Containers {
+		for _, arg := range c.Args {
+			if strings.HasPrefix(arg, "--smart-prefix=") {
+				prefixes = append(prefixes, strings.TrimPrefix(arg, "--smart-prefix="))
+			}
+		}
 	}
+	return prefixes
+}
 
-	return job
+// CreateInitJob returns a job that initializes the repositories of restic with the given smart
+// prefixes, unless they exist already.
+func CreateInitJob(restic *api.Restic, smartPrefixes []string, tag string) (*batch.Job, error) {
+	if len(smartPrefixes) == 0 {
+		return nil, fmt.Errorf("no repository to initialize for Restic %s/%s", restic.Namespace, restic.Name)
+	}
+	volumes, mounts, env, err := BackendToVolumesAndEnv(restic.Spec.Backend)
+	if err != nil {
+		return nil, err
+	}
+
+	args := []string{
+		"init",
+		"--restic-name=" + restic.Name,
+	}
+	for _, prefix := range smartPrefixes {
+		args = append(args, "--smart-prefix="+prefix)
+	}
+	args = append(args, fmt.Sprintf("--v=%d", resolveLogLevel(restic, DefaultLogLevel, 10)))
+
+	job := &batch.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      InitJobPrefix + restic.Name,
+			Namespace: restic.Namespace,
+			OwnerReferences: []metav1.OwnerReference{
+				*metav1.NewControllerRef(restic, api.SchemeGroupVersion.WithKind(api.ResourceKindRestic)),
+			},
+			Labels: map[string]string{
+				"app": AppLabelStash,
+			},
+			Annotations: map[string]string{
+				AnnotationRestic:    restic.Name,
+				AnnotationOperation: OperationInit,
+			},
+		},
+		Spec: batch.JobSpec{
+			Template: core.PodTemplateSpec{
+				Spec: core.PodSpec{
+					Containers: []core.Container{
+						{
+							Name:            StashContainer,
+							Image:           docker.ImageOperator + ":" + tag,
+							Args:            args,
+							ImagePullPolicy: restic.Spec.ImagePullPolicy,
+							// the repository url of the backend env refers to the first prefix, the init
+							// command sets it for each prefix
+							Env:       append(append([]core.EnvVar{{Name: RepositoryPrefixEnv, Value: smartPrefixes[0]}}, env...), restic.Spec.Env...),
+							EnvFrom:   append(BackendToEnvFrom(restic.Spec.Backend), restic.Spec.EnvFrom...),
+							Resources: restic.Spec.Resources,
+							VolumeMounts: append([]core.VolumeMount{
+								{
+									Name:      ScratchDirVolumeName,
+									MountPath: restic.GetScratchMountPath(),
+								},
+							}, mounts...),
+						},
+					},
+					RestartPolicy: core.RestartPolicyOnFailure,
+					Volumes: append([]core.Volume{
+						{
+							Name: ScratchDirVolumeName,
+							VolumeSource: core.VolumeSource{
+								EmptyDir: &core.EmptyDirVolumeSource{},
+							},
+						},
+					}, volumes...),
+				},
+			},
+		},
+	}
+	return job, nil
+}
+
+// RepositoryInitializedCondition returns the RepositoryInitialized condition of a Restic
+// for the result of its init job.
+func RepositoryInitializedCondition(result JobResult, msg string) api.ResticCondition {
+	if result != JobResultSucceeded {
+		return api.ResticCondition{
+			Type:    api.ResticConditionRepositoryInitialized,
+			Status:  core.ConditionFalse,
+			Reason:  "InitFailed",
+			Message: msg,
+		}
+	}
+	return api.ResticCondition{
+		Type:    api.ResticConditionRepositoryInitialized,
+		Status:  core.ConditionTrue,
+		Reason:  "InitSucceeded",
+		Message: msg,
+	}
+}
+
+// HasResticCondition returns true if restic has a condition of the given type and status.
+func HasResticCondition(restic *api.Restic, condType api.ResticConditionType, status core.ConditionStatus) bool {
+	for _, cond := range restic.Status.Conditions {
+		if cond.Type == condType && cond.Status == status {
+			return true
+		}
+	}
+	return false
 }
@@ -0,0 +1,2204 @@
+package util
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	api "github.com/appscode/stash/apis/stash/v1alpha1"
+	stash_listers "github.com/appscode/stash/listers/stash/v1alpha1"
+	"github.com/appscode/stash/pkg/cli"
+	"github.com/appscode/stash/pkg/docker"
+	"github.com/google/go-cmp/cmp"
+	apps "k8s.io/api/apps/v1beta1"
+	batch "k8s.io/api/batch/v1"
+	core "k8s.io/api/core/v1"
+	extensions "k8s.io/api/extensions/v1beta1"
+	policy "k8s.io/api/policy/v1beta1"
+	kerr "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+	batchv1 "k8s.io/client-go/kubernetes/typed/batch/v1"
+	"k8s.io/client-go/rest"
+	clienttesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+)
+
+func TestWorkloadExistsUnknownKind(t *testing.T) {
+	err := WorkloadExists(fake.NewSimpleClientset(), "default", api.LocalTypedReference{Kind: "Foobar", Name: "foo"})
+	if err == nil {
+		t.Fatal("expected error for unrecognized workload kind")
+	}
+}
+
+// newOpenShiftServer fakes the subset of the OpenShift apps API used by GetWorkloadMeta.
+func newOpenShiftServer(deploymentConfigs ...string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Path == "/apis/"+OpenShiftAppsGroupVersion {
+			w.Write([]byte(`{"kind":"APIResourceList","groupVersion":"apps.openshift.io/v1","resources":[{"name":"deploymentconfigs","namespaced":true,"kind":"DeploymentConfig"}]}`))
+			return
+		}
+		for _, name := range deploymentConfigs {
+			if r.URL.Path == "/apis/"+OpenShiftAppsGroupVersion+"/namespaces/default/deploymentconfigs/"+name {
+				w.Write([]byte(`{"kind":"DeploymentConfig","apiVersion":"apps.openshift.io/v1","metadata":{"name":"` + name + `","namespace":"default","labels":{"app":"` + name + `"}}}`))
+				return
+			}
+		}
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"kind":"Status","apiVersion":"v1","status":"Failure","reason":"NotFound","code":404}`))
+	}))
+}
+
+func TestWorkloadExistsDeploymentConfig(t *testing.T) {
+	server := newOpenShiftServer("app")
+	defer server.Close()
+	client, err := kubernetes.NewForConfig(&rest.Config{Host: server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	meta, err := GetWorkloadMeta(client, "default", api.LocalTypedReference{Kind: "dc", Name: "app"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta.Name != "app" || meta.Labels["app"] != "app" {
+		t.Errorf("unexpected metadata %+v", meta)
+	}
+
+	err = WorkloadExists(client, "default", api.LocalTypedReference{Kind: api.KindDeploymentConfig, Name: "missing"})
+	if err == nil || !strings.Contains(err.Error(), "not found") {
+		t.Errorf("expected not found error, got %v", err)
+	}
+}
+
+func TestWorkloadExistsPod(t *testing.T) {
+	pod := &core.Pod{ObjectMeta: metav1.ObjectMeta{Name: "db", Namespace: "default"}}
+	client := fake.NewSimpleClientset(pod)
+
+	meta, err := GetWorkloadMeta(client, "default", api.LocalTypedReference{Kind: "po", Name: "db"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta.Name != "db" {
+		t.Errorf("unexpected metadata %+v", meta)
+	}
+	if err := WorkloadExists(client, "default", api.LocalTypedReference{Kind: api.KindPod, Name: "missing"}); err == nil {
+		t.Error("expected error for missing pod")
+	}
+}
+
+func TestCreateSidecarContainerPod(t *testing.T) {
+	r := &api.Restic{}
+	r.Name = "db-backup"
+	r.Spec.Backend = api.Backend{
+		StorageSecretName: "local-secret",
+		Local:             &api.LocalSpec{Path: "/repo"},
+	}
+	workload := api.LocalTypedReference{Kind: api.KindPod, Name: "db"}
+
+	sidecar := sidecarContainer(t, r, "canary", "", workload, DefaultLogLevel, nil)
+	if v := envMap(sidecar)[RepositoryPrefixEnv].Value; v != "pod/db" {
+		t.Errorf("unexpected %s %q", RepositoryPrefixEnv, v)
+	}
+	if !HasStashSidecar(core.PodSpec{Containers: []core.Container{sidecar}}) {
+		t.Error("expected pod spec with sidecar to be detected")
+	}
+	if HasStashSidecar(core.PodSpec{Containers: []core.Container{{Name: "db"}}}) {
+		t.Error("expected pod spec without sidecar not to be detected")
+	}
+}
+
+func TestApplyDefaultResources(t *testing.T) {
+	q := resource.MustParse
+	defaults := core.ResourceRequirements{
+		Requests: core.ResourceList{core.ResourceCPU: q("100m"), core.ResourceMemory: q("128Mi")},
+		Limits:   core.ResourceList{core.ResourceCPU: q("500m"), core.ResourceMemory: q("256Mi")},
+	}
+	cases := map[string]struct {
+		resources core.ResourceRequirements
+		expected  core.ResourceRequirements
+	}{
+		"unset": {core.ResourceRequirements{}, defaults},
+		"request set": {
+			core.ResourceRequirements{Requests: core.ResourceList{core.ResourceCPU: q("200m")}},
+			core.ResourceRequirements{
+				Requests: core.ResourceList{core.ResourceCPU: q("200m"), core.ResourceMemory: q("128Mi")},
+				Limits:   defaults.Limits,
+			},
+		},
+		"limit set": {
+			core.ResourceRequirements{Limits: core.ResourceList{core.ResourceMemory: q("1Gi")}},
+			core.ResourceRequirements{
+				Requests: defaults.Requests,
+				Limits:   core.ResourceList{core.ResourceCPU: q("500m"), core.ResourceMemory: q("1Gi")},
+			},
+		},
+		"request above default limit": {
+			core.ResourceRequirements{Requests: core.ResourceList{core.ResourceCPU: q("1")}},
+			core.ResourceRequirements{
+				Requests: core.ResourceList{core.ResourceCPU: q("1"), core.ResourceMemory: q("128Mi")},
+				Limits:   core.ResourceList{core.ResourceMemory: q("256Mi")},
+			},
+		},
+		"limit below default request": {
+			core.ResourceRequirements{Limits: core.ResourceList{core.ResourceMemory: q("64Mi")}},
+			core.ResourceRequirements{
+				Requests: core.ResourceList{core.ResourceCPU: q("100m")},
+				Limits:   core.ResourceList{core.ResourceCPU: q("500m"), core.ResourceMemory: q("64Mi")},
+			},
+		},
+	}
+	for name, c := range cases {
+		original := c.resources.DeepCopy()
+		resources := ApplyDefaultResources(c.resources, defaults)
+		if !cmp.Equal(resources, c.expected, quantityComparer) {
+			t.Errorf("%s: expected %+v, found %+v", name, c.expected, resources)
+		}
+		if !cmp.Equal(c.resources, *original, quantityComparer) {
+			t.Errorf("%s: resources of Restic were modified", name)
+		}
+	}
+
+	// no defaults leave resources unset
+	if resources := ApplyDefaultResources(core.ResourceRequirements{}, core.ResourceRequirements{}); resources.Requests != nil || resources.Limits != nil {
+		t.Errorf("expected no resources, found %+v", resources)
+	}
+}
+
+func TestJobDefaultResources(t *testing.T) {
+	q := resource.MustParse
+	defaults := core.ResourceRequirements{
+		Requests: core.ResourceList{core.ResourceCPU: q("100m"), core.ResourceMemory: q("128Mi")},
+		Limits:   core.ResourceList{core.ResourceMemory: q("256Mi")},
+	}
+	restic := &api.Restic{}
+	restic.Spec.Resources.Requests = core.ResourceList{core.ResourceCPU: q("1")}
+	job, err := CreateForgetJob(restic, "host-0", "deployment/db", "canary")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ApplyJobDefaultResources(job, defaults)
+	expected := core.ResourceRequirements{
+		Requests: core.ResourceList{core.ResourceCPU: q("1"), core.ResourceMemory: q("128Mi")},
+		Limits:   defaults.Limits,
+	}
+	if resources := job.Spec.Template.Spec.Containers[0].Resources; !cmp.Equal(resources, expected, quantityComparer) {
+		t.Errorf("expected %+v, found %+v", expected, resources)
+	}
+
+	args := JobDefaultResourceArgs(defaults)
+	expectedArgs := []string{"--job-default-cpu-request=100m", "--job-default-memory-request=128Mi", "--job-default-memory-limit=256Mi"}
+	if strings.Join(args, "|") != strings.Join(expectedArgs, "|") {
+		t.Errorf("expected args %v, found %v", expectedArgs, args)
+	}
+	if args := JobDefaultResourceArgs(core.ResourceRequirements{}); len(args) != 0 {
+		t.Errorf("expected no args without defaults, found %v", args)
+	}
+}
+
+func TestCreateSidecarContainerImage(t *testing.T) {
+	const imageDigest = "sha256:5f70bf18a086007016e948b04aed3b82103a36bea41755b6cddfaf10ace3c6ef"
+	workload := api.LocalTypedReference{Kind: api.KindDeployment, Name: "db"}
+	r := &api.Restic{}
+
+	if image := sidecarContainer(t, r, "0.7.0", "", workload, DefaultLogLevel, nil).Image; image != docker.ImageOperator+":0.7.0" {
+		t.Errorf("expected image pinned by tag, found %s", image)
+	}
+	if image := sidecarContainer(t, r, "0.7.0", imageDigest, workload, DefaultLogLevel, nil).Image; image != docker.ImageOperator+"@"+imageDigest {
+		t.Errorf("expected image pinned by digest, found %s", image)
+	}
+	if image := initContainer(t, r, "0.7.0", imageDigest, workload, false, nil).Image; image != docker.ImageOperator+"@"+imageDigest {
+		t.Errorf("expected init container image pinned by digest, found %s", image)
+	}
+
+	// the digest belongs to the operator tag, not to a tag requested by the Restic
+	r.Annotations = map[string]string{api.VersionTag: "0.6.4"}
+	if image := sidecarContainer(t, r, "0.7.0", imageDigest, workload, DefaultLogLevel, nil).Image; image != docker.ImageOperator+":0.6.4" {
+		t.Errorf("expected image with tag of Restic, found %s", image)
+	}
+}
+
+func TestCreateSidecarContainerEnv(t *testing.T) {
+	r := &api.Restic{}
+	r.Spec.Backend = api.Backend{
+		StorageSecretName: "s3-secret",
+		S3:                &api.S3Spec{Endpoint: "s3.amazonaws.com", Bucket: "stash"},
+	}
+	r.Spec.Env = []core.EnvVar{{Name: cli.AWS_ACCESS_KEY_ID, Value: "workload-key"}}
+	r.Spec.EnvFrom = []core.EnvFromSource{
+		{SecretRef: &core.SecretEnvSource{LocalObjectReference: core.LocalObjectReference{Name: "aws-credentials"}}},
+		{Prefix: "BACKUP_", ConfigMapRef: &core.ConfigMapEnvSource{LocalObjectReference: core.LocalObjectReference{Name: "backup-config"}}},
+	}
+	workload := api.LocalTypedReference{Kind: api.KindDeployment, Name: "db"}
+	recovery := &api.Recovery{Spec: api.RecoverySpec{Workload: workload}}
+	checkJob, err := CreateCheckJob(r, "host-0", "deployment/db", "canary")
+	if err != nil {
+		t.Fatal(err)
+	}
+	forgetJob, err := CreateForgetJob(r, "host-0", "deployment/db", "canary")
+	if err != nil {
+		t.Fatal(err)
+	}
+	initJob, err := CreateInitJob(r, []string{"deployment/db"}, "canary")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, container := range []core.Container{
+		sidecarContainer(t, r, "canary", "", workload, DefaultLogLevel, nil),
+		initContainer(t, r, "canary", "", workload, false, nil),
+		CreateRecoveryJob(recovery, r, "canary", DefaultLogLevel).Spec.Template.Spec.Containers[0],
+		checkJob.Spec.Template.Spec.Containers[0],
+		forgetJob.Spec.Template.Spec.Containers[0],
+		initJob.Spec.Template.Spec.Containers[0],
+	} {
+		if !reflect.DeepEqual(container.EnvFrom, r.Spec.EnvFrom) {
+			t.Errorf("expected envFrom %+v, found %+v", r.Spec.EnvFrom, container.EnvFrom)
+		}
+		// the variable set in the Restic comes last, so it takes precedence over the repository secret
+		if last := container.Env[len(container.Env)-1]; last.Name != cli.AWS_ACCESS_KEY_ID || last.Value != "workload-key" {
+			t.Errorf("expected %s of Restic as last variable, found %+v", cli.AWS_ACCESS_KEY_ID, last)
+		}
+	}
+}
+
+func TestJobLogLevel(t *testing.T) {
+	level := int32(2)
+	cases := []struct {
+		logLevel *int32
+		expected string
+	}{
+		{nil, "--v=10"},
+		{&level, "--v=2"},
+	}
+	for _, c := range cases {
+		r := &api.Restic{}
+		r.Spec.LogLevel = c.logLevel
+		checkJob, err := CreateCheckJob(r, "host-0", "deployment/db", "canary")
+		if err != nil {
+			t.Fatal(err)
+		}
+		forgetJob, err := CreateForgetJob(r, "host-0", "deployment/db", "canary")
+		if err != nil {
+			t.Fatal(err)
+		}
+		initJob, err := CreateInitJob(r, []string{"deployment/db"}, "canary")
+		if err != nil {
+			t.Fatal(err)
+		}
+		for name, job := range map[string]*batch.Job{"check": checkJob, "forget": forgetJob, "init": initJob} {
+			if args := job.Spec.Template.Spec.Containers[0].Args; !strings.Contains("|"+strings.Join(args, "|")+"|", "|"+c.expected+"|") {
+				t.Errorf("%s: expected %s, found %v", name, c.expected, args)
+			}
+		}
+	}
+}
+
+func TestJobImagePullPolicy(t *testing.T) {
+	r := &api.Restic{}
+	r.Spec.ImagePullPolicy = core.PullNever
+	checkJob, err := CreateCheckJob(r, "host-0", "deployment/db", "canary")
+	if err != nil {
+		t.Fatal(err)
+	}
+	forgetJob, err := CreateForgetJob(r, "host-0", "deployment/db", "canary")
+	if err != nil {
+		t.Fatal(err)
+	}
+	initJob, err := CreateInitJob(r, []string{"deployment/db"}, "canary")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, job := range map[string]*batch.Job{"check": checkJob, "forget": forgetJob, "init": initJob} {
+		if policy := job.Spec.Template.Spec.Containers[0].ImagePullPolicy; policy != core.PullNever {
+			t.Errorf("%s: expected image pull policy %s, found %s", name, core.PullNever, policy)
+		}
+	}
+}
+
+func TestCreateSidecarContainerPasswordSource(t *testing.T) {
+	r := &api.Restic{}
+	r.Spec.Backend = api.Backend{
+		StorageSecretName: "local-secret",
+		Local:             &api.LocalSpec{Path: "/repository"},
+	}
+	workload := api.LocalTypedReference{Kind: api.KindDeployment, Name: "db"}
+	passwordFile := func(c core.Container) (string, bool) {
+		mounted := false
+		for _, m := range c.VolumeMounts {
+			mounted = mounted || m.Name == PasswordVolumeName
+		}
+		for _, e := range c.Env {
+			if e.Name == cli.RESTIC_PASSWORD_FILE {
+				return e.Value, mounted
+			}
+		}
+		return "", mounted
+	}
+
+	for _, source := range []api.PasswordSource{"", api.PasswordSourceEnv} {
+		r.Spec.Backend.PasswordSource = source
+		if file, mounted := passwordFile(sidecarContainer(t, r, "canary", "", workload, DefaultLogLevel, nil)); file != "" || mounted {
+			t.Errorf("%q: expected no password file, found %q, mounted %v", source, file, mounted)
+		}
+	}
+
+	r.Spec.Backend.PasswordSource = api.PasswordSourceFile
+	if file, mounted := passwordFile(sidecarContainer(t, r, "canary", "", workload, DefaultLogLevel, nil)); file != "/etc/stash-password/restic_password" || !mounted {
+		t.Errorf("expected mounted password file, found %q, mounted %v", file, mounted)
+	}
+	volumes, _, _, err := BackendToVolumesAndEnv(r.Spec.Backend)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(volumes) != 2 || volumes[0].Name != PasswordVolumeName || volumes[0].Secret == nil ||
+		volumes[0].Secret.SecretName != "local-secret" || volumes[0].Secret.Items[0].Key != cli.RESTIC_PASSWORD {
+		t.Errorf("expected password volume from repository secret, found %+v", volumes)
+	}
+}
+
+func TestUpsertDownwardVolume(t *testing.T) {
+	volumes := UpsertDownwardVolume(nil)
+	if len(volumes) != 1 || volumes[0].Name != PodinfoVolumeName || volumes[0].DownwardAPI == nil {
+		t.Fatalf("expected downward volume %s, found %+v", PodinfoVolumeName, volumes)
+	}
+	files := map[string]string{}
+	for _, item := range volumes[0].DownwardAPI.Items {
+		files[item.Path] = item.FieldRef.FieldPath
+	}
+	expected := map[string]string{
+		"labels":      "metadata.labels",
+		"annotations": "metadata.annotations",
+		"namespace":   "metadata.namespace",
+	}
+	if !reflect.DeepEqual(files, expected) {
+		t.Errorf("expected downward files %v, found %v", expected, files)
+	}
+}
+
+func TestCreateSidecarContainerPodinfoMountPath(t *testing.T) {
+	workload := api.LocalTypedReference{Kind: api.KindDeployment, Name: "db"}
+	podinfoMount := func(c core.Container) string {
+		for _, m := range c.VolumeMounts {
+			if m.Name == PodinfoVolumeName {
+				return m.MountPath
+			}
+		}
+		return ""
+	}
+	hasArg := func(c core.Container, arg string) bool {
+		for _, a := range c.Args {
+			if a == arg {
+				return true
+			}
+		}
+		return false
+	}
+
+	r := &api.Restic{}
+	sidecar := sidecarContainer(t, r, "canary", "", workload, DefaultLogLevel, nil)
+	if path := podinfoMount(sidecar); path != PodinfoMountPath {
+		t.Errorf("expected podinfo mounted at %s, found %s", PodinfoMountPath, path)
+	}
+	for _, a := range sidecar.Args {
+		if strings.HasPrefix(a, "--pod-labels-path") {
+			t.Errorf("unexpected arg %s for default podinfo mount path", a)
+		}
+	}
+
+	r.Spec.PodinfoMountPath = "/var/run/stash"
+	for _, container := range []core.Container{
+		sidecarContainer(t, r, "canary", "", workload, DefaultLogLevel, nil),
+		initContainer(t, r, "canary", "", workload, false, nil),
+	} {
+		if path := podinfoMount(container); path != "/var/run/stash" {
+			t.Errorf("expected podinfo mounted at /var/run/stash, found %s", path)
+		}
+		if !hasArg(container, "--pod-labels-path=/var/run/stash/labels") {
+			t.Errorf("expected pod labels path arg, found %v", container.Args)
+		}
+	}
+}
+
+func TestScratchMountPath(t *testing.T) {
+	workload := api.LocalTypedReference{Kind: api.KindDeployment, Name: "db"}
+	scratchMount := func(c core.Container) string {
+		for _, m := range c.VolumeMounts {
+			if m.Name == ScratchDirVolumeName {
+				return m.MountPath
+			}
+		}
+		return ""
+	}
+	scratchArg := func(c core.Container) string {
+		for _, a := range c.Args {
+			if strings.HasPrefix(a, "--scratch-dir") {
+				return a
+			}
+		}
+		return ""
+	}
+
+	r := &api.Restic{}
+	sidecar := sidecarContainer(t, r, "canary", "", workload, DefaultLogLevel, nil)
+	if path := scratchMount(sidecar); path != "/tmp" {
+		t.Errorf("expected scratch volume mounted at /tmp, found %s", path)
+	}
+	if arg := scratchArg(sidecar); arg != "" {
+		t.Errorf("unexpected arg %s for default scratch mount path", arg)
+	}
+
+	r.Spec.ScratchMountPath = "/var/cache/stash"
+	for _, container := range []core.Container{
+		sidecarContainer(t, r, "canary", "", workload, DefaultLogLevel, nil),
+		initContainer(t, r, "canary", "", workload, false, nil),
+	} {
+		if path := scratchMount(container); path != "/var/cache/stash" {
+			t.Errorf("expected scratch volume mounted at /var/cache/stash, found %s", path)
+		}
+		if arg := scratchArg(container); arg != "--scratch-dir=/var/cache/stash" {
+			t.Errorf("expected scratch dir arg, found %v", container.Args)
+		}
+	}
+
+	job := CreateRecoveryJob(&api.Recovery{}, r, "canary", DefaultLogLevel)
+	if path := scratchMount(job.Spec.Template.Spec.Containers[0]); path != "/var/cache/stash" {
+		t.Errorf("expected scratch volume of recovery job mounted at /var/cache/stash, found %s", path)
+	}
+}
+
+func TestExcludeArgs(t *testing.T) {
+	workload := api.LocalTypedReference{Kind: api.KindDeployment, Name: "db"}
+	excludeArgs := func(c core.Container) []string {
+		var args []string
+		for _, a := range c.Args {
+			if strings.HasPrefix(a, "--exclude") {
+				args = append(args, a)
+			}
+		}
+		return args
+	}
+
+	r := &api.Restic{}
+	if args := excludeArgs(sidecarContainer(t, r, "canary", "", workload, DefaultLogLevel, nil)); len(args) != 0 {
+		t.Errorf("unexpected exclude args %v", args)
+	}
+
+	r.Spec.Excludes = []string{"*.tmp", "/source/data/lost+found"}
+	r.Spec.ExcludeCaches = true
+	expected := []string{"--exclude=*.tmp", "--exclude=/source/data/lost+found", "--exclude-caches=true"}
+	for _, container := range []core.Container{
+		sidecarContainer(t, r, "canary", "", workload, DefaultLogLevel, nil),
+		initContainer(t, r, "canary", "", workload, false, nil),
+	} {
+		if args := excludeArgs(container); !reflect.DeepEqual(args, expected) {
+			t.Errorf("expected exclude args %v, found %v", expected, container.Args)
+		}
+	}
+}
+
+func TestOneFileSystemArg(t *testing.T) {
+	workload := api.LocalTypedReference{Kind: api.KindDeployment, Name: "db"}
+	hasArg := func(c core.Container) bool {
+		for _, a := range c.Args {
+			if a == "--one-file-system=true" {
+				return true
+			}
+		}
+		return false
+	}
+
+	for _, oneFileSystem := range []bool{false, true} {
+		r := &api.Restic{Spec: api.ResticSpec{OneFileSystem: oneFileSystem}}
+		for _, container := range []core.Container{
+			sidecarContainer(t, r, "canary", "", workload, DefaultLogLevel, nil),
+			initContainer(t, r, "canary", "", workload, false, nil),
+		} {
+			if hasArg(container) != oneFileSystem {
+				t.Errorf("oneFileSystem=%v: unexpected args %v", oneFileSystem, container.Args)
+			}
+		}
+	}
+}
+
+func TestCacheVolume(t *testing.T) {
+	workload := api.LocalTypedReference{Kind: api.KindDeployment, Name: "db"}
+	cacheEnv := func(c core.Container) string {
+		for _, e := range c.Env {
+			if e.Name == cli.RESTIC_CACHE_DIR {
+				return e.Value
+			}
+		}
+		return ""
+	}
+
+	r := &api.Restic{}
+	sidecar := sidecarContainer(t, r, "canary", "", workload, DefaultLogLevel, nil)
+	for _, m := range sidecar.VolumeMounts {
+		if m.Name == CacheVolumeName {
+			t.Errorf("unexpected cache volume mount %+v", m)
+		}
+	}
+	if dir := cacheEnv(sidecar); dir != "" {
+		t.Errorf("unexpected %s %s", cli.RESTIC_CACHE_DIR, dir)
+	}
+
+	r.Spec.Cache = &api.CacheSpec{ClaimName: "restic-cache"}
+	for path, container := range map[string]core.Container{
+		api.DefaultCacheMountPath: sidecarContainer(t, r, "canary", "", workload, DefaultLogLevel, nil),
+		"/cache": initContainer(t, &api.Restic{Spec: api.ResticSpec{
+			Cache: &api.CacheSpec{ClaimName: "restic-cache", MountPath: "/cache"},
+		}}, "canary", "", workload, false, nil),
+	} {
+		found := false
+		for _, m := range container.VolumeMounts {
+			found = found || (m.Name == CacheVolumeName && m.MountPath == path)
+		}
+		if !found {
+			t.Errorf("expected cache volume mounted at %s, found %+v", path, container.VolumeMounts)
+		}
+		if dir := cacheEnv(container); dir != path {
+			t.Errorf("expected %s %s, found %q", cli.RESTIC_CACHE_DIR, path, dir)
+		}
+	}
+
+	volumes := MergeCacheVolume(nil, r)
+	if len(volumes) != 1 || volumes[0].Name != CacheVolumeName ||
+		volumes[0].PersistentVolumeClaim == nil || volumes[0].PersistentVolumeClaim.ClaimName != "restic-cache" {
+		t.Errorf("expected cache volume claiming restic-cache, found %+v", volumes)
+	}
+	if volumes = MergeCacheVolume(volumes, &api.Restic{}); len(volumes) != 0 {
+		t.Errorf("expected cache volume removed, found %+v", volumes)
+	}
+}
+
+func TestWorkloadExistsDeploymentConfigUnsupported(t *testing.T) {
+	err := WorkloadExists(fake.NewSimpleClientset(), "default", api.LocalTypedReference{Kind: api.KindDeploymentConfig, Name: "app"})
+	if err == nil || !strings.Contains(err.Error(), "not supported") {
+		t.Errorf("expected unsupported error, got %v", err)
+	}
+}
+
+func TestGetAppliedResticWithEvent(t *testing.T) {
+	recorder := record.NewFakeRecorder(1)
+	ref := &core.ObjectReference{Kind: "Deployment", Namespace: "default", Name: "stash-demo"}
+
+	restic, err := GetAppliedResticWithEvent(map[string]string{}, recorder, ref)
+	if restic != nil || err != nil {
+		t.Errorf("expected no restic for missing annotation, found %v, %v", restic, err)
+	}
+
+	m := map[string]string{api.LastAppliedConfiguration: "{not json"}
+	if _, err = GetAppliedResticWithEvent(m, recorder, ref); err == nil {
+		t.Fatal("expected error for malformed annotation")
+	}
+	select {
+	case e := <-recorder.Events:
+		if !strings.HasPrefix(e, core.EventTypeWarning+" InvalidRestic ") {
+			t.Errorf("unexpected event %q", e)
+		}
+	default:
+		t.Error("expected a warning event for malformed annotation")
+	}
+}
+
+func TestUpsertScratchVolumeSizeLimit(t *testing.T) {
+	r := &api.Restic{}
+	volumes := UpsertScratchVolume(nil, r)
+	if len(volumes) != 1 || volumes[0].EmptyDir == nil {
+		t.Fatalf("expected a single EmptyDir scratch volume, found %v", volumes)
+	}
+	if volumes[0].EmptyDir.SizeLimit != nil {
+		t.Errorf("expected unbounded scratch volume, found size limit %v", volumes[0].EmptyDir.SizeLimit)
+	}
+
+	limit := resource.MustParse("2Gi")
+	r.Spec.ScratchSizeLimit = &limit
+	volumes = UpsertScratchVolume(volumes, r)
+	if len(volumes) != 1 {
+		t.Fatalf("expected scratch volume to be replaced, found %d volumes", len(volumes))
+	}
+	if sl := volumes[0].EmptyDir.SizeLimit; sl == nil || sl.Cmp(limit) != 0 {
+		t.Errorf("expected size limit %v, found %v", limit.String(), sl)
+	}
+}
+
+func TestUpsertScratchVolumeMedium(t *testing.T) {
+	r := &api.Restic{}
+	volumes := UpsertScratchVolume(nil, r)
+	if m := volumes[0].EmptyDir.Medium; m != core.StorageMediumDefault {
+		t.Errorf("expected default storage medium, found %s", m)
+	}
+
+	r.Spec.ScratchMedium = core.StorageMediumMemory
+	volumes = UpsertScratchVolume(volumes, r)
+	if m := volumes[0].EmptyDir.Medium; m != core.StorageMediumMemory {
+		t.Errorf("expected storage medium %s, found %s", core.StorageMediumMemory, m)
+	}
+}
+
+func TestCreateRecoveryJobImagePullSecrets(t *testing.T) {
+	recovery := &api.Recovery{}
+	restic := &api.Restic{}
+	job := CreateRecoveryJob(recovery, restic, "canary", DefaultLogLevel)
+	if n := len(job.Spec.Template.Spec.ImagePullSecrets); n != 0 {
+		t.Errorf("expected no image pull secrets, found %d", n)
+	}
+
+	recovery.Spec.ImagePullSecrets = []core.LocalObjectReference{{Name: "regcred"}}
+	job = CreateRecoveryJob(recovery, restic, "canary", DefaultLogLevel)
+	secrets := job.Spec.Template.Spec.ImagePullSecrets
+	if len(secrets) != 1 || secrets[0].Name != "regcred" {
+		t.Errorf("expected image pull secret regcred, found %v", secrets)
+	}
+}
+
+func TestCreateRecoveryJobResources(t *testing.T) {
+	resticResources := core.ResourceRequirements{
+		Limits: core.ResourceList{core.ResourceMemory: resource.MustParse("256Mi")},
+	}
+	recoveryResources := core.ResourceRequirements{
+		Requests: core.ResourceList{core.ResourceCPU: resource.MustParse("500m")},
+	}
+	recovery := &api.Recovery{}
+	restic := &api.Restic{}
+	restic.Spec.Resources = resticResources
+
+	job := CreateRecoveryJob(recovery, restic, "canary", DefaultLogLevel)
+	got := job.Spec.Template.Spec.Containers[0].Resources
+	if q := got.Limits[core.ResourceMemory]; q.Cmp(resource.MustParse("256Mi")) != 0 {
+		t.Errorf("expected memory limit from restic, found %v", got)
+	}
+
+	recovery.Spec.Resources = recoveryResources
+	job = CreateRecoveryJob(recovery, restic, "canary", DefaultLogLevel)
+	got = job.Spec.Template.Spec.Containers[0].Resources
+	if len(got.Limits) != 0 {
+		t.Errorf("expected no limits, found %v", got.Limits)
+	}
+	if q := got.Requests[core.ResourceCPU]; q.Cmp(resource.MustParse("500m")) != 0 {
+		t.Errorf("expected cpu request from recovery, found %v", got)
+	}
+}
+
+func TestCreateRecoveryJobSnapshotSelection(t *testing.T) {
+	cutoff := metav1.NewTime(time.Date(2018, 1, 2, 3, 4, 5, 0, time.UTC))
+	cases := map[string]struct {
+		spec     api.RecoverySpec
+		expected []string
+	}{
+		"latest":   {api.RecoverySpec{}, nil},
+		"snapshot": {api.RecoverySpec{SnapshotID: "4bba301e"}, []string{"--snapshot=4bba301e"}},
+		"tags":     {api.RecoverySpec{Tags: []string{"daily", "db"}}, []string{"--tag=daily,db"}},
+		"time":     {api.RecoverySpec{Time: &cutoff}, []string{"--before=2018-01-02T03:04:05Z"}},
+		"latest with tags": {
+			api.RecoverySpec{Snapshot: api.SnapshotLatest, Tags: []string{"prod"}},
+			[]string{"--snapshot=latest", "--tag=prod"},
+		},
+	}
+	for name, c := range cases {
+		recovery := &api.Recovery{Spec: c.spec}
+		args := CreateRecoveryJob(recovery, &api.Restic{}, "canary", DefaultLogLevel).Spec.Template.Spec.Containers[0].Args
+		if got := args[3:]; strings.Join(got, " ") != strings.Join(c.expected, " ") {
+			t.Errorf("%s: expected selection args %v, found %v", name, c.expected, got)
+		}
+	}
+}
+
+func TestCreateRecoveryJobPatterns(t *testing.T) {
+	recovery := &api.Recovery{Spec: api.RecoverySpec{
+		SnapshotID:      "4bba301e",
+		IncludePatterns: []string{"/source/data/db", "/source/data/*.conf"},
+	}}
+	args := CreateRecoveryJob(recovery, &api.Restic{}, "canary", DefaultLogLevel).Spec.Template.Spec.Containers[0].Args
+	expected := []string{
+		"--snapshot=4bba301e",
+		"--include=/source/data/db",
+		"--include=/source/data/*.conf",
+	}
+	if got := args[3:]; strings.Join(got, "|") != strings.Join(expected, "|") {
+		t.Errorf("expected args %v, found %v", expected, got)
+	}
+
+	recovery.Spec.IncludePatterns = nil
+	recovery.Spec.ExcludePatterns = []string{"*.tmp", "cache dir"}
+	args = CreateRecoveryJob(recovery, &api.Restic{}, "canary", DefaultLogLevel).Spec.Template.Spec.Containers[0].Args
+	expected = []string{
+		"--snapshot=4bba301e",
+		"--exclude=*.tmp",
+		"--exclude=cache dir",
+	}
+	if got := args[3:]; strings.Join(got, "|") != strings.Join(expected, "|") {
+		t.Errorf("expected args %v, found %v", expected, got)
+	}
+}
+
+func TestCreateRecoveryJobTarget(t *testing.T) {
+	restic := &api.Restic{Spec: api.ResticSpec{
+		VolumeMounts: []core.VolumeMount{{Name: "source-data", MountPath: "/source/data"}},
+	}}
+	recovery := &api.Recovery{Spec: api.RecoverySpec{
+		Volumes: []core.Volume{{Name: "staging"}},
+		Target:  &api.RecoveryTarget{Volume: "staging", MountPath: "/restore"},
+	}}
+	container := CreateRecoveryJob(recovery, restic, "canary", DefaultLogLevel).Spec.Template.Spec.Containers[0]
+	if got := container.Args[len(container.Args)-1]; got != "--target=/restore" {
+		t.Errorf("expected --target=/restore, found %v", container.Args)
+	}
+	mounts := map[string]string{}
+	for _, m := range container.VolumeMounts {
+		mounts[m.Name] = m.MountPath
+	}
+	if mounts["staging"] != "/restore" {
+		t.Errorf("expected target volume mounted at /restore, found %v", mounts)
+	}
+	if _, ok := mounts["source-data"]; ok {
+		t.Errorf("expected volume mounts of restic to be replaced by target, found %v", mounts)
+	}
+
+	recovery.Spec.Target = nil
+	container = CreateRecoveryJob(recovery, restic, "canary", DefaultLogLevel).Spec.Template.Spec.Containers[0]
+	if container.VolumeMounts[0].Name != "source-data" {
+		t.Errorf("expected volume mounts of restic without target, found %v", container.VolumeMounts)
+	}
+}
+
+func TestRecoverySourcePaths(t *testing.T) {
+	restic := &api.Restic{Spec: api.ResticSpec{
+		FileGroups: []api.FileGroup{{Path: "/source/data"}, {Path: "/source/config"}},
+	}}
+	recovery := &api.Recovery{}
+	if paths := RecoverySourcePaths(recovery, restic); !reflect.DeepEqual(paths, []string{"/source/data", "/source/config"}) {
+		t.Errorf("expected FileGroups of restic, found %v", paths)
+	}
+	recovery.Spec.SourcePaths = []string{"/var/lib/data"}
+	if paths := RecoverySourcePaths(recovery, restic); !reflect.DeepEqual(paths, []string{"/var/lib/data"}) {
+		t.Errorf("expected source paths, found %v", paths)
+	}
+}
+
+func TestUnmountedPaths(t *testing.T) {
+	mounts := []core.VolumeMount{{Name: "data", MountPath: "/source/data"}, {Name: "config", MountPath: "/source/config/"}}
+	paths := []string{"/source/data", "/source/data/db", "/source/config/app", "/source/database", "/source", "/var/lib"}
+	if unmounted := UnmountedPaths(paths, mounts); !reflect.DeepEqual(unmounted, []string{"/source/database", "/source", "/var/lib"}) {
+		t.Errorf("expected paths outside of the mounts, found %v", unmounted)
+	}
+}
+
+func TestCreateRecoveryJobs(t *testing.T) {
+	restic := &api.Restic{Spec: api.ResticSpec{
+		VolumeMounts: []core.VolumeMount{
+			{Name: "data", MountPath: "/source/data"},
+			{Name: "config", MountPath: "/source/config"},
+		},
+	}}
+	recovery := &api.Recovery{
+		ObjectMeta: metav1.ObjectMeta{Name: "rec", Namespace: "default"},
+		Spec: api.RecoverySpec{
+			Volumes: []core.Volume{{Name: "data"}, {Name: "config"}},
+		},
+	}
+	jobs := CreateRecoveryJobs(recovery, restic, "canary", DefaultLogLevel)
+	if len(jobs) != 1 || jobs[0].Name != RecoveryJobPrefix+"rec" {
+		t.Fatalf("expected single recovery job without parallelVolumes, found %v", jobs)
+	}
+	if _, ok := jobs[0].Annotations[AnnotationParallelJobs]; ok {
+		t.Errorf("expected no %s annotation on single recovery job", AnnotationParallelJobs)
+	}
+
+	recovery.Spec.ParallelVolumes = true
+	jobs = CreateRecoveryJobs(recovery, restic, "canary", DefaultLogLevel)
+	if len(jobs) != 2 {
+		t.Fatalf("expected one recovery job per volume mount, found %d", len(jobs))
+	}
+	for i, job := range jobs {
+		mount := restic.Spec.VolumeMounts[i]
+		if expected := fmt.Sprintf("%srec-%d", RecoveryJobPrefix, i); job.Name != expected {
+			t.Errorf("expected job name %s, found %s", expected, job.Name)
+		}
+		if n := job.Annotations[AnnotationParallelJobs]; n != "2" {
+			t.Errorf("expected %s=2, found %q", AnnotationParallelJobs, n)
+		}
+		container := job.Spec.Template.Spec.Containers[0]
+		if got := container.Args[len(container.Args)-1]; got != "--volume-mount-path="+mount.MountPath {
+			t.Errorf("expected --volume-mount-path=%s, found %v", mount.MountPath, container.Args)
+		}
+		mounts := map[string]bool{}
+		for _, m := range container.VolumeMounts {
+			mounts[m.Name] = true
+		}
+		if !mounts[mount.Name] || !mounts[ScratchDirVolumeName] || len(mounts) != 2 {
+			t.Errorf("expected volume %s and scratch dir mounted, found %v", mount.Name, container.VolumeMounts)
+		}
+		volumes := map[string]bool{}
+		for _, v := range job.Spec.Template.Spec.Volumes {
+			volumes[v.Name] = true
+		}
+		if !volumes[mount.Name] || !volumes[ScratchDirVolumeName] || len(volumes) != 2 {
+			t.Errorf("expected only mounted volumes, found %v", job.Spec.Template.Spec.Volumes)
+		}
+	}
+
+	recovery.Spec.Target = &api.RecoveryTarget{Volume: "data", MountPath: "/restore"}
+	if jobs = CreateRecoveryJobs(recovery, restic, "canary", DefaultLogLevel); len(jobs) != 1 {
+		t.Errorf("expected single recovery job with target, found %d", len(jobs))
+	}
+}
+
+func TestCreateRecoveryJobAffinity(t *testing.T) {
+	recovery := &api.Recovery{}
+	if a := CreateRecoveryJob(recovery, &api.Restic{}, "canary", DefaultLogLevel).Spec.Template.Spec.Affinity; a != nil {
+		t.Errorf("expected no affinity, found %v", a)
+	}
+	recovery.Spec.Affinity = &core.Affinity{
+		NodeAffinity: &core.NodeAffinity{
+			RequiredDuringSchedulingIgnoredDuringExecution: &core.NodeSelector{
+				NodeSelectorTerms: []core.NodeSelectorTerm{{
+					MatchExpressions: []core.NodeSelectorRequirement{{
+						Key:      "failure-domain.beta.kubernetes.io/zone",
+						Operator: core.NodeSelectorOpIn,
+						Values:   []string{"us-central1-a"},
+					}},
+				}},
+			},
+		},
+	}
+	affinity := CreateRecoveryJob(recovery, &api.Restic{}, "canary", DefaultLogLevel).Spec.Template.Spec.Affinity
+	if !reflect.DeepEqual(affinity, recovery.Spec.Affinity) {
+		t.Errorf("expected affinity %v, found %v", recovery.Spec.Affinity, affinity)
+	}
+}
+
+func TestCreateRecoveryJobRestartPolicy(t *testing.T) {
+	recovery := &api.Recovery{}
+	if p := CreateRecoveryJob(recovery, &api.Restic{}, "canary", DefaultLogLevel).Spec.Template.Spec.RestartPolicy; p != core.RestartPolicyOnFailure {
+		t.Errorf("expected default restart policy %s, found %s", core.RestartPolicyOnFailure, p)
+	}
+	recovery.Spec.RestartPolicy = core.RestartPolicyNever
+	if p := CreateRecoveryJob(recovery, &api.Restic{}, "canary", DefaultLogLevel).Spec.Template.Spec.RestartPolicy; p != core.RestartPolicyNever {
+		t.Errorf("expected restart policy %s, found %s", core.RestartPolicyNever, p)
+	}
+}
+
+func TestCreateRecoveryJobLabelsAnnotations(t *testing.T) {
+	recovery := &api.Recovery{Spec: api.RecoverySpec{
+		JobLabels:      map[string]string{"team": "db", "app": "mine"},
+		PodLabels:      map[string]string{"cost-center": "42", "app": "mine"},
+		PodAnnotations: map[string]string{"sidecar.istio.io/inject": "false"},
+	}}
+	recovery.Name = "stash-demo"
+	job := CreateRecoveryJob(recovery, &api.Restic{}, "canary", DefaultLogLevel)
+
+	if job.Labels["team"] != "db" || job.Labels["app"] != AppLabelStash {
+		t.Errorf("unexpected job labels %v", job.Labels)
+	}
+	if job.Annotations[AnnotationRecovery] != "stash-demo" {
+		t.Errorf("unexpected job annotations %v", job.Annotations)
+	}
+	pod := job.Spec.Template
+	if pod.Labels["cost-center"] != "42" || pod.Labels["app"] != AppLabelStash {
+		t.Errorf("unexpected pod labels %v", pod.Labels)
+	}
+	if pod.Annotations["sidecar.istio.io/inject"] != "false" {
+		t.Errorf("unexpected pod annotations %v", pod.Annotations)
+	}
+	if recovery.Spec.JobLabels["app"] != "mine" || recovery.Spec.PodLabels["app"] != "mine" {
+		t.Error("expected labels of recovery spec not to be modified")
+	}
+}
+
+func TestCreateRecoveryJobLimits(t *testing.T) {
+	recovery := &api.Recovery{}
+	job := CreateRecoveryJob(recovery, &api.Restic{}, "canary", DefaultLogLevel)
+	if job.Spec.BackoffLimit != nil || job.Spec.ActiveDeadlineSeconds != nil {
+		t.Errorf("expected Kubernetes defaults, found backoffLimit %v and activeDeadlineSeconds %v",
+			job.Spec.BackoffLimit, job.Spec.ActiveDeadlineSeconds)
+	}
+
+	backoffLimit, deadline := int32(2), int64(3600)
+	recovery.Spec.BackoffLimit = &backoffLimit
+	recovery.Spec.ActiveDeadlineSeconds = &deadline
+	job = CreateRecoveryJob(recovery, &api.Restic{}, "canary", DefaultLogLevel)
+	if job.Spec.BackoffLimit == nil || *job.Spec.BackoffLimit != 2 {
+		t.Errorf("expected backoffLimit 2, found %v", job.Spec.BackoffLimit)
+	}
+	if job.Spec.ActiveDeadlineSeconds == nil || *job.Spec.ActiveDeadlineSeconds != 3600 {
+		t.Errorf("expected activeDeadlineSeconds 3600, found %v", job.Spec.ActiveDeadlineSeconds)
+	}
+}
+
+func TestCreateRecoveryJobOwnerReference(t *testing.T) {
+	recovery := &api.Recovery{ObjectMeta: metav1.ObjectMeta{Name: "stash-demo", Namespace: "default", UID: "rec-uid"}}
+	job := CreateRecoveryJob(recovery, &api.Restic{}, "canary", DefaultLogLevel)
+	ref := metav1.GetControllerOf(job)
+	if ref == nil || ref.UID != recovery.UID || ref.Kind != api.ResourceKindRecovery ||
+		ref.BlockOwnerDeletion == nil || !*ref.BlockOwnerDeletion {
+		t.Fatalf("expected blocking controller reference to recovery, found %+v", job.OwnerReferences)
+	}
+
+	other := &batch.Job{ObjectMeta: metav1.ObjectMeta{Name: "other", Namespace: recovery.Namespace}}
+	client := fake.NewSimpleClientset(job, other)
+	// the fake client has no garbage collector, delete the jobs controlled by the Recovery as it would
+	jobs, err := client.BatchV1().Jobs(recovery.Namespace).List(metav1.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range jobs.Items {
+		if metav1.IsControlledBy(&jobs.Items[i], recovery) {
+			if err = client.BatchV1().Jobs(recovery.Namespace).Delete(jobs.Items[i].Name, nil); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if _, err = client.BatchV1().Jobs(recovery.Namespace).Get(job.Name, metav1.GetOptions{}); err == nil {
+		t.Errorf("expected job %s to be deleted with recovery", job.Name)
+	}
+	if _, err = client.BatchV1().Jobs(recovery.Namespace).Get(other.Name, metav1.GetOptions{}); err != nil {
+		t.Errorf("expected job %s to be kept, found %v", other.Name, err)
+	}
+}
+
+func TestCreateRecoveryJobPriorityClassName(t *testing.T) {
+	recovery := &api.Recovery{}
+	recovery.Spec.PriorityClassName = "stash-recovery"
+	job := CreateRecoveryJob(recovery, &api.Restic{}, "canary", DefaultLogLevel)
+	if name := job.Spec.Template.Spec.PriorityClassName; name != "stash-recovery" {
+		t.Errorf("expected priority class stash-recovery, found %q", name)
+	}
+}
+
+func TestMergePriorityClassName(t *testing.T) {
+	restic := func(name string) *api.Restic {
+		return &api.Restic{Spec: api.ResticSpec{PriorityClassName: name}}
+	}
+	cases := map[string]struct {
+		current  string
+		old, new *api.Restic
+		expected string
+	}{
+		"added":           {"", nil, restic("backup"), "backup"},
+		"changed":         {"backup", restic("backup"), restic("critical"), "critical"},
+		"removed":         {"backup", restic("backup"), restic(""), ""},
+		"workload own":    {"app", nil, restic(""), "app"},
+		"workload update": {"app", restic("backup"), restic(""), "app"},
+	}
+	for name, c := range cases {
+		if got := MergePriorityClassName(c.current, c.old, c.new); got != c.expected {
+			t.Errorf("%s: expected priority class %q, found %q", name, c.expected, got)
+		}
+	}
+	if got := EnsurePriorityClassNameDeleted("backup", restic("backup")); got != "" {
+		t.Errorf("expected priority class to be removed, found %q", got)
+	}
+}
+
+func TestCreateRecoveryJobLivenessProbe(t *testing.T) {
+	recovery := &api.Recovery{}
+	job := CreateRecoveryJob(recovery, &api.Restic{}, "canary", DefaultLogLevel)
+	if p := job.Spec.Template.Spec.Containers[0].LivenessProbe; p != nil {
+		t.Errorf("expected no liveness probe, found %v", p)
+	}
+
+	recovery.Spec.LivenessProbe = &core.Probe{PeriodSeconds: 20}
+	job = CreateRecoveryJob(recovery, &api.Restic{}, "canary", DefaultLogLevel)
+	p := job.Spec.Template.Spec.Containers[0].LivenessProbe
+	if p == nil || p.Exec == nil || len(p.Exec.Command) == 0 {
+		t.Fatalf("expected default exec liveness probe, found %v", p)
+	}
+	if p.PeriodSeconds != 20 || p.InitialDelaySeconds != 30 || p.TimeoutSeconds != 10 || p.FailureThreshold != 3 {
+		t.Errorf("unexpected probe timings %+v", p)
+	}
+	if recovery.Spec.LivenessProbe.Exec != nil || recovery.Spec.LivenessProbe.InitialDelaySeconds != 0 {
+		t.Errorf("expected recovery spec to be left unchanged, found %+v", recovery.Spec.LivenessProbe)
+	}
+
+	recovery.Spec.LivenessProbe = &core.Probe{Handler: core.Handler{TCPSocket: &core.TCPSocketAction{}}}
+	job = CreateRecoveryJob(recovery, &api.Restic{}, "canary", DefaultLogLevel)
+	if p := job.Spec.Template.Spec.Containers[0].LivenessProbe; p.Exec != nil || p.TCPSocket == nil {
+		t.Errorf("expected custom handler to be kept, found %+v", p)
+	}
+}
+
+func envMap(c core.Container) map[string]core.EnvVar {
+	m := map[string]core.EnvVar{}
+	for _, e := range c.Env {
+		m[e.Name] = e
+	}
+	return m
+}
+
+func TestCreateSidecarContainerRestBackend(t *testing.T) {
+	r := &api.Restic{}
+	r.Name = "rest"
+	r.Spec.Backend = api.Backend{
+		StorageSecretName: "rest-secret",
+		Rest:              &api.RestServerSpec{URL: "https://rest.example.com:8000/"},
+	}
+	workload := api.LocalTypedReference{Kind: api.KindDeployment, Name: "app"}
+
+	sidecar := sidecarContainer(t, r, "canary", "", workload, DefaultLogLevel, nil)
+	vars := envMap(sidecar)
+	if v := vars[RepositoryPrefixEnv].Value; v != "deployment/app" {
+		t.Errorf("unexpected %s %q", RepositoryPrefixEnv, v)
+	}
+	if v := vars[cli.RESTIC_REPOSITORY].Value; v != "rest:https://rest.example.com:8000/$(REPOSITORY_PREFIX)" {
+		t.Errorf("unexpected %s %q", cli.RESTIC_REPOSITORY, v)
+	}
+	for name, key := range map[string]string{
+		cli.RESTIC_REST_USERNAME: cli.REST_SERVER_USERNAME,
+		cli.RESTIC_REST_PASSWORD: cli.REST_SERVER_PASSWORD,
+	} {
+		e, ok := vars[name]
+		if !ok || e.ValueFrom == nil || e.ValueFrom.SecretKeyRef == nil {
+			t.Errorf("expected %s to be read from secret", name)
+			continue
+		}
+		if ref := e.ValueFrom.SecretKeyRef; ref.Name != "rest-secret" || ref.Key != key {
+			t.Errorf("expected %s from rest-secret/%s, found %s/%s", name, key, ref.Name, ref.Key)
+		}
+	}
+	for _, m := range sidecar.VolumeMounts {
+		if m.Name == RestTLSVolumeName {
+			t.Errorf("unexpected volume mount %s without TLS secret", RestTLSVolumeName)
+		}
+	}
+
+	old := r.DeepCopy()
+	r.Spec.Backend.Rest.TLSSecretName = "rest-tls"
+	sidecar = sidecarContainer(t, r, "canary", "", workload, DefaultLogLevel, nil)
+	mounted := false
+	for _, m := range sidecar.VolumeMounts {
+		if m.Name == RestTLSVolumeName && m.MountPath == RestTLSMountPath {
+			mounted = true
+		}
+	}
+	if !mounted {
+		t.Errorf("expected volume mount %s at %s", RestTLSVolumeName, RestTLSMountPath)
+	}
+	volumes := MergeBackendVolumes(nil, old, r)
+	if len(volumes) != 1 || volumes[0].Secret == nil || volumes[0].Secret.SecretName != "rest-tls" {
+		t.Errorf("expected TLS volume from secret rest-tls, found %v", volumes)
+	}
+	if volumes = MergeBackendVolumes(volumes, r, old); len(volumes) != 0 {
+		t.Errorf("expected TLS volume to be removed, found %v", volumes)
+	}
+}
+
+func TestB2BackendEnv(t *testing.T) {
+	r := &api.Restic{}
+	r.Name = "b2"
+	r.Spec.Backend = api.Backend{
+		StorageSecretName: "b2-secret",
+		B2:                &api.B2Spec{Bucket: "stash", Prefix: "demo"},
+	}
+	recovery := &api.Recovery{}
+	recovery.Spec.Workload = api.LocalTypedReference{Kind: api.KindStatefulSet, Name: "app"}
+	recovery.Spec.PodOrdinal = "0"
+
+	for name, c := range map[string]core.Container{
+		"sidecar":  sidecarContainer(t, r, "canary", "", recovery.Spec.Workload, DefaultLogLevel, nil),
+		"recovery": CreateRecoveryJob(recovery, r, "canary", DefaultLogLevel).Spec.Template.Spec.Containers[0],
+	} {
+		vars := envMap(c)
+		want := "statefulset/$(POD_NAME)"
+		if name == "recovery" {
+			want = "statefulset/app-0"
+		}
+		if v := vars[RepositoryPrefixEnv].Value; v != want {
+			t.Errorf("%s: expected %s %q, found %q", name, RepositoryPrefixEnv, want, v)
+		}
+		if v := vars[cli.RESTIC_REPOSITORY].Value; v != "b2:stash:demo/$(REPOSITORY_PREFIX)" {
+			t.Errorf("%s: unexpected %s %q", name, cli.RESTIC_REPOSITORY, v)
+		}
+		for _, key := range []string{cli.B2_ACCOUNT_ID, cli.B2_ACCOUNT_KEY} {
+			e, ok := vars[key]
+			if !ok || e.ValueFrom == nil || e.ValueFrom.SecretKeyRef == nil || e.ValueFrom.SecretKeyRef.Name != "b2-secret" {
+				t.Errorf("%s: expected %s to be read from secret b2-secret", name, key)
+			}
+		}
+	}
+}
+
+func TestSFTPBackend(t *testing.T) {
+	r := &api.Restic{}
+	r.Name = "sftp"
+	r.Spec.Backend = api.Backend{
+		StorageSecretName: "restic-secret",
+		SFTP:              &api.SFTPSpec{Host: "nas", Port: 2222, User: "backup", Path: "/srv/restic", SSHSecretName: "sftp-ssh"},
+	}
+
+	volumes, _, _, err := BackendToVolumesAndEnv(r.Spec.Backend)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(volumes) != 1 || volumes[0].Name != SFTPSSHVolumeName || volumes[0].Secret == nil {
+		t.Fatalf("expected secret volume %s, found %+v", SFTPSSHVolumeName, volumes)
+	}
+	if secret := volumes[0].Secret; secret.SecretName != "sftp-ssh" || secret.DefaultMode == nil || *secret.DefaultMode != 0444 {
+		t.Errorf("expected secret sftp-ssh with mode 0444, found %+v", secret)
+	}
+
+	recovery := &api.Recovery{}
+	recovery.Spec.Workload = api.LocalTypedReference{Kind: api.KindDeployment, Name: "app"}
+	for name, c := range map[string]core.Container{
+		"sidecar":  sidecarContainer(t, r, "canary", "", recovery.Spec.Workload, DefaultLogLevel, nil),
+		"recovery": CreateRecoveryJob(recovery, r, "canary", DefaultLogLevel).Spec.Template.Spec.Containers[0],
+	} {
+		if v := envMap(c)[cli.RESTIC_REPOSITORY].Value; v != "sftp:backup@nas:/srv/restic/$(REPOSITORY_PREFIX)" {
+			t.Errorf("%s: unexpected %s %q", name, cli.RESTIC_REPOSITORY, v)
+		}
+		mounted := false
+		for _, m := range c.VolumeMounts {
+			if m.Name == SFTPSSHVolumeName && m.MountPath == SFTPSSHMountPath && m.ReadOnly {
+				mounted = true
+			}
+		}
+		if !mounted {
+			t.Errorf("%s: expected %s mounted read only at %s, found %+v", name, SFTPSSHVolumeName, SFTPSSHMountPath, c.VolumeMounts)
+		}
+	}
+
+	r.Spec.Backend.SFTP.SSHSecretName = ""
+	if _, _, _, err := BackendToVolumesAndEnv(r.Spec.Backend); err == nil {
+		t.Error("expected error for sftp backend without ssh secret")
+	}
+}
+
+func TestRawBackendEnv(t *testing.T) {
+	r := &api.Restic{}
+	r.Name = "sftp"
+	r.Spec.Backend = api.Backend{
+		StorageSecretName: "restic-secret",
+		Raw:               &api.RawSpec{URL: "sftp:backup@nas:/srv/restic/", SecretName: "sftp-credentials"},
+	}
+	recovery := &api.Recovery{}
+	recovery.Spec.Workload = api.LocalTypedReference{Kind: api.KindStatefulSet, Name: "app"}
+	recovery.Spec.PodOrdinal = "0"
+
+	for name, c := range map[string]core.Container{
+		"sidecar":  sidecarContainer(t, r, "canary", "", recovery.Spec.Workload, DefaultLogLevel, nil),
+		"recovery": CreateRecoveryJob(recovery, r, "canary", DefaultLogLevel).Spec.Template.Spec.Containers[0],
+	} {
+		if v := envMap(c)[cli.RESTIC_REPOSITORY].Value; v != "sftp:backup@nas:/srv/restic/$(REPOSITORY_PREFIX)" {
+			t.Errorf("%s: unexpected %s %q", name, cli.RESTIC_REPOSITORY, v)
+		}
+		if len(c.EnvFrom) != 1 || c.EnvFrom[0].SecretRef == nil || c.EnvFrom[0].SecretRef.Name != "sftp-credentials" {
+			t.Errorf("%s: expected environment from secret sftp-credentials, found %+v", name, c.EnvFrom)
+		}
+	}
+
+	r.Spec.Backend.Raw.URL = ""
+	if _, _, _, err := BackendToVolumesAndEnv(r.Spec.Backend); err == nil {
+		t.Error("expected error for raw backend without url")
+	}
+}
+
+func TestAzureBackendEnv(t *testing.T) {
+	r := &api.Restic{}
+	r.Name = "azure"
+	r.Spec.Backend = api.Backend{
+		StorageSecretName: "azure-secret",
+		Azure:             &api.AzureSpec{Container: "stash", Prefix: "demo"},
+	}
+	recovery := &api.Recovery{}
+	recovery.Spec.Workload = api.LocalTypedReference{Kind: api.KindDeployment, Name: "app"}
+
+	for name, c := range map[string]core.Container{
+		"sidecar":  sidecarContainer(t, r, "canary", "", recovery.Spec.Workload, DefaultLogLevel, nil),
+		"recovery": CreateRecoveryJob(recovery, r, "canary", DefaultLogLevel).Spec.Template.Spec.Containers[0],
+	} {
+		vars := envMap(c)
+		if v := vars[cli.RESTIC_REPOSITORY].Value; v != "azure:stash:/demo/$(REPOSITORY_PREFIX)" {
+			t.Errorf("%s: unexpected %s %q", name, cli.RESTIC_REPOSITORY, v)
+		}
+		for _, key := range []string{cli.AZURE_ACCOUNT_NAME, cli.AZURE_ACCOUNT_KEY} {
+			e, ok := vars[key]
+			if !ok || e.ValueFrom == nil || e.ValueFrom.SecretKeyRef == nil ||
+				e.ValueFrom.SecretKeyRef.Name != "azure-secret" || e.ValueFrom.SecretKeyRef.Key != key {
+				t.Errorf("%s: expected %s to be read from secret azure-secret", name, key)
+			}
+		}
+	}
+
+	r.Spec.Backend.StorageSecretName = ""
+	if _, _, _, err := BackendToVolumesAndEnv(r.Spec.Backend); err == nil {
+		t.Error("expected error for azure backend without secret")
+	}
+	if _, err := CreateCheckJob(r, "host-0", "deployment/app", "canary"); err == nil {
+		t.Error("expected check job to fail for azure backend without secret")
+	}
+	r.Spec.Backend.StorageSecretName = "azure-secret"
+	r.Spec.Backend.Azure.Container = ""
+	if _, _, _, err := BackendToVolumesAndEnv(r.Spec.Backend); err == nil {
+		t.Error("expected error for azure backend without container")
+	}
+}
+
+func TestSwiftBackendEnv(t *testing.T) {
+	r := &api.Restic{}
+	r.Name = "swift"
+	r.Namespace = "default"
+	r.Spec.Backend = api.Backend{
+		StorageSecretName: "swift-secret",
+		Swift:             &api.SwiftSpec{Container: "stash", Prefix: "demo"},
+	}
+	recovery := &api.Recovery{}
+	recovery.Spec.Workload = api.LocalTypedReference{Kind: api.KindDeployment, Name: "app"}
+
+	vars := envMap(CreateRecoveryJob(recovery, r, "canary", DefaultLogLevel).Spec.Template.Spec.Containers[0])
+	if v := vars[cli.RESTIC_REPOSITORY].Value; v != "swift:stash:/demo/$(REPOSITORY_PREFIX)" {
+		t.Errorf("unexpected %s %q", cli.RESTIC_REPOSITORY, v)
+	}
+	for _, key := range []string{cli.OS_AUTH_URL, cli.OS_USERNAME, cli.OS_PASSWORD, cli.OS_TENANT_NAME, cli.OS_PROJECT_NAME} {
+		e, ok := vars[key]
+		if !ok || e.ValueFrom == nil || e.ValueFrom.SecretKeyRef == nil || e.ValueFrom.SecretKeyRef.Key != key {
+			t.Errorf("expected %s to be read from secret swift-secret", key)
+		}
+	}
+
+	secret := &core.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "swift-secret", Namespace: "default"},
+		Data: map[string][]byte{
+			cli.RESTIC_PASSWORD: []byte("changeit"),
+			cli.OS_AUTH_URL:     []byte("https://auth.example.com/v2.0"),
+			cli.OS_USERNAME:     []byte("stash"),
+		},
+	}
+	kubeClient := fake.NewSimpleClientset(secret)
+	err := ValidateBackendSecret(kubeClient, r.Namespace, r.Spec.Backend)
+	if err == nil || !strings.Contains(err.Error(), cli.OS_PASSWORD) {
+		t.Errorf("expected error naming the missing swift credentials, found %v", err)
+	}
+
+	secret.Data[cli.OS_PASSWORD] = []byte("secret")
+	if _, err = kubeClient.CoreV1().Secrets(secret.Namespace).Update(secret); err != nil {
+		t.Fatal(err)
+	}
+	if err = ValidateBackendSecret(kubeClient, r.Namespace, r.Spec.Backend); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+	if err = ValidateBackendSecret(fake.NewSimpleClientset(), r.Namespace, r.Spec.Backend); err == nil {
+		t.Error("expected error for missing swift secret")
+	}
+}
+
+func TestValidateBackendSecret(t *testing.T) {
+	secret := &core.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "repo-secret", Namespace: "default"},
+		Data: map[string][]byte{
+			cli.RESTIC_PASSWORD:   []byte("changeit"),
+			cli.AWS_ACCESS_KEY_ID: []byte("access-key"),
+		},
+	}
+	kubeClient := fake.NewSimpleClientset(secret)
+	cases := map[string]struct {
+		backend api.Backend
+		missing string
+	}{
+		"local":          {api.Backend{StorageSecretName: secret.Name, Local: &api.LocalSpec{Path: "/repo"}}, ""},
+		"missing secret": {api.Backend{StorageSecretName: "missing", Local: &api.LocalSpec{Path: "/repo"}}, "not found"},
+		"s3":             {api.Backend{StorageSecretName: secret.Name, S3: &api.S3Spec{Endpoint: "s3.amazonaws.com", Bucket: "stash"}}, cli.AWS_SECRET_ACCESS_KEY},
+		// credentials of the pod are used without keys
+		"gcs":   {api.Backend{StorageSecretName: secret.Name, GCS: &api.GCSSpec{Bucket: "stash"}}, ""},
+		"azure": {api.Backend{StorageSecretName: secret.Name, Azure: &api.AzureSpec{Container: "stash"}}, cli.AZURE_ACCOUNT_NAME},
+		"b2":    {api.Backend{StorageSecretName: secret.Name, B2: &api.B2Spec{Bucket: "stash"}}, cli.B2_ACCOUNT_KEY},
+	}
+	for name, c := range cases {
+		err := ValidateBackendSecret(kubeClient, secret.Namespace, c.backend)
+		if c.missing == "" && err != nil {
+			t.Errorf("%s: unexpected error: %s", name, err)
+		} else if c.missing != "" && (err == nil || !strings.Contains(err.Error(), c.missing)) {
+			t.Errorf("%s: expected error naming %s, found %v", name, c.missing, err)
+		}
+	}
+
+	delete(secret.Data, cli.AWS_ACCESS_KEY_ID)
+	if _, err := kubeClient.CoreV1().Secrets(secret.Namespace).Update(secret); err != nil {
+		t.Fatal(err)
+	}
+	if err := ValidateBackendSecret(kubeClient, secret.Namespace, cases["s3"].backend); err != nil {
+		t.Errorf("expected s3 backend without keys to use the IAM role, found %v", err)
+	}
+
+	delete(secret.Data, cli.RESTIC_PASSWORD)
+	if _, err := kubeClient.CoreV1().Secrets(secret.Namespace).Update(secret); err != nil {
+		t.Fatal(err)
+	}
+	err := ValidateBackendSecret(kubeClient, secret.Namespace, cases["local"].backend)
+	if err == nil || !strings.Contains(err.Error(), cli.RESTIC_PASSWORD) {
+		t.Errorf("expected error naming %s, found %v", cli.RESTIC_PASSWORD, err)
+	}
+}
+
+func TestBackendToVolumesAndEnv(t *testing.T) {
+	cases := []struct {
+		name    string
+		backend api.Backend
+		volumes []string
+		mounts  map[string]string
+		repo    string
+	}{
+		{
+			name: "local",
+			backend: api.Backend{
+				Local: &api.LocalSpec{
+					Path:         "/safe/data",
+					VolumeSource: core.VolumeSource{HostPath: &core.HostPathVolumeSource{Path: "/data/stash"}},
+				},
+			},
+			volumes: []string{LocalVolumeName},
+			mounts:  map[string]string{LocalVolumeName: "/safe/data"},
+			repo:    "/safe/data/$(REPOSITORY_PREFIX)",
+		},
+		{
+			name: "s3",
+			backend: api.Backend{
+				S3: &api.S3Spec{Endpoint: "s3.amazonaws.com", Bucket: "stash", Prefix: "demo"},
+			},
+			repo: "s3:s3.amazonaws.com/stash/demo/$(REPOSITORY_PREFIX)",
+		},
+		{
+			name: "gcs",
+			backend: api.Backend{
+				GCS: &api.GCSSpec{Bucket: "stash", Prefix: "demo"},
+			},
+			volumes: []string{GCSCredentialsVolumeName},
+			mounts:  map[string]string{GCSCredentialsVolumeName: GCSCredentialsMountPath},
+			repo:    "gs:stash:/demo/$(REPOSITORY_PREFIX)",
+		},
+	}
+
+	for _, c := range cases {
+		c.backend.StorageSecretName = "secret"
+		volumes, mounts, env, err := BackendToVolumesAndEnv(c.backend)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", c.name, err)
+			continue
+		}
+		if len(volumes) != len(c.volumes) {
+			t.Errorf("%s: expected volumes %v, found %v", c.name, c.volumes, volumes)
+		}
+		for i := range volumes {
+			if i < len(c.volumes) && volumes[i].Name != c.volumes[i] {
+				t.Errorf("%s: expected volume %s, found %s", c.name, c.volumes[i], volumes[i].Name)
+			}
+		}
+		if len(mounts) != len(c.mounts) {
+			t.Errorf("%s: expected mounts %v, found %v", c.name, c.mounts, mounts)
+		}
+		for _, m := range mounts {
+			if c.mounts[m.Name] != m.MountPath {
+				t.Errorf("%s: unexpected mount %s at %s", c.name, m.Name, m.MountPath)
+			}
+		}
+		if v := envMap(core.Container{Env: env})[cli.RESTIC_REPOSITORY].Value; v != c.repo {
+			t.Errorf("%s: expected %s %q, found %q", c.name, cli.RESTIC_REPOSITORY, c.repo, v)
+		}
+	}
+
+	if _, _, _, err := BackendToVolumesAndEnv(api.Backend{Local: &api.LocalSpec{}}); err == nil {
+		t.Error("expected error for local backend without path")
+	}
+	if _, _, _, err := BackendToVolumesAndEnv(api.Backend{S3: &api.S3Spec{Bucket: "stash"}}); err == nil {
+		t.Error("expected error for s3 backend without endpoint")
+	}
+}
+
+func TestMirrorBackendToVolumes(t *testing.T) {
+	local := func(path string) *api.LocalSpec {
+		return &api.LocalSpec{Path: path, VolumeSource: core.VolumeSource{HostPath: &core.HostPathVolumeSource{Path: "/data" + path}}}
+	}
+	cases := []struct {
+		name    string
+		backend api.Backend
+		mirror  api.Backend
+		mounts  map[string]string
+	}{
+		{
+			name:    "local and local",
+			backend: api.Backend{Local: local("/repository")},
+			mirror:  api.Backend{Local: local("/mirror")},
+			mounts:  map[string]string{LocalVolumeName: "/repository", MirrorLocalVolumeName: "/mirror"},
+		},
+		{
+			name:    "local and s3",
+			backend: api.Backend{Local: local("/repository")},
+			mirror:  api.Backend{S3: &api.S3Spec{Endpoint: "s3.amazonaws.com", Bucket: "stash"}},
+			mounts:  map[string]string{LocalVolumeName: "/repository"},
+		},
+		{
+			name:    "gcs and local",
+			backend: api.Backend{GCS: &api.GCSSpec{Bucket: "stash"}},
+			mirror:  api.Backend{Local: local("/mirror"), PasswordSource: api.PasswordSourceFile},
+			mounts:  map[string]string{GCSCredentialsVolumeName: GCSCredentialsMountPath, MirrorLocalVolumeName: "/mirror"},
+		},
+	}
+
+	workload := api.LocalTypedReference{Kind: api.KindDeployment, Name: "app"}
+	for _, c := range cases {
+		c.backend.StorageSecretName = "secret"
+		c.mirror.StorageSecretName = "mirror"
+		r := &api.Restic{Spec: api.ResticSpec{Backend: c.backend, Mirror: &c.mirror}}
+
+		sidecar := sidecarContainer(t, r, "0.5.1", "", workload, DefaultLogLevel, nil)
+		found := map[string]string{}
+		for _, m := range sidecar.VolumeMounts {
+			if _, ok := c.mounts[m.Name]; ok {
+				found[m.Name] = m.MountPath
+			}
+		}
+		if !reflect.DeepEqual(found, c.mounts) {
+			t.Errorf("%s: expected backend mounts %v, found %v", c.name, c.mounts, sidecar.VolumeMounts)
+		}
+		// the sidecar backs up to the mirror with credentials read from its secret
+		if v := envMap(sidecar)[cli.RESTIC_REPOSITORY].Value; strings.Contains(v, "mirror") {
+			t.Errorf("%s: expected repository of primary backend, found %q", c.name, v)
+		}
+
+		volumes := MergeBackendVolumes(nil, nil, r)
+		if len(volumes) != len(c.mounts) {
+			t.Errorf("%s: expected volumes %v, found %v", c.name, c.mounts, volumes)
+		}
+		for _, vol := range volumes {
+			if _, ok := c.mounts[vol.Name]; !ok {
+				t.Errorf("%s: unexpected volume %s", c.name, vol.Name)
+			}
+		}
+		if volumes = EnsureBackendVolumesDeleted(volumes, r); len(volumes) != 0 {
+			t.Errorf("%s: expected backend volumes to be deleted, found %v", c.name, volumes)
+		}
+	}
+
+	// removing the mirror removes its volume
+	old := &api.Restic{Spec: api.ResticSpec{Backend: cases[0].backend, Mirror: &cases[0].mirror}}
+	r := &api.Restic{Spec: api.ResticSpec{Backend: cases[0].backend}}
+	volumes := MergeBackendVolumes(MergeBackendVolumes(nil, nil, old), old, r)
+	if len(volumes) != 1 || volumes[0].Name != LocalVolumeName {
+		t.Errorf("expected only volume %s, found %v", LocalVolumeName, volumes)
+	}
+}
+
+func TestCreateForgetJob(t *testing.T) {
+	r := &api.Restic{}
+	r.Name = "stash-demo"
+	r.Spec.Backend.StorageSecretName = "backend-secret"
+	r.Spec.Backend.S3 = &api.S3Spec{Endpoint: "s3.amazonaws.com", Bucket: "stash"}
+
+	job, err := CreateForgetJob(r, "host-0", "deployment/stash-demo", "canary")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job.Name != ForgetJobPrefix+r.Name+"-host-0" || job.Annotations[AnnotationOperation] != OperationForget {
+		t.Errorf("unexpected forget job metadata %v", job.ObjectMeta)
+	}
+	container := job.Spec.Template.Spec.Containers[0]
+	if container.Args[0] != "forget" {
+		t.Errorf("expected forget command, found %v", container.Args)
+	}
+	env := envMap(container)
+	if env[RepositoryPrefixEnv].Value != "deployment/stash-demo" {
+		t.Errorf("expected repository prefix deployment/stash-demo, found %q", env[RepositoryPrefixEnv].Value)
+	}
+	if _, ok := env[cli.AWS_ACCESS_KEY_ID]; !ok {
+		t.Errorf("expected s3 credentials in env, found %v", container.Env)
+	}
+
+	r.Spec.Backend = api.Backend{Local: &api.LocalSpec{}}
+	if _, err = CreateForgetJob(r, "host-0", "deployment/stash-demo", "canary"); err == nil {
+		t.Error("expected error for local backend without path")
+	}
+}
+
+func TestCreateCheckJobBackend(t *testing.T) {
+	r := &api.Restic{}
+	r.Name = "stash-demo"
+	r.Spec.Backend.StorageSecretName = "gcs-secret"
+	r.Spec.Backend.GCS = &api.GCSSpec{Bucket: "stash"}
+
+	job, err := CreateCheckJob(r, "host-0", "deployment/stash-demo", "canary")
+	if err != nil {
+		t.Fatal(err)
+	}
+	container := job.Spec.Template.Spec.Containers[0]
+	if _, ok := envMap(container)[cli.GOOGLE_APPLICATION_CREDENTIALS]; !ok {
+		t.Errorf("expected gcs credentials in env, found %v", container.Env)
+	}
+	if len(job.Spec.Template.Spec.Volumes) != len(container.VolumeMounts) {
+		t.Errorf("expected a mount for each volume, found volumes %v and mounts %v", job.Spec.Template.Spec.Volumes, container.VolumeMounts)
+	}
+}
+
+func TestRepositoryHealthyCondition(t *testing.T) {
+	if c := RepositoryHealthyCondition("host-0", nil); c.Type != api.ResticConditionRepositoryHealthy || c.Status != core.ConditionTrue {
+		t.Errorf("expected healthy repository condition, found %v", c)
+	}
+	if c := RepositoryHealthyCondition("host-0", errors.New("pack not found")); c.Status != core.ConditionFalse {
+		t.Errorf("expected unhealthy repository condition, found %v", c)
+	}
+}
+
+func TestMirrorBackedUpCondition(t *testing.T) {
+	if c := MirrorBackedUpCondition("host-0", nil); c.Type != api.ResticConditionMirrorBackedUp || c.Status != core.ConditionTrue {
+		t.Errorf("expected mirror backed up condition, found %v", c)
+	}
+	if c := MirrorBackedUpCondition("host-0", errors.New("bucket not found")); c.Status != core.ConditionFalse || !strings.Contains(c.Message, "bucket not found") {
+		t.Errorf("expected failed mirror condition, found %v", c)
+	}
+}
+
+func TestWaitUntilSidecarAddedTimeout(t *testing.T) {
+	pod := &core.Pod{}
+	pod.Name = "stash-demo-0"
+	pod.Namespace = "default"
+	pod.Labels = map[string]string{"app": "stash-demo"}
+	client := fake.NewSimpleClientset(pod)
+	// pods are never recreated with the sidecar, pretend deletes succeed without removing the pod
+	client.PrependReactor("delete", "pods", func(action clienttesting.Action) (bool, runtime.Object, error) {
+		return true, nil, nil
+	})
+
+	bo := SidecarWaitBackoff{
+		InitialInterval: 10 * time.Millisecond,
+		MaxInterval:     20 * time.Millisecond,
+		MaxElapsedTime:  100 * time.Millisecond,
+	}
+	selector := &metav1.LabelSelector{MatchLabels: pod.Labels}
+	start := time.Now()
+	err := WaitUntilSidecarAdded(client, pod.Namespace, selector, api.BackupOnline, RestartStrategyDelete, bo)
+	if err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Errorf("expected timeout error, found %v", err)
+	}
+	if d := time.Since(start); d > 5*time.Second {
+		t.Errorf("expected to give up shortly after %s, took %s", bo.MaxElapsedTime, d)
+	}
+
+	if err = WaitUntilSidecarRemoved(client, pod.Namespace, selector, api.BackupOnline, RestartStrategyDelete, bo); err != nil {
+		t.Errorf("expected pod without sidecar to satisfy removal, found %v", err)
+	}
+}
+
+func TestWaitUntilSidecarAddedEviction(t *testing.T) {
+	controlled := &core.Pod{ObjectMeta: metav1.ObjectMeta{
+		Name:            "stash-demo-5d8f7-x2v4k",
+		Namespace:       "default",
+		Labels:          map[string]string{"app": "stash-demo"},
+		OwnerReferences: []metav1.OwnerReference{*metav1.NewControllerRef(&extensions.ReplicaSet{ObjectMeta: metav1.ObjectMeta{Name: "stash-demo-5d8f7"}}, extensions.SchemeGroupVersion.WithKind(api.KindReplicaSet))},
+	}}
+	orphan := &core.Pod{ObjectMeta: metav1.ObjectMeta{Name: "stash-demo", Namespace: "default", Labels: controlled.Labels}}
+	client := fake.NewSimpleClientset(controlled, orphan)
+
+	// the first eviction is blocked by a PodDisruptionBudget, pods are never recreated with the sidecar
+	var evicted, deleted []string
+	client.PrependReactor("create", "pods", func(action clienttesting.Action) (bool, runtime.Object, error) {
+		if action.GetSubresource() != "eviction" {
+			return false, nil, nil
+		}
+		evicted = append(evicted, action.(clienttesting.CreateAction).GetObject().(*policy.Eviction).Name)
+		if len(evicted) == 1 {
+			return true, nil, kerr.NewTooManyRequests("Cannot evict pod as it would violate the pod's disruption budget.", 10)
+		}
+		return true, nil, nil
+	})
+	client.PrependReactor("delete", "pods", func(action clienttesting.Action) (bool, runtime.Object, error) {
+		deleted = append(deleted, action.(clienttesting.DeleteAction).GetName())
+		return true, nil, nil
+	})
+
+	bo := SidecarWaitBackoff{
+		InitialInterval: 10 * time.Millisecond,
+		MaxInterval:     20 * time.Millisecond,
+		MaxElapsedTime:  100 * time.Millisecond,
+	}
+	selector := &metav1.LabelSelector{MatchLabels: controlled.Labels}
+	if err := WaitUntilSidecarAdded(client, controlled.Namespace, selector, api.BackupOnline, RestartStrategyDelete, bo); err == nil {
+		t.Fatal("expected timeout error")
+	}
+	if len(evicted) < 2 {
+		t.Errorf("expected blocked eviction of pod %s to be retried, found evictions %v", controlled.Name, evicted)
+	}
+	for _, name := range evicted {
+		if name != controlled.Name {
+			t.Errorf("expected only pod %s to be evicted, found evictions %v", controlled.Name, evicted)
+			break
+		}
+	}
+	for _, name := range deleted {
+		if name != orphan.Name {
+			t.Errorf("expected only pod %s without controller to be deleted, found deletes %v", orphan.Name, deleted)
+			break
+		}
+	}
+	if len(deleted) == 0 {
+		t.Errorf("expected pod %s without controller to be deleted", orphan.Name)
+	}
+}
+
+func TestRestartPodsRollout(t *testing.T) {
+	deployment := &apps.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "stash-demo", Namespace: "default"}}
+	rs := &extensions.ReplicaSet{ObjectMeta: metav1.ObjectMeta{
+		Name:            "stash-demo-5d8f7",
+		Namespace:       "default",
+		OwnerReferences: []metav1.OwnerReference{*metav1.NewControllerRef(deployment, apps.SchemeGroupVersion.WithKind(api.KindDeployment))},
+	}}
+	ss := &apps.StatefulSet{
+		ObjectMeta: metav1.ObjectMeta{Name: "stash-db", Namespace: "default"},
+		Spec:       apps.StatefulSetSpec{UpdateStrategy: apps.StatefulSetUpdateStrategy{Type: apps.OnDeleteStatefulSetStrategyType}},
+	}
+	newPod := func(name string, owner *metav1.OwnerReference) core.Pod {
+		pod := core.Pod{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"}}
+		if owner != nil {
+			pod.OwnerReferences = []metav1.OwnerReference{*owner}
+		}
+		return pod
+	}
+	pods := []core.Pod{
+		newPod("stash-demo-5d8f7-x2v4k", metav1.NewControllerRef(rs, extensions.SchemeGroupVersion.WithKind(api.KindReplicaSet))),
+		newPod("stash-demo-5d8f7-9qzcm", metav1.NewControllerRef(rs, extensions.SchemeGroupVersion.WithKind(api.KindReplicaSet))),
+		newPod("stash-db-0", metav1.NewControllerRef(ss, apps.SchemeGroupVersion.WithKind(api.KindStatefulSet))),
+		newPod("stash-demo", nil),
+	}
+	client := fake.NewSimpleClientset(deployment, rs, ss, &pods[0], &pods[1], &pods[2], &pods[3])
+
+	var patched, evicted, deleted []string
+	client.PrependReactor("patch", "deployments", func(action clienttesting.Action) (bool, runtime.Object, error) {
+		patch := action.(clienttesting.PatchAction)
+		if !strings.Contains(string(patch.GetPatch()), api.RestartedAt) {
+			t.Errorf("expected patch setting %s, found %s", api.RestartedAt, patch.GetPatch())
+		}
+		patched = append(patched, patch.GetName())
+		return true, deployment, nil
+	})
+	client.PrependReactor("create", "pods", func(action clienttesting.Action) (bool, runtime.Object, error) {
+		if action.GetSubresource() != "eviction" {
+			return false, nil, nil
+		}
+		evicted = append(evicted, action.(clienttesting.CreateAction).GetObject().(*policy.Eviction).Name)
+		return true, nil, nil
+	})
+	client.PrependReactor("delete", "pods", func(action clienttesting.Action) (bool, runtime.Object, error) {
+		deleted = append(deleted, action.(clienttesting.DeleteAction).GetName())
+		return true, nil, nil
+	})
+
+	restarted := map[string]bool{}
+	restartPods(client, "default", pods, RestartStrategyRollout, restarted)
+	// pods are checked again until they are recreated, the Deployment must not be patched again
+	restartPods(client, "default", pods[:2], RestartStrategyRollout, restarted)
+
+	if !reflect.DeepEqual(patched, []string{deployment.Name}) {
+		t.Errorf("expected Deployment %s to be patched once, found patches %v", deployment.Name, patched)
+	}
+	// a StatefulSet with OnDelete update strategy does not roll out on a template change
+	if !reflect.DeepEqual(evicted, []string{"stash-db-0"}) {
+		t.Errorf("expected pod stash-db-0 to be evicted, found evictions %v", evicted)
+	}
+	if !reflect.DeepEqual(deleted, []string{"stash-demo"}) {
+		t.Errorf("expected bare pod stash-demo to be deleted, found deletes %v", deleted)
+	}
+}
+
+func TestEvictPodForbidden(t *testing.T) {
+	pod := &core.Pod{ObjectMeta: metav1.ObjectMeta{
+		Name:            "stash-demo-5d8f7-x2v4k",
+		Namespace:       "default",
+		OwnerReferences: []metav1.OwnerReference{*metav1.NewControllerRef(&extensions.ReplicaSet{ObjectMeta: metav1.ObjectMeta{Name: "stash-demo-5d8f7"}}, extensions.SchemeGroupVersion.WithKind(api.KindReplicaSet))},
+	}}
+	client := fake.NewSimpleClientset(pod)
+	client.PrependReactor("create", "pods", func(action clienttesting.Action) (bool, runtime.Object, error) {
+		if action.GetSubresource() != "eviction" {
+			return false, nil, nil
+		}
+		return true, nil, kerr.NewForbidden(core.Resource("pods/eviction"), pod.Name, errors.New("rbac denied"))
+	})
+
+	evictPod(client, pod)
+	if _, err := client.CoreV1().Pods(pod.Namespace).Get(pod.Name, metav1.GetOptions{}); !kerr.IsNotFound(err) {
+		t.Errorf("expected pod %s to be deleted when eviction is forbidden, got %v", pod.Name, err)
+	}
+}
+
+func TestResticEqualAnnotations(t *testing.T) {
+	old := &api.Restic{}
+	old.Spec.Schedule = "@every 1m"
+	old.Annotations = map[string]string{api.LastAppliedConfiguration: "{}"}
+
+	new := old.DeepCopy()
+	new.Annotations[api.LastAppliedConfiguration] = `{"spec":{}}`
+	if !ResticEqual(old, new) {
+		t.Error("expected change of last applied configuration to be ignored")
+	}
+
+	new.Annotations[api.VersionTag] = "0.6.0"
+	if ResticEqual(old, new) {
+		t.Errorf("expected change of %s annotation to be detected", api.VersionTag)
+	}
+}
+
+func TestRecoveryEqualQuantities(t *testing.T) {
+	cutoff := metav1.NewTime(time.Date(2018, 1, 2, 3, 4, 5, 0, time.UTC))
+	old := &api.Recovery{}
+	old.Spec.Time = &cutoff
+	old.Spec.Resources.Limits = core.ResourceList{core.ResourceMemory: resource.MustParse("1Gi")}
+
+	new := old.DeepCopy()
+	new.Spec.Resources.Limits = core.ResourceList{core.ResourceMemory: resource.MustParse("1024Mi")}
+	if !RecoveryEqual(old, new) {
+		t.Error("expected 1Gi and 1024Mi memory limits to be equal")
+	}
+
+	new.Spec.Resources.Limits = core.ResourceList{core.ResourceMemory: resource.MustParse("2Gi")}
+	if RecoveryEqual(old, new) {
+		t.Error("expected 1Gi and 2Gi memory limits to differ")
+	}
+}
+
+func TestCreateSidecarContainerSecurityContext(t *testing.T) {
+	r := &api.Restic{}
+	workload := api.LocalTypedReference{Kind: api.KindDeployment, Name: "stash-demo"}
+
+	if sc := sidecarContainer(t, r, "canary", "", workload, DefaultLogLevel, nil).SecurityContext; sc != nil {
+		t.Errorf("expected no security context, found %v", sc)
+	}
+
+	sc := sidecarContainer(t, r, "canary", "", workload, DefaultLogLevel, DefaultSidecarSecurityContext()).SecurityContext
+	if sc == nil || sc.RunAsNonRoot == nil || !*sc.RunAsNonRoot {
+		t.Errorf("expected default non-root security context, found %v", sc)
+	}
+
+	var root int64 = 0
+	r.Spec.SecurityContext = &core.SecurityContext{RunAsUser: &root}
+	sc = sidecarContainer(t, r, "canary", "", workload, DefaultLogLevel, DefaultSidecarSecurityContext()).SecurityContext
+	if sc == nil || sc.RunAsUser == nil || *sc.RunAsUser != 0 || sc.RunAsNonRoot != nil {
+		t.Errorf("expected security context from restic, found %v", sc)
+	}
+
+	r.Spec.SecurityContext = nil
+	sc = initContainer(t, r, "canary", "", workload, false, DefaultSidecarSecurityContext()).SecurityContext
+	if sc == nil || sc.RunAsNonRoot == nil || !*sc.RunAsNonRoot {
+		t.Errorf("expected default non-root security context for init container, found %v", sc)
+	}
+}
+
+// deleteOptionsRecorder records the options passed to job deletes, which the fake clientset drops.
+type deleteOptionsRecorder struct {
+	kubernetes.Interface
+	batchv1.BatchV1Interface
+	batchv1.JobInterface
+	options *metav1.DeleteOptions
+}
+
+func (r *deleteOptionsRecorder) BatchV1() batchv1.BatchV1Interface { return r }
+func (r *deleteOptionsRecorder) Jobs(string) batchv1.JobInterface  { return r }
+func (r *deleteOptionsRecorder) Delete(name string, options *metav1.DeleteOptions) error {
+	r.options = options
+	return r.Interface.BatchV1().Jobs("default").Delete(name, options)
+}
+
+func TestDeleteStashJobPropagation(t *testing.T) {
+	job := batch.Job{}
+	job.Name = "stash-recovery-demo"
+	job.Namespace = "default"
+	client := fake.NewSimpleClientset(&job)
+	recorder := &deleteOptionsRecorder{Interface: client}
+
+	if err := DeleteStashJob(recorder, job); err != nil {
+		t.Fatal(err)
+	}
+	if recorder.options == nil || recorder.options.PropagationPolicy == nil || *recorder.options.PropagationPolicy != metav1.DeletePropagationForeground {
+		t.Errorf("expected foreground propagation policy, found %v", recorder.options)
+	}
+	for _, action := range client.Actions() {
+		if action.GetResource().Resource == "pods" {
+			t.Errorf("expected pods to be garbage collected with the job, found action %s", action.GetVerb())
+		}
+	}
+	if _, err := client.BatchV1().Jobs(job.Namespace).Get(job.Name, metav1.GetOptions{}); err == nil {
+		t.Error("expected job to be deleted")
+	}
+}
+
+func TestWaitUntilJobCompleted(t *testing.T) {
+	backoffLimit := int32(2)
+	job := func(name string, status batch.JobStatus) *batch.Job {
+		return &batch.Job{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+			Spec:       batch.JobSpec{BackoffLimit: &backoffLimit},
+			Status:     status,
+		}
+	}
+	client := fake.NewSimpleClientset(
+		job("succeeded", batch.JobStatus{Succeeded: 1}),
+		job("failed", batch.JobStatus{Conditions: []batch.JobCondition{{Type: batch.JobFailed, Status: core.ConditionTrue}}}),
+		job("backoff-exceeded", batch.JobStatus{Failed: 3}),
+		job("running", batch.JobStatus{Active: 1, Failed: 1}),
+	)
+
+	cases := map[string]JobResult{
+		"succeeded":        JobResultSucceeded,
+		"failed":           JobResultFailed,
+		"backoff-exceeded": JobResultFailed,
+		"running":          JobResultTimeout,
+	}
+	for name, expected := range cases {
+		result, err := WaitUntilJobCompleted(client, "default", name, 100*time.Millisecond)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", name, err)
+		} else if result != expected {
+			t.Errorf("%s: expected result %s, found %s", name, expected, result)
+		}
+	}
+
+	if _, err := WaitUntilJobCompleted(client, "default", "missing", 100*time.Millisecond); err == nil {
+		t.Error("expected error for missing job")
+	}
+}
+
+func TestDeleteRecoveryJobParallel(t *testing.T) {
+	recovery := &api.Recovery{ObjectMeta: metav1.ObjectMeta{Name: "rec", Namespace: "default"}}
+	recovery.Spec.ParallelVolumes = true
+	restic := &api.Restic{Spec: api.ResticSpec{
+		VolumeMounts: []core.VolumeMount{{Name: "a", MountPath: "/a"}, {Name: "b", MountPath: "/b"}},
+	}}
+	other := CreateRecoveryJob(&api.Recovery{ObjectMeta: metav1.ObjectMeta{Name: "other", Namespace: "default"}}, restic, "canary", DefaultLogLevel)
+	objects := []runtime.Object{other}
+	for _, job := range CreateRecoveryJobs(recovery, restic, "canary", DefaultLogLevel) {
+		objects = append(objects, job)
+	}
+	client := fake.NewSimpleClientset(objects...)
+
+	if err := DeleteRecoveryJob(client, recovery); err != nil {
+		t.Fatal(err)
+	}
+	jobs, err := client.BatchV1().Jobs("default").List(metav1.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(jobs.Items) != 1 || jobs.Items[0].Name != other.Name {
+		t.Errorf("expected only recovery job %s to be kept, found %v", other.Name, jobs.Items)
+	}
+}
+
+func TestFindAllRestics(t *testing.T) {
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	lister := stash_listers.NewResticLister(indexer)
+	workload := metav1.ObjectMeta{Name: "stash-demo", Namespace: "default", Labels: map[string]string{"app": "stash-demo", "tier": "db"}}
+
+	addRestic := func(name string, matchLabels map[string]string) {
+		restic := &api.Restic{}
+		restic.Name = name
+		restic.Namespace = "default"
+		restic.Spec.Selector = metav1.LabelSelector{MatchLabels: matchLabels}
+		indexer.Add(restic)
+	}
+
+	addRestic("other", map[string]string{"app": "other"})
+	if restics, err := FindAllRestics(lister, api.KindDeployment, workload); err != nil || len(restics) != 0 {
+		t.Errorf("expected no match, found %v, %v", restics, err)
+	}
+	if restic, err := FindRestic(lister, api.KindDeployment, workload); err != nil || restic != nil {
+		t.Errorf("expected no match, found %v, %v", restic, err)
+	}
+
+	addRestic("by-app", map[string]string{"app": "stash-demo"})
+	if restics, err := FindAllRestics(lister, api.KindDeployment, workload); err != nil || len(restics) != 1 || restics[0].Name != "by-app" {
+		t.Errorf("expected match by-app, found %v, %v", restics, err)
+	}
+	if restic, err := FindRestic(lister, api.KindDeployment, workload); err != nil || restic == nil || restic.Name != "by-app" {
+		t.Errorf("expected match by-app, found %v, %v", restic, err)
+	}
+
+	addRestic("by-tier", map[string]string{"tier": "db"})
+	restics, err := FindAllRestics(lister, api.KindDeployment, workload)
+	if err != nil || len(restics) != 2 {
+		t.Fatalf("expected 2 matches, found %v, %v", restics, err)
+	}
+	names := map[string]bool{restics[0].Name: true, restics[1].Name: true}
+	if !names["by-app"] || !names["by-tier"] {
+		t.Errorf("expected matches by-app and by-tier, found %v", names)
+	}
+	if _, err = FindRestic(lister, api.KindDeployment, workload); err == nil || !strings.Contains(err.Error(), "matches multiple Restics") {
+		t.Errorf("expected multiple match error, found %v", err)
+	}
+}
+
+func TestFindAllResticsEmptySelector(t *testing.T) {
+	workload := metav1.ObjectMeta{Name: "stash-demo", Namespace: "default", Labels: map[string]string{"app": "stash-demo"}}
+	matches := func(restics ...*api.Restic) []string {
+		indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+		for _, restic := range restics {
+			restic.Namespace = "default"
+			indexer.Add(restic)
+		}
+		result, err := FindAllRestics(stash_listers.NewResticLister(indexer), api.KindDeployment, workload)
+		if err != nil {
+			t.Fatal(err)
+		}
+		names := make([]string, 0, len(result))
+		for _, restic := range result {
+			names = append(names, restic.Name)
+		}
+		return names
+	}
+
+	empty := &api.Restic{ObjectMeta: metav1.ObjectMeta{Name: "empty"}}
+	if names := matches(empty); len(names) != 0 {
+		t.Errorf("expected Restic with empty selector to match nothing, found %v", names)
+	}
+	specific := &api.Restic{ObjectMeta: metav1.ObjectMeta{Name: "specific"}}
+	specific.Spec.Selector.MatchLabels = map[string]string{"app": "stash-demo"}
+	if names := matches(empty, specific); len(names) != 1 || names[0] != "specific" {
+		t.Errorf("expected match specific, found %v", names)
+	}
+	all := &api.Restic{ObjectMeta: metav1.ObjectMeta{Name: "all"}, Spec: api.ResticSpec{SelectAll: true}}
+	if names := matches(empty, all); len(names) != 1 || names[0] != "all" {
+		t.Errorf("expected match all, found %v", names)
+	}
+}
+
+func TestFindAllResticsByTarget(t *testing.T) {
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	lister := stash_listers.NewResticLister(indexer)
+	workload := metav1.ObjectMeta{Name: "stash-demo", Namespace: "default", Labels: map[string]string{"app": "stash-demo"}}
+
+	bySelector := &api.Restic{}
+	bySelector.Name = "by-selector"
+	bySelector.Namespace = "default"
+	bySelector.Spec.Selector = metav1.LabelSelector{MatchLabels: map[string]string{"app": "stash-demo"}}
+	indexer.Add(bySelector)
+
+	otherTarget := &api.Restic{}
+	otherTarget.Name = "other-target"
+	otherTarget.Namespace = "default"
+	otherTarget.Spec.Target = &api.LocalTypedReference{Kind: api.KindDaemonSet, Name: "stash-demo"}
+	indexer.Add(otherTarget)
+
+	if restic, err := FindRestic(lister, api.KindDeployment, workload); err != nil || restic == nil || restic.Name != "by-selector" {
+		t.Errorf("expected match by-selector, found %v, %v", restic, err)
+	}
+
+	byTarget := &api.Restic{}
+	byTarget.Name = "by-target"
+	byTarget.Namespace = "default"
+	byTarget.Spec.Target = &api.LocalTypedReference{Kind: "deploy", Name: "stash-demo"}
+	indexer.Add(byTarget)
+
+	restics, err := FindAllRestics(lister, api.KindDeployment, workload)
+	if err != nil || len(restics) != 1 || restics[0].Name != "by-target" {
+		t.Errorf("expected target match to take precedence, found %v, %v", restics, err)
+	}
+	if restic, err := FindRestic(lister, api.KindDaemonSet, workload); err != nil || restic == nil || restic.Name != "other-target" {
+		t.Errorf("expected match other-target for daemonset, found %v, %v", restic, err)
+	}
+	if restic, err := FindRestic(lister, api.KindReplicaSet, workload); err != nil || restic == nil || restic.Name != "by-selector" {
+		t.Errorf("expected match by-selector for replicaset, found %v, %v", restic, err)
+	}
+}
+
+func TestGetJobPodLogs(t *testing.T) {
+	job := &batch.Job{ObjectMeta: metav1.ObjectMeta{Name: RecoveryJobPrefix + "rec", Namespace: "default"}}
+	pod := func(name string, created time.Time, jobName string) *core.Pod {
+		return &core.Pod{ObjectMeta: metav1.ObjectMeta{
+			Name:              name,
+			Namespace:         "default",
+			Labels:            map[string]string{"job-name": jobName},
+			CreationTimestamp: metav1.NewTime(created),
+		}}
+	}
+	now := time.Now()
+	client := fake.NewSimpleClientset(
+		pod("stash-recovery-rec-first", now.Add(-time.Minute), job.Name),
+		pod("stash-recovery-rec-retry", now, job.Name),
+		pod("stash-recovery-other", now.Add(time.Minute), RecoveryJobPrefix+"other"),
+	)
+
+	orig := podLogs
+	defer func() { podLogs = orig }()
+	podLogs = func(_ kubernetes.Interface, namespace, name string, opts *core.PodLogOptions) ([]byte, error) {
+		if opts.TailLines == nil || *opts.TailLines != 2 {
+			t.Errorf("expected 2 tail lines, found %v", opts.TailLines)
+		}
+		return []byte("restoring /source/data\nFatal: wrong password\n"), nil
+	}
+
+	name, logs, err := GetJobPodLogs(client, job, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name != "stash-recovery-rec-retry" {
+		t.Errorf("expected logs of most recent pod of job, found pod %s", name)
+	}
+	if logs != "restoring /source/data\nFatal: wrong password\n" {
+		t.Errorf("unexpected logs %q", logs)
+	}
+
+	if _, _, err := GetJobPodLogs(fake.NewSimpleClientset(), job, 2); err == nil {
+		t.Error("expected error for job without pods")
+	}
+}
+
+func TestRecoveryPhaseForError(t *testing.T) {
+	cases := map[string]struct {
+		err   error
+		phase api.RecoveryPhase
+	}{
+		"succeeded": {nil, api.RecoverySucceeded},
+		"failed":    {errors.New("exit status 1"), api.RecoveryFailed},
+		"partial":   {&cli.PartialRestoreError{Path: "/source/data", Errors: []string{"/source/data/db: permission denied"}}, api.RecoveryPartial},
+	}
+	for name, c := range cases {
+		if got := RecoveryPhaseForError(c.err); got != c.phase {
+			t.Errorf("%s: expected phase %s, found %s", name, c.phase, got)
+		}
+	}
+}
+
+func TestRecoveryFinishedTime(t *testing.T) {
+	finished := time.Date(2017, 6, 28, 8, 28, 37, 0, time.UTC)
+	for _, phase := range []api.RecoveryPhase{api.RecoverySucceeded, api.RecoveryPartial, api.RecoveryFailed} {
+		rec := &api.Recovery{Status: api.RecoveryStatus{Phase: phase, Conditions: RecoveryPhaseConditions(phase, "reason", "msg")}}
+		if _, ok := RecoveryFinishedTime(rec); ok {
+			t.Errorf("%s: expected no finished time without transition time", phase)
+		}
+		rec.Status.Conditions[0].LastTransitionTime = metav1.NewTime(finished)
+		if got, ok := RecoveryFinishedTime(rec); !ok || !got.Equal(finished) {
+			t.Errorf("%s: expected finished time %s, found %s", phase, finished, got)
+		}
+	}
+
+	running := &api.Recovery{Status: api.RecoveryStatus{
+		Phase:      api.RecoveryRunning,
+		Conditions: []api.RecoveryCondition{{Type: api.RecoveryConditionJobCreated, Status: core.ConditionTrue, LastTransitionTime: metav1.NewTime(finished)}},
+	}}
+	if _, ok := RecoveryFinishedTime(running); ok {
+		t.Error("expected no finished time for running Recovery")
+	}
+}
+
+func TestIsBackupStale(t *testing.T) {
+	now := time.Date(2018, 1, 2, 0, 0, 0, 0, time.UTC)
+	created := metav1.NewTime(now.Add(-48 * time.Hour))
+	cases := map[string]struct {
+		created    metav1.Time
+		lastBackup *metav1.Time
+		stale      bool
+		schedule   string
+	}{
+		"recent":    {created, &metav1.Time{Time: now.Add(-time.Hour)}, false, ""},
+		"stale":     {created, &metav1.Time{Time: now.Add(-25 * time.Hour)}, true, ""},
+		"never":     {created, nil, true, ""},
+		"new":       {metav1.NewTime(now.Add(-time.Hour)), nil, false, ""},
+		"threshold": {created, &metav1.Time{Time: now.Add(-24 * time.Hour)}, false, ""},
+		// the next weekly backup is due in 5 days
+		"weekly":         {created, &metav1.Time{Time: now.Add(-48 * time.Hour)}, false, "@weekly"},
+		"weekly overdue": {created, &metav1.Time{Time: now.Add(-9 * 24 * time.Hour)}, true, "@weekly"},
+	}
+	for name, c := range cases {
+		restic := &api.Restic{
+			ObjectMeta: metav1.ObjectMeta{CreationTimestamp: c.created},
+			Spec:       api.ResticSpec{Schedule: c.schedule},
+			Status:     api.ResticStatus{LastSuccessfulBackupTime: c.lastBackup},
+		}
+		if stale := IsBackupStale(restic, 24*time.Hour, now); stale != c.stale {
+			t.Errorf("%s: expected stale %v, found %v", name, c.stale, stale)
+		}
+	}
+}
+
+func TestSkipInjection(t *testing.T) {
+	cases := map[string]struct {
+		workload map[string]string
+		template map[string]string
+		skip     bool
+	}{
+		"no annotations":     {nil, nil, false},
+		"other annotations":  {map[string]string{"app": "db"}, map[string]string{"app": "db"}, false},
+		"workload skipped":   {map[string]string{api.SkipInjection: "true"}, nil, true},
+		"pod skipped":        {nil, map[string]string{api.SkipInjection: "true"}, true},
+		"skip disabled":      {map[string]string{api.SkipInjection: "false"}, nil, false},
+		"invalid skip value": {map[string]string{api.SkipInjection: "yes please"}, nil, false},
+	}
+	for name, c := range cases {
+		if skip := SkipInjection(c.workload, c.template); skip != c.skip {
+			t.Errorf("%s: expected skip %v, found %v", name, c.skip, skip)
+		}
+	}
+}
+
+func TestResticLimitArgs(t *testing.T) {
+	restic := &api.Restic{}
+	restic.Name = "stash-demo"
+	workload := api.LocalTypedReference{Kind: api.KindDeployment, Name: "stash-demo"}
+	recovery := &api.Recovery{}
+	recovery.Spec.Workload = workload
+
+	hasLimitFlag := func(args []string) bool {
+		for _, arg := range args {
+			if strings.HasPrefix(arg, "--limit-") || strings.HasPrefix(arg, "--restic-timeout") {
+				return true
+			}
+		}
+		return false
+	}
+	if args := sidecarContainer(t, restic, "canary", "", workload, DefaultLogLevel, nil).Args; hasLimitFlag(args) {
+		t.Errorf("expected no limit flags on sidecar by default, found %v", args)
+	}
+	if args := CreateRecoveryJob(recovery, restic, "canary", DefaultLogLevel).Spec.Template.Spec.Containers[0].Args; hasLimitFlag(args) {
+		t.Errorf("expected no limit flags on recovery container by default, found %v", args)
+	}
+
+	upload, download := int32(512), int32(2048)
+	restic.Spec.LimitUpload = &upload
+	restic.Spec.LimitDownload = &download
+	restic.Spec.Timeout = &metav1.Duration{Duration: 2 * time.Hour}
+	expected := []string{"--limit-upload=512", "--limit-download=2048", "--restic-timeout=2h0m0s"}
+	checkJob, err := CreateCheckJob(restic, "host-0", "deployment/stash-demo", "canary")
+	if err != nil {
+		t.Fatal(err)
+	}
+	forgetJob, err := CreateForgetJob(restic, "host-0", "deployment/stash-demo", "canary")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, args := range map[string][]string{
+		"sidecar":  sidecarContainer(t, restic, "canary", "", workload, DefaultLogLevel, nil).Args,
+		"init":     initContainer(t, restic, "canary", "", workload, false, nil).Args,
+		"recovery": CreateRecoveryJob(recovery, restic, "canary", DefaultLogLevel).Spec.Template.Spec.Containers[0].Args,
+		"check":    checkJob.Spec.Template.Spec.Containers[0].Args,
+		"forget":   forgetJob.Spec.Template.Spec.Containers[0].Args,
+	} {
+		joined := "|" + strings.Join(args, "|") + "|"
+		for _, flag := range expected {
+			if !strings.Contains(joined, "|"+flag+"|") {
+				t.Errorf("%s: expected flag %s, found %v", name, flag, args)
+			}
+		}
+	}
+}
+
+// sidecarContainer returns the sidecar created by CreateSidecarContainer and fails the test on error.
+func sidecarContainer(t *testing.T, r *api.Restic, tag, imageDigest string, workload api.LocalTypedReference, logLevel int, defaultSecurityContext *core.SecurityContext) core.Container {
+	container, err := CreateSidecarContainer(r, tag, imageDigest, workload, logLevel, defaultSecurityContext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return container
+}
+
+// initContainer returns the init container created by CreateInitContainer and fails the test on error.
+func initContainer(t *testing.T, r *api.Restic, tag, imageDigest string, workload api.LocalTypedReference, enableRBAC bool, defaultSecurityContext *core.SecurityContext) core.Container {
+	container, err := CreateInitContainer(r, tag, imageDigest, workload, enableRBAC, defaultSecurityContext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return container
+}
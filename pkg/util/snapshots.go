@@ -0,0 +1,74 @@
+package util
+
+import (
+	"errors"
+
+	api "github.com/appscode/stash/apis/stash/v1alpha1"
+	"github.com/appscode/stash/pkg/cli"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// ListSnapshots returns the snapshots stored in the repository of restic. prefix selects
+// the directory inside the repository, as computed by HostnamePrefix for the backed up workload.
+// An uninitialized repository yields an empty list and a wrong password yields cli.ErrRepositoryAuth.
+func ListSnapshots(kubeClient kubernetes.Interface, restic *api.Restic, scratchDir, prefix string) ([]cli.Snapshot, error) {
+	if restic.Spec.Backend.StorageSecretName == "" {
+		return nil, errors.New("missing repository secret name")
+	}
+	secret, err := kubeClient.CoreV1().Secrets(restic.Namespace).Get(restic.Spec.Backend.StorageSecretName, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	resticCLI := cli.New(scratchDir, true, "")
+	defer resticCLI.Cleanup()
+	if err = resticCLI.SetupEnv(restic, secret, prefix); err != nil {
+		return nil, err
+	}
+	// the operator doesn't run with the environment and ssh secret of the backend
+	if err = setupBackendSecrets(kubeClient, resticCLI, restic.Namespace, restic.Spec.Backend); err != nil {
+		return nil, err
+	}
+	return resticCLI.ListSnapshots()
+}
+
+// SetupMirrorEnv sets up resticCLI to access the repository of the mirror backend of restic. Stash
+// containers only provide the secrets of the primary backend, so all secrets of the mirror are read
+// from the API server.
+func SetupMirrorEnv(kubeClient kubernetes.Interface, resticCLI *cli.ResticWrapper, restic *api.Restic, prefix string) error {
+	mirror := restic.Spec.Mirror
+	if mirror == nil || mirror.StorageSecretName == "" {
+		return errors.New("missing mirror repository secret name")
+	}
+	secret, err := kubeClient.CoreV1().Secrets(restic.Namespace).Get(mirror.StorageSecretName, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	if err = resticCLI.SetupMirrorEnv(*mirror, secret, prefix); err != nil {
+		return err
+	}
+	return setupBackendSecrets(kubeClient, resticCLI, restic.Namespace, *mirror)
+}
+
+// setupBackendSecrets sets up resticCLI with the raw backend secret and the ssh secret of sftp
+// backend, for restic commands run where they are not provided by the container.
+func setupBackendSecrets(kubeClient kubernetes.Interface, resticCLI *cli.ResticWrapper, namespace string, backend api.Backend) error {
+	if raw := backend.Raw; raw != nil && raw.SecretName != "" {
+		rawSecret, err := kubeClient.CoreV1().Secrets(namespace).Get(raw.SecretName, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		resticCLI.SetupSecretEnv(rawSecret)
+	}
+	if sftp := backend.SFTP; sftp != nil {
+		sshSecret, err := kubeClient.CoreV1().Secrets(namespace).Get(sftp.SSHSecretName, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		if err = resticCLI.SetupSFTPKey(sshSecret); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -7,7 +7,6 @@ import (
 	"github.com/appscode/stash/pkg/util"
 	. "github.com/onsi/gomega"
 	apps "k8s.io/api/apps/v1beta1"
-	core "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
@@ -34,12 +33,13 @@ func (fi *Invocation) StatefulSet(r api.Restic, sidecarImageTag string) apps.Sta
 		Kind: api.KindStatefulSet,
 		Name: resource.Name,
 	}
-	resource.Spec.Template.Spec.Containers = append(resource.Spec.Template.Spec.Containers, util.CreateSidecarContainer(&r, sidecarImageTag, workload))
-	resource.Spec.Template.Spec.Volumes = util.UpsertScratchVolume(resource.Spec.Template.Spec.Volumes)
+	sidecar, err := util.CreateSidecarContainer(&r, sidecarImageTag, "", workload, util.DefaultLogLevel, nil)
+	Expect(err).NotTo(HaveOccurred())
+	resource.Spec.Template.Spec.Containers = append(resource.Spec.Template.Spec.Containers, sidecar)
+	resource.Spec.Template.Spec.Volumes = util.UpsertScratchVolume(resource.Spec.Template.Spec.Volumes, &r)
 	resource.Spec.Template.Spec.Volumes = util.UpsertDownwardVolume(resource.Spec.Template.Spec.Volumes)
-	if r.Spec.Backend.Local != nil {
-		resource.Spec.Template.Spec.Volumes = append(resource.Spec.Template.Spec.Volumes, core.Volume{Name: util.LocalVolumeName, VolumeSource: r.Spec.Backend.Local.VolumeSource})
-	}
+	resource.Spec.Template.Spec.Volumes = util.MergeBackendVolumes(resource.Spec.Template.Spec.Volumes, nil, &r)
+	resource.Spec.Template.Spec.PriorityClassName = util.MergePriorityClassName(resource.Spec.Template.Spec.PriorityClassName, nil, &r)
 	return resource
 }
 
@@ -66,12 +66,13 @@ func (fi *Invocation) StatefulSetWitInitContainer(r api.Restic, sidecarImageTag
 		Kind: api.KindStatefulSet,
 		Name: resource.Name,
 	}
-	resource.Spec.Template.Spec.InitContainers = append(resource.Spec.Template.Spec.InitContainers, util.CreateInitContainer(&r, sidecarImageTag, workload, false))
-	resource.Spec.Template.Spec.Volumes = util.UpsertScratchVolume(resource.Spec.Template.Spec.Volumes)
+	initContainer, err := util.CreateInitContainer(&r, sidecarImageTag, "", workload, false, nil)
+	Expect(err).NotTo(HaveOccurred())
+	resource.Spec.Template.Spec.InitContainers = append(resource.Spec.Template.Spec.InitContainers, initContainer)
+	resource.Spec.Template.Spec.Volumes = util.UpsertScratchVolume(resource.Spec.Template.Spec.Volumes, &r)
 	resource.Spec.Template.Spec.Volumes = util.UpsertDownwardVolume(resource.Spec.Template.Spec.Volumes)
-	if r.Spec.Backend.Local != nil {
-		resource.Spec.Template.Spec.Volumes = append(resource.Spec.Template.Spec.Volumes, core.Volume{Name: util.LocalVolumeName, VolumeSource: r.Spec.Backend.Local.VolumeSource})
-	}
+	resource.Spec.Template.Spec.Volumes = util.MergeBackendVolumes(resource.Spec.Template.Spec.Volumes, nil, &r)
+	resource.Spec.Template.Spec.PriorityClassName = util.MergePriorityClassName(resource.Spec.Template.Spec.PriorityClassName, nil, &r)
 	return resource
 }
 